@@ -7,8 +7,11 @@ import (
 
 import "bytes"
 import "fmt"
+import "net"
+import "sort"
 import "strconv"
 import "strings"
+import "time"
 
 // Whitespace recognised by SIP protocol.
 const c_ABNF_WS = " \t"
@@ -111,6 +114,166 @@ func copyWithNil(params Params) Params {
 	return params.Copy()
 }
 
+// A BufferWriter is a header which can write its string representation straight into a shared buffer, without
+// building an intermediate string as String() does. Messages are serialized this way for those headers which
+// support it, which saves an allocation per header when writing out many messages.
+type BufferWriter interface {
+	WriteToBuffer(buffer *bytes.Buffer)
+}
+
+// Write the decimal representation of the given number into the buffer without allocating.
+func writeUint(buffer *bytes.Buffer, value uint64) {
+	var digits [20]byte
+	buffer.Write(strconv.AppendUint(digits[:0], value, 10))
+}
+
+// Render a host for inclusion in a URI or Via header. IPv6 literals are stored without their enclosing brackets,
+// so we must re-add them to keep the host distinguishable from any port that follows it (RFC 3261 s. 25.1).
+func hostString(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// Parse the value of a 'maddr' parameter, which must be a host (RFC 3261 s. 25.1): a hostname, an IPv4 address,
+// or a bracketed IPv6 reference. IPv6 hosts are returned without their brackets, as hosts are stored elsewhere.
+// ok is false if the value is missing or is not a valid host.
+func parseMaddr(value MaybeString) (host string, ok bool) {
+	text, hasValue := value.(String)
+	if !hasValue {
+		return "", false
+	}
+	host = text.S
+
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+		return host, strings.Contains(host, ":") && net.ParseIP(host) != nil
+	} else if strings.Contains(host, ":") {
+		// IPv6 references must be bracketed.
+		return "", false
+	} else if net.ParseIP(host) != nil {
+		return host, true
+	}
+
+	// Otherwise this must be a hostname: dot-separated labels of alphanumerics and hyphens, with an optional
+	// trailing dot. Labels may not start or end with a hyphen, and the last must start with a letter.
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	topLabel := labels[len(labels)-1]
+	if len(topLabel) == 0 || !(topLabel[0] >= 'a' && topLabel[0] <= 'z') && !(topLabel[0] >= 'A' && topLabel[0] <= 'Z') {
+		return "", false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", false
+		}
+		for idx := 0; idx < len(label); idx++ {
+			c := label[idx]
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' {
+				return "", false
+			}
+		}
+	}
+	return host, true
+}
+
+// Parse the value of a 'ttl' parameter, which must be an integer from 0 to 255 (RFC 3261 s. 25.1).
+// ok is false if the value is missing or malformed.
+func parseTTL(value MaybeString) (ttl uint8, ok bool) {
+	text, hasValue := value.(String)
+	if !hasValue || len(text.S) == 0 || len(text.S) > 3 {
+		return 0, false
+	}
+	parsed, err := strconv.ParseUint(text.S, 10, 8)
+	if err != nil {
+		return 0, false
+	}
+	return uint8(parsed), true
+}
+
+// Remove the quotes from a parameter value given as a quoted-string, e.g. '"sip:bob@biloxi.com;gr"'.
+// The parser strips such quotes already, but parameters built by hand may still have them.
+func unquoteParam(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Render the given text as a quoted-string (RFC 3261 s. 25.1), backslash-escaping any quotes and backslashes.
+func quoteString(text string) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("\"")
+	for idx := 0; idx < len(text); idx++ {
+		if text[idx] == '"' || text[idx] == '\\' {
+			buffer.WriteByte('\\')
+		}
+		buffer.WriteByte(text[idx])
+	}
+	buffer.WriteString("\"")
+	return buffer.String()
+}
+
+// A Delimiter is any pair of characters used for quoting text (i.e. bulk escaping literals).
+type Delimiter struct {
+	Start uint8
+	End   uint8
+}
+
+// Common delimiters, for use with SplitUnquoted.
+var (
+	Quotes        = Delimiter{'"', '"'}
+	AngleBrackets = Delimiter{'<', '>'}
+)
+
+// Split the given text around each instance of the separator which is not enclosed in any delimiters from the
+// list provided, e.g. splitting 'a, "b, c", <d,e>' on commas, outside quotes and angle brackets, gives
+// ['a', ' "b, c"', ' <d,e>']. Within quotes, backslash-escaped characters (including quotes) are skipped over.
+// The sections are not trimmed. The separator must be an ASCII character.
+func SplitUnquoted(text string, sep rune, delims ...Delimiter) []string {
+	result := make([]string, 0)
+	for {
+		idx := IndexAnyUnquoted(text, string(sep), delims...)
+		if idx == -1 {
+			return append(result, text)
+		}
+		result = append(result, text[:idx])
+		text = text[idx+1:]
+	}
+}
+
+// Find the first instance of any of the target characters in the given text that is not enclosed in any
+// delimiters from the list provided, returning -1 if there is none.
+func IndexAnyUnquoted(text string, targets string, delims ...Delimiter) int {
+	escaped := false
+	var endEscape uint8 = 0
+
+	endChars := make(map[uint8]uint8)
+	for _, delim := range delims {
+		endChars[delim.Start] = delim.End
+	}
+
+	for idx := 0; idx < len(text); idx++ {
+		if !escaped && strings.Contains(targets, string(text[idx])) {
+			return idx
+		}
+
+		if escaped {
+			if endEscape == '"' && text[idx] == '\\' {
+				// Within a quoted string, a backslash escapes the following character (RFC 3261 s. 25.1).
+				idx++
+				continue
+			}
+			escaped = (text[idx] != endEscape)
+			continue
+		} else {
+			endEscape, escaped = endChars[text[idx]]
+		}
+	}
+
+	return -1
+}
+
 // Copy the Sip URI.
 func (uri *SipUri) Copy() Uri {
 	var port *uint16
@@ -136,6 +299,101 @@ func (uri *SipUri) IsWildcard() bool {
 	return false
 }
 
+// Look up a URI parameter by name. Parameter names are case-insensitive (RFC 3261 s. 19.1.4).
+func (uri *SipUri) param(name string) (MaybeString, bool) {
+	if uri.UriParams == nil {
+		return nil, false
+	}
+	for _, key := range uri.UriParams.Keys() {
+		if strings.EqualFold(key, name) {
+			return uri.UriParams.Get(key)
+		}
+	}
+	return nil, false
+}
+
+// Return the value of the named URI parameter, e.g. 'udp' for 'transport' in 'sip:bob@biloxi.com;transport=udp'.
+// The boolean result is false if the parameter is absent. Parameters with no value (such as 'lr') give "".
+func (uri *SipUri) ParamString(name string) (string, bool) {
+	value, ok := uri.param(name)
+	if !ok {
+		return "", false
+	}
+	if s, ok := value.(String); ok {
+		return s.S, true
+	}
+	return "", true
+}
+
+// Return the value of the named URI parameter as an integer, e.g. 16 for 'ttl' in 'sip:bob@biloxi.com;ttl=16'.
+// The boolean result is false if the parameter is absent; an error is returned if it is present but not an integer.
+func (uri *SipUri) ParamInt(name string) (int, bool, error) {
+	value, ok := uri.ParamString(name)
+	if !ok {
+		return 0, false, nil
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, true, fmt.Errorf("URI parameter %s has non-integer value '%s' in %s", name, value, uri.String())
+	}
+	return result, true, nil
+}
+
+// Return the value of the 'transport' URI parameter, lowercased, e.g. 'tcp' for 'sip:bob@biloxi.com;transport=TCP'.
+// Returns NoString if the parameter is absent or has no value, in which case the transport should be
+// inferred from the URI scheme.
+func (uri *SipUri) Transport() MaybeString {
+	if transport, ok := uri.ParamString("transport"); ok && transport != "" {
+		return String{strings.ToLower(transport)}
+	}
+	return NoString{}
+}
+
+// Determine if the URI identifies a loose router, i.e. has an 'lr' URI parameter (RFC 3261 s. 16.12).
+// The parameter's value, if any, is irrelevant. Proxies use this to choose between loose and strict routing.
+func (uri *SipUri) IsLooseRouter() bool {
+	_, ok := uri.param("lr")
+	return ok
+}
+
+// Determine if the URI represents a telephone number, i.e. has a 'user=phone' URI parameter, as in
+// 'sip:+15551234567@gw.example.com;user=phone' (RFC 3261 s. 19.1.1). The parameter value is case-insensitive.
+func (uri *SipUri) IsPhoneNumber() bool {
+	user, ok := uri.ParamString("user")
+	return ok && strings.EqualFold(user, "phone")
+}
+
+// Return the telephone number represented by the URI, which is its user part, e.g. '+15551234567' for
+// 'sip:+15551234567@gw.example.com;user=phone'. Returns NoString if the URI is not a phone number (see IsPhoneNumber).
+func (uri *SipUri) PhoneNumber() MaybeString {
+	if user, ok := uri.User.(String); ok && uri.IsPhoneNumber() {
+		return user
+	}
+	return NoString{}
+}
+
+// Return the host given by the 'maddr' URI parameter, to which requests for this URI should be sent in place of
+// the URI's own host, e.g. a multicast address (RFC 3261 s. 19.1.1).
+// ok is false if the parameter is absent or its value is not a valid host.
+func (uri *SipUri) Maddr() (host string, ok bool) {
+	value, present := uri.param("maddr")
+	if !present {
+		return "", false
+	}
+	return parseMaddr(value)
+}
+
+// Return the value of the 'ttl' URI parameter, the time-to-live for UDP multicast packets (RFC 3261 s. 19.1.1).
+// ok is false if the parameter is absent or its value is not an integer from 0 to 255.
+func (uri *SipUri) TTL() (ttl uint8, ok bool) {
+	value, present := uri.param("ttl")
+	if !present {
+		return 0, false
+	}
+	return parseTTL(value)
+}
+
 // Determine if the SIP URI is equal to the specified URI according to the rules laid down in RFC 3261 s. 19.1.4.
 // TODO: The Equals method is not currently RFC-compliant; fix this!
 func (uri *SipUri) Equals(otherUri Uri) bool {
@@ -166,6 +424,213 @@ func (uri *SipUri) Equals(otherUri Uri) bool {
 	return true
 }
 
+// Determine if the SIP URI is equivalent to the specified URI according to the comparison rules in
+// RFC 3261 s. 19.1.4. Unlike Equals, this ignores the order of parameters and headers, compares the host
+// and all parameters case-insensitively, and treats escaped and unescaped characters as equivalent.
+// The user, ttl, method, maddr and transport parameters must appear in both URIs or in neither;
+// any other parameter is only compared if it appears in both.
+// Note that a URI with no port does not match a URI that gives the default port explicitly.
+func (uri *SipUri) EqualsRFC(otherUri Uri) bool {
+	other, ok := otherUri.(*SipUri)
+	if !ok {
+		return false
+	}
+
+	if uri.IsEncrypted != other.IsEncrypted ||
+		!strings.EqualFold(uri.Host, other.Host) ||
+		!utils.Uint16PtrEq(uri.Port, other.Port) {
+		return false
+	}
+
+	// Userinfo is compared case-sensitively.
+	if !maybeStringsEqualUnescaped(uri.User, other.User, false) ||
+		!maybeStringsEqualUnescaped(uri.Password, other.Password, false) {
+		return false
+	}
+
+	for _, name := range []string{"user", "ttl", "method", "maddr", "transport"} {
+		_, inUri := uri.param(name)
+		_, inOther := other.param(name)
+		if inUri != inOther {
+			return false
+		}
+	}
+
+	if uri.UriParams != nil {
+		for _, key := range uri.UriParams.Keys() {
+			value, _ := uri.UriParams.Get(key)
+			if otherValue, ok := other.param(key); ok && !maybeStringsEqualUnescaped(value, otherValue, true) {
+				return false
+			}
+		}
+	}
+
+	// Headers are never ignored: every header must appear in both URIs, with matching values.
+	if paramsLength(uri.Headers) != paramsLength(other.Headers) {
+		return false
+	}
+	if uri.Headers != nil {
+		for _, key := range uri.Headers.Keys() {
+			value, _ := uri.Headers.Get(key)
+			otherValue, ok := lookupParamFold(other.Headers, key)
+			if !ok || !maybeStringsEqualUnescaped(value, otherValue, true) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func paramsLength(params Params) int {
+	if params == nil {
+		return 0
+	}
+	return params.Length()
+}
+
+// Look up a parameter by name, ignoring case.
+func lookupParamFold(params Params, name string) (MaybeString, bool) {
+	if params == nil {
+		return nil, false
+	}
+	for _, key := range params.Keys() {
+		if strings.EqualFold(key, name) {
+			return params.Get(key)
+		}
+	}
+	return nil, false
+}
+
+// Compare two MaybeStrings after decoding any %HH escapes, optionally ignoring case.
+func maybeStringsEqualUnescaped(a MaybeString, b MaybeString, ignoreCase bool) bool {
+	aString, aOk := a.(String)
+	bString, bOk := b.(String)
+	if !aOk || !bOk {
+		return aOk == bOk
+	}
+
+	aText, bText := unescapeUriPart(aString.S), unescapeUriPart(bString.S)
+	if ignoreCase {
+		return strings.EqualFold(aText, bText)
+	}
+	return aText == bText
+}
+
+// Decode any %HH escape sequences in part of a URI. Malformed escapes are left as they are.
+func unescapeUriPart(text string) string {
+	var buffer bytes.Buffer
+	for idx := 0; idx < len(text); idx++ {
+		if text[idx] == '%' && idx+2 < len(text) {
+			if value, err := strconv.ParseUint(text[idx+1:idx+3], 16, 8); err == nil {
+				buffer.WriteByte(byte(value))
+				idx += 2
+				continue
+			}
+		}
+		buffer.WriteByte(text[idx])
+	}
+	return buffer.String()
+}
+
+// Characters, besides alphanumerics, which may appear unescaped in each part of a SIP URI.
+// These are the RFC 3261 'unreserved' marks plus those reserved characters that are unambiguous in that part.
+// Characters the parser uses as delimiters (e.g. ';', '?' and '=' in the user part) are always escaped.
+const (
+	c_UNRESERVED_MARKS = "-_.!~*'()"
+	c_USER_CHARS       = c_UNRESERVED_MARKS + "&+$,/"
+	c_PASSWORD_CHARS   = c_UNRESERVED_MARKS + "&+$,"
+	c_PARAM_CHARS      = c_UNRESERVED_MARKS + "[]/:&+$"
+	c_HEADER_CHARS     = c_UNRESERVED_MARKS + "[]/?:+$"
+)
+
+// Percent-encode the user part of a SIP URI, so that it can be safely written into the URI's string form.
+func EscapeUser(user string) string {
+	return escapeUriPart(user, c_USER_CHARS)
+}
+
+// Decode the percent-encoded user part of a SIP URI.
+// Returns an error if the text contains a malformed escape sequence.
+func UnescapeUser(user string) (string, error) {
+	return strictUnescapeUriPart(user)
+}
+
+// Percent-encode a URI parameter name or value, so that it can be safely written into a URI's string form.
+func EscapeParam(param string) string {
+	return escapeUriPart(param, c_PARAM_CHARS)
+}
+
+// Decode a percent-encoded URI parameter name or value.
+// Returns an error if the text contains a malformed escape sequence.
+// UnescapeParam also decodes the names and values of URI headers, such as 'subject' in 'sip:a@h?subject=hi'.
+func UnescapeParam(param string) (string, error) {
+	return strictUnescapeUriPart(param)
+}
+
+// Percent-encode a URI header name or value. This differs from EscapeParam only in the separators it escapes.
+func escapeUriHeader(header string) string {
+	return escapeUriPart(header, c_HEADER_CHARS)
+}
+
+// Percent-encode every byte of the text other than alphanumerics and the given permitted characters.
+func escapeUriPart(text string, permitted string) string {
+	var buffer bytes.Buffer
+	for idx := 0; idx < len(text); idx++ {
+		c := text[idx]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte(permitted, c) != -1 {
+			buffer.WriteByte(c)
+		} else {
+			buffer.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return buffer.String()
+}
+
+// Decode all %HH escape sequences in part of a URI, failing if any are malformed.
+func strictUnescapeUriPart(text string) (string, error) {
+	var buffer bytes.Buffer
+	for idx := 0; idx < len(text); idx++ {
+		if text[idx] == '%' {
+			if idx+2 >= len(text) {
+				return "", fmt.Errorf("truncated escape sequence in '%s'", text)
+			}
+			value, err := strconv.ParseUint(text[idx+1:idx+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape sequence '%s' in '%s'", text[idx:idx+3], text)
+			}
+			buffer.WriteByte(byte(value))
+			idx += 2
+			continue
+		}
+		buffer.WriteByte(text[idx])
+	}
+	return buffer.String(), nil
+}
+
+// Default ports for SIP and SIPS URIs with no explicit port (RFC 3261 s. 19.1.2).
+const (
+	DefaultSipPort  uint16 = 5060
+	DefaultSipsPort uint16 = 5061
+)
+
+// Generates the string representation of a SipUri struct, like String, but always including a port.
+// If no port is set, the default port for the scheme is written: 5060 for sip, or 5061 for sips.
+// This is for interoperating with implementations that expect an explicit port.
+func (uri *SipUri) StringWithDefaultPort() string {
+	if uri.Port != nil {
+		return uri.String()
+	}
+
+	withPort := *uri
+	port := DefaultSipPort
+	if uri.IsEncrypted {
+		port = DefaultSipsPort
+	}
+	withPort.Port = &port
+	return withPort.String()
+}
+
 // Generates the string representation of a SipUri struct.
 func (uri *SipUri) String() string {
 	var buffer bytes.Buffer
@@ -182,17 +647,17 @@ func (uri *SipUri) String() string {
 	// Optional userinfo part.
 	switch user := uri.User.(type) {
 	case String:
-		buffer.WriteString(user.String())
+		buffer.WriteString(EscapeUser(user.String()))
 		switch pw := uri.Password.(type) {
 		case String:
 			buffer.WriteString(":")
-			buffer.WriteString(pw.String())
+			buffer.WriteString(escapeUriPart(pw.String(), c_PASSWORD_CHARS))
 		}
 		buffer.WriteString("@")
 	}
 
 	// Compulsory hostname.
-	buffer.WriteString(uri.Host)
+	buffer.WriteString(hostString(uri.Host))
 
 	// Optional port number.
 	if uri.Port != nil {
@@ -202,17 +667,131 @@ func (uri *SipUri) String() string {
 
 	if (uri.UriParams != nil) && uri.UriParams.Length() > 0 {
 		buffer.WriteString(";")
-		buffer.WriteString(uri.UriParams.ToString(';'))
+		buffer.WriteString(uriParamsString(uri.UriParams, ';', EscapeParam))
 	}
 
 	if (uri.Headers != nil) && uri.Headers.Length() > 0 {
 		buffer.WriteString("?")
-		buffer.WriteString(uri.Headers.ToString('&'))
+		buffer.WriteString(uriParamsString(uri.Headers, '&', escapeUriHeader))
+	}
+
+	return buffer.String()
+}
+
+// A tel URI (RFC 3966), identifying a resource by telephone number, e.g. 'tel:+1-201-555-0123'.
+type TelUri struct {
+	// The telephone number, including any visual separators (e.g. '-' or '.').
+	// Global numbers begin with '+'; local numbers must carry a 'phone-context' parameter.
+	Number string
+
+	// Any parameters present in the URI, e.g. 'phone-context' or 'ext'.
+	Params Params
+}
+
+// Copy the tel URI.
+func (uri *TelUri) Copy() Uri {
+	return &TelUri{uri.Number, copyWithNil(uri.Params)}
+}
+
+// Determine whether the telephone number is global (i.e. begins with '+') rather than local.
+func (uri *TelUri) IsGlobal() bool {
+	return strings.HasPrefix(uri.Number, "+")
+}
+
+// Determine if the tel URI is equal to the specified URI according to the rules in RFC 3966 s. 4.
+// Visual separators in the number are ignored, as is the order of parameters.
+func (uri *TelUri) Equals(otherUri Uri) bool {
+	other, ok := otherUri.(*TelUri)
+	if !ok {
+		return false
+	}
+
+	if !strings.EqualFold(stripVisualSeparators(uri.Number), stripVisualSeparators(other.Number)) {
+		return false
+	}
+
+	if !uri.Params.Equals(other.Params) {
+		return false
+	}
+
+	return true
+}
+
+// Generates the string representation of a TelUri struct.
+func (uri *TelUri) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("tel:")
+	buffer.WriteString(uri.Number)
+
+	if (uri.Params != nil) && uri.Params.Length() > 0 {
+		buffer.WriteString(";")
+		buffer.WriteString(uriParamsString(uri.Params, ';', EscapeParam))
 	}
 
 	return buffer.String()
 }
 
+// Render the parameters or headers of a URI, separated by sep, percent-encoding each name and value with escape.
+// Unlike Params.ToString, values are never quoted, since quoted-strings are not part of the URI syntax.
+func uriParamsString(params Params, sep uint8, escape func(string) string) string {
+	var buffer bytes.Buffer
+	for idx, k := range params.Keys() {
+		if idx > 0 {
+			buffer.WriteByte(sep)
+		}
+		buffer.WriteString(escape(k))
+		if v, ok := params.Get(k); ok {
+			switch v := v.(type) {
+			case String:
+				buffer.WriteString("=" + escape(v.String()))
+			}
+		}
+	}
+	return buffer.String()
+}
+
+// Remove the visual separators permitted in a telephone number (RFC 3966 s. 3).
+func stripVisualSeparators(number string) string {
+	return strings.Map(func(char rune) rune {
+		if strings.ContainsRune("-.()", char) {
+			return -1
+		}
+		return char
+	}, number)
+}
+
+// A URI from a scheme which gossip does not natively support, e.g. 'http://www.example.com/sounds/moo.wav'
+// (c.f. absoluteURI in RFC 3261 s. 25.1). The URI is held as opaque data, split only into its scheme and
+// the remainder following the colon.
+type AbsoluteUri struct {
+	// The URI scheme, e.g. 'http'.
+	Scheme string
+
+	// Everything following the colon after the scheme, e.g. '//www.example.com/sounds/moo.wav'.
+	Opaque string
+}
+
+// Copy the absolute URI.
+func (uri *AbsoluteUri) Copy() Uri {
+	return &AbsoluteUri{uri.Scheme, uri.Opaque}
+}
+
+// Determine if the absolute URI is equal to the specified URI.
+// Schemes are compared case-insensitively; the rest of the URI must match exactly.
+func (uri *AbsoluteUri) Equals(otherUri Uri) bool {
+	other, ok := otherUri.(*AbsoluteUri)
+	if !ok {
+		return false
+	}
+
+	return strings.EqualFold(uri.Scheme, other.Scheme) && uri.Opaque == other.Opaque
+}
+
+// Generates the string representation of an AbsoluteUri struct.
+func (uri *AbsoluteUri) String() string {
+	return uri.Scheme + ":" + uri.Opaque
+}
+
 // The special wildcard URI used in Contact: headers in REGISTER requests when expiring all registrations.
 type WildcardUri struct{}
 
@@ -306,8 +885,9 @@ func (p *params) Copy() Params {
 	return dup
 }
 
-// Render params to a string.
-// Note that this does not escape special characters, this should already have been done before calling this method.
+// Render header params to a string.
+// Values which could not be reparsed as plain tokens are written as quoted-strings; no other escaping is done.
+// The params of URIs are never quoted, so are written by the URIs themselves.
 func (p *params) ToString(sep uint8) string {
 	var buffer bytes.Buffer
 	first := true
@@ -328,8 +908,11 @@ func (p *params) ToString(sep uint8) string {
 
 		switch v := v.(type) {
 		case String:
-			if strings.ContainsAny(v.String(), c_ABNF_WS) {
-				buffer.WriteString(fmt.Sprintf("=\"%s\"", v.String()))
+			// Values that would otherwise be split up when reparsed, such as the URIs in GRUU parameters, are quoted,
+			// as are values containing angle brackets, which may only appear in a quoted string, e.g. '+sip.instance'.
+			// Any quotes and backslashes are then escaped.
+			if strings.ContainsAny(v.String(), c_ABNF_WS+";,<>\"\\") {
+				buffer.WriteString("=" + quoteString(v.String()))
 			} else {
 				buffer.WriteString(fmt.Sprintf("=%s", v.String()))
 			}
@@ -381,12 +964,19 @@ type GenericHeader struct {
 
 // Convert the header to a flat string representation.
 func (header *GenericHeader) String() string {
-	return header.HeaderName + ": " + header.Contents
+	return header.Name() + ": " + header.Contents
+}
+
+// Implements BufferWriter.
+func (header *GenericHeader) WriteToBuffer(buffer *bytes.Buffer) {
+	buffer.WriteString(header.Name())
+	buffer.WriteString(": ")
+	buffer.WriteString(header.Contents)
 }
 
-// Pull out the header name.
+// Pull out the header name, in canonical form (e.g. 'Supported' for 'k').
 func (h *GenericHeader) Name() string {
-	return h.HeaderName
+	return CanonicalHeaderName(h.HeaderName)
 }
 
 // Copy the header.
@@ -406,21 +996,27 @@ type ToHeader struct {
 
 func (to *ToHeader) String() string {
 	var buffer bytes.Buffer
+	to.WriteToBuffer(&buffer)
+	return buffer.String()
+}
+
+// Implements BufferWriter.
+func (to *ToHeader) WriteToBuffer(buffer *bytes.Buffer) {
 	buffer.WriteString("To: ")
 
 	switch s := to.DisplayName.(type) {
 	case String:
-		buffer.WriteString(fmt.Sprintf("\"%s\" ", s.String()))
+		buffer.WriteString(quoteString(s.String()) + " ")
 	}
 
-	buffer.WriteString(fmt.Sprintf("<%s>", to.Address))
+	buffer.WriteString("<")
+	buffer.WriteString(to.Address.String())
+	buffer.WriteString(">")
 
 	if to.Params.Length() > 0 {
 		buffer.WriteString(";")
 		buffer.WriteString(to.Params.ToString(';'))
 	}
-
-	return buffer.String()
 }
 
 func (h *ToHeader) Name() string { return "To" }
@@ -430,6 +1026,17 @@ func (h *ToHeader) Copy() SipHeader {
 	return &ToHeader{h.DisplayName, h.Address.Copy(), h.Params.Copy()}
 }
 
+// Return the value of the 'tag' parameter, which identifies the remote party's side of a dialog.
+// Returns NoString if the tag is absent.
+func (h *ToHeader) Tag() MaybeString {
+	return tagParam(h.Params)
+}
+
+// Set the 'tag' parameter to the given value, replacing any existing tag.
+func (h *ToHeader) SetTag(tag string) {
+	h.Params = setTagParam(h.Params, tag)
+}
+
 type FromHeader struct {
 	// The display name from the header, may be omitted.
 	DisplayName MaybeString
@@ -442,20 +1049,27 @@ type FromHeader struct {
 
 func (from *FromHeader) String() string {
 	var buffer bytes.Buffer
+	from.WriteToBuffer(&buffer)
+	return buffer.String()
+}
+
+// Implements BufferWriter.
+func (from *FromHeader) WriteToBuffer(buffer *bytes.Buffer) {
 	buffer.WriteString("From: ")
 
 	switch s := from.DisplayName.(type) {
 	case String:
-		buffer.WriteString(fmt.Sprintf("\"%s\" ", s.String()))
+		buffer.WriteString(quoteString(s.String()) + " ")
 	}
 
-	buffer.WriteString(fmt.Sprintf("<%s>", from.Address))
+	buffer.WriteString("<")
+	buffer.WriteString(from.Address.String())
+	buffer.WriteString(">")
+
 	if from.Params.Length() > 0 {
 		buffer.WriteString(";")
 		buffer.WriteString(from.Params.ToString(';'))
 	}
-
-	return buffer.String()
 }
 
 func (h *FromHeader) Name() string { return "From" }
@@ -465,6 +1079,33 @@ func (h *FromHeader) Copy() SipHeader {
 	return &FromHeader{h.DisplayName, h.Address.Copy(), h.Params.Copy()}
 }
 
+// Return the value of the 'tag' parameter, which identifies the sender's side of a dialog.
+// Returns NoString if the tag is absent.
+func (h *FromHeader) Tag() MaybeString {
+	return tagParam(h.Params)
+}
+
+// Set the 'tag' parameter to the given value, replacing any existing tag.
+func (h *FromHeader) SetTag(tag string) {
+	h.Params = setTagParam(h.Params, tag)
+}
+
+func tagParam(params Params) MaybeString {
+	if params != nil {
+		if tag, ok := params.Get("tag"); ok {
+			return tag
+		}
+	}
+	return NoString{}
+}
+
+func setTagParam(params Params, tag string) Params {
+	if params == nil {
+		params = NewParams()
+	}
+	return params.Add("tag", String{tag})
+}
+
 type ContactHeader struct {
 	// The display name from the header, may be omitted.
 	DisplayName MaybeString
@@ -477,11 +1118,17 @@ type ContactHeader struct {
 
 func (contact *ContactHeader) String() string {
 	var buffer bytes.Buffer
+	contact.WriteToBuffer(&buffer)
+	return buffer.String()
+}
+
+// Implements BufferWriter.
+func (contact *ContactHeader) WriteToBuffer(buffer *bytes.Buffer) {
 	buffer.WriteString("Contact: ")
 
 	switch s := contact.DisplayName.(type) {
 	case String:
-		buffer.WriteString(fmt.Sprintf("\"%s\" ", s.String()))
+		buffer.WriteString(quoteString(s.String()) + " ")
 	}
 
 	switch contact.Address.(type) {
@@ -489,15 +1136,15 @@ func (contact *ContactHeader) String() string {
 		// Treat the Wildcard URI separately as it must not be contained in < > angle brackets.
 		buffer.WriteString("*")
 	default:
-		buffer.WriteString(fmt.Sprintf("<%s>", contact.Address.String()))
+		buffer.WriteString("<")
+		buffer.WriteString(contact.Address.String())
+		buffer.WriteString(">")
 	}
 
 	if (contact.Params != nil) && (contact.Params.Length() > 0) {
 		buffer.WriteString(";")
 		buffer.WriteString(contact.Params.ToString(';'))
 	}
-
-	return buffer.String()
 }
 
 func (h *ContactHeader) Name() string { return "Contact" }
@@ -507,10 +1154,135 @@ func (h *ContactHeader) Copy() SipHeader {
 	return &ContactHeader{h.DisplayName, h.Address.Copy().(ContactUri), h.Params.Copy()}
 }
 
-type CallId string
-
-func (callId CallId) String() string {
-	return "Call-Id: " + (string)(callId)
+// Determine if this is a wildcard contact, 'Contact: *', which in a REGISTER request removes all of the
+// bindings for the address-of-record (RFC 3261 s. 10.2.2).
+func (h *ContactHeader) IsWildcard() bool {
+	return h.Address != nil && h.Address.IsWildcard()
+}
+
+// Determine whether two contacts identify the same binding, as a registrar must when deciding whether a
+// REGISTER updates an existing binding or adds a new one (RFC 3261 s. 10.3). Only the contacts' URIs are
+// compared, using the rules in RFC 3261 s. 19.1.4; display names and header parameters (such as expires) are ignored.
+func ContactsMatch(a, b *ContactHeader) bool {
+	if a == nil || b == nil || a.Address == nil || b.Address == nil {
+		return false
+	}
+	if uri, ok := a.Address.(*SipUri); ok {
+		return uri.EqualsRFC(b.Address)
+	}
+	return a.Address.Equals(b.Address)
+}
+
+// Return the value of the 'gr' URI parameter on the contact's address, which marks the address as a GRUU
+// (RFC 5627 s. 3.1). Temporary GRUUs carry the parameter with no value, which gives an empty String.
+// Returns NoString if the address is not a SIP URI or has no 'gr' parameter.
+func (h *ContactHeader) GRUU() MaybeString {
+	uri, ok := h.Address.(*SipUri)
+	if !ok {
+		return NoString{}
+	}
+	value, present := uri.param("gr")
+	if !present {
+		return NoString{}
+	}
+	if text, hasValue := value.(String); hasValue {
+		return String{unquoteParam(text.S)}
+	}
+	return String{""}
+}
+
+// Return the public GRUU that a registrar has assigned to this contact, from the 'pub-gruu' parameter
+// (RFC 5627 s. 5.1). Returns NoString if the parameter is absent or has no value.
+func (h *ContactHeader) PubGRUU() MaybeString {
+	return h.gruuParam("pub-gruu")
+}
+
+// Return the temporary GRUU that a registrar has assigned to this contact, from the 'temp-gruu' parameter
+// (RFC 5627 s. 5.1). Returns NoString if the parameter is absent or has no value.
+func (h *ContactHeader) TempGRUU() MaybeString {
+	return h.gruuParam("temp-gruu")
+}
+
+// Return the value of the named GRUU contact parameter, without any surrounding quotes.
+func (h *ContactHeader) gruuParam(name string) MaybeString {
+	if h.Params == nil {
+		return NoString{}
+	}
+	value, present := h.Params.Get(name)
+	text, hasValue := value.(String)
+	if !present || !hasValue {
+		return NoString{}
+	}
+	return String{unquoteParam(text.S)}
+}
+
+// Return the value of the 'expires' parameter, which gives the lifetime of a registered binding in seconds.
+// Returns ok=false if the parameter is absent or is not a valid number of seconds.
+func (h *ContactHeader) Expires() (expires uint32, ok bool) {
+	if h.Params == nil {
+		return 0, false
+	}
+	value, present := h.Params.Get("expires")
+	text, hasValue := value.(String)
+	if !present || !hasValue {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseUint(text.S, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(seconds), true
+}
+
+// Set the 'expires' parameter to the given number of seconds, replacing any existing value.
+func (h *ContactHeader) SetExpires(expires uint32) {
+	if h.Params == nil {
+		h.Params = NewParams()
+	}
+	h.Params.Add("expires", String{strconv.FormatUint(uint64(expires), 10)})
+}
+
+// Determine whether this contact, in a REGISTER request, asks the registrar to remove its binding rather than
+// refresh it; that is, whether it has an 'expires' parameter of zero (RFC 3261 s. 10.2.2).
+// A contact with no valid 'expires' parameter takes its lifetime from the request's Expires header instead,
+// so this returns false, and registrars should check that header themselves (see Expires).
+func (h *ContactHeader) IsDeregistration() bool {
+	expires, ok := h.Expires()
+	return ok && expires == 0
+}
+
+// Return the value of the 'q' parameter, which gives the relative preference of this contact, from 0 to 1.
+// Returns ok=false if the parameter is absent or is not a valid q-value.
+func (h *ContactHeader) Q() (q float32, ok bool) {
+	return ParseQValue(h.Params)
+}
+
+// Set the 'q' parameter to the given value, replacing any existing value.
+// The value is clamped to between 0 and 1, and rounded to three decimal places, as RFC 3261 s. 25.1 requires.
+func (h *ContactHeader) SetQ(q float32) {
+	if h.Params == nil {
+		h.Params = NewParams()
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+	text := strings.TrimRight(strconv.FormatFloat(float64(q), 'f', 3, 32), "0")
+	h.Params.Add("q", String{strings.TrimSuffix(text, ".")})
+}
+
+type CallId string
+
+func (callId CallId) String() string {
+	return "Call-Id: " + (string)(callId)
+}
+
+// Implements BufferWriter.
+func (callId CallId) WriteToBuffer(buffer *bytes.Buffer) {
+	buffer.WriteString("Call-Id: ")
+	buffer.WriteString(string(callId))
 }
 
 func (h *CallId) Name() string { return "Call-Id" }
@@ -520,25 +1292,66 @@ func (h *CallId) Copy() SipHeader {
 	return &temp
 }
 
+// The maximum permissible CSeq number in a SIP message (2**31 - 1).
+// C.f. RFC 3261 S. 8.1.1.5.
+const MAX_CSEQ = 2147483647
+
 type CSeq struct {
 	SeqNo      uint32
 	MethodName Method
 }
 
+// Increment the sequence number, as for each new request within a dialog (RFC 3261 s. 8.1.1.5).
+// Sequence numbers must be less than 2**31, so MAX_CSEQ wraps around to 0.
+func (cseq *CSeq) Increment() {
+	cseq.SeqNo = (cseq.SeqNo + 1) % (MAX_CSEQ + 1)
+}
+
+// Compare the sequence numbers of two CSeqs, returning -1, 0 or 1 as this CSeq is earlier than, the same as,
+// or later than the other. The methods are not compared.
+// Sequence numbers wrap around at 2**31, so a number is considered later than those less than 2**30 before it
+// (modulo 2**31), and earlier than all others; e.g. 0 is later than MAX_CSEQ.
+func (cseq *CSeq) Compare(other *CSeq) int {
+	diff := (cseq.SeqNo - other.SeqNo) % (MAX_CSEQ + 1)
+	if diff == 0 {
+		return 0
+	} else if diff < (MAX_CSEQ+1)/2 {
+		return 1
+	}
+	return -1
+}
+
 func (cseq *CSeq) String() string {
 	return fmt.Sprintf("CSeq: %d %s", cseq.SeqNo, cseq.MethodName)
 }
 
+// Implements BufferWriter.
+func (cseq *CSeq) WriteToBuffer(buffer *bytes.Buffer) {
+	buffer.WriteString("CSeq: ")
+	writeUint(buffer, uint64(cseq.SeqNo))
+	buffer.WriteString(" ")
+	buffer.WriteString(string(cseq.MethodName))
+}
+
 func (h *CSeq) Name() string { return "CSeq" }
 
 func (h *CSeq) Copy() SipHeader { return &CSeq{h.SeqNo, h.MethodName} }
 
+// The Max-Forwards value that a UAC should set on requests it originates (RFC 3261 s. 8.1.1.6).
+const DefaultMaxForwards MaxForwards = 70
+
 type MaxForwards uint32
 
 func (maxForwards MaxForwards) String() string {
 	return fmt.Sprintf("Max-Forwards: %d", ((int)(maxForwards)))
 }
 
+// Implements BufferWriter.
+func (maxForwards MaxForwards) WriteToBuffer(buffer *bytes.Buffer) {
+	buffer.WriteString("Max-Forwards: ")
+	writeUint(buffer, uint64(maxForwards))
+}
+
 func (h MaxForwards) Name() string { return "Max-Forwards" }
 
 func (h MaxForwards) Copy() SipHeader { return h }
@@ -549,10 +1362,238 @@ func (contentLength ContentLength) String() string {
 	return fmt.Sprintf("Content-Length: %d", ((int)(contentLength)))
 }
 
+// Implements BufferWriter.
+func (contentLength ContentLength) WriteToBuffer(buffer *bytes.Buffer) {
+	buffer.WriteString("Content-Length: ")
+	writeUint(buffer, uint64(contentLength))
+}
+
 func (h ContentLength) Name() string { return "Content-Length" }
 
 func (h ContentLength) Copy() SipHeader { return h }
 
+// RSeq header (RFC 3262 s. 7.1), numbering a reliably-transmitted provisional response.
+type RSeq uint32
+
+func (rseq RSeq) String() string {
+	return fmt.Sprintf("RSeq: %d", ((uint32)(rseq)))
+}
+
+func (h RSeq) Name() string { return "RSeq" }
+
+func (h RSeq) Copy() SipHeader { return h }
+
+// RAck header (RFC 3262 s. 7.2), sent in a PRACK to acknowledge a reliable provisional response.
+// It identifies the response by its RSeq, together with the CSeq number and method of the request it answered.
+type RAck struct {
+	RSeqNo     uint32
+	CSeqNo     uint32
+	MethodName Method
+}
+
+func (rack *RAck) String() string {
+	return fmt.Sprintf("RAck: %d %d %s", rack.RSeqNo, rack.CSeqNo, rack.MethodName)
+}
+
+func (h *RAck) Name() string { return "RAck" }
+
+func (h *RAck) Copy() SipHeader { return &RAck{h.RSeqNo, h.CSeqNo, h.MethodName} }
+
+// The layout of a SIP-date (RFC 3261 s. 25.1): an RFC 1123 date, which must always be in GMT.
+// Note that time.RFC1123 would render a UTC time with the zone 'UTC', which SIP does not permit.
+const SipDateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Date header (RFC 3261 s. 20.17), giving the date and time at which the message was sent.
+type DateHeader struct {
+	Time time.Time
+}
+
+func (date *DateHeader) String() string {
+	return "Date: " + date.Time.UTC().Format(SipDateFormat)
+}
+
+func (h *DateHeader) Name() string { return "Date" }
+
+func (h *DateHeader) Copy() SipHeader { return &DateHeader{h.Time} }
+
+// Session-Expires header (RFC 4028 s. 4), giving the session interval for a session timer.
+// The 'refresher' parameter, if present, names which side ('uac' or 'uas') is responsible for refreshing
+// the session, and is kept in Params along with any extension parameters.
+type SessionExpiresHeader struct {
+	// The session interval, in seconds.
+	Seconds uint32
+
+	// The parameters of the header, including 'refresher'.
+	Params Params
+}
+
+func (sessionExpires *SessionExpiresHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Session-Expires: %d", sessionExpires.Seconds))
+
+	if (sessionExpires.Params != nil) && (sessionExpires.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(sessionExpires.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *SessionExpiresHeader) Name() string { return "Session-Expires" }
+
+func (h *SessionExpiresHeader) Copy() SipHeader {
+	return &SessionExpiresHeader{h.Seconds, copyWithNil(h.Params)}
+}
+
+// Return the value of the 'refresher' parameter, i.e. 'uac' or 'uas'.
+// Returns NoString if the parameter is absent.
+func (h *SessionExpiresHeader) Refresher() MaybeString {
+	if h.Params != nil {
+		if value, ok := h.Params.Get("refresher"); ok {
+			return value
+		}
+	}
+	return NoString{}
+}
+
+// Min-SE header (RFC 4028 s. 5), giving the minimum session interval in seconds.
+type MinSE uint32
+
+func (minSE MinSE) String() string {
+	return fmt.Sprintf("Min-SE: %d", ((uint32)(minSE)))
+}
+
+func (h MinSE) Name() string { return "Min-SE" }
+
+func (h MinSE) Copy() SipHeader { return h }
+
+// Min-Expires header (RFC 3261 s. 20.23), giving the minimum registration refresh interval a registrar supports.
+// It is carried on 423 (Interval Too Brief) responses.
+type MinExpires uint32
+
+func (minExpires MinExpires) String() string {
+	return fmt.Sprintf("Min-Expires: %d", ((uint32)(minExpires)))
+}
+
+func (h MinExpires) Name() string { return "Min-Expires" }
+
+func (h MinExpires) Copy() SipHeader { return h }
+
+// Retry-After header (RFC 3261 s. 20.33), indicating how long the service is expected to be unavailable.
+type RetryAfterHeader struct {
+	// The number of seconds after which the request may be retried.
+	Seconds uint32
+
+	// The text of the comment, without its enclosing parentheses. May be omitted.
+	Comment MaybeString
+
+	// Any parameters present in the header, e.g. 'duration'.
+	Params Params
+}
+
+func (retryAfter *RetryAfterHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Retry-After: %d", retryAfter.Seconds))
+
+	switch s := retryAfter.Comment.(type) {
+	case String:
+		buffer.WriteString(fmt.Sprintf(" (%s)", s.String()))
+	}
+
+	if (retryAfter.Params != nil) && (retryAfter.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(retryAfter.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *RetryAfterHeader) Name() string { return "Retry-After" }
+
+func (h *RetryAfterHeader) Copy() SipHeader {
+	return &RetryAfterHeader{h.Seconds, h.Comment, copyWithNil(h.Params)}
+}
+
+// Timestamp header (RFC 3261 s. 20.38), which a UAC uses to measure round-trip times.
+// A UAS copies the timestamp into its response, optionally adding the delay before it responded.
+type TimestampHeader struct {
+	// The time at which the request was sent, in units chosen by the UAC.
+	Timestamp float64
+
+	// The delay in seconds between the UAS receiving the request and sending the response. May be omitted (nil).
+	Delay *float64
+}
+
+func (timestamp *TimestampHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Timestamp: ")
+	buffer.WriteString(strconv.FormatFloat(timestamp.Timestamp, 'f', -1, 64))
+
+	if timestamp.Delay != nil {
+		buffer.WriteString(" ")
+		buffer.WriteString(strconv.FormatFloat(*timestamp.Delay, 'f', -1, 64))
+	}
+
+	return buffer.String()
+}
+
+func (h *TimestampHeader) Name() string { return "Timestamp" }
+
+func (h *TimestampHeader) Copy() SipHeader {
+	if h.Delay == nil {
+		return &TimestampHeader{h.Timestamp, nil}
+	}
+	delay := *h.Delay
+	return &TimestampHeader{h.Timestamp, &delay}
+}
+
+// Warning header (RFC 3261 s. 20.43), carrying additional information about the status of a response.
+// A single header may contain several comma-separated warnings.
+type WarningHeader []*Warning
+
+// A single warning within a Warning header, e.g. '307 isi.edu "Session parameter 'foo' not understood"'.
+type Warning struct {
+	// The three-digit warning code, e.g. 307.
+	Code uint16
+
+	// The host (and optional port) or pseudonym of the entity adding the warning.
+	Agent string
+
+	// The warning text, without its enclosing quotes or escaping.
+	Text string
+}
+
+func (warning *Warning) String() string {
+	return fmt.Sprintf("%03d %s %s", warning.Code, warning.Agent, quoteString(warning.Text))
+}
+
+// Return an exact copy of this warning.
+func (warning *Warning) Copy() *Warning {
+	return &Warning{warning.Code, warning.Agent, warning.Text}
+}
+
+func (header WarningHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Warning: ")
+	for idx, warning := range header {
+		buffer.WriteString(warning.String())
+		if idx != len(header)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	return buffer.String()
+}
+
+func (h WarningHeader) Name() string { return "Warning" }
+
+func (h WarningHeader) Copy() SipHeader {
+	dup := make([]*Warning, 0, len(h))
+	for _, warning := range h {
+		dup = append(dup, warning.Copy())
+	}
+	return WarningHeader(dup)
+}
+
 type ViaHeader []*ViaHop
 
 // A single component in a Via header.
@@ -574,20 +1615,37 @@ type ViaHop struct {
 
 func (hop *ViaHop) String() string {
 	var buffer bytes.Buffer
-	buffer.WriteString(fmt.Sprintf("%s/%s/%s %s",
-		hop.ProtocolName, hop.ProtocolVersion,
-		hop.Transport,
-		hop.Host))
+	hop.writeToBuffer(&buffer)
+	return buffer.String()
+}
+
+// Write the string representation of the hop into the given buffer.
+func (hop *ViaHop) writeToBuffer(buffer *bytes.Buffer) {
+	buffer.WriteString(hop.ProtocolName)
+	buffer.WriteString("/")
+	buffer.WriteString(hop.ProtocolVersion)
+	buffer.WriteString("/")
+	buffer.WriteString(hop.Transport)
+	buffer.WriteString(" ")
+	buffer.WriteString(hostString(hop.Host))
 	if hop.Port != nil {
-		buffer.WriteString(fmt.Sprintf(":%d", *hop.Port))
+		buffer.WriteString(":")
+		writeUint(buffer, uint64(*hop.Port))
 	}
 
 	if hop.Params.Length() > 0 {
 		buffer.WriteString(";")
 		buffer.WriteString(hop.Params.ToString(';'))
 	}
+}
 
-	return buffer.String()
+// Return the hop's sent-by address (RFC 3261 s. 18.2.2), e.g. 'pc33.atlanta.com:5066', or just the host if the
+// hop has no port. IPv6 hosts are enclosed in brackets, e.g. '[2001:db8::1]:5060'.
+func (hop *ViaHop) SentBy() string {
+	if hop.Port == nil {
+		return hostString(hop.Host)
+	}
+	return hostString(hop.Host) + ":" + strconv.FormatUint(uint64(*hop.Port), 10)
 }
 
 // Return an exact copy of this ViaHop.
@@ -607,21 +1665,155 @@ func (hop *ViaHop) Copy() *ViaHop {
 	}
 }
 
+// Return the value of the 'received' parameter, which a server adds to record the source IP address
+// from which it actually received the request (RFC 3261 s. 18.2.1). Returns NoString if it is absent.
+func (hop *ViaHop) Received() MaybeString {
+	if hop.Params != nil {
+		if received, ok := hop.Params.Get("received"); ok {
+			return received
+		}
+	}
+	return NoString{}
+}
+
+// Set the 'received' parameter to the given IP address, replacing any existing value.
+func (hop *ViaHop) SetReceived(ip string) {
+	if hop.Params == nil {
+		hop.Params = NewParams()
+	}
+	hop.Params.Add("received", String{ip})
+}
+
+// Return the value of the 'rport' parameter (RFC 3581), which a server fills in with the source port
+// from which it received the request. ok is true if the parameter is present; if it has no value, as when
+// a client is asking the server to fill it in, the returned port is 0. ok is false if the value is not a valid port.
+func (hop *ViaHop) RPort() (port uint16, ok bool) {
+	if hop.Params == nil {
+		return 0, false
+	}
+	value, present := hop.Params.Get("rport")
+	if !present {
+		return 0, false
+	}
+
+	text, hasValue := value.(String)
+	if !hasValue {
+		return 0, true
+	}
+	parsed, err := strconv.ParseUint(text.S, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(parsed), true
+}
+
+// Set the 'rport' parameter to the given port, replacing any existing value.
+// A port of 0 adds the valueless ';rport' form, which asks the server to fill in the port.
+func (hop *ViaHop) SetRPort(port uint16) {
+	if hop.Params == nil {
+		hop.Params = NewParams()
+	}
+	if port == 0 {
+		hop.Params.Add("rport", NoString{})
+	} else {
+		hop.Params.Add("rport", String{strconv.FormatUint(uint64(port), 10)})
+	}
+}
+
+// Return the host given by the 'maddr' parameter, the multicast address to which responses should be sent
+// (RFC 3261 s. 18.2.2). ok is false if the parameter is absent or its value is not a valid host.
+func (hop *ViaHop) Maddr() (host string, ok bool) {
+	if hop.Params == nil {
+		return "", false
+	}
+	value, present := hop.Params.Get("maddr")
+	if !present {
+		return "", false
+	}
+	return parseMaddr(value)
+}
+
+// Return the value of the 'ttl' parameter, the time-to-live for multicast responses (RFC 3261 s. 18.2.2).
+// ok is false if the parameter is absent or its value is not an integer from 0 to 255.
+func (hop *ViaHop) TTL() (ttl uint8, ok bool) {
+	if hop.Params == nil {
+		return 0, false
+	}
+	value, present := hop.Params.Get("ttl")
+	if !present {
+		return 0, false
+	}
+	return parseTTL(value)
+}
+
 func (via ViaHeader) String() string {
 	var buffer bytes.Buffer
+	via.WriteToBuffer(&buffer)
+	return buffer.String()
+}
+
+// Implements BufferWriter.
+func (via ViaHeader) WriteToBuffer(buffer *bytes.Buffer) {
+	via.writeToBufferWithStyle(buffer, ViaCommaList)
+}
+
+// Determines how a Via header with several hops is serialized.
+type ViaStyle int
+
+const (
+	// All hops on a single Via line, separated by commas.
+	ViaCommaList ViaStyle = iota
+
+	// Each hop on its own Via line, for stacks which do not accept comma-separated Via values.
+	ViaSeparateLines
+)
+
+// As String, but with the hops laid out according to the given style.
+// With ViaSeparateLines, the lines are separated by CRLFs, but the last line has no trailing CRLF.
+func (via ViaHeader) StringWithStyle(style ViaStyle) string {
+	var buffer bytes.Buffer
+	via.writeToBufferWithStyle(&buffer, style)
+	return buffer.String()
+}
+
+func (via ViaHeader) writeToBufferWithStyle(buffer *bytes.Buffer, style ViaStyle) {
 	buffer.WriteString("Via: ")
 	for idx, hop := range via {
-		buffer.WriteString(hop.String())
+		hop.writeToBuffer(buffer)
 		if idx != len(via)-1 {
-			buffer.WriteString(", ")
+			if style == ViaSeparateLines {
+				buffer.WriteString("\r\nVia: ")
+			} else {
+				buffer.WriteString(", ")
+			}
 		}
 	}
-
-	return buffer.String()
 }
 
 func (h ViaHeader) Name() string { return "Via" }
 
+// The prefix of every branch parameter generated by an RFC 3261 element (RFC 3261 s. 8.1.1.7).
+const RFC3261BranchMagicCookie = "z9hG4bK"
+
+// Determine whether the given branch parameter value begins with the RFC 3261 magic cookie.
+// If so, the branch uniquely identifies a transaction, and can be used to match requests and responses to it
+// (RFC 3261 s. 17.1.3 and 17.2.3); if not, the request came from an older RFC 2543 element, and transactions
+// must be matched on other fields instead. The comparison is case-sensitive.
+func IsRFC3261Branch(branch string) bool {
+	return strings.HasPrefix(branch, RFC3261BranchMagicCookie)
+}
+
+// Determine whether the topmost hop of this Via header has a branch parameter beginning with the RFC 3261 magic
+// cookie (see IsRFC3261Branch). Returns false if the header has no hops, or the topmost hop has no branch.
+func (via ViaHeader) IsRFC3261Compliant() bool {
+	if len(via) == 0 || via[0].Params == nil {
+		return false
+	}
+	branch, ok := via[0].Params.Get("branch")
+	text, hasValue := branch.(String)
+	return ok && hasValue && IsRFC3261Branch(text.S)
+}
+
 func (h ViaHeader) Copy() SipHeader {
 	dup := make([]*ViaHop, 0, len(h))
 	for _, hop := range h {
@@ -699,3 +1891,1089 @@ func (h *UnsupportedHeader) Copy() SipHeader {
 	copy(h.Options, dup)
 	return &UnsupportedHeader{dup}
 }
+
+// P-Visited-Network-ID header (RFC 3455 s. 4.3), used by IMS to identify the visited network when roaming.
+// The header holds a comma-separated list of network identifiers, each of which may carry parameters.
+type PVisitedNetworkID []*VisitedNetwork
+
+// A single network identifier in a P-Visited-Network-ID header.
+type VisitedNetwork struct {
+	// The network identifier, e.g. 'other.net'. If the identifier was a quoted string, this holds
+	// its contents without the surrounding quotes.
+	Network string
+
+	// True if and only if the network identifier is a quoted string rather than a token.
+	Quoted bool
+
+	// Any parameters present on this network identifier.
+	Params Params
+}
+
+func (network *VisitedNetwork) String() string {
+	var buffer bytes.Buffer
+	if network.Quoted {
+		buffer.WriteString(quoteString(network.Network))
+	} else {
+		buffer.WriteString(network.Network)
+	}
+
+	if (network.Params != nil) && (network.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(network.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+// Return an exact copy of this network identifier.
+func (network *VisitedNetwork) Copy() *VisitedNetwork {
+	return &VisitedNetwork{network.Network, network.Quoted, copyWithNil(network.Params)}
+}
+
+func (header PVisitedNetworkID) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("P-Visited-Network-ID: ")
+	for idx, network := range header {
+		buffer.WriteString(network.String())
+		if idx != len(header)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+
+	return buffer.String()
+}
+
+func (h PVisitedNetworkID) Name() string { return "P-Visited-Network-ID" }
+
+func (h PVisitedNetworkID) Copy() SipHeader {
+	dup := make([]*VisitedNetwork, 0, len(h))
+	for _, network := range h {
+		dup = append(dup, network.Copy())
+	}
+	return PVisitedNetworkID(dup)
+}
+
+// Accept-Contact header (RFC 3841 s. 9.2), listing caller preferences for the UAs a request should reach.
+type AcceptContactHeader []*CallerPrefEntry
+
+// Reject-Contact header (RFC 3841 s. 9.3), listing caller preferences for the UAs a request should not reach.
+type RejectContactHeader []*CallerPrefEntry
+
+// A single '*'-prefixed entry in an Accept-Contact or Reject-Contact header, e.g. '*;video;!audio;require'.
+// Each feature parameter is a predicate over a UA's capabilities (RFC 3840). A bare tag (e.g. 'video') requires
+// the feature to be present; a negated tag (e.g. '!audio') requires it to be absent; and a quoted list
+// (e.g. 'language="en,fr"') requires it to take one of the listed values.
+// The 'require' and 'explicit' flags modify how the entry is applied, and are not themselves predicates.
+type CallerPrefEntry struct {
+	Params Params
+}
+
+func (entry *CallerPrefEntry) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("*")
+
+	if entry.Params == nil {
+		return buffer.String()
+	}
+
+	// Feature values are always quoted (RFC 3840 s. 9), so we do not use Params.ToString here.
+	for _, key := range entry.Params.Keys() {
+		buffer.WriteString(";")
+		buffer.WriteString(key)
+		if value, ok := entry.Params.Get(key); ok {
+			switch value := value.(type) {
+			case String:
+				buffer.WriteString(fmt.Sprintf("=\"%s\"", value.S))
+			}
+		}
+	}
+
+	return buffer.String()
+}
+
+// Return an exact copy of this entry.
+func (entry *CallerPrefEntry) Copy() *CallerPrefEntry {
+	return &CallerPrefEntry{copyWithNil(entry.Params)}
+}
+
+// Determine whether a UA with the given capabilities satisfies every feature predicate in this entry.
+func (entry *CallerPrefEntry) Matches(caps Params) bool {
+	if entry.Params == nil {
+		return true
+	}
+	if caps == nil {
+		caps = NewParams()
+	}
+
+	for _, key := range entry.Params.Keys() {
+		if key == "require" || key == "explicit" {
+			continue
+		}
+
+		if strings.HasPrefix(key, "!") {
+			if _, present := caps.Get(key[1:]); present {
+				return false
+			}
+			continue
+		}
+
+		capValue, present := caps.Get(key)
+		if !present {
+			return false
+		}
+
+		wanted, _ := entry.Params.Get(key)
+		wantedList, ok := wanted.(String)
+		if !ok {
+			// A bare feature tag only requires the feature to be present.
+			continue
+		}
+		offeredList, ok := capValue.(String)
+		if !ok || !listsIntersect(wantedList.S, offeredList.S) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Determine whether two comma-separated lists share any element, ignoring surrounding whitespace.
+func listsIntersect(a string, b string) bool {
+	for _, x := range strings.Split(a, ",") {
+		for _, y := range strings.Split(b, ",") {
+			if strings.TrimSpace(x) == strings.TrimSpace(y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func callerPrefsString(entries []*CallerPrefEntry) string {
+	var buffer bytes.Buffer
+	for idx, entry := range entries {
+		buffer.WriteString(entry.String())
+		if idx != len(entries)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	return buffer.String()
+}
+
+func copyCallerPrefs(entries []*CallerPrefEntry) []*CallerPrefEntry {
+	dup := make([]*CallerPrefEntry, 0, len(entries))
+	for _, entry := range entries {
+		dup = append(dup, entry.Copy())
+	}
+	return dup
+}
+
+func (header AcceptContactHeader) String() string {
+	return "Accept-Contact: " + callerPrefsString(header)
+}
+
+func (h AcceptContactHeader) Name() string { return "Accept-Contact" }
+
+func (h AcceptContactHeader) Copy() SipHeader {
+	return AcceptContactHeader(copyCallerPrefs(h))
+}
+
+func (header RejectContactHeader) String() string {
+	return "Reject-Contact: " + callerPrefsString(header)
+}
+
+func (h RejectContactHeader) Name() string { return "Reject-Contact" }
+
+func (h RejectContactHeader) Copy() SipHeader {
+	return RejectContactHeader(copyCallerPrefs(h))
+}
+
+// Content-Type header (RFC 3261 s. 20.15), describing the media type of the message body.
+type ContentType struct {
+	// The media type and subtype, e.g. 'application/sdp'.
+	MediaType string
+
+	// Any parameters present on the media type, e.g. 'charset' or 'boundary'.
+	Params Params
+}
+
+func (contentType *ContentType) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Content-Type: ")
+	buffer.WriteString(contentType.MediaType)
+
+	if (contentType.Params != nil) && (contentType.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(contentType.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *ContentType) Name() string { return "Content-Type" }
+
+func (h *ContentType) Copy() SipHeader {
+	return &ContentType{h.MediaType, copyWithNil(h.Params)}
+}
+
+// Accept-Encoding header (RFC 3261 s. 20.2), listing the content codings the sender can accept.
+// Parsed headers keep the order in which the codings were written; Sorted gives them most preferred first.
+type AcceptEncodingHeader []*QualifiedValue
+
+// Accept-Language header (RFC 3261 s. 20.3), listing the languages the sender would prefer for reason phrases,
+// session descriptions and the like. Parsed headers keep the order in which the languages were written; Sorted
+// gives them most preferred first.
+type AcceptLanguageHeader []*QualifiedValue
+
+// A single entry in a comma-separated list of preferences, e.g. 'gzip;q=0.5' or 'en-gb;q=0.8'.
+type QualifiedValue struct {
+	// The value being qualified, e.g. a content coding or a language range.
+	Value string
+
+	// The q-value (RFC 3261 s. 20.1) expressing how strongly this value is preferred, between 0 and 1.
+	// A value which has no explicit q-value has a q-value of 1.
+	Q float32
+
+	// Any parameters present on the entry other than q.
+	Params Params
+}
+
+func (qualified *QualifiedValue) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(qualified.Value)
+
+	if qualified.Q != 1 {
+		buffer.WriteString(";q=")
+		buffer.WriteString(strconv.FormatFloat(float64(qualified.Q), 'f', -1, 32))
+	}
+
+	if (qualified.Params != nil) && (qualified.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(qualified.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+// Return an exact copy of this entry.
+func (qualified *QualifiedValue) Copy() *QualifiedValue {
+	return &QualifiedValue{qualified.Value, qualified.Q, copyWithNil(qualified.Params)}
+}
+
+// Return the q-value (RFC 3261 s. 20.1) held in the 'q' parameter of the given parameters, which is matched
+// case-insensitively. Returns ok=false if the parameter is absent, has no value, or does not hold a valid q-value:
+// a number between 0 and 1 with at most three decimal places (RFC 3261 s. 25.1).
+func ParseQValue(params Params) (q float32, ok bool) {
+	if params == nil {
+		return 0, false
+	}
+
+	for _, key := range params.Keys() {
+		if strings.ToLower(key) != "q" {
+			continue
+		}
+		value, _ := params.Get(key)
+		text, hasValue := value.(String)
+		if !hasValue {
+			return 0, false
+		}
+		return parseQValueText(text.S)
+	}
+
+	return 0, false
+}
+
+// Parse the text of a q-value, e.g. '0.5', checking it against the grammar in RFC 3261 s. 25.1.
+func parseQValueText(text string) (q float32, ok bool) {
+	intPart, fraction := text, ""
+	if dotIdx := strings.Index(text, "."); dotIdx != -1 {
+		intPart, fraction = text[:dotIdx], text[dotIdx+1:]
+	}
+	if (intPart != "0" && intPart != "1") || len(fraction) > 3 ||
+		strings.Trim(fraction, "0123456789") != "" ||
+		(intPart == "1" && strings.Trim(fraction, "0") != "") {
+		return 0, false
+	}
+
+	value, err := strconv.ParseFloat(text, 32)
+	if err != nil {
+		return 0, false
+	}
+	return float32(value), true
+}
+
+// Sort the given slice by q-value, most preferred first, where q returns the q-value of the i'th element.
+// Elements with equal q-values keep their original order, so the sender's ordering breaks ties.
+// The slice argument must be a slice, as for sort.SliceStable.
+func SortByQ(slice interface{}, q func(i int) float32) {
+	sort.SliceStable(slice, func(i, j int) bool {
+		return q(i) > q(j)
+	})
+}
+
+func qualifiedValuesString(values []*QualifiedValue) string {
+	var buffer bytes.Buffer
+	for idx, value := range values {
+		buffer.WriteString(value.String())
+		if idx != len(values)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	return buffer.String()
+}
+
+func copyQualifiedValues(values []*QualifiedValue) []*QualifiedValue {
+	dup := make([]*QualifiedValue, 0, len(values))
+	for _, value := range values {
+		dup = append(dup, value.Copy())
+	}
+	return dup
+}
+
+func sortedQualifiedValues(values []*QualifiedValue) []*QualifiedValue {
+	sorted := append([]*QualifiedValue{}, values...)
+	SortByQ(sorted, func(i int) float32 { return sorted[i].Q })
+	return sorted
+}
+
+func (header AcceptEncodingHeader) String() string {
+	return "Accept-Encoding: " + qualifiedValuesString(header)
+}
+
+func (h AcceptEncodingHeader) Name() string { return "Accept-Encoding" }
+
+func (h AcceptEncodingHeader) Copy() SipHeader {
+	return AcceptEncodingHeader(copyQualifiedValues(h))
+}
+
+// Returns the content codings sorted by q-value, most preferred first, leaving the header itself unchanged.
+// Codings with equal q-values keep the order in which they were written.
+func (h AcceptEncodingHeader) Sorted() []*QualifiedValue {
+	return sortedQualifiedValues(h)
+}
+
+func (header AcceptLanguageHeader) String() string {
+	return "Accept-Language: " + qualifiedValuesString(header)
+}
+
+func (h AcceptLanguageHeader) Name() string { return "Accept-Language" }
+
+func (h AcceptLanguageHeader) Copy() SipHeader {
+	return AcceptLanguageHeader(copyQualifiedValues(h))
+}
+
+// Returns the language ranges sorted by q-value, most preferred first, leaving the header itself unchanged.
+// Ranges with equal q-values keep the order in which they were written.
+func (h AcceptLanguageHeader) Sorted() []*QualifiedValue {
+	return sortedQualifiedValues(h)
+}
+
+// Accept header (RFC 3261 s. 20.1), listing the media types the sender can accept in message bodies,
+// e.g. 'application/sdp', most preferred first. An empty list means that no body is acceptable.
+type AcceptHeader []*QualifiedValue
+
+func (header AcceptHeader) String() string {
+	return "Accept: " + qualifiedValuesString(header)
+}
+
+func (h AcceptHeader) Name() string { return "Accept" }
+
+func (h AcceptHeader) Copy() SipHeader {
+	return AcceptHeader(copyQualifiedValues(h))
+}
+
+// Allow header (RFC 3261 s. 20.5), listing the methods supported by the sender.
+type AllowHeader []Method
+
+func (header AllowHeader) String() string {
+	methods := make([]string, 0, len(header))
+	for _, method := range header {
+		methods = append(methods, string(method))
+	}
+	return "Allow: " + strings.Join(methods, ", ")
+}
+
+func (h AllowHeader) Name() string { return "Allow" }
+
+func (h AllowHeader) Copy() SipHeader {
+	dup := make([]Method, len(h))
+	copy(dup, h)
+	return AllowHeader(dup)
+}
+
+// Content-Encoding header (RFC 3261 s. 20.12), listing the content codings applied to the message body,
+// in the order they were applied.
+type ContentEncodingHeader []string
+
+func (header ContentEncodingHeader) String() string {
+	return "Content-Encoding: " + strings.Join(header, ", ")
+}
+
+func (h ContentEncodingHeader) Name() string { return "Content-Encoding" }
+
+func (h ContentEncodingHeader) Copy() SipHeader {
+	dup := make([]string, len(h))
+	copy(dup, h)
+	return ContentEncodingHeader(dup)
+}
+
+// Event header (RFC 6665 s. 8.2.1), identifying the event package of a SUBSCRIBE or NOTIFY.
+type EventHeader struct {
+	// The name of the event package, e.g. 'presence'.
+	EventType string
+
+	// Any parameters present in the header, e.g. 'id'.
+	Params Params
+}
+
+func (event *EventHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Event: ")
+	buffer.WriteString(event.EventType)
+
+	if (event.Params != nil) && (event.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(event.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *EventHeader) Name() string { return "Event" }
+
+func (h *EventHeader) Copy() SipHeader {
+	return &EventHeader{h.EventType, copyWithNil(h.Params)}
+}
+
+// Allow-Events header (RFC 6665 s. 8.2.2), listing the event packages the sender supports.
+type AllowEventsHeader []string
+
+func (header AllowEventsHeader) String() string {
+	return "Allow-Events: " + strings.Join(header, ", ")
+}
+
+func (h AllowEventsHeader) Name() string { return "Allow-Events" }
+
+func (h AllowEventsHeader) Copy() SipHeader {
+	dup := make([]string, len(h))
+	copy(dup, h)
+	return AllowEventsHeader(dup)
+}
+
+// In-Reply-To header (RFC 3261 s. 20.21), listing the Call-Ids of the calls that this call references or returns.
+type InReplyToHeader []CallId
+
+func (header InReplyToHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("In-Reply-To: ")
+	for idx, callId := range header {
+		buffer.WriteString(string(callId))
+		if idx != len(header)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	return buffer.String()
+}
+
+func (h InReplyToHeader) Name() string { return "In-Reply-To" }
+
+func (h InReplyToHeader) Copy() SipHeader {
+	dup := make([]CallId, len(h))
+	copy(dup, h)
+	return InReplyToHeader(dup)
+}
+
+// Return the 'id' parameter of the Event header, which distinguishes multiple subscriptions
+// to the same event package within a dialog. Returns NoString if the parameter is absent.
+func (h *EventHeader) Id() MaybeString {
+	if h.Params != nil {
+		if id, ok := h.Params.Get("id"); ok {
+			return id
+		}
+	}
+	return NoString{}
+}
+
+// Refer-To header (RFC 3515 s. 2.1), giving the URI that the recipient of a REFER should contact.
+// The URI may carry embedded headers (e.g. '?Replaces=...'); these are available in its Headers params.
+type ReferToHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+func (referTo *ReferToHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Refer-To: ")
+
+	switch s := referTo.DisplayName.(type) {
+	case String:
+		buffer.WriteString(quoteString(s.String()) + " ")
+	}
+
+	buffer.WriteString(fmt.Sprintf("<%s>", referTo.Address))
+
+	if (referTo.Params != nil) && (referTo.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(referTo.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *ReferToHeader) Name() string { return "Refer-To" }
+
+// Copy the header.
+func (h *ReferToHeader) Copy() SipHeader {
+	return &ReferToHeader{h.DisplayName, h.Address.Copy(), copyWithNil(h.Params)}
+}
+
+// Referred-By header (RFC 3892 s. 3), identifying the party that sent a REFER.
+type ReferredByHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+func (referredBy *ReferredByHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Referred-By: ")
+
+	switch s := referredBy.DisplayName.(type) {
+	case String:
+		buffer.WriteString(quoteString(s.String()) + " ")
+	}
+
+	buffer.WriteString(fmt.Sprintf("<%s>", referredBy.Address))
+
+	if (referredBy.Params != nil) && (referredBy.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(referredBy.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *ReferredByHeader) Name() string { return "Referred-By" }
+
+// Copy the header.
+func (h *ReferredByHeader) Copy() SipHeader {
+	return &ReferredByHeader{h.DisplayName, h.Address.Copy(), copyWithNil(h.Params)}
+}
+
+// Reply-To header (RFC 3261 s. 20.31), giving a logical return URI that may differ from the From header.
+type ReplyToHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+func (replyTo *ReplyToHeader) String() string {
+	return "Reply-To: " + nameAddrString(replyTo.DisplayName, replyTo.Address, replyTo.Params)
+}
+
+func (h *ReplyToHeader) Name() string { return "Reply-To" }
+
+// Copy the header.
+func (h *ReplyToHeader) Copy() SipHeader {
+	return &ReplyToHeader{h.DisplayName, h.Address.Copy(), copyWithNil(h.Params)}
+}
+
+// Organization header (RFC 3261 s. 20.25), naming the organization to which the sender belongs.
+type OrganizationHeader string
+
+func (header OrganizationHeader) String() string {
+	return "Organization: " + string(header)
+}
+
+func (h OrganizationHeader) Name() string { return "Organization" }
+
+func (h OrganizationHeader) Copy() SipHeader { return h }
+
+// Subject header (RFC 3261 s. 20.36), summarizing the nature of the call.
+type SubjectHeader string
+
+func (header SubjectHeader) String() string {
+	return "Subject: " + string(header)
+}
+
+func (h SubjectHeader) Name() string { return "Subject" }
+
+func (h SubjectHeader) Copy() SipHeader { return h }
+
+// Priority header (RFC 3261 s. 20.26), indicating the urgency of the request as perceived by the client.
+// The value is usually one of the Priority constants below, but may be any token.
+type PriorityHeader string
+
+const (
+	PriorityEmergency PriorityHeader = "emergency"
+	PriorityUrgent    PriorityHeader = "urgent"
+	PriorityNormal    PriorityHeader = "normal"
+	PriorityNonUrgent PriorityHeader = "non-urgent"
+)
+
+func (header PriorityHeader) String() string {
+	return "Priority: " + string(header)
+}
+
+func (h PriorityHeader) Name() string { return "Priority" }
+
+func (h PriorityHeader) Copy() SipHeader { return h }
+
+// SIP-ETag header (RFC 3903 s. 11.3.1), carrying the entity-tag that an event state compositor assigns to
+// published event state in its 2xx response to a PUBLISH.
+type SIPETagHeader string
+
+func (header SIPETagHeader) String() string {
+	return "SIP-ETag: " + string(header)
+}
+
+func (h SIPETagHeader) Name() string { return "SIP-ETag" }
+
+func (h SIPETagHeader) Copy() SipHeader { return h }
+
+// SIP-If-Match header (RFC 3903 s. 11.3.2), identifying by its entity-tag the published event state which
+// a PUBLISH request refreshes, modifies or removes.
+type SIPIfMatchHeader string
+
+func (header SIPIfMatchHeader) String() string {
+	return "SIP-If-Match: " + string(header)
+}
+
+func (h SIPIfMatchHeader) Name() string { return "SIP-If-Match" }
+
+func (h SIPIfMatchHeader) Copy() SipHeader { return h }
+
+// Refer-Sub header (RFC 4488 s. 4), indicating whether a REFER should create an implicit subscription.
+type ReferSub struct {
+	// False if and only if the implicit subscription is to be suppressed.
+	Value bool
+
+	// Any extension parameters present in the header.
+	Params Params
+}
+
+func (referSub *ReferSub) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Refer-Sub: %t", referSub.Value))
+
+	if (referSub.Params != nil) && (referSub.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(referSub.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *ReferSub) Name() string { return "Refer-Sub" }
+
+func (h *ReferSub) Copy() SipHeader {
+	return &ReferSub{h.Value, copyWithNil(h.Params)}
+}
+
+// Content-Disposition header (RFC 3261 s. 20.11), describing how the message body should be interpreted,
+// e.g. 'session' for an SDP offer. The 'handling' parameter, if present, is kept in Params.
+type ContentDispositionHeader struct {
+	// The disposition type, e.g. 'session' or 'render'.
+	DispositionType string
+
+	// Any parameters present in the header, e.g. 'handling'.
+	Params Params
+}
+
+func (contentDisposition *ContentDispositionHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Content-Disposition: ")
+	buffer.WriteString(contentDisposition.DispositionType)
+
+	if (contentDisposition.Params != nil) && (contentDisposition.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(contentDisposition.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *ContentDispositionHeader) Name() string { return "Content-Disposition" }
+
+func (h *ContentDispositionHeader) Copy() SipHeader {
+	return &ContentDispositionHeader{h.DispositionType, copyWithNil(h.Params)}
+}
+
+// Replaces header (RFC 3891 s. 6.1), identifying an existing dialog which the new dialog should replace.
+// The to-tag and from-tag parameters are mandatory; any others (e.g. 'early-only') are also kept in Params.
+type ReplacesHeader struct {
+	// The Call-Id of the dialog to be replaced.
+	CallId CallId
+
+	// The parameters of the header, including the to-tag and from-tag.
+	Params Params
+}
+
+func (replaces *ReplacesHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("Replaces: ")
+	buffer.WriteString(string(replaces.CallId))
+
+	if (replaces.Params != nil) && (replaces.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(replaces.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+func (h *ReplacesHeader) Name() string { return "Replaces" }
+
+func (h *ReplacesHeader) Copy() SipHeader {
+	return &ReplacesHeader{h.CallId, copyWithNil(h.Params)}
+}
+
+// Return the local tag of the dialog to be replaced, from the perspective of the UA receiving the
+// Replaces header. Returns NoString if the parameter is absent.
+func (h *ReplacesHeader) ToTag() MaybeString {
+	return h.param("to-tag")
+}
+
+// Return the remote tag of the dialog to be replaced, from the perspective of the UA receiving the
+// Replaces header. Returns NoString if the parameter is absent.
+func (h *ReplacesHeader) FromTag() MaybeString {
+	return h.param("from-tag")
+}
+
+// Determine whether the 'early-only' flag is set, meaning that only an early dialog may be replaced.
+func (h *ReplacesHeader) EarlyOnly() bool {
+	if h.Params == nil {
+		return false
+	}
+	_, ok := h.Params.Get("early-only")
+	return ok
+}
+
+func (h *ReplacesHeader) param(key string) MaybeString {
+	if h.Params != nil {
+		if value, ok := h.Params.Get(key); ok {
+			return value
+		}
+	}
+	return NoString{}
+}
+
+// Route header (RFC 3261 s. 20.34), giving one hop of the route a request should take.
+// A message's route set is expressed as a sequence of these headers, one per hop.
+type RouteHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+func (route *RouteHeader) String() string {
+	return "Route: " + nameAddrString(route.DisplayName, route.Address, route.Params)
+}
+
+func (h *RouteHeader) Name() string { return "Route" }
+
+func (h *RouteHeader) Copy() SipHeader {
+	return &RouteHeader{h.DisplayName, h.Address.Copy(), copyWithNil(h.Params)}
+}
+
+// Record-Route header (RFC 3261 s. 20.30), inserted by a proxy which wishes to remain on the path
+// of subsequent requests within a dialog. There is one header per proxy.
+type RecordRouteHeader struct {
+	// The display name from the header, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present in the header.
+	Params Params
+}
+
+func (recordRoute *RecordRouteHeader) String() string {
+	return "Record-Route: " + nameAddrString(recordRoute.DisplayName, recordRoute.Address, recordRoute.Params)
+}
+
+func (h *RecordRouteHeader) Name() string { return "Record-Route" }
+
+func (h *RecordRouteHeader) Copy() SipHeader {
+	return &RecordRouteHeader{h.DisplayName, h.Address.Copy(), copyWithNil(h.Params)}
+}
+
+// P-Asserted-Identity header (RFC 3325 s. 9.1), conveying the identity of the user as asserted by a trusted
+// network element. It may contain up to two identities: at most one SIP or SIPS URI, and at most one tel URI.
+type PAssertedIdentityHeader []*NameAddr
+
+// A display name, URI and parameters as found in a single entry of a name-addr list, e.g.
+// '"Cullen Jennings" <sip:fluffy@cisco.com>'.
+type NameAddr struct {
+	// The display name, may be omitted.
+	DisplayName MaybeString
+
+	Address Uri
+
+	// Any parameters present on the entry.
+	Params Params
+}
+
+func (nameAddr *NameAddr) String() string {
+	return nameAddrString(nameAddr.DisplayName, nameAddr.Address, nameAddr.Params)
+}
+
+// Return an exact copy of this entry.
+func (nameAddr *NameAddr) Copy() *NameAddr {
+	return &NameAddr{nameAddr.DisplayName, nameAddr.Address.Copy(), copyWithNil(nameAddr.Params)}
+}
+
+func (header PAssertedIdentityHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("P-Asserted-Identity: ")
+	for idx, identity := range header {
+		buffer.WriteString(identity.String())
+		if idx != len(header)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	return buffer.String()
+}
+
+func (h PAssertedIdentityHeader) Name() string { return "P-Asserted-Identity" }
+
+func (h PAssertedIdentityHeader) Copy() SipHeader {
+	dup := make([]*NameAddr, 0, len(h))
+	for _, identity := range h {
+		dup = append(dup, identity.Copy())
+	}
+	return PAssertedIdentityHeader(dup)
+}
+
+// Alert-Info header (RFC 3261 s. 20.4), giving alternative ring tones for the callee to play.
+type AlertInfoHeader []*InfoEntry
+
+// Call-Info header (RFC 3261 s. 20.9), giving additional information about the caller or callee,
+// with its 'purpose' parameter describing what each URI is for (e.g. 'icon' or 'card').
+type CallInfoHeader []*InfoEntry
+
+// Error-Info header (RFC 3261 s. 20.18), pointing to additional information about an error response.
+type ErrorInfoHeader []*InfoEntry
+
+// A single entry of an Alert-Info, Call-Info or Error-Info header, e.g. '<http://www.example.com/alice/>;purpose=info'.
+type InfoEntry struct {
+	Address Uri
+
+	// Any parameters present on the entry, e.g. 'purpose'.
+	Params Params
+}
+
+func (entry *InfoEntry) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("<%s>", entry.Address))
+
+	if (entry.Params != nil) && (entry.Params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(entry.Params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+// Return an exact copy of this entry.
+func (entry *InfoEntry) Copy() *InfoEntry {
+	return &InfoEntry{entry.Address.Copy(), copyWithNil(entry.Params)}
+}
+
+func infoEntriesString(entries []*InfoEntry) string {
+	var buffer bytes.Buffer
+	for idx, entry := range entries {
+		buffer.WriteString(entry.String())
+		if idx != len(entries)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+	return buffer.String()
+}
+
+func copyInfoEntries(entries []*InfoEntry) []*InfoEntry {
+	dup := make([]*InfoEntry, 0, len(entries))
+	for _, entry := range entries {
+		dup = append(dup, entry.Copy())
+	}
+	return dup
+}
+
+func (header AlertInfoHeader) String() string {
+	return "Alert-Info: " + infoEntriesString(header)
+}
+
+func (h AlertInfoHeader) Name() string { return "Alert-Info" }
+
+func (h AlertInfoHeader) Copy() SipHeader {
+	return AlertInfoHeader(copyInfoEntries(h))
+}
+
+func (header CallInfoHeader) String() string {
+	return "Call-Info: " + infoEntriesString(header)
+}
+
+func (h CallInfoHeader) Name() string { return "Call-Info" }
+
+func (h CallInfoHeader) Copy() SipHeader {
+	return CallInfoHeader(copyInfoEntries(h))
+}
+
+func (header ErrorInfoHeader) String() string {
+	return "Error-Info: " + infoEntriesString(header)
+}
+
+func (h ErrorInfoHeader) Name() string { return "Error-Info" }
+
+func (h ErrorInfoHeader) Copy() SipHeader {
+	return ErrorInfoHeader(copyInfoEntries(h))
+}
+
+// Render a display name, URI and parameters in name-addr form, e.g. '"Bob" <sip:bob@biloxi.com>;lr'.
+func nameAddrString(displayName MaybeString, address Uri, params Params) string {
+	var buffer bytes.Buffer
+
+	switch s := displayName.(type) {
+	case String:
+		buffer.WriteString(quoteString(s.String()) + " ")
+	}
+
+	buffer.WriteString(fmt.Sprintf("<%s>", address))
+
+	if (params != nil) && (params.Length() > 0) {
+		buffer.WriteString(";")
+		buffer.WriteString(params.ToString(';'))
+	}
+
+	return buffer.String()
+}
+
+// An Authorization, Proxy-Authorization, WWW-Authenticate or Proxy-Authenticate header (RFC 3261 s. 20.7, 20.27,
+// 20.28 and 20.44), e.g. 'Authorization: Digest username="bob", realm="biloxi.com", nc=00000001'.
+type AuthHeader struct {
+	// The name of the header, e.g. "Authorization".
+	HeaderName string
+
+	// The authentication scheme, e.g. "Digest".
+	Scheme string
+
+	// The comma-separated parameters following the scheme, e.g. 'realm' and 'nonce'.
+	Params Params
+}
+
+// Parameters whose values are tokens rather than quoted strings (RFC 2617 s. 3.2). 'qop' is a token in credentials,
+// but a quoted list of options in challenges.
+var authTokenParams = map[string]bool{"algorithm": true, "stale": true, "nc": true}
+
+func (h *AuthHeader) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(h.Name() + ": " + h.Scheme)
+
+	if h.Params == nil {
+		return buffer.String()
+	}
+
+	isCredentials := h.Name() == "Authorization" || h.Name() == "Proxy-Authorization"
+	for idx, key := range h.Params.Keys() {
+		if idx == 0 {
+			buffer.WriteString(" ")
+		} else {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(key)
+
+		value, _ := h.Params.Get(key)
+		if s, ok := value.(String); ok {
+			if authTokenParams[key] || (key == "qop" && isCredentials) {
+				buffer.WriteString("=" + s.String())
+			} else {
+				buffer.WriteString("=" + quoteString(s.String()))
+			}
+		}
+	}
+
+	return buffer.String()
+}
+
+// Pull out the header name, in canonical form (e.g. 'WWW-Authenticate').
+func (h *AuthHeader) Name() string {
+	return CanonicalHeaderName(h.HeaderName)
+}
+
+func (h *AuthHeader) Copy() SipHeader {
+	return &AuthHeader{h.HeaderName, h.Scheme, copyWithNil(h.Params)}
+}
+
+// Get the nonce count ('nc') of the credentials: the number of requests, including this one, which the client has
+// sent with the current nonce. Returns ok=false if the parameter is absent or is not a hexadecimal count.
+func (h *AuthHeader) NonceCount() (nc uint32, ok bool) {
+	if h.Params == nil {
+		return 0, false
+	}
+	value, present := h.Params.Get("nc")
+	s, isString := value.(String)
+	if !present || !isString {
+		return 0, false
+	}
+
+	count, err := strconv.ParseUint(s.String(), 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(count), true
+}
+
+// Set the nonce count ('nc') of the credentials, formatted as 8 hex digits as RFC 2617 s. 3.2.2 requires.
+func (h *AuthHeader) SetNonceCount(nc uint32) {
+	if h.Params == nil {
+		h.Params = NewParams()
+	}
+	h.Params.Add("nc", String{fmt.Sprintf("%08x", nc)})
+}
+
+// Increment the nonce count ('nc') of the credentials, as a client does for each request it sends with the same
+// nonce, and return the new count. A missing count is treated as 0, so the first request gets a count of 1.
+func (h *AuthHeader) IncrementNonceCount() uint32 {
+	nc, _ := h.NonceCount()
+	nc++
+	h.SetNonceCount(nc)
+	return nc
+}
+
+// Get the client nonce ('cnonce') of the credentials, if present.
+func (h *AuthHeader) CNonce() (cnonce string, ok bool) {
+	if h.Params == nil {
+		return "", false
+	}
+	value, present := h.Params.Get("cnonce")
+	s, isString := value.(String)
+	if !present || !isString {
+		return "", false
+	}
+	return s.String(), true
+}
+
+// Set the client nonce ('cnonce') of the credentials, which must be present when qop is in use.
+func (h *AuthHeader) SetCNonce(cnonce string) {
+	if h.Params == nil {
+		h.Params = NewParams()
+	}
+	h.Params.Add("cnonce", String{cnonce})
+}
@@ -0,0 +1,637 @@
+package base
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUriParams(t *testing.T) {
+	uri := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com",
+		UriParams: NewParams().Add("ttl", String{"16"}).Add("lr", NoString{}).Add("Transport", String{"udp"}).Add("maddr", String{"239.255.255.1"}),
+		Headers:   noParams}
+
+	if ttl, ok, err := uri.ParamInt("ttl"); err != nil || !ok || ttl != 16 {
+		t.Errorf("expected ttl=16; got %d (ok=%t, err=%v)", ttl, ok, err)
+	}
+	if _, ok, err := uri.ParamInt("method"); err != nil || ok {
+		t.Errorf("expected missing method param to give ok=false and no error; got ok=%t, err=%v", ok, err)
+	}
+	if _, ok, err := uri.ParamInt("maddr"); err == nil || !ok {
+		t.Errorf("expected non-integer maddr param to give ok=true and an error; got ok=%t, err=%v", ok, err)
+	}
+	if _, ok, err := uri.ParamInt("lr"); err == nil || !ok {
+		t.Errorf("expected valueless lr param to give ok=true and an error; got ok=%t, err=%v", ok, err)
+	}
+
+	if transport, ok := uri.ParamString("transport"); !ok || transport != "udp" {
+		t.Errorf("expected transport=udp; got '%s' (ok=%t)", transport, ok)
+	}
+	if lr, ok := uri.ParamString("LR"); !ok || lr != "" {
+		t.Errorf("expected valueless lr param; got '%s' (ok=%t)", lr, ok)
+	}
+	if _, ok := uri.ParamString("user"); ok {
+		t.Errorf("expected missing user param to give ok=false")
+	}
+
+	bare := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com"}
+	if _, ok := bare.ParamString("transport"); ok {
+		t.Errorf("expected URI with nil params to give ok=false")
+	}
+}
+
+func TestTags(t *testing.T) {
+	alice := &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams}
+
+	from := &FromHeader{NoString{}, alice, NewParams().Add("tag", String{"1928301774"})}
+	if tag := from.Tag(); tag != (String{"1928301774"}) {
+		t.Errorf("expected From tag 1928301774; got %v", tag)
+	}
+	from.SetTag("a73kszlfl")
+	if tag := from.Tag(); tag != (String{"a73kszlfl"}) {
+		t.Errorf("expected replaced From tag a73kszlfl; got %v", tag)
+	}
+	if from.Params.Length() != 1 || from.String() != "From: <sip:alice@atlanta.com>;tag=a73kszlfl" {
+		t.Errorf("unexpected From header after replacing tag: %s", from.String())
+	}
+
+	to := &ToHeader{NoString{}, alice, NewParams()}
+	if tag := to.Tag(); tag != (NoString{}) {
+		t.Errorf("expected no To tag; got %v", tag)
+	}
+	to.SetTag("8321234356")
+	if tag := to.Tag(); tag != (String{"8321234356"}) {
+		t.Errorf("expected To tag 8321234356; got %v", tag)
+	}
+
+	bare := &ToHeader{NoString{}, alice, nil}
+	if tag := bare.Tag(); tag != (NoString{}) {
+		t.Errorf("expected no tag on To header with nil params; got %v", tag)
+	}
+	bare.SetTag("xyz")
+	if tag := bare.Tag(); tag != (String{"xyz"}) {
+		t.Errorf("expected To tag xyz after setting on nil params; got %v", tag)
+	}
+}
+
+func TestUriTransport(t *testing.T) {
+	tcp := &SipUri{User: String{"x"}, Password: NoString{}, Host: "h", UriParams: NewParams().Add("transport", String{"tcp"}), Headers: noParams}
+	if transport := tcp.Transport(); transport != (String{"tcp"}) {
+		t.Errorf("expected transport tcp for %s; got %v", tcp.String(), transport)
+	}
+
+	upper := &SipUri{User: String{"x"}, Password: NoString{}, Host: "h", UriParams: NewParams().Add("Transport", String{"TLS"}), Headers: noParams}
+	if transport := upper.Transport(); transport != (String{"tls"}) {
+		t.Errorf("expected transport tls for %s; got %v", upper.String(), transport)
+	}
+
+	none := &SipUri{User: String{"x"}, Password: NoString{}, Host: "h", UriParams: noParams, Headers: noParams}
+	if transport := none.Transport(); transport != (NoString{}) {
+		t.Errorf("expected no transport for %s; got %v", none.String(), transport)
+	}
+}
+
+func TestUriEscaping(t *testing.T) {
+	users := []struct {
+		raw     string
+		escaped string
+	}{
+		{"alice", "alice"},
+		{"alice@home", "alice%40home"},
+		{"a;b?c=d", "a%3Bb%3Fc%3Dd"},
+		{"+1-(555)", "+1-(555)"},
+		{"50% off", "50%25%20off"},
+	}
+
+	for _, user := range users {
+		if escaped := EscapeUser(user.raw); escaped != user.escaped {
+			t.Errorf("expected user %q to escape as %q; got %q", user.raw, user.escaped, escaped)
+		}
+		if unescaped, err := UnescapeUser(user.escaped); err != nil || unescaped != user.raw {
+			t.Errorf("expected %q to unescape as %q; got %q (err=%v)", user.escaped, user.raw, unescaped, err)
+		}
+	}
+
+	if escaped := EscapeParam("a b;c=d"); escaped != "a%20b%3Bc%3Dd" {
+		t.Errorf("expected param to escape as a%%20b%%3Bc%%3Dd; got %q", escaped)
+	}
+	if unescaped, err := UnescapeParam("a%20b"); err != nil || unescaped != "a b" {
+		t.Errorf("expected a%%20b to unescape as \"a b\"; got %q (err=%v)", unescaped, err)
+	}
+
+	for _, bad := range []string{"%", "a%4", "%zz"} {
+		if _, err := UnescapeUser(bad); err == nil {
+			t.Errorf("expected error unescaping %q", bad)
+		}
+	}
+
+	uri := &SipUri{User: String{"a@b;c?d=e"}, Password: String{"p:w"}, Host: "h", UriParams: noParams, Headers: noParams}
+	if uri.String() != "sip:a%40b%3Bc%3Fd%3De:p%3Aw@h" {
+		t.Errorf("expected user and password to be escaped; got %s", uri.String())
+	}
+
+	uri = &SipUri{User: NoString{}, Password: NoString{}, Host: "h",
+		UriParams: NewParams().Add("x", String{"a;b"}).Add("note", String{"two words"}),
+		Headers:   NewParams().Add("subject", String{"fish & chips?"})}
+	if uri.String() != "sip:h;x=a%3Bb;note=two%20words?subject=fish%20%26%20chips?" {
+		t.Errorf("expected URI params and headers to be escaped, not quoted; got %s", uri.String())
+	}
+}
+
+func TestLooseRouter(t *testing.T) {
+	tests := []struct {
+		params Params
+		loose  bool
+	}{
+		{NewParams().Add("lr", NoString{}), true},
+		{NewParams().Add("lr", String{"on"}), true},
+		{NewParams().Add("LR", NoString{}), true},
+		{NewParams().Add("transport", String{"tcp"}), false},
+		{noParams, false},
+	}
+
+	for _, test := range tests {
+		uri := &SipUri{User: NoString{}, Password: NoString{}, Host: "p", UriParams: test.params, Headers: noParams}
+		if uri.IsLooseRouter() != test.loose {
+			t.Errorf("expected IsLooseRouter() to be %t for %s", test.loose, uri.String())
+		}
+	}
+}
+
+func TestMaddrAndTTL(t *testing.T) {
+	tests := []struct {
+		params Params
+		maddr  string
+		ttl    int // -1 if TTL() should not be ok.
+	}{
+		{NewParams().Add("maddr", String{"239.255.255.1"}).Add("ttl", String{"16"}), "239.255.255.1", 16},
+		{NewParams().Add("maddr", String{"sip.example.com"}).Add("ttl", String{"0"}), "sip.example.com", 0},
+		{NewParams().Add("maddr", String{"[ff02::1]"}).Add("ttl", String{"255"}), "ff02::1", 255},
+		{NewParams().Add("ttl", String{"999"}), "", -1},
+		{NewParams().Add("ttl", String{"-1"}), "", -1},
+		{NewParams().Add("ttl", String{"sixteen"}), "", -1},
+		{NewParams().Add("ttl", NoString{}), "", -1},
+		{NewParams().Add("maddr", String{"ff02::1"}), "", -1},
+		{NewParams().Add("maddr", String{"bad host"}), "", -1},
+		{NewParams().Add("maddr", String{"-example.com"}), "", -1},
+		{NewParams().Add("maddr", String{"999.1.1.1"}), "", -1},
+		{NewParams().Add("maddr", NoString{}), "", -1},
+		{noParams, "", -1},
+	}
+
+	for _, test := range tests {
+		uri := &SipUri{User: NoString{}, Password: NoString{}, Host: "p", UriParams: test.params, Headers: noParams}
+		hop := &ViaHop{"SIP", "2.0", "UDP", "p", nil, test.params}
+
+		for _, accessor := range []interface {
+			Maddr() (string, bool)
+			TTL() (uint8, bool)
+		}{uri, hop} {
+			if maddr, ok := accessor.Maddr(); ok != (test.maddr != "") || maddr != test.maddr {
+				t.Errorf("unexpected maddr for params '%s': expected '%s', got '%s' (ok=%t)",
+					test.params.ToString(';'), test.maddr, maddr, ok)
+			}
+			if ttl, ok := accessor.TTL(); ok != (test.ttl != -1) || (ok && int(ttl) != test.ttl) {
+				t.Errorf("unexpected ttl for params '%s': expected %d, got %d (ok=%t)",
+					test.params.ToString(';'), test.ttl, ttl, ok)
+			}
+		}
+	}
+}
+
+func TestViaSentBy(t *testing.T) {
+	port := uint16(5066)
+	tests := []struct {
+		host   string
+		port   *uint16
+		sentBy string
+	}{
+		{"pc33.atlanta.com", nil, "pc33.atlanta.com"},
+		{"pc33.atlanta.com", &port, "pc33.atlanta.com:5066"},
+		{"192.0.2.4", &port, "192.0.2.4:5066"},
+		{"2001:db8::1", nil, "[2001:db8::1]"},
+		{"2001:db8::1", &port, "[2001:db8::1]:5066"},
+	}
+
+	for _, test := range tests {
+		hop := &ViaHop{"SIP", "2.0", "UDP", test.host, test.port, noParams}
+		if sentBy := hop.SentBy(); sentBy != test.sentBy {
+			t.Errorf("expected sent-by '%s' for hop '%s'; got '%s'", test.sentBy, hop.String(), sentBy)
+		}
+	}
+}
+
+func TestRFC3261Branch(t *testing.T) {
+	tests := []struct {
+		branch    MaybeString
+		compliant bool
+	}{
+		{String{"z9hG4bK776asdhds"}, true},
+		{String{"z9hG4bK"}, true},
+		{String{"776asdhds"}, false},
+		{String{"Z9HG4BK776asdhds"}, false},
+		{String{""}, false},
+		{NoString{}, false},
+	}
+
+	for _, test := range tests {
+		if text, ok := test.branch.(String); ok && IsRFC3261Branch(text.S) != test.compliant {
+			t.Errorf("expected IsRFC3261Branch('%s') to be %t", text.S, test.compliant)
+		}
+
+		hop := &ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, NewParams().Add("branch", test.branch)}
+		legacy := &ViaHop{"SIP", "2.0", "UDP", "bigbox3.site3.atlanta.com", nil, NewParams().Add("branch", String{"1"})}
+		if via := (ViaHeader{hop, legacy}); via.IsRFC3261Compliant() != test.compliant {
+			t.Errorf("expected IsRFC3261Compliant() to be %t for %s", test.compliant, via.String())
+		}
+	}
+
+	if (ViaHeader{}).IsRFC3261Compliant() {
+		t.Errorf("expected empty Via header not to be RFC 3261 compliant")
+	}
+	noBranch := &ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, noParams}
+	if (ViaHeader{noBranch}).IsRFC3261Compliant() {
+		t.Errorf("expected Via header with no branch not to be RFC 3261 compliant")
+	}
+}
+
+func TestPhoneNumber(t *testing.T) {
+	tests := []struct {
+		user    MaybeString
+		params  Params
+		isPhone bool
+		number  MaybeString
+	}{
+		{String{"+15551234567"}, NewParams().Add("user", String{"phone"}), true, String{"+15551234567"}},
+		{String{"+15551234567"}, NewParams().Add("transport", String{"tcp"}).Add("User", String{"PHONE"}), true,
+			String{"+15551234567"}},
+		{String{"bob"}, noParams, false, NoString{}},
+		{String{"+15551234567"}, NewParams().Add("user", String{"ip"}), false, NoString{}},
+		{String{"+15551234567"}, NewParams().Add("user", NoString{}), false, NoString{}},
+		{NoString{}, NewParams().Add("user", String{"phone"}), true, NoString{}},
+	}
+
+	for _, test := range tests {
+		uri := &SipUri{User: test.user, Password: NoString{}, Host: "gw.example.com", UriParams: test.params,
+			Headers: noParams}
+		if uri.IsPhoneNumber() != test.isPhone {
+			t.Errorf("expected IsPhoneNumber() to be %t for %s", test.isPhone, uri.String())
+		}
+		if number := uri.PhoneNumber(); number != test.number {
+			t.Errorf("expected phone number %v for %s; got %v", test.number, uri.String(), number)
+		}
+	}
+}
+
+func TestContactExpiresAndQ(t *testing.T) {
+	address := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "192.0.2.4", UriParams: noParams, Headers: noParams}
+
+	contact := &ContactHeader{NoString{}, address, NewParams().Add("expires", String{"3600"}).Add("q", String{"0.5"})}
+	if expires, ok := contact.Expires(); !ok || expires != 3600 {
+		t.Errorf("expected expires=3600; got %d (ok=%t)", expires, ok)
+	}
+	if q, ok := contact.Q(); !ok || q != 0.5 {
+		t.Errorf("expected q=0.5; got %v (ok=%t)", q, ok)
+	}
+
+	absent := &ContactHeader{NoString{}, address, NewParams()}
+	if _, ok := absent.Expires(); ok {
+		t.Errorf("expected no expires on %s", absent.String())
+	}
+	if _, ok := absent.Q(); ok {
+		t.Errorf("expected no q on %s", absent.String())
+	}
+
+	malformed := &ContactHeader{NoString{}, address, NewParams().Add("expires", String{"abc"}).Add("q", String{"1.5"})}
+	if _, ok := malformed.Expires(); ok {
+		t.Errorf("expected malformed expires to be rejected on %s", malformed.String())
+	}
+	if _, ok := malformed.Q(); ok {
+		t.Errorf("expected out-of-range q to be rejected on %s", malformed.String())
+	}
+
+	absent.SetExpires(60)
+	absent.SetQ(0.7)
+	if absent.String() != "Contact: <sip:bob@192.0.2.4>;expires=60;q=0.7" {
+		t.Errorf("unexpected Contact after setting expires and q: %s", absent.String())
+	}
+	if expires, ok := absent.Expires(); !ok || expires != 60 {
+		t.Errorf("expected expires=60 after setting it; got %d (ok=%t)", expires, ok)
+	}
+	if q, ok := absent.Q(); !ok || q != 0.7 {
+		t.Errorf("expected q=0.7 after setting it; got %v (ok=%t)", q, ok)
+	}
+}
+
+func TestParseQValue(t *testing.T) {
+	valid := map[string]float32{"0": 0, "0.5": 0.5, "0.125": 0.125, "1": 1, "1.000": 1}
+	for text, expected := range valid {
+		if q, ok := ParseQValue(NewParams().Add("q", String{text})); !ok || q != expected {
+			t.Errorf("expected q=%s to give %v; got %v (ok=%t)", text, expected, q, ok)
+		}
+	}
+
+	if q, ok := ParseQValue(NewParams().Add("Q", String{"0.3"})); !ok || q != 0.3 {
+		t.Errorf("expected Q=0.3 to give 0.3; got %v (ok=%t)", q, ok)
+	}
+
+	invalid := []string{"1.5", "2", "-0.5", "0.1234", "abc", "", ".5"}
+	for _, text := range invalid {
+		if q, ok := ParseQValue(NewParams().Add("q", String{text})); ok {
+			t.Errorf("expected q=%s to be rejected; got %v", text, q)
+		}
+	}
+
+	if _, ok := ParseQValue(NewParams().Add("q", NoString{})); ok {
+		t.Errorf("expected valueless q to be rejected")
+	}
+	if _, ok := ParseQValue(NewParams().Add("expires", String{"60"})); ok {
+		t.Errorf("expected missing q to be rejected")
+	}
+	if _, ok := ParseQValue(nil); ok {
+		t.Errorf("expected nil params to be rejected")
+	}
+}
+
+func TestSortByQ(t *testing.T) {
+	values := []*QualifiedValue{
+		&QualifiedValue{"a", 0.5, NewParams()},
+		&QualifiedValue{"b", 1, NewParams()},
+		&QualifiedValue{"c", 0.5, NewParams()},
+		&QualifiedValue{"d", 0, NewParams()},
+		&QualifiedValue{"e", 1, NewParams()},
+	}
+	SortByQ(values, func(i int) float32 { return values[i].Q })
+
+	order := ""
+	for _, value := range values {
+		order += value.Value
+	}
+	if order != "beacd" {
+		t.Errorf("expected values sorted as 'beacd'; got '%s'", order)
+	}
+}
+
+func TestContactSetQRoundTrip(t *testing.T) {
+	address := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "192.0.2.4", UriParams: noParams, Headers: noParams}
+	tests := []struct {
+		q        float32
+		text     string
+		expected float32
+	}{
+		{1.0 / 3, "0.333", 0.333},
+		{0.5, "0.5", 0.5},
+		{0.9999, "1", 1},
+		{1, "1", 1},
+		{0, "0", 0},
+		{1.5, "1", 1},
+		{-0.5, "0", 0},
+	}
+
+	for _, test := range tests {
+		contact := &ContactHeader{NoString{}, address, NewParams()}
+		contact.SetQ(test.q)
+		if text, _ := contact.Params.Get("q"); text != (String{test.text}) {
+			t.Errorf("expected SetQ(%v) to write q=%s; got %v", test.q, test.text, text)
+		}
+		if q, ok := contact.Q(); !ok || q != test.expected {
+			t.Errorf("expected q=%v after SetQ(%v); got %v (ok=%t)", test.expected, test.q, q, ok)
+		}
+	}
+}
+
+func TestContactIsDeregistration(t *testing.T) {
+	address := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "192.0.2.4", UriParams: noParams, Headers: noParams}
+
+	removal := &ContactHeader{NoString{}, address, NewParams().Add("expires", String{"0"})}
+	if !removal.IsDeregistration() {
+		t.Errorf("expected %s to be a deregistration", removal.String())
+	}
+
+	refresh := &ContactHeader{NoString{}, address, NewParams().Add("expires", String{"3600"})}
+	if refresh.IsDeregistration() {
+		t.Errorf("expected %s not to be a deregistration", refresh.String())
+	}
+
+	absent := &ContactHeader{NoString{}, address, NewParams()}
+	if absent.IsDeregistration() {
+		t.Errorf("expected %s not to be a deregistration", absent.String())
+	}
+}
+
+func TestContactIsWildcard(t *testing.T) {
+	wildcard := &ContactHeader{NoString{}, WildcardUri{}, NewParams()}
+	if !wildcard.IsWildcard() {
+		t.Errorf("expected %s to be a wildcard contact", wildcard.String())
+	}
+
+	address := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "192.0.2.4", UriParams: noParams, Headers: noParams}
+	contact := &ContactHeader{String{"Bob"}, address, NewParams().Add("expires", String{"0"})}
+	if contact.IsWildcard() {
+		t.Errorf("expected %s not to be a wildcard contact", contact.String())
+	}
+}
+
+func TestContactsMatch(t *testing.T) {
+	bob := func(host string, uriParams Params) *SipUri {
+		return &SipUri{User: String{"bob"}, Password: NoString{}, Host: host, UriParams: uriParams, Headers: noParams}
+	}
+
+	tests := []struct {
+		a, b  *ContactHeader
+		match bool
+	}{
+		{&ContactHeader{String{"Bob"}, bob("192.0.2.4", noParams), NewParams()},
+			&ContactHeader{NoString{}, bob("192.0.2.4", noParams), NewParams()}, true},
+		{&ContactHeader{String{"Bob"}, bob("192.0.2.4", noParams), NewParams().Add("expires", String{"3600"})},
+			&ContactHeader{String{"Robert"}, bob("192.0.2.4", noParams), NewParams().Add("expires", String{"0"})}, true},
+		{&ContactHeader{NoString{}, bob("Biloxi.COM", NewParams().Add("transport", String{"TCP"})), NewParams()},
+			&ContactHeader{NoString{}, bob("biloxi.com", NewParams().Add("transport", String{"tcp"})), NewParams()}, true},
+		{&ContactHeader{String{"Bob"}, bob("192.0.2.4", noParams), NewParams()},
+			&ContactHeader{String{"Bob"}, bob("192.0.2.5", noParams), NewParams()}, false},
+		{&ContactHeader{NoString{}, bob("biloxi.com", NewParams().Add("transport", String{"tcp"})), NewParams()},
+			&ContactHeader{NoString{}, bob("biloxi.com", noParams), NewParams()}, false},
+		{&ContactHeader{NoString{}, WildcardUri{}, NewParams()},
+			&ContactHeader{NoString{}, bob("biloxi.com", noParams), NewParams()}, false},
+		{&ContactHeader{NoString{}, bob("biloxi.com", noParams), NewParams()}, nil, false},
+	}
+
+	for _, test := range tests {
+		if ContactsMatch(test.a, test.b) != test.match {
+			t.Errorf("expected ContactsMatch to be %t for %v and %v", test.match, test.a, test.b)
+		}
+		if test.b != nil && ContactsMatch(test.b, test.a) != test.match {
+			t.Errorf("expected ContactsMatch to be %t for %v and %v", test.match, test.b, test.a)
+		}
+	}
+}
+
+func TestStringWithDefaultPort(t *testing.T) {
+	var port uint16 = 6060
+	tests := []struct {
+		uri      *SipUri
+		expected string
+	}{
+		{&SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams},
+			"sip:bob@biloxi.com:5060"},
+		{&SipUri{IsEncrypted: true, User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams},
+			"sips:bob@biloxi.com:5061"},
+		{&SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", Port: &port, UriParams: noParams, Headers: noParams},
+			"sip:bob@biloxi.com:6060"},
+		{&SipUri{User: NoString{}, Password: NoString{}, Host: "2001:db8::1", UriParams: NewParams().Add("lr", NoString{}), Headers: noParams},
+			"sip:[2001:db8::1]:5060;lr"},
+	}
+
+	for _, test := range tests {
+		if actual := test.uri.StringWithDefaultPort(); actual != test.expected {
+			t.Errorf("expected %s; got %s", test.expected, actual)
+		}
+		if test.uri.Port == nil && test.uri.String() == test.expected {
+			t.Errorf("expected String() to omit the default port for %s", test.expected)
+		}
+	}
+}
+
+func TestViaReceivedAndRPort(t *testing.T) {
+	flag := &ViaHop{"SIP", "2.0", "UDP", "192.168.1.2", nil, NewParams().Add("rport", NoString{}).Add("branch", String{"z9hG4bK776asdhds"})}
+	if port, ok := flag.RPort(); !ok || port != 0 {
+		t.Errorf("expected valueless rport on %s; got %d (ok=%t)", flag.String(), port, ok)
+	}
+	if received := flag.Received(); received != (NoString{}) {
+		t.Errorf("expected no received parameter on %s; got %v", flag.String(), received)
+	}
+
+	// A server fills in the parameters with the address it saw the request come from.
+	flag.SetReceived("203.0.113.7")
+	flag.SetRPort(1234)
+	if flag.String() != "SIP/2.0/UDP 192.168.1.2;rport=1234;branch=z9hG4bK776asdhds;received=203.0.113.7" {
+		t.Errorf("unexpected Via hop after setting received and rport: %s", flag.String())
+	}
+	if port, ok := flag.RPort(); !ok || port != 1234 {
+		t.Errorf("expected rport=1234 on %s; got %d (ok=%t)", flag.String(), port, ok)
+	}
+	if received := flag.Received(); received != (String{"203.0.113.7"}) {
+		t.Errorf("expected received=203.0.113.7 on %s; got %v", flag.String(), received)
+	}
+
+	absent := &ViaHop{"SIP", "2.0", "UDP", "192.168.1.2", nil, NewParams()}
+	if _, ok := absent.RPort(); ok {
+		t.Errorf("expected no rport on %s", absent.String())
+	}
+	absent.SetRPort(0)
+	if absent.String() != "SIP/2.0/UDP 192.168.1.2;rport" {
+		t.Errorf("expected valueless rport after SetRPort(0); got %s", absent.String())
+	}
+
+	malformed := &ViaHop{"SIP", "2.0", "UDP", "192.168.1.2", nil, NewParams().Add("rport", String{"99999"})}
+	if _, ok := malformed.RPort(); ok {
+		t.Errorf("expected out-of-range rport to be rejected on %s", malformed.String())
+	}
+}
+
+func TestCSeqIncrementAndCompare(t *testing.T) {
+	cseq := &CSeq{314159, INVITE}
+	cseq.Increment()
+	if cseq.SeqNo != 314160 || cseq.MethodName != INVITE {
+		t.Errorf("expected CSeq 314160 INVITE after increment; got %s", cseq.String())
+	}
+
+	// Sequence numbers wrap around rather than exceeding MAX_CSEQ.
+	last := &CSeq{MAX_CSEQ - 1, BYE}
+	last.Increment()
+	if last.SeqNo != MAX_CSEQ {
+		t.Errorf("expected CSeq %d after increment; got %d", uint32(MAX_CSEQ), last.SeqNo)
+	}
+	last.Increment()
+	if last.SeqNo != 0 {
+		t.Errorf("expected CSeq to wrap around to 0 after MAX_CSEQ; got %d", last.SeqNo)
+	}
+
+	tests := []struct {
+		a, b     uint32
+		expected int
+	}{
+		{1, 2, -1},
+		{2, 1, 1},
+		{5, 5, 0},
+		{0, MAX_CSEQ, 1},
+		{MAX_CSEQ, 0, -1},
+		{3, MAX_CSEQ - 3, 1},
+		{MAX_CSEQ - 3, 3, -1},
+		{0, 1<<30 - 1, -1},
+		{1<<30 - 1, 0, 1},
+	}
+
+	for _, test := range tests {
+		if result := (&CSeq{test.a, INVITE}).Compare(&CSeq{test.b, ACK}); result != test.expected {
+			t.Errorf("unexpected comparison of CSeq %d with %d: expected %d, got %d",
+				test.a, test.b, test.expected, result)
+		}
+	}
+}
+
+func TestWriteToBuffer(t *testing.T) {
+	headers := append(benchmarkHeaders(), &GenericHeader{"X-Custom", "foo bar"}, &GenericHeader{"k", "100rel"})
+	for _, header := range headers {
+		writer, ok := header.(BufferWriter)
+		if !ok {
+			t.Errorf("expected %s header to implement BufferWriter", header.Name())
+			continue
+		}
+
+		var buffer bytes.Buffer
+		buffer.WriteString("prefix|")
+		writer.WriteToBuffer(&buffer)
+		if buffer.String() != "prefix|"+header.String() {
+			t.Errorf("expected WriteToBuffer to append %q; got %q", header.String(), buffer.String())
+		}
+	}
+}
+
+// Headers typical of a SIP request, for the serialization benchmarks.
+func benchmarkHeaders() []SipHeader {
+	port := uint16(5060)
+	alice := &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams}
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+	length := ContentLength(142)
+	return []SipHeader{
+		ViaHeader{&ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", &port, NewParams().Add("branch", String{"z9hG4bK776asdhds"})}},
+		MaxForwards(70),
+		&ToHeader{String{"Bob"}, bob, NewParams()},
+		&FromHeader{String{"Alice"}, alice, NewParams().Add("tag", String{"1928301774"})},
+		&callId,
+		&CSeq{314159, INVITE},
+		&ContactHeader{NoString{}, alice, NewParams()},
+		&length,
+	}
+}
+
+// Serialize headers by building a string for each, as before BufferWriter was introduced.
+func BenchmarkHeaderString(b *testing.B) {
+	headers := benchmarkHeaders()
+	var buffer bytes.Buffer
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buffer.Reset()
+		for _, header := range headers {
+			buffer.WriteString(header.String())
+		}
+	}
+}
+
+// Serialize headers by writing each straight into a shared buffer.
+func BenchmarkHeaderWriteToBuffer(b *testing.B) {
+	headers := benchmarkHeaders()
+	var buffer bytes.Buffer
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		buffer.Reset()
+		for _, header := range headers {
+			header.(BufferWriter).WriteToBuffer(&buffer)
+		}
+	}
+}
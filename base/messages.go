@@ -3,6 +3,7 @@ package base
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -34,6 +35,11 @@ const (
 	SUBSCRIBE Method = "SUBSCRIBE"
 	NOTIFY    Method = "NOTIFY"
 	REFER     Method = "REFER"
+	INFO      Method = "INFO"
+	PRACK     Method = "PRACK"
+	PUBLISH   Method = "PUBLISH"
+	MESSAGE   Method = "MESSAGE"
+	UPDATE    Method = "UPDATE"
 )
 
 // Internal representation of a SIP message - either a Request or a Response.
@@ -48,6 +54,11 @@ type SipMessage interface {
 	// If there are no headers of the requested type, returns an empty slice.
 	Headers(name string) []SipHeader
 
+	// Returns a slice of all headers with the given name, matching case-insensitively
+	// and accepting compact forms (e.g. "v" for Via).
+	// If there are no matching headers, returns an empty slice.
+	Header(name string) []SipHeader
+
 	// Return all headers attached to the message, as a slice.
 	AllHeaders() []SipHeader
 
@@ -62,6 +73,18 @@ type SipMessage interface {
 
 	// Set the body of the message.
 	SetBody(body string)
+
+	// Determine whether the message carries an SDP body: a non-empty body, with a Content-Type of application/sdp.
+	HasSDPBody() bool
+}
+
+// A single part of a multipart message body (RFC 2046 s. 5.1), e.g. the SDP part of a body carrying SDP and ISUP.
+type BodyPart struct {
+	// The headers of the part, such as its Content-Type, in the order they appeared.
+	Headers []SipHeader
+
+	// The content of the part, following its headers.
+	Content string
 }
 
 // A shared type for holding headers and their ordering.
@@ -69,8 +92,12 @@ type headers struct {
 	// The logical SIP headers attached to this message.
 	headers map[string][]SipHeader
 
-	// The order the headers should be displayed in.
+	// The order in which each type of header was first added.
 	headerOrder []string
+
+	// Every header, in the order the headers should be displayed in.
+	// This is the order in which they were added (e.g. parsed), so headers of different types may be interleaved.
+	headerList []SipHeader
 }
 
 func newHeaders() (result headers) {
@@ -80,17 +107,29 @@ func newHeaders() (result headers) {
 
 func (h headers) String() string {
 	buffer := bytes.Buffer{}
-	// Construct each header in turn and add it to the message.
-	for typeIdx, name := range h.headerOrder {
-		headers := h.headers[name]
-		for idx, header := range headers {
+	h.writeToBuffer(&buffer)
+	return buffer.String()
+}
+
+// Write each header in turn into the given buffer, each followed by a CRLF.
+func (h headers) writeToBuffer(buffer *bytes.Buffer) {
+	h.writeToBufferWithStyle(buffer, ViaCommaList)
+}
+
+// As writeToBuffer, but with Via headers laid out according to the given style.
+func (h headers) writeToBufferWithStyle(buffer *bytes.Buffer, viaStyle ViaStyle) {
+	for _, header := range h.headerList {
+		if via, ok := header.(ViaHeader); ok {
+			via.writeToBufferWithStyle(buffer, viaStyle)
+		} else if via, ok := header.(*ViaHeader); ok {
+			via.writeToBufferWithStyle(buffer, viaStyle)
+		} else if writer, ok := header.(BufferWriter); ok {
+			writer.WriteToBuffer(buffer)
+		} else {
 			buffer.WriteString(header.String())
-			if typeIdx < len(h.headerOrder) || idx < len(headers) {
-				buffer.WriteString("\r\n")
-			}
 		}
+		buffer.WriteString("\r\n")
 	}
-	return buffer.String()
 }
 
 // Add the given header.
@@ -106,6 +145,7 @@ func (hs *headers) AddHeader(h SipHeader) {
 		hs.headers[name] = []SipHeader{h}
 		hs.headerOrder = append(hs.headerOrder, name)
 	}
+	hs.headerList = append(hs.headerList, h)
 }
 
 // AddFrontHeader adds header to the front of header list
@@ -121,9 +161,59 @@ func (hs *headers) AddFrontHeader(h SipHeader) {
 		newHdrs := make([]SipHeader, 1, len(hdrs)+1)
 		newHdrs[0] = h
 		hs.headers[name] = append(newHdrs, hdrs...)
+
+		// Display the new header immediately before the first existing header of the same type.
+		for idx, hdr := range hs.headerList {
+			if hdr.Name() == name {
+				hs.headerList = append(hs.headerList[:idx], append([]SipHeader{h}, hs.headerList[idx:]...)...)
+				break
+			}
+		}
 	} else {
 		hs.headers[name] = []SipHeader{h}
 		hs.headerOrder = append(hs.headerOrder, name)
+		hs.headerList = append(hs.headerList, h)
+	}
+}
+
+// Remove the given header from the display order.
+func (hs *headers) removeFromList(h SipHeader) {
+	for idx, hdr := range hs.headerList {
+		if hdr == h {
+			hs.headerList = append(hs.headerList[:idx], hs.headerList[idx+1:]...)
+			return
+		}
+	}
+}
+
+// Set the first Content-Length header to the given length, keeping its position in the display order,
+// or add one if there is none.
+func (hs *headers) setContentLength(length int) {
+	hdrs := hs.Headers("Content-Length")
+	if len(hdrs) == 0 {
+		hs.AddHeader(ContentLength(length))
+	} else if _, ok := hdrs[0].(*ContentLength); ok {
+		replacement := ContentLength(length)
+		hs.replaceHeader(hdrs[0], &replacement)
+	} else {
+		hs.replaceHeader(hdrs[0], ContentLength(length))
+	}
+}
+
+// Replace the given header with another of the same type, keeping its position in the display order.
+func (hs *headers) replaceHeader(old SipHeader, replacement SipHeader) {
+	name := old.Name()
+	for idx, hdr := range hs.headers[name] {
+		if hdr == old {
+			hs.headers[name][idx] = replacement
+			break
+		}
+	}
+	for idx, hdr := range hs.headerList {
+		if hdr == old {
+			hs.headerList[idx] = replacement
+			break
+		}
 	}
 }
 
@@ -140,6 +230,231 @@ func (hs *headers) Headers(name string) []SipHeader {
 	}
 }
 
+// Compact forms of header names (RFC 3261 s. 7.3.3, and the RFCs defining the other headers), mapped to the
+// canonical header names they abbreviate.
+var compactHeaderNames = map[string]string{
+	"a": "Accept-Contact",
+	"b": "Referred-By",
+	"c": "Content-Type",
+	"e": "Content-Encoding",
+	"f": "From",
+	"i": "Call-Id",
+	"j": "Reject-Contact",
+	"k": "Supported",
+	"l": "Content-Length",
+	"m": "Contact",
+	"o": "Event",
+	"r": "Refer-To",
+	"s": "Subject",
+	"t": "To",
+	"u": "Allow-Events",
+	"v": "Via",
+	"x": "Session-Expires",
+}
+
+// Canonical names of the headers this package knows about, keyed by their lowercase forms.
+// These match the names returned by the headers' Name methods.
+var canonicalHeaderNames = map[string]string{
+	"accept":               "Accept",
+	"accept-contact":       "Accept-Contact",
+	"accept-encoding":      "Accept-Encoding",
+	"accept-language":      "Accept-Language",
+	"alert-info":           "Alert-Info",
+	"allow":                "Allow",
+	"allow-events":         "Allow-Events",
+	"authentication-info":  "Authentication-Info",
+	"authorization":        "Authorization",
+	"call-id":              "Call-Id",
+	"call-info":            "Call-Info",
+	"contact":              "Contact",
+	"content-disposition":  "Content-Disposition",
+	"content-encoding":     "Content-Encoding",
+	"content-language":     "Content-Language",
+	"content-length":       "Content-Length",
+	"content-type":         "Content-Type",
+	"cseq":                 "CSeq",
+	"date":                 "Date",
+	"error-info":           "Error-Info",
+	"event":                "Event",
+	"expires":              "Expires",
+	"from":                 "From",
+	"in-reply-to":          "In-Reply-To",
+	"max-forwards":         "Max-Forwards",
+	"mime-version":         "MIME-Version",
+	"min-expires":          "Min-Expires",
+	"min-se":               "Min-SE",
+	"organization":         "Organization",
+	"p-asserted-identity":  "P-Asserted-Identity",
+	"p-visited-network-id": "P-Visited-Network-ID",
+	"priority":             "Priority",
+	"proxy-authenticate":   "Proxy-Authenticate",
+	"proxy-authorization":  "Proxy-Authorization",
+	"proxy-require":        "Proxy-Require",
+	"rack":                 "RAck",
+	"record-route":         "Record-Route",
+	"refer-sub":            "Refer-Sub",
+	"refer-to":             "Refer-To",
+	"referred-by":          "Referred-By",
+	"reject-contact":       "Reject-Contact",
+	"replaces":             "Replaces",
+	"reply-to":             "Reply-To",
+	"require":              "Require",
+	"retry-after":          "Retry-After",
+	"route":                "Route",
+	"rseq":                 "RSeq",
+	"server":               "Server",
+	"session-expires":      "Session-Expires",
+	"sip-etag":             "SIP-ETag",
+	"sip-if-match":         "SIP-If-Match",
+	"subject":              "Subject",
+	"supported":            "Supported",
+	"timestamp":            "Timestamp",
+	"to":                   "To",
+	"unsupported":          "Unsupported",
+	"user-agent":           "User-Agent",
+	"via":                  "Via",
+	"warning":              "Warning",
+	"www-authenticate":     "WWW-Authenticate",
+}
+
+// Return the canonical form of the given header name, expanding compact forms, e.g. 'v' becomes 'Via' and
+// 'content-length' becomes 'Content-Length'. This is the form in which headers are stored and written out.
+// Names are matched case-insensitively. Names of unknown headers are returned as they are, so that extension
+// headers are written exactly as their users name them.
+func CanonicalHeaderName(name string) string {
+	name = strings.TrimSpace(name)
+	lower := strings.ToLower(name)
+	if canonical, ok := compactHeaderNames[lower]; ok {
+		return canonical
+	} else if canonical, ok := canonicalHeaderNames[lower]; ok {
+		return canonical
+	}
+	return name
+}
+
+// Write the first Call-Id and CSeq headers, if present, for a message's short description.
+func (hs *headers) writeShortIds(buffer *bytes.Buffer) {
+	ids := make([]string, 0, 2)
+	for _, name := range []string{"Call-Id", "CSeq"} {
+		if found := hs.Header(name); len(found) > 0 {
+			ids = append(ids, found[0].String())
+		}
+	}
+
+	if len(ids) > 0 {
+		buffer.WriteString(" (" + strings.Join(ids, ", ") + ")")
+	}
+}
+
+// Gets all headers with the given name, expanding compact forms and ignoring case.
+// Headers are returned in the order they appear on the message.
+func (hs *headers) Header(name string) []SipHeader {
+	name = CanonicalHeaderName(name)
+
+	result := []SipHeader{}
+	for _, key := range hs.headerOrder {
+		if strings.EqualFold(key, name) {
+			result = append(result, hs.headers[key]...)
+		}
+	}
+
+	return result
+}
+
+// Return every hop from every Via header attached to the message, in order.
+// A message may carry several Via headers, each of which may contain several comma-separated hops;
+// this flattens them into a single list so that the topmost hop is always the first element.
+func (hs *headers) ViaHops() []*ViaHop {
+	hops := make([]*ViaHop, 0)
+	for _, h := range hs.Headers("Via") {
+		switch via := h.(type) {
+		case ViaHeader:
+			hops = append(hops, via...)
+		case *ViaHeader:
+			hops = append(hops, (*via)...)
+		}
+	}
+
+	return hops
+}
+
+// Determine whether the given body is an SDP body, according to the first Content-Type header.
+// The media type is matched case-insensitively, and any parameters (e.g. charset) are ignored.
+func (hs *headers) hasSDPBody(body string) bool {
+	if len(body) == 0 {
+		return false
+	}
+
+	contentTypes := hs.Header("Content-Type")
+	if len(contentTypes) == 0 {
+		return false
+	}
+
+	var mediaType string
+	switch contentType := contentTypes[0].(type) {
+	case *ContentType:
+		mediaType = contentType.MediaType
+	case *GenericHeader:
+		mediaType = strings.SplitN(contentType.Contents, ";", 2)[0]
+	default:
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(mediaType), "application/sdp")
+}
+
+// Remove and return the topmost hop of the first Via header, removing the header entirely if that was its only hop.
+// Returns ok=false if there is no Via hop to remove.
+func (hs *headers) popVia() (hop *ViaHop, ok bool) {
+	vias := hs.Headers("Via")
+	if len(vias) == 0 {
+		return nil, false
+	}
+
+	var remaining SipHeader
+	var empty bool
+	switch via := vias[0].(type) {
+	case ViaHeader:
+		if len(via) == 0 {
+			return nil, false
+		}
+		hop, remaining, empty = via[0], via[1:], len(via) == 1
+	case *ViaHeader:
+		if len(*via) == 0 {
+			return nil, false
+		}
+		rest := (*via)[1:]
+		hop, remaining, empty = (*via)[0], &rest, len(rest) == 0
+	default:
+		return nil, false
+	}
+
+	// The first Via in the display order is the first Via in the map. Locate it by name rather than by comparison,
+	// since ViaHeader values are not comparable.
+	listIdx := 0
+	for hs.headerList[listIdx].Name() != "Via" {
+		listIdx++
+	}
+
+	if !empty {
+		hs.headers["Via"][0] = remaining
+		hs.headerList[listIdx] = remaining
+		return hop, true
+	}
+
+	hs.headers["Via"] = vias[1:]
+	hs.headerList = append(hs.headerList[:listIdx], hs.headerList[listIdx+1:]...)
+	if len(hs.headers["Via"]) == 0 {
+		delete(hs.headers, "Via")
+		for idx, entry := range hs.headerOrder {
+			if entry == "Via" {
+				hs.headerOrder = append(hs.headerOrder[:idx], hs.headerOrder[idx+1:]...)
+				break
+			}
+		}
+	}
+	return hop, true
+}
+
 // Copy all headers of one type from one message to another.
 // Appending to any headers that were already there.
 func CopyHeaders(name string, from, to SipMessage) {
@@ -163,9 +478,6 @@ type Request struct {
 	// A Request has headers.
 	headers
 
-	// The order the headers should be displayed in.
-	headerOrder []string
-
 	// The application data of the message.
 	Body string
 }
@@ -187,19 +499,21 @@ func NewRequest(method Method, recipient Uri, sipVersion string, headers []SipHe
 
 func (request *Request) String() string {
 	var buffer bytes.Buffer
+	request.writeToBuffer(&buffer, ViaCommaList)
+	return buffer.String()
+}
 
+func (request *Request) writeToBuffer(buffer *bytes.Buffer, viaStyle ViaStyle) {
 	// Every SIP request starts with a Request Line - RFC 2361 7.1.
 	buffer.WriteString(fmt.Sprintf("%s %s %s\r\n",
 		(string)(request.Method),
 		request.Recipient.String(),
 		request.SipVersion))
 
-	buffer.WriteString(request.headers.String())
+	request.headers.writeToBufferWithStyle(buffer, viaStyle)
 
 	// If the request has a message body, add it.
 	buffer.WriteString("\r\n" + request.Body)
-
-	return buffer.String()
 }
 
 func (request *Request) Short() string {
@@ -209,20 +523,14 @@ func (request *Request) Short() string {
 		(string)(request.Method),
 		request.Recipient.String(),
 		request.SipVersion))
-
-	cseqs := request.Headers("CSeq")
-	if len(cseqs) > 0 {
-		buffer.WriteString(fmt.Sprintf(" (CSeq: %s)", (cseqs[0].(*CSeq)).String()))
-	}
+	request.headers.writeShortIds(&buffer)
 
 	return buffer.String()
 }
 
 func (request *Request) AllHeaders() []SipHeader {
-	allHeaders := make([]SipHeader, 0)
-	for _, key := range request.headers.headerOrder {
-		allHeaders = append(allHeaders, request.headers.headers[key]...)
-	}
+	allHeaders := make([]SipHeader, len(request.headers.headerList))
+	copy(allHeaders, request.headers.headerList)
 
 	return allHeaders
 }
@@ -242,6 +550,7 @@ func (request *Request) RemoveHeader(header SipHeader) error {
 	for idx, hdr := range headersOfSameType {
 		if hdr == header {
 			request.headers.headers[name] = append(headersOfSameType[:idx], headersOfSameType[idx+1:]...)
+			request.headers.removeFromList(header)
 			found = true
 			break
 		}
@@ -256,9 +565,9 @@ func (request *Request) RemoveHeader(header SipHeader) error {
 		// and removing the entry from the headerOrder list.
 		delete(request.headers.headers, name)
 
-		for idx, entry := range request.headerOrder {
+		for idx, entry := range request.headers.headerOrder {
 			if entry == name {
-				request.headers.headerOrder = append(request.headerOrder[:idx], request.headerOrder[idx+1:]...)
+				request.headers.headerOrder = append(request.headers.headerOrder[:idx], request.headers.headerOrder[idx+1:]...)
 			}
 		}
 	}
@@ -270,15 +579,56 @@ func (request *Request) GetBody() string {
 	return request.Body
 }
 
+// Implements SipMessage.HasSDPBody.
+func (request *Request) HasSDPBody() bool {
+	return request.headers.hasSDPBody(request.Body)
+}
+
 func (request *Request) SetBody(body string) {
 	request.Body = body
-	hdrs := request.Headers("Content-Length")
-	if len(hdrs) == 0 {
-		length := ContentLength(len(body))
-		request.AddHeader(length)
-	} else {
-		hdrs[0] = ContentLength(len(body))
+	request.headers.setContentLength(len(body))
+}
+
+// Create a CANCEL request for the given outstanding INVITE (RFC 3261 s. 9.1).
+// The CANCEL has the same Request-URI, Call-Id, From, To and Route headers as the INVITE, the same CSeq
+// number (but with method CANCEL), and a single Via header matching the INVITE's topmost Via hop, so that
+// it is matched to the same server transaction.
+func NewCancel(invite *Request) (*Request, error) {
+	if invite.Method != INVITE {
+		return nil, fmt.Errorf("cannot cancel %s request; only INVITEs may be cancelled", invite.Method)
+	}
+
+	hops := invite.ViaHops()
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("cannot cancel request %s with no Via header", invite.Short())
+	}
+
+	cseqs := invite.Headers("CSeq")
+	if len(cseqs) == 0 {
+		return nil, fmt.Errorf("cannot cancel request %s with no CSeq header", invite.Short())
 	}
+	cseq, ok := cseqs[0].(*CSeq)
+	if !ok {
+		return nil, fmt.Errorf("invalid CSeq '%s' in request %s", cseqs[0].String(), invite.Short())
+	}
+
+	for _, name := range []string{"Call-Id", "From", "To"} {
+		if len(invite.Headers(name)) == 0 {
+			return nil, fmt.Errorf("cannot cancel request %s with no %s header", invite.Short(), name)
+		}
+	}
+
+	cancel := NewRequest(CANCEL, invite.Recipient.Copy(), invite.SipVersion, []SipHeader{}, "")
+	cancel.AddHeader(ViaHeader{hops[0].Copy()})
+	CopyHeaders("Max-Forwards", invite, cancel)
+	CopyHeaders("To", invite, cancel)
+	CopyHeaders("From", invite, cancel)
+	CopyHeaders("Call-Id", invite, cancel)
+	cancel.AddHeader(&CSeq{cseq.SeqNo, CANCEL})
+	CopyHeaders("Route", invite, cancel)
+	cancel.SetBody("")
+
+	return cancel, nil
 }
 
 // A SIP response object  (c.f. RFC 3261 section 7.2).
@@ -318,9 +668,101 @@ func NewResponse(sipVersion string, statusCode uint16, reason string, headers []
 	return
 }
 
+// The default reason phrases for each status code, from the IANA SIP response code registry (RFC 3261 s. 21 and
+// its extensions).
+var defaultReasons = map[uint16]string{
+	100: "Trying",
+	180: "Ringing",
+	181: "Call Is Being Forwarded",
+	182: "Queued",
+	183: "Session Progress",
+	199: "Early Dialog Terminated",
+
+	200: "OK",
+	202: "Accepted",
+	204: "No Notification",
+
+	300: "Multiple Choices",
+	301: "Moved Permanently",
+	302: "Moved Temporarily",
+	305: "Use Proxy",
+	380: "Alternative Service",
+
+	400: "Bad Request",
+	401: "Unauthorized",
+	402: "Payment Required",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	410: "Gone",
+	412: "Conditional Request Failed",
+	413: "Request Entity Too Large",
+	414: "Request-URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Unsupported URI Scheme",
+	417: "Unknown Resource-Priority",
+	420: "Bad Extension",
+	421: "Extension Required",
+	422: "Session Interval Too Small",
+	423: "Interval Too Brief",
+	424: "Bad Location Information",
+	428: "Use Identity Header",
+	429: "Provide Referrer Identity",
+	430: "Flow Failed",
+	433: "Anonymity Disallowed",
+	436: "Bad Identity-Info",
+	437: "Unsupported Certificate",
+	438: "Invalid Identity Header",
+	439: "First Hop Lacks Outbound Support",
+	440: "Max-Breadth Exceeded",
+	469: "Bad Info Package",
+	470: "Consent Needed",
+	480: "Temporarily Unavailable",
+	481: "Call/Transaction Does Not Exist",
+	482: "Loop Detected",
+	483: "Too Many Hops",
+	484: "Address Incomplete",
+	485: "Ambiguous",
+	486: "Busy Here",
+	487: "Request Terminated",
+	488: "Not Acceptable Here",
+	489: "Bad Event",
+	491: "Request Pending",
+	493: "Undecipherable",
+	494: "Security Agreement Required",
+
+	500: "Server Internal Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Server Time-out",
+	505: "Version Not Supported",
+	513: "Message Too Large",
+	580: "Precondition Failure",
+
+	600: "Busy Everywhere",
+	603: "Decline",
+	604: "Does Not Exist Anywhere",
+	606: "Not Acceptable",
+	607: "Unwanted",
+}
+
+// Return the default reason phrase for the given status code, e.g. 'Not Found' for 404, for use when building
+// responses. Returns an empty string if the status code is not registered.
+func DefaultReason(code uint16) string {
+	return defaultReasons[code]
+}
+
 func (response *Response) String() string {
 	var buffer bytes.Buffer
+	response.writeToBuffer(&buffer, ViaCommaList)
+	return buffer.String()
+}
 
+func (response *Response) writeToBuffer(buffer *bytes.Buffer, viaStyle ViaStyle) {
 	// Every SIP response starts with a Status Line - RFC 2361 7.2.
 	buffer.WriteString(fmt.Sprintf("%s %d %s\r\n",
 		response.SipVersion,
@@ -328,35 +770,27 @@ func (response *Response) String() string {
 		response.Reason))
 
 	// Write the headers.
-	buffer.WriteString(response.headers.String())
+	response.headers.writeToBufferWithStyle(buffer, viaStyle)
 
 	// If the request has a message body, add it.
 	buffer.WriteString("\r\n" + response.Body)
-
-	return buffer.String()
 }
 
 func (response *Response) Short() string {
 	var buffer bytes.Buffer
 
-	buffer.WriteString(fmt.Sprintf("%s %d %s\r\n",
+	buffer.WriteString(fmt.Sprintf("%s %d %s",
 		response.SipVersion,
 		response.StatusCode,
 		response.Reason))
-
-	cseqs := response.Headers("CSeq")
-	if len(cseqs) > 0 {
-		buffer.WriteString(fmt.Sprintf(" (CSeq: %s)", (cseqs[0].(*CSeq)).String()))
-	}
+	response.headers.writeShortIds(&buffer)
 
 	return buffer.String()
 }
 
 func (response *Response) AllHeaders() []SipHeader {
-	allHeaders := make([]SipHeader, 0)
-	for _, key := range response.headers.headerOrder {
-		allHeaders = append(allHeaders, response.headers.headers[key]...)
-	}
+	allHeaders := make([]SipHeader, len(response.headers.headerList))
+	copy(allHeaders, response.headers.headerList)
 
 	return allHeaders
 }
@@ -376,6 +810,7 @@ func (response *Response) RemoveHeader(header SipHeader) error {
 	for idx, hdr := range headersOfSameType {
 		if hdr == header {
 			response.headers.headers[name] = append(headersOfSameType[:idx], headersOfSameType[idx+1:]...)
+			response.headers.removeFromList(header)
 			found = true
 			break
 		}
@@ -404,13 +839,531 @@ func (response *Response) GetBody() string {
 	return response.Body
 }
 
+// Implements SipMessage.HasSDPBody.
+func (response *Response) HasSDPBody() bool {
+	return response.headers.hasSDPBody(response.Body)
+}
+
 func (response *Response) SetBody(body string) {
 	response.Body = body
-	hdrs := response.Headers("Content-Length")
-	if len(hdrs) == 0 {
-		length := ContentLength(len(body))
-		response.AddHeader(length)
+	response.headers.setContentLength(len(body))
+}
+
+// Determine if the response is provisional (1xx).
+func (response *Response) IsProvisional() bool {
+	return response.StatusCode >= 100 && response.StatusCode < 200
+}
+
+// Determine if the response is a '199 Early Dialog Terminated' response (RFC 6228), which indicates
+// that the early dialog identified by the response's To tag has been terminated by a downstream element.
+func (response *Response) IsEarlyDialogTerminated() bool {
+	return response.StatusCode == 199
+}
+
+// Determine if the response forms an early dialog; that is, if it is a provisional response other than
+// '100 Trying' which carries a To tag (RFC 3261 s. 12.1).
+// A '199 Early Dialog Terminated' response ends an early dialog rather than forming one.
+func (response *Response) FormsEarlyDialog() bool {
+	if !response.IsProvisional() || response.StatusCode == 100 || response.IsEarlyDialogTerminated() {
+		return false
+	}
+
+	_, ok := toTag(response).(String)
+	return ok
+}
+
+// Remove and return the topmost Via hop, as a proxy does to its own hop before forwarding the response upstream.
+// If the first Via header had only that hop, the header is removed entirely.
+// Returns an error if the response has no Via hop.
+func (response *Response) PopVia() (*ViaHop, error) {
+	hop, ok := response.headers.popVia()
+	if !ok {
+		return nil, fmt.Errorf("cannot pop Via from response %s as it has no Via hop", response.Short())
+	}
+	return hop, nil
+}
+
+// Extract the tag parameter from the To header of the given message, if there is one.
+func toTag(msg SipMessage) MaybeString {
+	tos := msg.Headers("To")
+	if len(tos) == 0 {
+		return NoString{}
+	}
+
+	to, ok := tos[0].(*ToHeader)
+	if !ok {
+		return NoString{}
+	}
+
+	return to.Tag()
+}
+
+// EarlyDialogs tracks the early dialogs formed by the provisional responses to a single INVITE.
+// Since an INVITE may fork, several early dialogs can exist at once; they are distinguished by
+// the To tag of the responses that formed them.
+type EarlyDialogs struct {
+	tags map[string]bool
+}
+
+// Create an empty set of early dialogs.
+func NewEarlyDialogs() *EarlyDialogs {
+	return &EarlyDialogs{map[string]bool{}}
+}
+
+// Update the set of early dialogs with the given response.
+// Provisional responses carrying a To tag add an early dialog, and '199 Early Dialog Terminated'
+// responses remove the early dialog matching their To tag.
+func (dialogs *EarlyDialogs) Update(response *Response) {
+	tag, ok := toTag(response).(String)
+	if !ok {
+		return
+	}
+
+	if response.FormsEarlyDialog() {
+		dialogs.tags[tag.S] = true
+	} else if response.IsEarlyDialogTerminated() {
+		delete(dialogs.tags, tag.S)
+	}
+}
+
+// Determine if an early dialog with the given To tag is currently active.
+func (dialogs *EarlyDialogs) Contains(tag string) bool {
+	return dialogs.tags[tag]
+}
+
+// Returns the number of currently active early dialogs.
+func (dialogs *EarlyDialogs) Length() int {
+	return len(dialogs.tags)
+}
+
+// Extract the tag parameter from the From header of the given message, if there is one.
+func fromTag(msg SipMessage) MaybeString {
+	froms := msg.Headers("From")
+	if len(froms) == 0 {
+		return NoString{}
+	}
+
+	from, ok := froms[0].(*FromHeader)
+	if !ok {
+		return NoString{}
+	}
+
+	return from.Tag()
+}
+
+// The state of a dialog, as established at the UAC by a 2xx response to an INVITE (RFC 3261 s. 12.1.2).
+type DialogState struct {
+	// The Call-Id shared by all requests within the dialog.
+	CallId CallId
+
+	// The tag from the From header; identifies our side of the dialog.
+	LocalTag string
+
+	// The tag from the To header of the response; identifies the remote side of the dialog.
+	RemoteTag string
+
+	// The URI from the From header.
+	LocalUri Uri
+
+	// The URI from the To header.
+	RemoteUri Uri
+
+	// The URI from the Contact header of the response, to which in-dialog requests should be sent.
+	RemoteTarget Uri
+
+	// The URIs from the Record-Route headers of the response, in reverse order.
+	// This is the order in which in-dialog requests should visit them as Route headers.
+	RouteSet []Uri
+
+	// The sequence number from the CSeq of the INVITE.
+	LocalSeqNo uint32
+}
+
+// Extract the dialog state established at the UAC by the given 2xx response to the given INVITE.
+// Returns an error if the response does not establish a dialog, or if any of the information required
+// to form one is missing.
+func ExtractDialogState(invite *Request, resp *Response) (*DialogState, error) {
+	if invite.Method != INVITE {
+		return nil, fmt.Errorf("cannot form dialog from %s request; must be INVITE", invite.Method)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("cannot form dialog from %d response; must be 2xx", resp.StatusCode)
+	}
+
+	state := &DialogState{}
+
+	callIds := resp.Headers("Call-Id")
+	if len(callIds) == 0 {
+		return nil, fmt.Errorf("no Call-Id in response %s", resp.Short())
+	}
+	callId, ok := callIds[0].(*CallId)
+	if !ok {
+		return nil, fmt.Errorf("invalid Call-Id '%s' in response %s", callIds[0].String(), resp.Short())
+	}
+	state.CallId = *callId
+
+	localTag, ok := fromTag(resp).(String)
+	if !ok {
+		return nil, fmt.Errorf("no From tag in response %s", resp.Short())
+	}
+	state.LocalTag = localTag.S
+
+	remoteTag, ok := toTag(resp).(String)
+	if !ok {
+		return nil, fmt.Errorf("no To tag in response %s", resp.Short())
+	}
+	state.RemoteTag = remoteTag.S
+
+	state.LocalUri = resp.Headers("From")[0].(*FromHeader).Address
+	state.RemoteUri = resp.Headers("To")[0].(*ToHeader).Address
+
+	contacts := resp.Headers("Contact")
+	if len(contacts) != 1 {
+		return nil, fmt.Errorf("expected exactly one Contact in response %s; got %d", resp.Short(), len(contacts))
+	}
+	contact, ok := contacts[0].(*ContactHeader)
+	if !ok || contact.Address.IsWildcard() {
+		return nil, fmt.Errorf("invalid Contact '%s' in response %s", contacts[0].String(), resp.Short())
+	}
+	state.RemoteTarget = contact.Address
+
+	recordRoutes := resp.Headers("Record-Route")
+	state.RouteSet = make([]Uri, 0, len(recordRoutes))
+	for idx := len(recordRoutes) - 1; idx >= 0; idx-- {
+		recordRoute, ok := recordRoutes[idx].(*RecordRouteHeader)
+		if !ok {
+			return nil, fmt.Errorf("invalid Record-Route '%s' in response %s", recordRoutes[idx].String(), resp.Short())
+		}
+		state.RouteSet = append(state.RouteSet, recordRoute.Address)
+	}
+
+	cseqs := invite.Headers("CSeq")
+	if len(cseqs) == 0 {
+		return nil, fmt.Errorf("no CSeq in request %s", invite.Short())
+	}
+	cseq, ok := cseqs[0].(*CSeq)
+	if !ok {
+		return nil, fmt.Errorf("invalid CSeq '%s' in request %s", cseqs[0].String(), invite.Short())
+	}
+	state.LocalSeqNo = cseq.SeqNo
+
+	return state, nil
+}
+
+// Produce a canonical key identifying the dialog with the given Call-Id and tags (RFC 3261 s. 12), suitable for
+// use as a map key when matching messages to dialogs. A missing tag (e.g. the remote tag of a half-formed dialog)
+// is represented as empty, so the key for an early request differs from that of the dialog it goes on to establish.
+func DialogID(callID CallId, localTag, remoteTag MaybeString) string {
+	local, remote := "", ""
+	if tag, ok := localTag.(String); ok {
+		local = tag.S
+	}
+	if tag, ok := remoteTag.(String); ok {
+		remote = tag.S
+	}
+	return fmt.Sprintf("%s;local-tag=%s;remote-tag=%s", string(callID), local, remote)
+}
+
+// Produce the canonical dialog key (see DialogID) for this request, as seen by its sender, the UAC.
+// The local tag is taken from the From header and the remote tag from the To header; a UAS matching a received
+// request should swap these, by calling DialogID directly.
+// Returns an error if the request has no Call-Id.
+func (request *Request) DialogID() (string, error) {
+	callIds := request.Headers("Call-Id")
+	if len(callIds) == 0 {
+		return "", fmt.Errorf("no Call-Id in request %s", request.Short())
+	}
+	callId, ok := callIds[0].(*CallId)
+	if !ok {
+		return "", fmt.Errorf("invalid Call-Id '%s' in request %s", callIds[0].String(), request.Short())
+	}
+
+	return DialogID(*callId, fromTag(request), toTag(request)), nil
+}
+
+// Decrement the request's Max-Forwards header in place, as a proxy must before forwarding it (RFC 3261 s. 16.6),
+// and return the number of hops remaining. If the request has no Max-Forwards header, one is added with the default
+// value of 70 before decrementing. Returns an error if Max-Forwards is already 0, in which case the request must not
+// be forwarded and should be rejected with a 483 (Too Many Hops) response.
+func (request *Request) DecrementMaxForwards() (remaining uint32, err error) {
+	maxForwardses := request.Headers("Max-Forwards")
+	if len(maxForwardses) == 0 {
+		request.AddHeader(DefaultMaxForwards)
+		maxForwardses = request.Headers("Max-Forwards")
+	}
+
+	// Parsed requests hold a *MaxForwards, whereas those built in code usually hold a MaxForwards.
+	var maxForwards MaxForwards
+	switch header := maxForwardses[0].(type) {
+	case MaxForwards:
+		maxForwards = header
+	case *MaxForwards:
+		maxForwards = *header
+	default:
+		return 0, fmt.Errorf("invalid Max-Forwards '%s' in request %s", maxForwardses[0].String(), request.Short())
+	}
+	if maxForwards == 0 {
+		return 0, fmt.Errorf("too many hops: Max-Forwards is 0 in request %s", request.Short())
+	}
+
+	decremented := maxForwards - 1
+	if _, isPointer := maxForwardses[0].(*MaxForwards); isPointer {
+		request.headers.replaceHeader(maxForwardses[0], &decremented)
 	} else {
-		hdrs[0] = ContentLength(len(body))
+		request.headers.replaceHeader(maxForwardses[0], decremented)
+	}
+	return uint32(decremented), nil
+}
+
+// Remove and return the topmost Via hop, as a proxy does to its own hop before forwarding a response.
+// If the first Via header had only that hop, the header is removed entirely.
+// Returns an error if the request has no Via hop.
+func (request *Request) PopVia() (*ViaHop, error) {
+	hop, ok := request.headers.popVia()
+	if !ok {
+		return nil, fmt.Errorf("cannot pop Via from request %s as it has no Via hop", request.Short())
+	}
+	return hop, nil
+}
+
+// Add a Route header for the given URI above any existing Route headers, so that the request visits that URI
+// first, as an outbound proxy does to route a request through itself.
+func (request *Request) PushRoute(uri Uri) {
+	request.AddFrontHeader(&RouteHeader{NoString{}, uri, NewParams()})
+}
+
+// Replace the request's Route headers with one for each URI in the given route set, in the order given, e.g. to
+// install a dialog's route set (see DialogState.RouteSet) on an in-dialog request (RFC 3261 s. 12.2.1.1).
+// An empty route set removes all Route headers.
+func (request *Request) SetRouteSet(routeSet []Uri) {
+	existing := request.Headers("Route")
+	routes := make([]SipHeader, len(existing))
+	copy(routes, existing)
+	for _, route := range routes {
+		request.RemoveHeader(route)
+	}
+
+	for _, uri := range routeSet {
+		request.AddHeader(&RouteHeader{NoString{}, uri, NewParams()})
+	}
+}
+
+// Determine where this request should be sent next (RFC 3261 s. 8.1.2 and 16.12): to the topmost Route if that
+// is a loose router (marked with 'lr'), and otherwise to the Request-URI. The host is taken from the URI's maddr
+// parameter if it has one. If the URI gives no port or transport, the defaults for its scheme are used: port 5060
+// over UDP for SIP URIs, and port 5061 over TLS for SIPS URIs.
+// Returns an error if the chosen URI is not a SIP or SIPS URI.
+func (request *Request) NextHop() (host string, port uint16, transport string, err error) {
+	target := request.Recipient
+	if routes := request.Headers("Route"); len(routes) > 0 {
+		if route, ok := routes[0].(*RouteHeader); ok {
+			if uri, ok := route.Address.(*SipUri); ok && uri.IsLooseRouter() {
+				target = uri
+			}
+		}
+	}
+
+	uri, ok := target.(*SipUri)
+	if !ok {
+		err = fmt.Errorf("cannot route request %s to non-SIP URI %v", request.Short(), target)
+		return
+	}
+
+	host = uri.Host
+	if maddr, ok := uri.Maddr(); ok {
+		host = maddr
+	}
+
+	if uri.Port != nil {
+		port = *uri.Port
+	} else if uri.IsEncrypted {
+		port = DefaultSipsPort
+	} else {
+		port = DefaultSipPort
+	}
+
+	if t, ok := uri.Transport().(String); ok {
+		transport = t.S
+	} else if uri.IsEncrypted {
+		transport = "tls"
+	} else {
+		transport = "udp"
+	}
+
+	return
+}
+
+// Serialize the given message onto the writer in wire format.
+// Headers are written in the order they were added to the message (for parsed messages, the order in which they
+// appeared on the wire), so a parsed message round-trips without its headers being regrouped.
+func WriteMessage(w io.Writer, msg SipMessage) error {
+	_, err := io.WriteString(w, msg.String())
+	return err
+}
+
+// As WriteMessage, but with any Via headers carrying several hops laid out according to the given style.
+// Either style parses back to the same list of hops.
+func WriteMessageWithViaStyle(w io.Writer, msg SipMessage, viaStyle ViaStyle) error {
+	var buffer bytes.Buffer
+	switch m := msg.(type) {
+	case *Request:
+		m.writeToBuffer(&buffer, viaStyle)
+	case *Response:
+		m.writeToBuffer(&buffer, viaStyle)
+	default:
+		buffer.WriteString(msg.String())
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// An error found while parsing part of a SIP message, which records where the problem lies so that callers can
+// report it, or handle different kinds of error, programmatically.
+type ParseError struct {
+	// The canonical name of the header being parsed, e.g. "Via", or "start-line" for the first line of a message.
+	// Empty if the text was parsed on its own, e.g. by a direct call to parse a URI.
+	Header string
+
+	// The text which failed to parse.
+	Text string
+
+	// The byte offset into Text of the start of the element which failed to parse, or -1 if it is not known.
+	Offset int
+
+	// The underlying error, describing the problem.
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+// Check that a request carries the headers mandatory for all requests (RFC 3261 s. 8.1.1): To, From, CSeq,
+// Call-Id, Max-Forwards and at least one Via. Also checks that the CSeq method matches the request method.
+// If the request is invalid, the returned error lists every problem found.
+func ValidateRequest(request *Request) error {
+	problems := missingHeaders(request, "To", "From", "CSeq", "Call-Id", "Max-Forwards")
+	problems = append(problems, viaProblems(request)...)
+
+	for _, h := range request.Headers("CSeq") {
+		if cseq, ok := h.(*CSeq); ok && !cseq.MethodName.Equals(&request.Method) {
+			problems = append(problems, fmt.Sprintf("CSeq method %s does not match request method %s",
+				cseq.MethodName, request.Method))
+		}
+	}
+
+	return validationError(request, problems)
+}
+
+// Check that a response carries the headers mandatory for all responses (RFC 3261 s. 8.2.6.2): To, From,
+// CSeq, Call-Id and at least one Via.
+// If the response is invalid, the returned error lists every problem found.
+func ValidateResponse(response *Response) error {
+	problems := missingHeaders(response, "To", "From", "CSeq", "Call-Id")
+	problems = append(problems, viaProblems(response)...)
+
+	return validationError(response, problems)
+}
+
+func missingHeaders(msg SipMessage, names ...string) []string {
+	problems := make([]string, 0)
+	for _, name := range names {
+		if len(msg.Headers(name)) == 0 {
+			problems = append(problems, fmt.Sprintf("missing %s header", name))
+		}
+	}
+	return problems
+}
+
+func viaProblems(msg SipMessage) []string {
+	if len(msg.Headers("Via")) == 0 {
+		return []string{"missing Via header"}
+	}
+
+	hops := 0
+	for _, h := range msg.Headers("Via") {
+		switch via := h.(type) {
+		case ViaHeader:
+			hops += len(via)
+		case *ViaHeader:
+			hops += len(*via)
+		}
+	}
+	if hops == 0 {
+		return []string{"no hops in Via header"}
+	}
+	return nil
+}
+
+func validationError(msg SipMessage, problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid message %s: %s", msg.Short(), strings.Join(problems, "; "))
+}
+
+// Determine which of the option tags required by the message's Require and Proxy-Require headers are not among
+// the given locally-supported tags (RFC 3261 s. 8.2.2.3). If any are returned, the request should be rejected with
+// a '420 Bad Extension' response listing them in an Unsupported header. Each tag is returned at most once.
+// Require headers which the parser did not recognise, and so kept as GenericHeaders, are also checked.
+func CheckRequiredOptions(msg SipMessage, supported []string) (unsupported []string) {
+	unsupported = make([]string, 0)
+	for _, required := range requiredOptions(msg) {
+		if !containsOption(supported, required) && !containsOption(unsupported, required) {
+			unsupported = append(unsupported, required)
+		}
+	}
+	return
+}
+
+// Get every option tag from the Require and Proxy-Require headers of the message, in order.
+func requiredOptions(msg SipMessage) []string {
+	options := make([]string, 0)
+	for _, name := range []string{"Require", "Proxy-Require"} {
+		for _, header := range msg.Headers(name) {
+			switch h := header.(type) {
+			case *RequireHeader:
+				options = append(options, h.Options...)
+			case *ProxyRequireHeader:
+				options = append(options, h.Options...)
+			case *GenericHeader:
+				for _, option := range strings.Split(h.Contents, ",") {
+					if option = strings.TrimSpace(option); len(option) > 0 {
+						options = append(options, option)
+					}
+				}
+			}
+		}
+	}
+	return options
+}
+
+// Build the headers describing the local endpoint's capabilities, for a 200 response to an OPTIONS request
+// (RFC 3261 s. 11.2): Allow, listing the given methods; Supported, listing the given option tags; Accept, listing
+// the given media types; and Accept-Encoding, listing only the 'identity' coding, since no other content codings
+// are supported.
+func CapabilityHeaders(methods []Method, options []string, accept []string) []SipHeader {
+	allow := make(AllowHeader, len(methods))
+	copy(allow, methods)
+
+	supported := make([]string, len(options))
+	copy(supported, options)
+
+	acceptHeader := make(AcceptHeader, 0, len(accept))
+	for _, mediaType := range accept {
+		acceptHeader = append(acceptHeader, &QualifiedValue{mediaType, 1, NewParams()})
+	}
+
+	acceptEncoding := AcceptEncodingHeader{&QualifiedValue{"identity", 1, NewParams()}}
+
+	return []SipHeader{&allow, &SupportedHeader{supported}, &acceptHeader, &acceptEncoding}
+}
+
+func containsOption(options []string, option string) bool {
+	for _, candidate := range options {
+		if candidate == option {
+			return true
+		}
 	}
+	return false
 }
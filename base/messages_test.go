@@ -0,0 +1,641 @@
+package base
+
+import (
+	"strings"
+	"testing"
+)
+
+// Build a response with the given status code, and with a To header bearing the given tag (if any).
+func responseWithToTag(statusCode uint16, tag MaybeString) *Response {
+	params := NewParams()
+	if tag, ok := tag.(String); ok {
+		params.Add("tag", tag)
+	}
+
+	to := &ToHeader{DisplayName: NoString{},
+		Address: &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams},
+		Params:  params}
+	return NewResponse("SIP/2.0", statusCode, "", []SipHeader{to}, "")
+}
+
+func TestEarlyDialogs(t *testing.T) {
+	terminated := responseWithToTag(199, String{"a6c85cf"})
+	if !terminated.IsEarlyDialogTerminated() {
+		t.Errorf("199 response not recognised as Early Dialog Terminated")
+	}
+	if terminated.FormsEarlyDialog() {
+		t.Errorf("199 response unexpectedly forms an early dialog")
+	}
+
+	ringing := responseWithToTag(180, String{"a6c85cf"})
+	if ringing.IsEarlyDialogTerminated() {
+		t.Errorf("180 response unexpectedly recognised as Early Dialog Terminated")
+	}
+	if !ringing.FormsEarlyDialog() {
+		t.Errorf("180 response with To tag does not form an early dialog")
+	}
+
+	if responseWithToTag(180, NoString{}).FormsEarlyDialog() {
+		t.Errorf("180 response without To tag unexpectedly forms an early dialog")
+	}
+	if responseWithToTag(100, String{"a6c85cf"}).FormsEarlyDialog() {
+		t.Errorf("100 response unexpectedly forms an early dialog")
+	}
+	if responseWithToTag(200, String{"a6c85cf"}).FormsEarlyDialog() {
+		t.Errorf("200 response unexpectedly forms an early dialog")
+	}
+
+	dialogs := NewEarlyDialogs()
+	dialogs.Update(ringing)
+	dialogs.Update(responseWithToTag(183, String{"1928301774"}))
+	if dialogs.Length() != 2 || !dialogs.Contains("a6c85cf") || !dialogs.Contains("1928301774") {
+		t.Errorf("expected early dialogs a6c85cf and 1928301774; got %d dialogs", dialogs.Length())
+	}
+
+	dialogs.Update(terminated)
+	if dialogs.Length() != 1 || dialogs.Contains("a6c85cf") || !dialogs.Contains("1928301774") {
+		t.Errorf("expected only early dialog 1928301774 after 199; got %d dialogs", dialogs.Length())
+	}
+}
+
+func TestExtractDialogState(t *testing.T) {
+	alice := &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams}
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	bobTarget := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "192.0.2.4", UriParams: noParams, Headers: noParams}
+	lr := NewParams().Add("lr", NoString{})
+	proxy1 := &SipUri{Password: NoString{}, User: NoString{}, Host: "p1.atlanta.com", UriParams: lr, Headers: noParams}
+	proxy2 := &SipUri{Password: NoString{}, User: NoString{}, Host: "p2.biloxi.com", UriParams: lr, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+
+	from := &FromHeader{NoString{}, alice, NewParams().Add("tag", String{"1928301774"})}
+	to := &ToHeader{NoString{}, bob, NewParams()}
+	invite := NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{
+		to, from, &callId, &CSeq{314159, INVITE},
+	}, "")
+
+	resp := NewResponse("SIP/2.0", 200, "OK", []SipHeader{
+		&RecordRouteHeader{NoString{}, proxy2, NewParams()},
+		&RecordRouteHeader{NoString{}, proxy1, NewParams()},
+		&ToHeader{NoString{}, bob, NewParams().Add("tag", String{"a6c85cf"})},
+		from.Copy(),
+		&callId,
+		&CSeq{314159, INVITE},
+		&ContactHeader{NoString{}, bobTarget, NewParams()},
+	}, "")
+
+	state, err := ExtractDialogState(invite, resp)
+	if err != nil {
+		t.Fatalf("unexpected error extracting dialog state: %s", err.Error())
+	}
+
+	if state.CallId != callId {
+		t.Errorf("unexpected Call-Id: expected %s, got %s", string(callId), string(state.CallId))
+	}
+	if state.LocalTag != "1928301774" || state.RemoteTag != "a6c85cf" {
+		t.Errorf("unexpected tags: expected local 1928301774 and remote a6c85cf, got %s and %s",
+			state.LocalTag, state.RemoteTag)
+	}
+	if !state.LocalUri.Equals(alice) || !state.RemoteUri.Equals(bob) {
+		t.Errorf("unexpected URIs: expected local %s and remote %s, got %s and %s",
+			alice.String(), bob.String(), state.LocalUri.String(), state.RemoteUri.String())
+	}
+	if !state.RemoteTarget.Equals(bobTarget) {
+		t.Errorf("unexpected remote target: expected %s, got %s", bobTarget.String(), state.RemoteTarget.String())
+	}
+	if len(state.RouteSet) != 2 || !state.RouteSet[0].Equals(proxy1) || !state.RouteSet[1].Equals(proxy2) {
+		t.Errorf("unexpected route set: expected [%s %s], got %v", proxy1.String(), proxy2.String(), state.RouteSet)
+	}
+	if state.LocalSeqNo != 314159 {
+		t.Errorf("unexpected local sequence number: expected 314159, got %d", state.LocalSeqNo)
+	}
+
+	ringing := NewResponse("SIP/2.0", 180, "Ringing", resp.AllHeaders(), "")
+	if _, err := ExtractDialogState(invite, ringing); err == nil {
+		t.Errorf("unexpectedly extracted dialog state from a 180 response")
+	}
+
+	noTag := NewResponse("SIP/2.0", 200, "OK", []SipHeader{to, from, &callId, resp.Headers("Contact")[0]}, "")
+	if _, err := ExtractDialogState(invite, noTag); err == nil {
+		t.Errorf("unexpectedly extracted dialog state from a 200 response without a To tag")
+	}
+}
+
+func TestDialogID(t *testing.T) {
+	alice := &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams}
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+	from := &FromHeader{NoString{}, alice, NewParams().Add("tag", String{"1928301774"})}
+
+	// An initial request forms a half dialog, with no remote tag.
+	invite := NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{
+		&ToHeader{NoString{}, bob, NewParams()}, from, &callId, &CSeq{314159, INVITE},
+	}, "")
+	halfId, err := invite.DialogID()
+	if err != nil {
+		t.Fatalf("unexpected error getting dialog ID of initial request: %s", err.Error())
+	}
+	if expected := DialogID(callId, String{"1928301774"}, NoString{}); halfId != expected {
+		t.Errorf("unexpected half dialog ID: expected %s, got %s", expected, halfId)
+	}
+
+	// Requests within the established dialog carry both tags.
+	bye := NewRequest(BYE, bob, "SIP/2.0", []SipHeader{
+		&ToHeader{NoString{}, bob, NewParams().Add("tag", String{"a6c85cf"})}, from, &callId, &CSeq{231, BYE},
+	}, "")
+	fullId, err := bye.DialogID()
+	if err != nil {
+		t.Fatalf("unexpected error getting dialog ID of in-dialog request: %s", err.Error())
+	}
+	if expected := DialogID(callId, String{"1928301774"}, String{"a6c85cf"}); fullId != expected {
+		t.Errorf("unexpected dialog ID: expected %s, got %s", expected, fullId)
+	}
+	if fullId == halfId {
+		t.Errorf("expected established and half dialogs to have different IDs; both were %s", fullId)
+	}
+
+	// The key depends on which side each tag belongs to.
+	if DialogID(callId, String{"a"}, String{"b"}) == DialogID(callId, String{"b"}, String{"a"}) {
+		t.Errorf("expected dialog ID to distinguish local and remote tags")
+	}
+
+	noCallId := NewRequest(BYE, bob, "SIP/2.0", []SipHeader{from}, "")
+	if _, err := noCallId.DialogID(); err == nil {
+		t.Errorf("expected error getting dialog ID of request with no Call-Id")
+	}
+}
+
+func TestDecrementMaxForwards(t *testing.T) {
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+
+	// A nonzero Max-Forwards is decremented in place, keeping its position among the headers.
+	request := NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{&callId, MaxForwards(2), &CSeq{314159, INVITE}}, "")
+	remaining, err := request.DecrementMaxForwards()
+	if err != nil {
+		t.Fatalf("unexpected error decrementing Max-Forwards of 2: %s", err.Error())
+	}
+	if remaining != 1 {
+		t.Errorf("expected 1 hop remaining; got %d", remaining)
+	}
+	expected := "INVITE sip:bob@biloxi.com SIP/2.0\r\nCall-Id: a84b4c76e66710@pc33.atlanta.com\r\n" +
+		"Max-Forwards: 1\r\nCSeq: 314159 INVITE\r\n\r\n"
+	if request.String() != expected {
+		t.Errorf("unexpected request after decrementing Max-Forwards: expected\n%s\ngot\n%s", expected, request.String())
+	}
+
+	remaining, err = request.DecrementMaxForwards()
+	if err != nil || remaining != 0 {
+		t.Errorf("expected 0 hops remaining with no error; got %d, %v", remaining, err)
+	}
+
+	// Once Max-Forwards reaches 0, the request must not be forwarded.
+	if _, err = request.DecrementMaxForwards(); err == nil {
+		t.Errorf("expected error decrementing Max-Forwards of 0")
+	}
+	if maxForwards := request.Headers("Max-Forwards"); len(maxForwards) != 1 || maxForwards[0] != MaxForwards(0) {
+		t.Errorf("expected Max-Forwards to remain 0 after failed decrement; got %v", maxForwards)
+	}
+
+	// A missing Max-Forwards is taken to be the default of 70.
+	request = NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{&callId}, "")
+	remaining, err = request.DecrementMaxForwards()
+	if err != nil {
+		t.Fatalf("unexpected error decrementing absent Max-Forwards: %s", err.Error())
+	}
+	if remaining != 69 {
+		t.Errorf("expected 69 hops remaining; got %d", remaining)
+	}
+	if maxForwards := request.Headers("Max-Forwards"); len(maxForwards) != 1 || maxForwards[0] != MaxForwards(69) {
+		t.Errorf("expected a single Max-Forwards of 69; got %v", maxForwards)
+	}
+}
+
+func TestDefaultReason(t *testing.T) {
+	tests := []struct {
+		code   uint16
+		reason string
+	}{
+		{100, "Trying"},
+		{180, "Ringing"},
+		{200, "OK"},
+		{302, "Moved Temporarily"},
+		{404, "Not Found"},
+		{422, "Session Interval Too Small"},
+		{481, "Call/Transaction Does Not Exist"},
+		{503, "Service Unavailable"},
+		{603, "Decline"},
+		{999, ""},
+		{299, ""},
+	}
+
+	for _, test := range tests {
+		if reason := DefaultReason(test.code); reason != test.reason {
+			t.Errorf("unexpected reason phrase for %d: expected '%s', got '%s'", test.code, test.reason, reason)
+		}
+	}
+}
+
+func TestNextHop(t *testing.T) {
+	port := uint16(5070)
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	looseRoute := &RouteHeader{NoString{}, &SipUri{User: NoString{}, Password: NoString{}, Host: "p1.example.com",
+		Port: &port, UriParams: NewParams().Add("lr", NoString{}).Add("transport", String{"TCP"}), Headers: noParams}, noParams}
+	strictRoute := &RouteHeader{NoString{}, &SipUri{User: NoString{}, Password: NoString{}, Host: "p2.example.com",
+		UriParams: noParams, Headers: noParams}, noParams}
+
+	tests := []struct {
+		recipient Uri
+		routes    []SipHeader
+		host      string
+		port      uint16
+		transport string
+	}{
+		{bob, []SipHeader{}, "biloxi.com", 5060, "udp"},
+		{bob, []SipHeader{looseRoute, strictRoute}, "p1.example.com", 5070, "tcp"},
+		{bob, []SipHeader{strictRoute, looseRoute}, "biloxi.com", 5060, "udp"},
+		{&SipUri{IsEncrypted: true, User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams,
+			Headers: noParams}, []SipHeader{}, "biloxi.com", 5061, "tls"},
+		{&SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com",
+			UriParams: NewParams().Add("maddr", String{"239.255.255.1"}), Headers: noParams},
+			[]SipHeader{}, "239.255.255.1", 5060, "udp"},
+	}
+
+	for _, test := range tests {
+		request := NewRequest(INVITE, test.recipient, "SIP/2.0", test.routes, "")
+		host, port, transport, err := request.NextHop()
+		if err != nil {
+			t.Errorf("unexpected error getting next hop of request:\n%s\n%s", request.String(), err.Error())
+		} else if host != test.host || port != test.port || transport != test.transport {
+			t.Errorf("unexpected next hop of request:\n%s\nexpected %s:%d over %s; got %s:%d over %s",
+				request.String(), test.host, test.port, test.transport, host, port, transport)
+		}
+	}
+
+	tel := NewRequest(INVITE, &TelUri{Number: "+12125551212", Params: NewParams()}, "SIP/2.0", []SipHeader{}, "")
+	if _, _, _, err := tel.NextHop(); err == nil {
+		t.Errorf("expected error getting next hop of request to tel URI")
+	}
+}
+
+func TestNewCancel(t *testing.T) {
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	alice := &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams}
+	port := uint16(5060)
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+	invite := NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{
+		ViaHeader{
+			&ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", &port, NewParams().Add("branch", String{"z9hG4bK776asdhds"})},
+			&ViaHop{"SIP", "2.0", "UDP", "client.atlanta.com", nil, NewParams().Add("branch", String{"z9hG4bK74bf9"})},
+		},
+		MaxForwards(70),
+		&ToHeader{NoString{}, bob, NewParams()},
+		&FromHeader{NoString{}, alice, NewParams().Add("tag", String{"1928301774"})},
+		&callId,
+		&CSeq{314159, INVITE},
+	}, "v=0")
+
+	cancel, err := NewCancel(invite)
+	if err != nil {
+		t.Fatalf("unexpected error creating CANCEL: %s", err.Error())
+	}
+
+	if cancel.Method != CANCEL {
+		t.Errorf("unexpected method: expected CANCEL, got %s", cancel.Method)
+	}
+	if !cancel.Recipient.Equals(invite.Recipient) {
+		t.Errorf("unexpected Request-URI: expected %s, got %s", invite.Recipient.String(), cancel.Recipient.String())
+	}
+
+	hops := cancel.ViaHops()
+	if len(hops) != 1 {
+		t.Fatalf("expected exactly one Via hop on CANCEL; got %d", len(hops))
+	}
+	if branch, _ := hops[0].Params.Get("branch"); branch != (String{"z9hG4bK776asdhds"}) {
+		t.Errorf("unexpected Via branch: expected z9hG4bK776asdhds, got %s", branch)
+	}
+
+	cseq := cancel.Headers("CSeq")[0].(*CSeq)
+	if cseq.SeqNo != 314159 || cseq.MethodName != CANCEL {
+		t.Errorf("unexpected CSeq: expected 314159 CANCEL, got %s", cseq.String())
+	}
+	if invite.Headers("CSeq")[0].(*CSeq).MethodName != INVITE {
+		t.Errorf("creating CANCEL modified the INVITE's CSeq")
+	}
+
+	for _, name := range []string{"To", "From", "Call-Id", "Max-Forwards"} {
+		if cancel.Headers(name)[0].String() != invite.Headers(name)[0].String() {
+			t.Errorf("unexpected %s header: expected %s, got %s",
+				name, invite.Headers(name)[0].String(), cancel.Headers(name)[0].String())
+		}
+	}
+	if cancel.GetBody() != "" {
+		t.Errorf("unexpected body on CANCEL: %s", cancel.GetBody())
+	}
+
+	bye := NewRequest(BYE, bob, "SIP/2.0", invite.AllHeaders(), "")
+	if _, err := NewCancel(bye); err == nil {
+		t.Errorf("unexpectedly created a CANCEL for a BYE")
+	}
+}
+
+func TestHeaderLookup(t *testing.T) {
+	via1 := ViaHeader{&ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, NewParams()}}
+	via2 := ViaHeader{&ViaHop{"SIP", "2.0", "UDP", "bigbox3.site3.atlanta.com", nil, NewParams()}}
+	length := ContentLength(0)
+	request := NewRequest(BYE, &SipUri{Host: "biloxi.com", UriParams: noParams, Headers: noParams},
+		"SIP/2.0", []SipHeader{via1, &length, via2}, "")
+
+	for _, name := range []string{"v", "V", "via", "Via", "VIA"} {
+		vias := request.Header(name)
+		if len(vias) != 2 || vias[0].String() != via1.String() || vias[1].String() != via2.String() {
+			t.Errorf("Header(%q) returned %v; expected both Via headers in order", name, vias)
+		}
+	}
+
+	if lengths := request.Header("l"); len(lengths) != 1 || lengths[0].Name() != "Content-Length" {
+		t.Errorf("Header(\"l\") returned %v; expected the Content-Length header", lengths)
+	}
+
+	if routes := request.Header("Route"); len(routes) != 0 {
+		t.Errorf("Header(\"Route\") returned %v; expected no headers", routes)
+	}
+}
+
+func TestCanonicalHeaderNames(t *testing.T) {
+	compact := map[string]string{
+		"a": "Accept-Contact",
+		"b": "Referred-By",
+		"c": "Content-Type",
+		"e": "Content-Encoding",
+		"f": "From",
+		"i": "Call-Id",
+		"j": "Reject-Contact",
+		"k": "Supported",
+		"l": "Content-Length",
+		"m": "Contact",
+		"o": "Event",
+		"r": "Refer-To",
+		"s": "Subject",
+		"t": "To",
+		"u": "Allow-Events",
+		"v": "Via",
+		"x": "Session-Expires",
+	}
+	for short, long := range compact {
+		if canonical := CanonicalHeaderName(short); canonical != long {
+			t.Errorf("expected compact form %s to expand to %s; got %s", short, long, canonical)
+		}
+		if canonical := CanonicalHeaderName(strings.ToUpper(short)); canonical != long {
+			t.Errorf("expected compact form %s to expand to %s; got %s", strings.ToUpper(short), long, canonical)
+		}
+	}
+
+	// Canonical names must match the names under which typed headers are stored.
+	callId := CallId("a84b4c76e66710")
+	length := ContentLength(0)
+	for _, header := range []SipHeader{&callId, &CSeq{}, &RAck{}, MinSE(0), &length, ViaHeader{}, &ToHeader{},
+		PVisitedNetworkID{}, &SessionExpiresHeader{}} {
+		for _, name := range []string{header.Name(), strings.ToLower(header.Name()), strings.ToUpper(header.Name())} {
+			if canonical := CanonicalHeaderName(name); canonical != header.Name() {
+				t.Errorf("expected %s to have canonical name %s; got %s", name, header.Name(), canonical)
+			}
+		}
+	}
+
+	// Unknown headers keep their names as given.
+	if canonical := CanonicalHeaderName("X-Custom-HEADER"); canonical != "X-Custom-HEADER" {
+		t.Errorf("expected unknown header name to be unchanged; got %s", canonical)
+	}
+
+	// Generic headers are written out under their canonical names.
+	generic := &GenericHeader{"k", "100rel"}
+	if generic.String() != "Supported: 100rel" || generic.Name() != "Supported" {
+		t.Errorf("expected generic header 'k' to be written as Supported; got %s", generic.String())
+	}
+}
+
+func TestValidateMessages(t *testing.T) {
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	alice := &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+	via := ViaHeader{&ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", nil, NewParams().Add("branch", String{"z9hG4bK776asdhds"})}}
+	to := &ToHeader{NoString{}, bob, NewParams()}
+	from := &FromHeader{NoString{}, alice, NewParams().Add("tag", String{"1928301774"})}
+
+	tests := []struct {
+		description string
+		method      Method
+		headers     []SipHeader
+		problems    []string
+	}{
+		{"valid request", INVITE,
+			[]SipHeader{via, MaxForwards(70), to, from, &callId, &CSeq{314159, INVITE}}, nil},
+		{"request missing Max-Forwards", INVITE,
+			[]SipHeader{via, to, from, &callId, &CSeq{314159, INVITE}}, []string{"missing Max-Forwards header"}},
+		{"request missing Via and Call-Id", BYE,
+			[]SipHeader{MaxForwards(70), to, from, &CSeq{314159, BYE}},
+			[]string{"missing Call-Id header", "missing Via header"}},
+		{"request with empty Via", BYE,
+			[]SipHeader{ViaHeader{}, MaxForwards(70), to, from, &callId, &CSeq{314159, BYE}},
+			[]string{"no hops in Via header"}},
+		{"request with mismatched CSeq method", BYE,
+			[]SipHeader{via, MaxForwards(70), to, from, &callId, &CSeq{314159, INVITE}},
+			[]string{"CSeq method INVITE does not match request method BYE"}},
+		{"request with lowercase CSeq method", INVITE,
+			[]SipHeader{via, MaxForwards(70), to, from, &callId, &CSeq{314159, Method("invite")}}, nil},
+		{"request with no headers", OPTIONS, []SipHeader{},
+			[]string{"missing To header", "missing From header", "missing CSeq header", "missing Call-Id header",
+				"missing Max-Forwards header", "missing Via header"}},
+	}
+
+	for _, test := range tests {
+		err := ValidateRequest(NewRequest(test.method, bob, "SIP/2.0", test.headers, ""))
+		checkValidationError(t, test.description, err, test.problems)
+	}
+
+	response := NewResponse("SIP/2.0", 200, "OK", []SipHeader{via, to, from, &callId, &CSeq{314159, INVITE}}, "")
+	checkValidationError(t, "valid response", ValidateResponse(response), nil)
+
+	response = NewResponse("SIP/2.0", 200, "OK", []SipHeader{via, to, &CSeq{314159, INVITE}}, "")
+	checkValidationError(t, "response missing From and Call-Id", ValidateResponse(response),
+		[]string{"missing From header", "missing Call-Id header"})
+}
+
+func checkValidationError(t *testing.T, description string, err error, problems []string) {
+	if len(problems) == 0 {
+		if err != nil {
+			t.Errorf("%s: unexpected validation error: %s", description, err.Error())
+		}
+		return
+	}
+
+	if err == nil {
+		t.Errorf("%s: expected validation error listing %q; got none", description, problems)
+		return
+	}
+	for _, problem := range problems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("%s: expected validation error to contain %q; got %q", description, problem, err.Error())
+		}
+	}
+}
+
+func TestSetBody(t *testing.T) {
+	callId := CallId("abc")
+	response := NewResponse("SIP/2.0", 200, "OK", []SipHeader{&callId}, "")
+	response.SetBody("hello")
+	response.SetBody("hello, world")
+	expected := "SIP/2.0 200 OK\r\nCall-Id: abc\r\nContent-Length: 12\r\n\r\nhello, world"
+	if response.String() != expected {
+		t.Errorf("unexpected response after setting body: expected\n%s\ngot\n%s", expected, response.String())
+	}
+	if lengths := response.Headers("Content-Length"); len(lengths) != 1 || lengths[0].String() != "Content-Length: 12" {
+		t.Errorf("expected a single Content-Length of 12; got %v", lengths)
+	}
+}
+
+func TestShort(t *testing.T) {
+	uri := &SipUri{User: String{"b"}, Password: NoString{}, Host: "h", UriParams: noParams, Headers: noParams}
+	callId := CallId("abc")
+	cseq := &CSeq{1, INVITE}
+
+	request := NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{&callId, cseq}, "")
+	if short := request.Short(); short != "INVITE sip:b@h SIP/2.0 (Call-Id: abc, CSeq: 1 INVITE)" {
+		t.Errorf("unexpected short form of request with Call-Id and CSeq: '%s'", short)
+	}
+
+	request = NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{cseq}, "")
+	if short := request.Short(); short != "INVITE sip:b@h SIP/2.0 (CSeq: 1 INVITE)" {
+		t.Errorf("unexpected short form of request with only CSeq: '%s'", short)
+	}
+
+	request = NewRequest(INVITE, uri, "SIP/2.0", []SipHeader{}, "")
+	if short := request.Short(); short != "INVITE sip:b@h SIP/2.0" {
+		t.Errorf("unexpected short form of request with no headers: '%s'", short)
+	}
+
+	response := NewResponse("SIP/2.0", 200, "OK", []SipHeader{cseq, &callId}, "")
+	if short := response.Short(); short != "SIP/2.0 200 OK (Call-Id: abc, CSeq: 1 INVITE)" {
+		t.Errorf("unexpected short form of response with Call-Id and CSeq: '%s'", short)
+	}
+
+	response = NewResponse("SIP/2.0", 200, "OK", []SipHeader{&callId}, "")
+	if short := response.Short(); short != "SIP/2.0 200 OK (Call-Id: abc)" {
+		t.Errorf("unexpected short form of response with only Call-Id: '%s'", short)
+	}
+
+	response = NewResponse("SIP/2.0", 200, "OK", []SipHeader{}, "")
+	if short := response.Short(); short != "SIP/2.0 200 OK" {
+		t.Errorf("unexpected short form of response with no headers: '%s'", short)
+	}
+}
+
+func TestPopVia(t *testing.T) {
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+	p1 := &ViaHop{"SIP", "2.0", "UDP", "p1.example.com", nil, NewParams().Add("branch", String{"z9hG4bK1"})}
+	p2 := &ViaHop{"SIP", "2.0", "UDP", "p2.example.com", nil, NewParams().Add("branch", String{"z9hG4bK2"})}
+	p3 := &ViaHop{"SIP", "2.0", "UDP", "p3.example.com", nil, NewParams().Add("branch", String{"z9hG4bK3"})}
+
+	// Popping from a multi-hop Via leaves the rest of its hops in place.
+	multiHop := ViaHeader{p1, p2}
+	response := NewResponse("SIP/2.0", 200, "OK", []SipHeader{&multiHop, &callId, &ViaHeader{p3}}, "")
+	hop, err := response.PopVia()
+	if err != nil || hop != p1 {
+		t.Fatalf("expected to pop hop %s; got %v, %v", p1.String(), hop, err)
+	}
+	expected := "SIP/2.0 200 OK\r\nVia: SIP/2.0/UDP p2.example.com;branch=z9hG4bK2\r\n" +
+		"Call-Id: a84b4c76e66710@pc33.atlanta.com\r\nVia: SIP/2.0/UDP p3.example.com;branch=z9hG4bK3\r\n\r\n"
+	if response.String() != expected {
+		t.Errorf("unexpected response after popping Via: expected\n%s\ngot\n%s", expected, response.String())
+	}
+
+	// Popping the only hop of a Via removes the header.
+	if hop, err = response.PopVia(); err != nil || hop != p2 {
+		t.Fatalf("expected to pop hop %s; got %v, %v", p2.String(), hop, err)
+	}
+	if hops := response.ViaHops(); len(hops) != 1 || hops[0] != p3 {
+		t.Errorf("expected only hop %s to remain; got %v", p3.String(), hops)
+	}
+	if vias := response.Headers("Via"); len(vias) != 1 {
+		t.Errorf("expected one Via header to remain; got %d", len(vias))
+	}
+
+	// Single-hop Vias held by value are removed just the same.
+	request := NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{ViaHeader{p1}, &callId}, "")
+	if hop, err = request.PopVia(); err != nil || hop != p1 {
+		t.Fatalf("expected to pop hop %s; got %v, %v", p1.String(), hop, err)
+	}
+	expected = "INVITE sip:bob@biloxi.com SIP/2.0\r\nCall-Id: a84b4c76e66710@pc33.atlanta.com\r\n\r\n"
+	if request.String() != expected {
+		t.Errorf("unexpected request after popping Via: expected\n%s\ngot\n%s", expected, request.String())
+	}
+	if vias := request.Headers("Via"); len(vias) != 0 {
+		t.Errorf("expected no Via headers to remain; got %v", vias)
+	}
+
+	if _, err = request.PopVia(); err == nil {
+		t.Errorf("expected error popping Via from request with no Via")
+	}
+}
+
+func TestRouteSet(t *testing.T) {
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+	route := func(host string) *SipUri {
+		return &SipUri{User: NoString{}, Password: NoString{}, Host: host, UriParams: NewParams().Add("lr", NoString{}),
+			Headers: noParams}
+	}
+
+	// Pushed routes go above existing ones, so the last pushed is visited first.
+	request := NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{&callId}, "")
+	request.PushRoute(route("p3.example.com"))
+	request.PushRoute(route("p2.example.com"))
+	request.PushRoute(route("p1.example.com"))
+	expected := "INVITE sip:bob@biloxi.com SIP/2.0\r\nCall-Id: a84b4c76e66710@pc33.atlanta.com\r\n" +
+		"Route: <sip:p1.example.com;lr>\r\nRoute: <sip:p2.example.com;lr>\r\nRoute: <sip:p3.example.com;lr>\r\n\r\n"
+	if request.String() != expected {
+		t.Errorf("unexpected request after pushing routes: expected\n%s\ngot\n%s", expected, request.String())
+	}
+	if host, _, _, err := request.NextHop(); err != nil || host != "p1.example.com" {
+		t.Errorf("expected next hop p1.example.com after pushing routes; got '%s', %v", host, err)
+	}
+
+	// Setting the route set replaces the existing routes, keeping the given order.
+	request.SetRouteSet([]Uri{route("q1.example.com"), route("q2.example.com")})
+	expected = "INVITE sip:bob@biloxi.com SIP/2.0\r\nCall-Id: a84b4c76e66710@pc33.atlanta.com\r\n" +
+		"Route: <sip:q1.example.com;lr>\r\nRoute: <sip:q2.example.com;lr>\r\n\r\n"
+	if request.String() != expected {
+		t.Errorf("unexpected request after setting route set: expected\n%s\ngot\n%s", expected, request.String())
+	}
+
+	request.SetRouteSet(nil)
+	if routes := request.Headers("Route"); len(routes) != 0 {
+		t.Errorf("expected no Route headers after setting empty route set; got %v", routes)
+	}
+}
+
+func TestCheckRequiredOptions(t *testing.T) {
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	supported := []string{"100rel", "timer", "replaces"}
+
+	tests := []struct {
+		headers     []SipHeader
+		unsupported []string
+	}{
+		{[]SipHeader{}, []string{}},
+		{[]SipHeader{&RequireHeader{[]string{"100rel", "timer"}}}, []string{}},
+		{[]SipHeader{&RequireHeader{[]string{"100rel", "gruu"}}, &ProxyRequireHeader{[]string{"sec-agree", "timer"}}},
+			[]string{"gruu", "sec-agree"}},
+		{[]SipHeader{&RequireHeader{[]string{"gruu"}}, &GenericHeader{"Require", "replaces, gruu,path"}},
+			[]string{"gruu", "path"}},
+	}
+
+	for _, test := range tests {
+		request := NewRequest(INVITE, bob, "SIP/2.0", test.headers, "")
+		unsupported := CheckRequiredOptions(request, supported)
+		if strings.Join(unsupported, ",") != strings.Join(test.unsupported, ",") {
+			t.Errorf("expected unsupported options %v for request:\n%s\ngot %v", test.unsupported, request, unsupported)
+		}
+	}
+}
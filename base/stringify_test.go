@@ -5,6 +5,7 @@ package base
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 // Generic test for testing anything with a String() method.
@@ -33,6 +34,7 @@ func doTests(tests []stringTest, t *testing.T) {
 // Some global ports to use since port is still a pointer.
 var port5060 uint16 = 5060
 var port6060 uint16 = 6060
+var halfSecond float64 = 0.5
 var noParams = NewParams()
 
 func TestSipUri(t *testing.T) {
@@ -52,6 +54,12 @@ func TestSipUri(t *testing.T) {
 		{"SIP URI with other port",
 			&SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com", Port: &port6060, UriParams: noParams, Headers: noParams},
 			"sip:alice@wonderland.com:6060"},
+		{"SIP URI with IPv6 host",
+			&SipUri{User: String{"bob"}, Password: NoString{}, Host: "2001:db8::1", UriParams: noParams, Headers: noParams},
+			"sip:bob@[2001:db8::1]"},
+		{"SIP URI with IPv6 host and port",
+			&SipUri{User: String{"bob"}, Password: NoString{}, Host: "2001:db8::1", Port: &port5060, UriParams: noParams, Headers: noParams},
+			"sip:bob@[2001:db8::1]:5060"},
 		{"Basic SIPS URI",
 			&SipUri{IsEncrypted: true, User: String{"alice"}, Password: NoString{}, Host: "wonderland.com", UriParams: noParams, Headers: noParams},
 			"sips:alice@wonderland.com"},
@@ -74,17 +82,17 @@ func TestSipUri(t *testing.T) {
 			&SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com",
 				UriParams: noParams,
 				Headers:   NewParams().Add("CakeLocation", String{"Tea Party"})},
-			"sip:alice@wonderland.com?CakeLocation=\"Tea Party\""},
+			"sip:alice@wonderland.com?CakeLocation=Tea%20Party"},
 		{"SIP URI with three headers",
 			&SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com",
 				UriParams: noParams,
 				Headers:   NewParams().Add("CakeLocation", String{"Tea Party"}).Add("Identity", String{"Mad Hatter"}).Add("OtherHeader", String{"Some value"})},
-			"sip:alice@wonderland.com?CakeLocation=\"Tea Party\"&Identity=\"Mad Hatter\"&OtherHeader=\"Some value\""},
+			"sip:alice@wonderland.com?CakeLocation=Tea%20Party&Identity=Mad%20Hatter&OtherHeader=Some%20value"},
 		{"SIP URI with parameter and header",
 			&SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com",
 				UriParams: NewParams().Add("food", String{"cake"}),
 				Headers:   NewParams().Add("CakeLocation", String{"Tea Party"})},
-			"sip:alice@wonderland.com;food=cake?CakeLocation=\"Tea Party\""},
+			"sip:alice@wonderland.com;food=cake?CakeLocation=Tea%20Party"},
 		{"Wildcard URI", &WildcardUri{}, "*"},
 	}, t)
 }
@@ -102,6 +110,16 @@ func TestHeaders(t *testing.T) {
 				Address: &SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com", UriParams: noParams, Headers: noParams},
 				Params:  noParams},
 			"To: \"Alice Liddell\" <sip:alice@wonderland.com>"},
+		{"To Header with quotes in display name",
+			&ToHeader{DisplayName: String{"Alice \"the\" Liddell"},
+				Address: &SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com", UriParams: noParams, Headers: noParams},
+				Params:  noParams},
+			"To: \"Alice \\\"the\\\" Liddell\" <sip:alice@wonderland.com>"},
+		{"From Header with backslash in display name",
+			&FromHeader{DisplayName: String{"Alice\\Liddell"},
+				Address: &SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com", UriParams: noParams, Headers: noParams},
+				Params:  noParams},
+			"From: \"Alice\\\\Liddell\" <sip:alice@wonderland.com>"},
 		{"To Header with parameters",
 			&ToHeader{DisplayName: NoString{},
 				Address: &SipUri{User: String{"alice"}, Password: NoString{}, Host: "wonderland.com", UriParams: noParams, Headers: noParams},
@@ -151,6 +169,108 @@ func TestHeaders(t *testing.T) {
 			&ContactHeader{DisplayName: NoString{}, Address: &WildcardUri{}, Params: NewParams().Add("food", String{"cake"})},
 			"Contact: *;food=cake"},
 
+		// Refer-To and Referred-By Headers.
+		{"Refer-To Header",
+			&ReferToHeader{DisplayName: NoString{},
+				Address: &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams,
+					Headers: NewParams().Add("Replaces", String{"12345@192.168.118.3;to-tag=12345;from-tag=5FFE-3994"})},
+				Params: noParams},
+			"Refer-To: <sip:bob@biloxi.com?Replaces=12345%40192.168.118.3%3Bto-tag%3D12345%3Bfrom-tag%3D5FFE-3994>"},
+		{"Referred-By Header with display name",
+			&ReferredByHeader{DisplayName: String{"Alice"},
+				Address: &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams},
+				Params:  noParams},
+			"Referred-By: \"Alice\" <sip:alice@atlanta.com>"},
+
+		// Warning Headers.
+		{"Warning Header", WarningHeader{&Warning{307, "isi.edu", "Session parameter 'foo' not understood"}},
+			"Warning: 307 isi.edu \"Session parameter 'foo' not understood\""},
+		{"Warning Header with two warnings",
+			WarningHeader{&Warning{307, "isi.edu", "Session parameter 'foo' not understood"}, &Warning{301, "isi.edu", "Say \"hi\""}},
+			"Warning: 307 isi.edu \"Session parameter 'foo' not understood\", 301 isi.edu \"Say \\\"hi\\\"\""},
+
+		// Content-Disposition Headers.
+		{"Content-Disposition Header", &ContentDispositionHeader{"render", noParams}, "Content-Disposition: render"},
+		{"Content-Disposition Header with params", &ContentDispositionHeader{"session", NewParams().Add("handling", String{"optional"})},
+			"Content-Disposition: session;handling=optional"},
+
+		// Refer-Sub Headers.
+		{"Refer-Sub Header", &ReferSub{false, noParams}, "Refer-Sub: false"},
+		{"Refer-Sub Header with params", &ReferSub{true, NewParams().Add("foo", String{"bar"})}, "Refer-Sub: true;foo=bar"},
+
+		// Replaces Headers.
+		{"Replaces Header",
+			&ReplacesHeader{"98732@sip.example.com",
+				NewParams().Add("to-tag", String{"xyz"}).Add("from-tag", String{"abc"})},
+			"Replaces: 98732@sip.example.com;to-tag=xyz;from-tag=abc"},
+		{"Replaces Header with early-only flag",
+			&ReplacesHeader{"98732@sip.example.com",
+				NewParams().Add("to-tag", String{"xyz"}).Add("from-tag", String{"abc"}).Add("early-only", NoString{})},
+			"Replaces: 98732@sip.example.com;to-tag=xyz;from-tag=abc;early-only"},
+
+		// Route and Record-Route Headers.
+		{"Route Header",
+			&RouteHeader{DisplayName: NoString{},
+				Address: &SipUri{User: NoString{}, Password: NoString{}, Host: "p1.atlanta.com",
+					UriParams: NewParams().Add("lr", NoString{}), Headers: noParams},
+				Params: noParams},
+			"Route: <sip:p1.atlanta.com;lr>"},
+		{"Record-Route Header",
+			&RecordRouteHeader{DisplayName: NoString{},
+				Address: &SipUri{User: NoString{}, Password: NoString{}, Host: "p1.atlanta.com",
+					UriParams: NewParams().Add("lr", NoString{}), Headers: noParams},
+				Params: noParams},
+			"Record-Route: <sip:p1.atlanta.com;lr>"},
+
+		// RSeq and RAck Headers.
+		{"RSeq Header", RSeq(988789), "RSeq: 988789"},
+		{"RAck Header", &RAck{776656, 1, INVITE}, "RAck: 776656 1 INVITE"},
+
+		// Session timer Headers.
+		{"Session-Expires Header", &SessionExpiresHeader{90, noParams}, "Session-Expires: 90"},
+		{"Session-Expires Header with refresher", &SessionExpiresHeader{1800, NewParams().Add("refresher", String{"uac"})},
+			"Session-Expires: 1800;refresher=uac"},
+		{"Min-SE Header", MinSE(90), "Min-SE: 90"},
+		{"Min-Expires Header", MinExpires(60), "Min-Expires: 60"},
+		{"Organization Header", OrganizationHeader("Boxes by Bob"), "Organization: Boxes by Bob"},
+		{"Subject Header", SubjectHeader("Need more boxes"), "Subject: Need more boxes"},
+		{"SIP-ETag Header", SIPETagHeader("dx200xyz"), "SIP-ETag: dx200xyz"},
+		{"SIP-If-Match Header", SIPIfMatchHeader("dx200xyz"), "SIP-If-Match: dx200xyz"},
+		{"Priority Header", PriorityEmergency, "Priority: emergency"},
+		{"In-Reply-To Header", InReplyToHeader{"70710@saturn.bell-tel.com", "17320@saturn.bell-tel.com"},
+			"In-Reply-To: 70710@saturn.bell-tel.com, 17320@saturn.bell-tel.com"},
+		{"Allow-Events Header", AllowEventsHeader{"presence", "dialog"}, "Allow-Events: presence, dialog"},
+		{"Reply-To Header",
+			&ReplyToHeader{String{"Bob"}, &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}, noParams},
+			"Reply-To: \"Bob\" <sip:bob@biloxi.com>"},
+
+		// Accept-Contact and Reject-Contact Headers.
+		{"Accept-Contact Header",
+			AcceptContactHeader{
+				&CallerPrefEntry{NewParams().Add("video", NoString{}).Add("!audio", NoString{}).Add("require", NoString{})},
+				&CallerPrefEntry{NewParams().Add("language", String{"en,fr"})}},
+			"Accept-Contact: *;video;!audio;require, *;language=\"en,fr\""},
+		{"Reject-Contact Header",
+			RejectContactHeader{&CallerPrefEntry{NewParams().Add("audio", NoString{})}},
+			"Reject-Contact: *;audio"},
+
+		// Date Headers.
+		{"Date Header", &DateHeader{time.Date(2010, time.November, 13, 23, 29, 0, 0, time.UTC)},
+			"Date: Sat, 13 Nov 2010 23:29:00 GMT"},
+		{"Date Header converted to GMT", &DateHeader{time.Date(2010, time.November, 13, 18, 29, 0, 0, time.FixedZone("EST", -5*60*60))},
+			"Date: Sat, 13 Nov 2010 23:29:00 GMT"},
+
+		// Retry-After Headers.
+		{"Retry-After Header", &RetryAfterHeader{120, NoString{}, noParams}, "Retry-After: 120"},
+		{"Retry-After Header with comment and params",
+			&RetryAfterHeader{120, String{"I'm busy"}, NewParams().Add("duration", String{"3600"})},
+			"Retry-After: 120 (I'm busy);duration=3600"},
+
+		// Timestamp Headers.
+		{"Timestamp Header", &TimestampHeader{54, nil}, "Timestamp: 54"},
+		{"Timestamp Header with fraction", &TimestampHeader{54.21, nil}, "Timestamp: 54.21"},
+		{"Timestamp Header with delay", &TimestampHeader{54.21, &halfSecond}, "Timestamp: 54.21 0.5"},
+
 		// Via Headers.
 		{"Basic Via Header", ViaHeader{&ViaHop{"SIP", "2.0", "UDP", "wonderland.com", nil, NewParams()}}, "Via: SIP/2.0/UDP wonderland.com"},
 		{"Via Header with port", ViaHeader{&ViaHop{"SIP", "2.0", "UDP", "wonderland.com", &port6060, NewParams()}}, "Via: SIP/2.0/UDP wonderland.com:6060"},
@@ -168,6 +288,33 @@ func TestHeaders(t *testing.T) {
 			&ViaHop{"SIP", "2.0", "UDP", "oxford.co.uk", nil, NewParams().Add("delicious", NoString{})},
 		}, "Via: SIP/2.0/UDP wonderland.com:5060, SIP/2.0/TCP looking-glass.net:6060;food=cake, SIP/2.0/UDP oxford.co.uk;delicious"},
 
+		// Alert-Info, Call-Info and Error-Info Headers.
+		{"Alert-Info Header", AlertInfoHeader{&InfoEntry{&AbsoluteUri{"http", "//www.example.com/sounds/moo.wav"}, noParams}},
+			"Alert-Info: <http://www.example.com/sounds/moo.wav>"},
+		{"Call-Info Header with purpose",
+			CallInfoHeader{&InfoEntry{&AbsoluteUri{"http", "//wwww.example.com/alice/photo.jpg"}, NewParams().Add("purpose", String{"icon"})}},
+			"Call-Info: <http://wwww.example.com/alice/photo.jpg>;purpose=icon"},
+		{"Error-Info Header", ErrorInfoHeader{&InfoEntry{&AbsoluteUri{"http", "//www.example.com/error"}, noParams}},
+			"Error-Info: <http://www.example.com/error>"},
+
+		// P-Asserted-Identity Headers.
+		{"P-Asserted-Identity Header with SIP and tel identities",
+			PAssertedIdentityHeader{
+				&NameAddr{String{"Cullen Jennings"}, &SipUri{User: String{"fluffy"}, Password: NoString{}, Host: "cisco.com", UriParams: noParams, Headers: noParams}, noParams},
+				&NameAddr{NoString{}, &TelUri{"+14085551212", noParams}, noParams}},
+			"P-Asserted-Identity: \"Cullen Jennings\" <sip:fluffy@cisco.com>, <tel:+14085551212>"},
+
+		// P-Visited-Network-ID Headers.
+		{"P-Visited-Network-ID Header with token", PVisitedNetworkID{&VisitedNetwork{"other.net", false, NewParams()}},
+			"P-Visited-Network-ID: other.net"},
+		{"P-Visited-Network-ID Header with quoted string and token", PVisitedNetworkID{
+			&VisitedNetwork{"Visited network number 1", true, NewParams()},
+			&VisitedNetwork{"other.net", false, NewParams().Add("food", String{"cake"})},
+		}, "P-Visited-Network-ID: \"Visited network number 1\", other.net;food=cake"},
+		{"P-Visited-Network-ID Header with escaped quote", PVisitedNetworkID{
+			&VisitedNetwork{"The \"other\" net\\", true, NewParams()},
+		}, "P-Visited-Network-ID: \"The \\\"other\\\" net\\\\\""},
+
 		// Require Headers.
 		{"Require Header (empty)", &RequireHeader{[]string{}}, "Require: "},
 		{"Require Header (one option)", &RequireHeader{[]string{"NewFeature1"}}, "Require: NewFeature1"},
@@ -188,6 +335,30 @@ func TestHeaders(t *testing.T) {
 		{"Unsupported Header (one option)", &UnsupportedHeader{[]string{"NewFeature1"}}, "Unsupported: NewFeature1"},
 		{"Unsupported Header (three options)", &UnsupportedHeader{[]string{"NewFeature1", "FunkyExtension", "UnnecessaryAddition"}}, "Unsupported: NewFeature1, FunkyExtension, UnnecessaryAddition"},
 
+		// Accept-Encoding, Accept-Language and Content-Encoding Headers.
+		{"Accept-Encoding Header",
+			AcceptEncodingHeader{&QualifiedValue{"gzip", 1, noParams}, &QualifiedValue{"identity", 0.5, noParams}},
+			"Accept-Encoding: gzip, identity;q=0.5"},
+		{"Empty Accept-Encoding Header", AcceptEncodingHeader{}, "Accept-Encoding: "},
+		{"Accept-Language Header",
+			AcceptLanguageHeader{&QualifiedValue{"da", 1, noParams}, &QualifiedValue{"en-gb", 0.8, noParams}},
+			"Accept-Language: da, en-gb;q=0.8"},
+		{"Content-Encoding Header", ContentEncodingHeader{"gzip", "compress"}, "Content-Encoding: gzip, compress"},
+		{"Accept Header",
+			AcceptHeader{&QualifiedValue{"application/sdp", 1, noParams}, &QualifiedValue{"text/*", 0.5, noParams}},
+			"Accept: application/sdp, text/*;q=0.5"},
+		{"Allow Header", AllowHeader{INVITE, ACK, OPTIONS}, "Allow: INVITE, ACK, OPTIONS"},
+		{"Empty Allow Header", AllowHeader{}, "Allow: "},
+
+		// Content-Type Headers.
+		{"Content-Type Header", &ContentType{"application/sdp", NewParams()}, "Content-Type: application/sdp"},
+		{"Content-Type Header with params", &ContentType{"multipart/mixed", NewParams().Add("boundary", String{"unique-boundary-1"})},
+			"Content-Type: multipart/mixed;boundary=unique-boundary-1"},
+
+		// Event Headers.
+		{"Event Header", &EventHeader{"presence", NewParams()}, "Event: presence"},
+		{"Event Header with id", &EventHeader{"presence", NewParams().Add("id", String{"abc"})}, "Event: presence;id=abc"},
+
 		// Various simple headers.
 		{"Call-Id Header", CallId("call-id-1"), "Call-Id: call-id-1"},
 		{"CSeq Header", &CSeq{1234, "INVITE"}, "CSeq: 1234 INVITE"},
@@ -195,3 +366,72 @@ func TestHeaders(t *testing.T) {
 		{"Content Length Header", ContentLength(70), "Content-Length: 70"},
 	}, t)
 }
+
+// Check the exact wire format of typical headers and messages, as they would be sent on the network.
+// These complement the parser tests by pinning down the output side, including edge cases such as
+// valueless params, quoted display names and params whose values need quoting.
+func TestWireFormat(t *testing.T) {
+	alice := &SipUri{User: String{"alice"}, Password: NoString{}, Host: "atlanta.com", UriParams: noParams, Headers: noParams}
+	bob := &SipUri{User: String{"bob"}, Password: NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}
+	callId := CallId("a84b4c76e66710@pc33.atlanta.com")
+	maxForwards := MaxForwards(70)
+	contentLength := ContentLength(0)
+
+	via := ViaHeader{&ViaHop{"SIP", "2.0", "UDP", "pc33.atlanta.com", &port5060,
+		NewParams().Add("branch", String{"z9hG4bK776asdhds"}).Add("rport", NoString{})}}
+	to := &ToHeader{String{"Bob"}, bob, noParams}
+	from := &FromHeader{String{"Alice Liddell"}, alice, NewParams().Add("tag", String{"1928301774"})}
+	contact := &ContactHeader{String{"Alice Liddell"},
+		&SipUri{User: String{"alice"}, Password: NoString{}, Host: "pc33.atlanta.com", Port: &port5060,
+			UriParams: NewParams().Add("transport", String{"tcp"}).Add("lr", NoString{}), Headers: noParams},
+		NewParams().Add("q", String{"0.7"}).Add("expires", String{"3600"})}
+
+	doTests([]stringTest{
+		// Individual headers.
+		{"CSeq", &CSeq{314159, INVITE}, "CSeq: 314159 INVITE"},
+		{"Via with branch and valueless rport", via,
+			"Via: SIP/2.0/UDP pc33.atlanta.com:5060;branch=z9hG4bK776asdhds;rport"},
+		{"To with quoted display name", to, "To: \"Bob\" <sip:bob@biloxi.com>"},
+		{"From with display name containing a space and a tag", from,
+			"From: \"Alice Liddell\" <sip:alice@atlanta.com>;tag=1928301774"},
+		{"Contact with display name, URI params and q-value", contact,
+			"Contact: \"Alice Liddell\" <sip:alice@pc33.atlanta.com:5060;transport=tcp;lr>;q=0.7;expires=3600"},
+		{"Contact with valueless param", &ContactHeader{NoString{}, bob, NewParams().Add("+sip.instance", String{"<urn:uuid:1234>"}).Add("reg-id", String{"1"}).Add("ob", NoString{})},
+			"Contact: <sip:bob@biloxi.com>;+sip.instance=\"<urn:uuid:1234>\";reg-id=1;ob"},
+		{"Param value containing whitespace is quoted", &ToHeader{NoString{}, bob, NewParams().Add("note", String{"two words"})},
+			"To: <sip:bob@biloxi.com>;note=\"two words\""},
+		{"Param value containing quotes and backslashes is escaped",
+			&ToHeader{NoString{}, bob, NewParams().Add("x", String{"say \"hi\", now"}).Add("y", String{"a\\b"})},
+			"To: <sip:bob@biloxi.com>;x=\"say \\\"hi\\\", now\";y=\"a\\\\b\""},
+		{"Empty param value", &ToHeader{NoString{}, bob, NewParams().Add("empty", String{""})},
+			"To: <sip:bob@biloxi.com>;empty="},
+		{"Call-Id pointer", &callId, "Call-Id: a84b4c76e66710@pc33.atlanta.com"},
+		{"Max-Forwards pointer", &maxForwards, "Max-Forwards: 70"},
+		{"Content-Length pointer", &contentLength, "Content-Length: 0"},
+		{"Generic header", &GenericHeader{"Subject", "Lunch?"}, "Subject: Lunch?"},
+
+		// Whole messages, including CRLF framing.
+		{"INVITE request",
+			NewRequest(INVITE, bob, "SIP/2.0", []SipHeader{via, &maxForwards, to, from, &callId, &CSeq{314159, INVITE}, &contentLength}, ""),
+			"INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+				"Via: SIP/2.0/UDP pc33.atlanta.com:5060;branch=z9hG4bK776asdhds;rport\r\n" +
+				"Max-Forwards: 70\r\n" +
+				"To: \"Bob\" <sip:bob@biloxi.com>\r\n" +
+				"From: \"Alice Liddell\" <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+				"Call-Id: a84b4c76e66710@pc33.atlanta.com\r\n" +
+				"CSeq: 314159 INVITE\r\n" +
+				"Content-Length: 0\r\n" +
+				"\r\n"},
+		{"200 response with body",
+			NewResponse("SIP/2.0", 200, "OK", []SipHeader{via, to, from, &callId, &CSeq{314159, INVITE}, ContentLength(4)}, "v=0\n"),
+			"SIP/2.0 200 OK\r\n" +
+				"Via: SIP/2.0/UDP pc33.atlanta.com:5060;branch=z9hG4bK776asdhds;rport\r\n" +
+				"To: \"Bob\" <sip:bob@biloxi.com>\r\n" +
+				"From: \"Alice Liddell\" <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+				"Call-Id: a84b4c76e66710@pc33.atlanta.com\r\n" +
+				"CSeq: 314159 INVITE\r\n" +
+				"Content-Length: 4\r\n" +
+				"\r\n" +
+				"v=0\n"},
+	}, t)
+}
@@ -187,6 +187,13 @@ func Severe(msg string, args ...interface{}) {
 	defaultLogger.Severe(msg, args...)
 }
 
+func SetDefaultOutput(out io.Writer) {
+	if defaultLogger == nil {
+		defaultLogger = New(os.Stderr, "", 0)
+	}
+	defaultLogger.SetOutput(out)
+}
+
 func SetDefaultLogLevel(level Level) {
 	if defaultLogger == nil {
 		defaultLogger = New(os.Stderr, "", 0)
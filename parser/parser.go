@@ -9,8 +9,11 @@ import (
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"net"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -19,9 +22,37 @@ import (
 // that SIP uses (RFC 3261 S.25).
 const c_ABNF_WS = " \t"
 
+// The number of bytes ParseStream requests from its reader at a time.
+const c_STREAM_READ_SIZE = 4096
+
 // The maximum permissible CSeq number in a SIP message (2**31 - 1).
 // C.f. RFC 3261 S. 8.1.1.5.
-const MAX_CSEQ = 2147483647
+const MAX_CSEQ = base.MAX_CSEQ
+
+// Convert err into a *base.ParseError for the given text, in which the element that failed to parse starts at the
+// given offset (-1 if unknown). If err is already a ParseError for some element within that one, it is updated
+// to refer to the given text instead, offset accordingly. Returns nil if err is nil.
+func parseErrorAt(err error, header string, text string, offset int) error {
+	if err == nil {
+		return nil
+	}
+
+	parseErr, ok := err.(*base.ParseError)
+	if !ok {
+		return &base.ParseError{Header: header, Text: text, Offset: offset, Err: err}
+	}
+
+	if parseErr.Header == "" {
+		parseErr.Header = header
+	}
+	parseErr.Text = text
+	if offset < 0 || parseErr.Offset < 0 {
+		parseErr.Offset = -1
+	} else {
+		parseErr.Offset += offset
+	}
+	return parseErr
+}
 
 // The buffer size of the parser input channel.
 const c_INPUT_CHAN_SIZE = 10
@@ -40,6 +71,145 @@ type Parser interface {
 	// If a parser is not available for a header type in a message, the parser will produce a base.GenericHeader struct.
 	SetHeaderParser(headerName string, headerParser HeaderParser)
 
+	// Set whether multiple headers with the given name, which have no registered parser and so are parsed as
+	// base.GenericHeaders, are merged into a single header whose value is their values joined with commas.
+	// This suits headers which the application treats as lists. By default, each header is kept separately.
+	SetMergeGenericHeaders(headerName string, enabled bool)
+
+	// Get the parser currently registered for a particular header type, if there is one.
+	// This allows a custom parser to decorate the existing one, by calling it and then post-processing its result,
+	// rather than replacing it wholesale. Note that compact forms, such as 'v' for 'Via', are registered separately.
+	HeaderParser(headerName string) (headerParser HeaderParser, ok bool)
+
+	// Parse a header value, such as 'SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds', as though it were the
+	// value of a header with the given name, e.g. 'Via'. This uses the same parser as a header in a message would,
+	// so a base.GenericHeader is returned if no parser is registered for the header name.
+	ParseHeaderValue(headerName string, value string) ([]base.SipHeader, error)
+
+	// Enable or disable diagnostic warnings about message bodies which look like they contain SIP headers.
+	// When enabled, the parser logs a warning for each message with an SDP body that has header-like lines
+	// before the SDP version line, which usually indicates a framing problem on the connection.
+	// Such messages are still parsed and passed on as normal. Diagnostics are disabled by default.
+	SetBodyDiagnostics(enabled bool)
+
+	// Set the largest message body, in bytes, that the parser will accept in streamed mode.
+	// If a message declares a larger Content-Length, the parser sends a terminal error rather than
+	// attempting to buffer the body. A value of 0 (the default) means that body length is not limited.
+	SetMaxBodyLength(length int)
+
+	// Set the largest header section, in bytes, that the parser will accept in a single message.
+	// This counts every header line (including continuation lines and their line endings), but not the start line.
+	// If the limit is exceeded before the end of the header section, the parser sends a terminal error.
+	// A value of 0 (the default) means that the header section is not limited.
+	SetMaxHeaderBytes(length int)
+
+	// Enable or disable strict checking of request methods.
+	// When enabled, a request whose method is not one of the standard SIP methods (INVITE, ACK, BYE, CANCEL,
+	// REGISTER, OPTIONS, INFO, PRACK, SUBSCRIBE, NOTIFY, PUBLISH, MESSAGE, REFER and UPDATE) causes a terminal error.
+	// Strict checking is disabled by default, so extension methods are accepted.
+	SetStrictMethods(enabled bool)
+
+	// Enable or disable lenient parsing of request lines.
+	// RFC 3261 requires the method, Request-URI and SIP version to be separated by single spaces, but some
+	// implementations send request lines with repeated spaces, or with unescaped spaces inside the Request-URI.
+	// When enabled, the method is taken to end at the first space and the SIP version to begin after the last space,
+	// and everything in between is treated as the Request-URI, with any spaces inside it percent-encoded.
+	// Lenient parsing is disabled by default, so such request lines cause a terminal error.
+	SetLenientRequestLine(enabled bool)
+
+	// Enable or disable strict checking of the sent-protocol in Via headers.
+	// When enabled, each hop in a Via header must have the protocol name SIP, a numeric version (e.g. 2.0), and one of
+	// the transports UDP, TCP, TLS, SCTP, WS or WSS; a Via header with any other hop fails to parse, and is discarded
+	// and reported like any other malformed header (see SetOnHeaderError).
+	// Strict checking is disabled by default, so any sent-protocol is accepted.
+	SetStrictVia(enabled bool)
+
+	// Enable or disable strict checking of hosts in SIP URIs and Via headers.
+	// When enabled, each host in the Request-URI and in the To, From, Contact, Route, Record-Route and Via headers
+	// must be an IPv4 address, an IPv6 reference, or a hostname made up of letters, digits, hyphens and dots
+	// (RFC 3261 s. 25.1). A malformed Request-URI host makes the message malformed; a header with a malformed host
+	// is discarded and reported like any other malformed header (see SetOnHeaderError).
+	// Strict checking is disabled by default, so any host text is accepted.
+	SetStrictHosts(enabled bool)
+
+	// Enable or disable acceptance of tel URIs (RFC 3966) as the Request-URI, e.g. 'MESSAGE tel:+15551234567 SIP/2.0'.
+	// Such requests are sent to a gateway or proxy that can route telephone numbers. Normally only SIP and SIPS URIs
+	// are accepted, and any other scheme causes a terminal error. The wildcard URI '*' is never accepted.
+	// Tel Request-URIs are disabled by default.
+	SetTelRequestUris(enabled bool)
+
+	// Set whether parameters following a URI that is not enclosed in angle brackets, as in 'From: sip:a@h;tag=1',
+	// belong to the URI or to the header. RFC 3261 s. 20.10 says that they belong to the header, which is the
+	// default (BareUriParamsToHeader). Some implementations instead intend them as URI parameters, e.g. in
+	// 'Contact: sip:a@h;transport=tcp'; with BareUriParamsToUri, all such parameters are kept on the URI.
+	// This applies to the address headers To, From, Contact, Refer-To, Referred-By, Route, Record-Route and
+	// Reply-To, and replaces any custom parsers registered for them.
+	SetBareUriParams(policy BareUriParamPolicy)
+
+	// Set how parameters which appear more than once in a header or URI, as in 'Via: SIP/2.0/UDP h;foo=a;foo=b',
+	// are handled. By default (LastDuplicateParam), the last value given is kept; FirstDuplicateParam keeps the
+	// first instead, and with StrictDuplicateParams such a header fails to parse. This applies to all the standard
+	// headers which carry parameters, and replaces any custom parsers registered for them.
+	SetDuplicateParamPolicy(policy DuplicateParamPolicy)
+
+	// Enable or disable frame-per-write mode, in which each call to Write must contain exactly one complete message.
+	// The message body is taken to be everything following the header section up to the end of the write, so no
+	// Content-Length header is needed, even on a streamed parser. This is intended for transports which preserve
+	// message boundaries, such as WebSocket (RFC 7118) or DTLS, where each frame carries a single SIP message.
+	// Frame-per-write mode is disabled by default, and should be set before the first call to Write.
+	SetFramePerWrite(enabled bool)
+
+	// Enable or disable rejection of obsolete line folding, in which a header is continued onto a following line
+	// that starts with whitespace. RFC 7230 s. 3.2.4 deprecates such folding, as parsers which do not support it may
+	// interpret the message differently. When enabled, a continuation line in the header section causes a terminal
+	// error. Rejection is disabled by default, so folded headers are unfolded as RFC 3261 s. 7.3.1 requires.
+	SetRejectObsFold(enabled bool)
+
+	// Enable or disable rejection of header lines which are not valid UTF-8 (RFC 3261 s. 7.3.1).
+	// When enabled, such a header fails to parse: it is discarded from the message and reported like any other
+	// malformed header (see SetOnHeaderError). Rejection is disabled by default, so invalid byte sequences are
+	// passed through to the parsed headers unchanged.
+	SetRejectInvalidUTF8(enabled bool)
+
+	// Enable or disable acceptance of bare LFs as line endings in the start line and header section.
+	// RFC 3261 requires every line to end with CRLF, but some non-compliant senders use a lone LF instead.
+	// When enabled, either ending is accepted, so such messages parse as if they had used CRLFs; the message body
+	// is left untouched. Acceptance is disabled by default, and should be set before the first call to Write.
+	SetAcceptBareLF(enabled bool)
+
+	// Enable or disable recovery from malformed messages on a streamed parser.
+	// Normally, a malformed message causes a terminal error, after which the parser rejects all further input.
+	// When recovery is enabled, the error is still sent down the error channel, but the parser then discards input
+	// up to the next line that looks like a start line, and resumes parsing from there. This lets a long-lived
+	// connection survive a single bad message. The input ending part-way through a message is still terminal.
+	// Recovery is disabled by default, and has no effect on unstreamed parsers or in frame-per-write mode.
+	SetRecoverable(enabled bool)
+
+	// Set how long a streamed parser waits for more input part-way through a message before giving up.
+	// If no bytes arrive within the timeout while a message is incomplete, a terminal error is sent down the error
+	// channel and the parser stops, so that a half-open connection cannot leave the parser's goroutine blocked forever.
+	// The timeout does not apply between messages. A zero timeout, the default, waits indefinitely. The timeout
+	// should be set before the first call to Write.
+	SetIdleTimeout(timeout time.Duration)
+
+	// Set how a streamed parser handles a message with more than one Content-Length header. By default
+	// (StrictContentLength), such a message is malformed. Some implementations harmlessly duplicate the header;
+	// FirstContentLength uses the first one to delimit the body, and MaxContentLength uses the largest.
+	// All the headers are kept on the parsed message regardless.
+	SetContentLengthPolicy(policy ContentLengthPolicy)
+
+	// Set a callback to be invoked for each header which fails to parse, with the header's text and the parse error.
+	// Such headers are discarded from the message, which is otherwise parsed and passed on as normal, so this allows
+	// callers to count or log the discarded headers. The callback is invoked on the parser's own goroutine, so should
+	// not block. Pass nil to remove the callback; by default, failed headers are only reported in the debug logs.
+	SetOnHeaderError(callback func(headerText string, err error))
+
+	// Set a callback to be invoked when a streamed parser receives a CRLFCRLF keepalive ping (RFC 5626 s. 3.5.1)
+	// between messages. The ping produces no message or error; the callback may answer it by sending a single CRLF
+	// pong back down the connection. The callback is invoked on the parser's own goroutine, so should not block.
+	// Pass nil to remove the callback; by default, pings are silently discarded.
+	SetOnPing(callback func())
+
 	Stop()
 }
 
@@ -50,20 +220,98 @@ type HeaderParser func(headerName string, headerData string) (
 	headers []base.SipHeader, err error)
 
 func defaultHeaderParsers() map[string]HeaderParser {
-	return map[string]HeaderParser{
-		"to":             parseAddressHeader,
-		"t":              parseAddressHeader,
-		"from":           parseAddressHeader,
-		"f":              parseAddressHeader,
-		"contact":        parseAddressHeader,
-		"m":              parseAddressHeader,
-		"call-id":        parseCallId,
-		"cseq":           parseCSeq,
-		"via":            parseViaHeader,
-		"v":              parseViaHeader,
-		"max-forwards":   parseMaxForwards,
-		"content-length": parseContentLength,
-		"l":              parseContentLength,
+	headerParsers := map[string]HeaderParser{
+		"to":               parseAddressHeader,
+		"t":                parseAddressHeader,
+		"from":             parseAddressHeader,
+		"f":                parseAddressHeader,
+		"contact":          parseAddressHeader,
+		"m":                parseAddressHeader,
+		"refer-to":         parseAddressHeader,
+		"r":                parseAddressHeader,
+		"referred-by":      parseAddressHeader,
+		"b":                parseAddressHeader,
+		"route":            parseAddressHeader,
+		"record-route":     parseAddressHeader,
+		"reply-to":         parseAddressHeader,
+		"call-id":          parseCallId,
+		"i":                parseCallId,
+		"cseq":             parseCSeq,
+		"rseq":             parseRSeq,
+		"rack":             parseRAck,
+		"date":             parseDate,
+		"timestamp":        parseTimestamp,
+		"warning":          parseWarning,
+		"min-se":           parseMinSE,
+		"min-expires":      parseMinExpires,
+		"max-forwards":     parseMaxForwards,
+		"content-length":   parseContentLength,
+		"l":                parseContentLength,
+		"allow":            parseAllow,
+		"allow-events":     parseAllowEvents,
+		"u":                parseAllowEvents,
+		"supported":        parseSupported,
+		"k":                parseSupported,
+		"in-reply-to":      parseInReplyTo,
+		"organization":     parseOrganization,
+		"subject":          parseSubject,
+		"s":                parseSubject,
+		"priority":         parsePriority,
+		"sip-etag":         parseEntityTag,
+		"sip-if-match":     parseEntityTag,
+		"content-encoding": parseContentEncoding,
+		"e":                parseContentEncoding,
+	}
+
+	for headerName, headerParser := range paramHeaderParsers() {
+		headerParsers[headerName] = headerParser.withPolicy(LastDuplicateParam)
+	}
+	return headerParsers
+}
+
+// A paramHeaderParser is a HeaderParser for a header which may carry parameters, taking the policy with which to
+// handle repeated parameter names.
+type paramHeaderParser func(headerName string, headerData string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error)
+
+// Bind the policy to the paramHeaderParser, giving a HeaderParser.
+func (headerParser paramHeaderParser) withPolicy(policy DuplicateParamPolicy) HeaderParser {
+	return func(headerName string, headerData string) ([]base.SipHeader, error) {
+		return headerParser(headerName, headerData, policy)
+	}
+}
+
+// The default parsers for headers which may carry parameters, other than the address headers.
+func paramHeaderParsers() map[string]paramHeaderParser {
+	return map[string]paramHeaderParser{
+		"retry-after":          parseRetryAfter,
+		"session-expires":      parseSessionExpires,
+		"x":                    parseSessionExpires,
+		"p-asserted-identity":  parsePAssertedIdentity,
+		"via":                  parseViaHeader,
+		"v":                    parseViaHeader,
+		"content-type":         parseContentType,
+		"c":                    parseContentType,
+		"event":                parseEventHeader,
+		"o":                    parseEventHeader,
+		"replaces":             parseReplaces,
+		"refer-sub":            parseReferSub,
+		"content-disposition":  parseContentDisposition,
+		"accept":               parseAccept,
+		"accept-encoding":      parseAcceptEncoding,
+		"accept-language":      parseAcceptLanguage,
+		"alert-info":           parseInfoHeader,
+		"call-info":            parseInfoHeader,
+		"error-info":           parseInfoHeader,
+		"accept-contact":       parseCallerPrefs,
+		"a":                    parseCallerPrefs,
+		"reject-contact":       parseCallerPrefs,
+		"j":                    parseCallerPrefs,
+		"authorization":        parseAuthHeader,
+		"proxy-authorization":  parseAuthHeader,
+		"www-authenticate":     parseAuthHeader,
+		"proxy-authenticate":   parseAuthHeader,
+		"p-visited-network-id": parsePVisitedNetworkID,
 	}
 }
 
@@ -72,6 +320,11 @@ func defaultHeaderParsers() map[string]HeaderParser {
 // have a guarantee that all messages coming over a connection are from the
 // same endpoint (e.g. UDP).
 func ParseMessage(msgData []byte) (base.SipMessage, error) {
+	// Empty lines before the message are ignored, so data consisting only of them holds no message at all.
+	if len(trimLeadingLineEnds(msgData, false)) == 0 {
+		return nil, fmt.Errorf("no message in %d bytes of data", len(msgData))
+	}
+
 	output := make(chan base.SipMessage, 0)
 	errors := make(chan error, 0)
 	parser := NewParser(output, errors, false)
@@ -86,6 +339,140 @@ func ParseMessage(msgData []byte) (base.SipMessage, error) {
 	}
 }
 
+// Parse the first complete SIP message in the given data, which may be followed by further messages, as when
+// buffering input from a TCP connection. Returns the message along with the number of bytes it occupied, from the
+// start of the data to the end of the body (as delimited by its Content-Length), so that the caller can discard
+// them before parsing the remainder. Any empty lines before the start line are included in this count.
+// If the data does not yet contain a complete message, returns io.ErrUnexpectedEOF, and the caller should retry
+// once more data has arrived.
+func ParseMessageN(data []byte) (msg base.SipMessage, n int, err error) {
+	// Skip any empty lines, such as CRLF keepalives, before the message; they count towards the bytes it occupied.
+	skipped := len(data) - len(trimLeadingLineEnds(data, false))
+	msg, n, err = parseMessageN(data[skipped:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return msg, skipped + n, nil
+}
+
+// As ParseMessageN, but for data which starts with the start line.
+func parseMessageN(data []byte) (msg base.SipMessage, n int, err error) {
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	if headerEnd == -1 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	headerEnd += 4
+
+	// Parse the start line and headers alone to find the length of the body.
+	msg, err = ParseMessage(data[:headerEnd])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	contentLengths := msg.Headers("Content-Length")
+	if len(contentLengths) != 1 {
+		return nil, 0, fmt.Errorf("expected exactly one Content-Length header on message %s; got %d",
+			msg.Short(), len(contentLengths))
+	}
+	n = headerEnd + int(*(contentLengths[0].(*base.ContentLength)))
+	if n > len(data) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	msg, err = ParseMessage(data[:n])
+	if err != nil {
+		return nil, 0, err
+	}
+	return msg, n, nil
+}
+
+// Split a multipart message body (RFC 2046 s. 5.1), such as one carrying both SDP and ISUP, into its parts.
+// The parts are delimited by the 'boundary' parameter of the given Content-Type, which must be a multipart type.
+// Each part's headers are parsed in the same way as SIP message headers, so that, for example, a part's
+// Content-Type is returned as a *base.ContentType. Any preamble and epilogue around the parts are discarded.
+// This lives in the parser rather than in base, alongside base.BodyPart, because it needs the parser's header
+// parsers, and base cannot import the parser package.
+func ParseMultipartBody(contentType base.ContentType, body string) ([]base.BodyPart, error) {
+	if !strings.HasPrefix(strings.ToLower(contentType.MediaType), "multipart/") {
+		return nil, fmt.Errorf("cannot split body of non-multipart type '%s'", contentType.MediaType)
+	}
+
+	var boundary base.String
+	if contentType.Params != nil {
+		if value, ok := contentType.Params.Get("boundary"); ok {
+			boundary, _ = value.(base.String)
+		}
+	}
+	if boundary.S == "" {
+		return nil, fmt.Errorf("no boundary parameter on Content-Type '%s'", contentType.String())
+	}
+
+	// Each delimiter must start a line; treat the start of the body as the start of a line.
+	delimiter := "\r\n--" + boundary.S
+	text := "\r\n" + body
+	start := strings.Index(text, delimiter)
+	if start == -1 {
+		return nil, fmt.Errorf("no '--%s' delimiter in multipart body", boundary.S)
+	}
+	text = text[start+len(delimiter):]
+
+	p := &parser{headerParsers: defaultHeaderParsers()}
+	parts := make([]base.BodyPart, 0)
+	for !strings.HasPrefix(text, "--") {
+		// The rest of the delimiter line may only contain transport padding.
+		endOfLine := strings.Index(text, "\r\n")
+		if endOfLine == -1 || strings.Trim(text[:endOfLine], c_ABNF_WS) != "" {
+			return nil, fmt.Errorf("malformed delimiter line in multipart body with boundary '%s'", boundary.S)
+		}
+		text = text[endOfLine+2:]
+
+		// The part runs up to the CRLF preceding the next delimiter.
+		end := strings.Index(text, delimiter)
+		if end == -1 {
+			return nil, fmt.Errorf("no closing '--%s--' delimiter in multipart body", boundary.S)
+		}
+		part, err := p.parseBodyPart(text[:end])
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		text = text[end+len(delimiter):]
+	}
+
+	return parts, nil
+}
+
+// Parse a stream of SIP messages, such as those received over a TCP connection, by reading from r until it is
+// exhausted. Messages are delimited by their Content-Length headers, and reads may split messages at any point.
+// Parsed messages are sent down 'output'; any error that stops parsing, including r ending part-way through a
+// message, is sent down 'errs'.
+// This blocks until r returns EOF or an error, or parsing fails, so callers will usually run it in its own goroutine.
+func ParseStream(r io.Reader, output chan<- base.SipMessage, errs chan<- error) {
+	p := NewParser(output, errs, true).(*parser)
+	defer p.Stop()
+
+	buffer := make([]byte, c_STREAM_READ_SIZE)
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			if _, writeErr := p.Write(buffer[:n]); writeErr != nil {
+				// The parser has already reported its terminal error down errs.
+				return
+			}
+		}
+
+		if err == io.EOF {
+			// Let the parser consume anything left in its buffer, so it can report a truncated final message.
+			p.input.Close()
+			<-p.done
+			return
+		} else if err != nil {
+			errs <- err
+			return
+		}
+	}
+}
+
 // Create a new Parser.
 //
 // Parsed SIP messages will be sent down the 'output' chan provided.
@@ -120,6 +507,7 @@ func NewParser(output chan<- base.SipMessage, errs chan<- error, streamed bool)
 	// Create a managed buffer to allow message data to be asynchronously provided to the parser, and
 	// to allow the parser to block until enough data is available to parse.
 	p.input = newParserBuffer()
+	p.done = make(chan struct{})
 
 	// Wait for input a line at a time, and produce SipMessages to send down p.output.
 	go p.parse(streamed)
@@ -136,6 +524,27 @@ type parser struct {
 	errs          chan<- error
 	terminalErr   error
 	stopped       bool
+	done          chan struct{}
+
+	bodyDiagnostics bool
+	maxBodyLength   int
+	maxHeaderBytes  int
+	strictMethods   bool
+	lenientReqLine  bool
+	strictVia       bool
+	strictHosts     bool
+	telRequestUris  bool
+	framePerWrite   bool
+	rejectObsFold   bool
+	rejectBadUTF8   bool
+	acceptBareLF    bool
+	recoverable     bool
+	lengthPolicy    ContentLengthPolicy
+	bareUriParams   BareUriParamPolicy
+	duplicateParams DuplicateParamPolicy
+	onHeaderError   func(headerText string, err error)
+	mergedGenerics  map[string]bool
+	onPing          func()
 }
 
 func (p *parser) Write(data []byte) (n int, err error) {
@@ -147,13 +556,47 @@ func (p *parser) Write(data []byte) (n int, err error) {
 		return 0, fmt.Errorf("Cannot write data to stopped parser %p", p)
 	}
 
-	if !p.streamed {
-		l := getBodyLength(data)
+	if p.framePerWrite || !p.streamed {
+		// Each write is a single message, which may be preceded by empty lines that should be ignored (RFC 3261
+		// s. 7.5). A write of nothing but empty lines, such as a CRLF keepalive, contains no message at all.
+		n = len(data)
+		data = trimLeadingLineEnds(data, p.acceptBareLF)
+		if len(data) == 0 {
+			log.Debug("Parser %p ignores keepalive of %d bytes", p, n)
+			return n, nil
+		}
+	}
+
+	if p.framePerWrite {
+		// The whole write is a single message, so pass its length to the parser to delimit the body.
+		p.bodyLengths.In <- len(data)
+	} else if !p.streamed {
+		l := getBodyLength(data, p.acceptBareLF)
 		p.bodyLengths.In <- l
 	}
 
-	p.input.Write(data)
-	return len(data), nil
+	if _, err = p.input.Write(data); err != nil && p.terminalErr != nil {
+		// The parser hit a terminal error while we were waiting for it to consume the data.
+		return 0, p.terminalErr
+	}
+	if n == 0 {
+		n = len(data)
+	}
+	return n, nil
+}
+
+// Strip any empty lines from the start of the data. These may precede a message, e.g. as a keepalive, and are
+// ignored (RFC 3261 s. 7.5). A bare LF also counts as a line ending if acceptBareLF is true.
+func trimLeadingLineEnds(data []byte, acceptBareLF bool) []byte {
+	for {
+		if bytes.HasPrefix(data, []byte("\r\n")) {
+			data = data[2:]
+		} else if acceptBareLF && bytes.HasPrefix(data, []byte("\n")) {
+			data = data[1:]
+		} else {
+			return data
+		}
+	}
 }
 
 // Stop parser processing, and allow all resources to be garbage collected.
@@ -170,31 +613,87 @@ func (p *parser) Stop() {
 func (p *parser) parse(requireContentLength bool) {
 	var message base.SipMessage
 
+	// Set when recovering from a malformed message, to skip input until the next start line.
+	resync := false
+
 	for {
 		// Parse the StartLine.
+		p.input.MarkMessageStart()
 		startLine, err := p.input.NextLine()
+		emptyLines := 0
+		for err == nil && len(startLine) == 0 {
+			// Empty lines before a message, such as CRLF keepalives, are ignored (RFC 3261 s. 7.5).
+			// A pair of them arriving together is a CRLFCRLF ping (RFC 5626 s. 3.5.1), whereas a lone one is a pong
+			// (RFC 5626 s. 4.4.1), so only count an empty line towards a ping if more input is already waiting.
+			log.Debug("Parser %p discards empty line before start line", p)
+			emptyLines++
+			if emptyLines == 2 {
+				emptyLines = 0
+				if p.onPing != nil {
+					log.Debug("Parser %p received keepalive ping", p)
+					p.onPing()
+				}
+			} else if p.input.Buffered() == 0 {
+				emptyLines = 0
+			}
+			p.input.MarkMessageStart()
+			startLine, err = p.input.NextLine()
+		}
+		for resync && err == nil && !p.isRequest(startLine) && !isResponse(startLine) {
+			log.Debug("Parser %p discards line '%s' while recovering from a malformed message", p, startLine)
+			startLine, err = p.input.NextLine()
+		}
+		resync = false
+		// Count the bytes actually consumed, since lines may end in either a CRLF or a bare LF.
+		startLineBytes := p.input.MessageBytes()
 
-		if err != nil {
+		// Any error in the message being parsed that need not be terminal, e.g. a malformed start line.
+		var msgErr error
+
+		if err == io.ErrUnexpectedEOF {
+			p.terminalErr = fmt.Errorf("input ended part-way through the first line of a message")
+			p.errs <- p.terminalErr
+			break
+		} else if err == errIdleTimeout {
+			p.terminalErr = fmt.Errorf("no input for %s part-way through the first line of a message", p.input.idleTimeout)
+			p.errs <- p.terminalErr
+			break
+		} else if err != nil {
 			log.Debug("Parser %p stopped", p)
 			break
 		}
 
-		if isRequest(startLine) {
-			method, recipient, sipVersion, err := parseRequestLine(startLine)
+		if p.isRequest(startLine) {
+			parseLine := parseRequestLine
+			if p.lenientReqLine {
+				parseLine = parseLenientRequestLine
+			}
+			method, recipient, sipVersion, err := parseLine(startLine)
+			if err == nil {
+				err = checkRequestUri(startLine, recipient, p.telRequestUris)
+			}
+			if err == nil && p.strictHosts {
+				err = checkUriHost(recipient)
+			}
+			if err == nil && p.strictMethods && !isStandardMethod(method) {
+				err = fmt.Errorf("unknown method %s", method)
+			}
 			message = base.NewRequest(method, recipient, sipVersion, []base.SipHeader{}, "")
-			p.terminalErr = err
+			msgErr = err
 		} else if isResponse(startLine) {
 			sipVersion, statusCode, reason, err := parseStatusLine(startLine)
 			message = base.NewResponse(sipVersion, statusCode, reason, []base.SipHeader{}, "")
-			p.terminalErr = err
+			msgErr = err
 		} else {
-			p.terminalErr = fmt.Errorf("transmission beginning '%s' is not a SIP message", startLine)
+			msgErr = fmt.Errorf("transmission beginning '%s' is not a SIP message", startLine)
 		}
 
-		if p.terminalErr != nil {
-			p.terminalErr = fmt.Errorf("failed to parse first line of message: %s", p.terminalErr.Error())
-			p.errs <- p.terminalErr
-			break
+		if msgErr != nil {
+			if p.reportMessageError(fmt.Errorf("failed to parse first line of message: %s", msgErr.Error())) {
+				break
+			}
+			resync = true
+			continue
 		}
 
 		// Parse the header section.
@@ -210,19 +709,40 @@ func (p *parser) parse(requireContentLength bool) {
 					headers = append(headers, newHeaders...)
 				} else {
 					log.Debug("Skipping header '%s' due to error: %s", buffer.String(), err.Error())
+					if p.onHeaderError != nil {
+						p.onHeaderError(buffer.String(), err)
+					}
 				}
 				buffer.Reset()
 			}
 		}
 
+		headerBytes := 0
 		for {
+			if p.maxHeaderBytes > 0 {
+				// Stop reading a line part-way through if it would take the header section over the limit.
+				p.input.SetLineLimit(p.maxHeaderBytes - headerBytes)
+			}
 			line, err := p.input.NextLine()
 
-			if err != nil {
+			if err == errLineTooLong {
+				msgErr = fmt.Errorf("header section exceeds maximum size of %d bytes on message %s",
+					p.maxHeaderBytes, message.Short())
+				break
+			} else if err == io.EOF || err == io.ErrUnexpectedEOF {
+				p.terminalErr = fmt.Errorf("input ended part-way through the headers of message %s", message.Short())
+				break
+			} else if err == errIdleTimeout {
+				p.terminalErr = fmt.Errorf("no input for %s part-way through the headers of message %s",
+					p.input.idleTimeout, message.Short())
+				break
+			} else if err != nil {
 				log.Debug("Parser %p stopped", p)
 				break
 			}
 
+			headerBytes = p.input.MessageBytes() - startLineBytes
+
 			if len(line) == 0 {
 				// We've hit the end of the header section.
 				// Parse anything remaining in the buffer, then break out.
@@ -235,10 +755,12 @@ func (p *parser) parse(requireContentLength bool) {
 				// Parse anything currently in the buffer, then store the new header line in the buffer.
 				flushBuffer()
 				buffer.WriteString(line)
+			} else if p.rejectObsFold {
+				msgErr = fmt.Errorf("folded header line '%s' in message %s", line, message.Short())
+				break
 			} else if buffer.Len() > 0 {
 				// This is a continuation line, so just add it to the buffer.
-				buffer.WriteString(" ")
-				buffer.WriteString(line)
+				appendFoldedLine(&buffer, line)
 			} else {
 				// This is a continuation line, but also the first line of the whole header section.
 				// Discard it and log.
@@ -247,23 +769,51 @@ func (p *parser) parse(requireContentLength bool) {
 					message.Short())
 			}
 		}
+		p.input.SetLineLimit(0)
+
+		if p.terminalErr != nil {
+			p.errs <- p.terminalErr
+			break
+		} else if msgErr != nil {
+			if p.reportMessageError(msgErr) {
+				break
+			}
+			resync = true
+			continue
+		}
 
 		// Store the headers in the message object.
+		mergedHeaders := make(map[string]*base.GenericHeader)
 		for _, header := range headers {
+			if generic, ok := header.(*base.GenericHeader); ok && p.mergedGenerics[strings.ToLower(generic.Name())] {
+				// Fold this header's value into the first header of the same name, rather than adding it separately.
+				name := strings.ToLower(generic.Name())
+				if first, ok := mergedHeaders[name]; ok {
+					first.Contents += ", " + generic.Contents
+					continue
+				}
+				mergedHeaders[name] = generic
+			}
 			message.AddHeader(header)
 		}
 
 		var contentLength int
 
 		// Determine the length of the body, so we know when to stop parsing this message.
-		if p.streamed {
+		if p.framePerWrite {
+			// The body runs from the end of the header section to the end of the frame.
+			frameLength := (<-p.bodyLengths.Out).(int)
+			contentLength = frameLength - startLineBytes - headerBytes
+			if contentLength < 0 {
+				msgErr = fmt.Errorf("header section of message %s runs past the end of its %d byte frame",
+					message.Short(), frameLength)
+			}
+		} else if p.streamed {
 			// Use the content-length header to identify the end of the message.
 			contentLengthHeaders := message.Headers("Content-Length")
 			if len(contentLengthHeaders) == 0 {
-				p.terminalErr = fmt.Errorf("Missing required content-length header on message %s", message.Short())
-				p.errs <- p.terminalErr
-				break
-			} else if len(contentLengthHeaders) > 1 {
+				msgErr = fmt.Errorf("Missing required content-length header on message %s", message.Short())
+			} else if len(contentLengthHeaders) > 1 && p.lengthPolicy == StrictContentLength {
 				var errbuf bytes.Buffer
 				errbuf.WriteString("Multiple content-length headers on message ")
 				errbuf.WriteString(message.Short())
@@ -272,21 +822,48 @@ func (p *parser) parse(requireContentLength bool) {
 					errbuf.WriteString("\t")
 					errbuf.WriteString(header.String())
 				}
-				p.terminalErr = fmt.Errorf(errbuf.String())
-				p.errs <- p.terminalErr
-				break
-			}
+				msgErr = fmt.Errorf(errbuf.String())
+			} else {
+				contentLength = int(*(contentLengthHeaders[0].(*base.ContentLength)))
+				if p.lengthPolicy == MaxContentLength {
+					for _, header := range contentLengthHeaders[1:] {
+						if length := int(*(header.(*base.ContentLength))); length > contentLength {
+							contentLength = length
+						}
+					}
+				}
 
-			contentLength = int(*(contentLengthHeaders[0].(*base.ContentLength)))
+				if p.maxBodyLength > 0 && contentLength > p.maxBodyLength {
+					msgErr = fmt.Errorf("Content-Length %d exceeds maximum body length %d on message %s",
+						contentLength, p.maxBodyLength, message.Short())
+				}
+			}
 		} else {
 			// We're not in streaming mode, so the Write method should have calculated the length of the body for us.
 			contentLength = (<-p.bodyLengths.Out).(int)
 		}
 
+		if msgErr != nil {
+			if p.reportMessageError(msgErr) {
+				break
+			}
+			resync = true
+			continue
+		}
+
 		// Extract the message body.
 		body, err := p.input.NextChunk(contentLength)
 
-		if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			p.terminalErr = fmt.Errorf("input ended part-way through the body of message %s", message.Short())
+			p.errs <- p.terminalErr
+			break
+		} else if err == errIdleTimeout {
+			p.terminalErr = fmt.Errorf("no input for %s part-way through the body of message %s",
+				p.input.idleTimeout, message.Short())
+			p.errs <- p.terminalErr
+			break
+		} else if err != nil {
 			log.Debug("Parsed %p stopped", p)
 			break
 		}
@@ -299,30 +876,301 @@ func (p *parser) parse(requireContentLength bool) {
 		default:
 			log.Severe("Internal error - message %s is neither a request type nor a response type", message.Short())
 		}
+
+		if p.bodyDiagnostics && HasHeadersInSdpBody(message) {
+			log.Warn("Parser %p found header-like lines in the SDP body of message %s; "+
+				"the message may have been incorrectly framed", p, message.Short())
+		}
+
 		p.output <- message
 	}
 
-	if !p.streamed {
-		// We're in unstreamed mode, so we created a bodyLengths ElasticChan which
+	if p.terminalErr != nil {
+		// Nothing will read any further input, so release any writers which are blocked waiting for us to do so.
+		p.input.Stop()
+	}
+
+	if p.bodyLengths.In != nil {
+		// We're in unstreamed or frame-per-write mode, so we created a bodyLengths ElasticChan which
 		// needs to be disposed.
 		close(p.bodyLengths.In)
 	}
+
+	close(p.done)
 	return
 }
 
 // Implements ParserFactory.SetHeaderParser.
 func (p *parser) SetHeaderParser(headerName string, headerParser HeaderParser) {
 	headerName = strings.ToLower(headerName)
+	if _, ok := p.headerParsers[headerName]; ok {
+		log.Debug("Parser %p replaces the existing parser for %s headers", p, headerName)
+	}
 	p.headerParsers[headerName] = headerParser
 }
 
+// Implements Parser.SetMergeGenericHeaders.
+func (p *parser) SetMergeGenericHeaders(headerName string, enabled bool) {
+	if p.mergedGenerics == nil {
+		p.mergedGenerics = make(map[string]bool)
+	}
+	p.mergedGenerics[strings.ToLower(base.CanonicalHeaderName(headerName))] = enabled
+}
+
+// Implements Parser.HeaderParser.
+func (p *parser) HeaderParser(headerName string) (headerParser HeaderParser, ok bool) {
+	headerParser, ok = p.headerParsers[strings.ToLower(headerName)]
+	return
+}
+
+// Implements Parser.SetBodyDiagnostics.
+func (p *parser) SetBodyDiagnostics(enabled bool) {
+	p.bodyDiagnostics = enabled
+}
+
+// Implements Parser.SetMaxBodyLength.
+func (p *parser) SetMaxBodyLength(length int) {
+	p.maxBodyLength = length
+}
+
+// Implements Parser.SetMaxHeaderBytes.
+func (p *parser) SetMaxHeaderBytes(length int) {
+	p.maxHeaderBytes = length
+}
+
+// Implements Parser.SetStrictMethods.
+func (p *parser) SetStrictMethods(enabled bool) {
+	p.strictMethods = enabled
+}
+
+// Implements Parser.SetLenientRequestLine.
+func (p *parser) SetLenientRequestLine(enabled bool) {
+	p.lenientReqLine = enabled
+}
+
+// Implements Parser.SetTelRequestUris.
+func (p *parser) SetTelRequestUris(enabled bool) {
+	p.telRequestUris = enabled
+}
+
+// Implements Parser.SetStrictVia.
+func (p *parser) SetStrictVia(enabled bool) {
+	p.strictVia = enabled
+}
+
+// Implements Parser.SetStrictHosts.
+func (p *parser) SetStrictHosts(enabled bool) {
+	p.strictHosts = enabled
+}
+
+// The headers parsed by parseAddressHeader, including compact forms.
+var addressHeaderNames = []string{
+	"to", "t", "from", "f", "contact", "m", "refer-to", "r", "referred-by", "b", "route", "record-route", "reply-to",
+}
+
+// Implements Parser.SetBareUriParams.
+func (p *parser) SetBareUriParams(policy BareUriParamPolicy) {
+	p.bareUriParams = policy
+	p.setAddressHeaderParsers()
+}
+
+// Implements Parser.SetDuplicateParamPolicy.
+func (p *parser) SetDuplicateParamPolicy(policy DuplicateParamPolicy) {
+	p.duplicateParams = policy
+	for headerName, headerParser := range paramHeaderParsers() {
+		p.SetHeaderParser(headerName, headerParser.withPolicy(policy))
+	}
+	p.setAddressHeaderParsers()
+}
+
+// Register parsers for the address headers which follow the parser's BareUriParamPolicy and DuplicateParamPolicy.
+func (p *parser) setAddressHeaderParsers() {
+	bareUriParams, duplicateParams := p.bareUriParams, p.duplicateParams
+	for _, headerName := range addressHeaderNames {
+		p.SetHeaderParser(headerName, func(headerName string, headerText string) ([]base.SipHeader, error) {
+			return parseAddressHeaderWithPolicy(headerName, headerText, bareUriParams, duplicateParams)
+		})
+	}
+}
+
+// Determines how parameters whose names appear more than once in a header or URI are handled.
+type DuplicateParamPolicy int
+
+const (
+	// The last value given is kept, so ';foo=a;foo=b' gives foo=b.
+	LastDuplicateParam DuplicateParamPolicy = iota
+
+	// The first value given is kept, so ';foo=a;foo=b' gives foo=a.
+	FirstDuplicateParam
+
+	// The header or URI is malformed.
+	StrictDuplicateParams
+)
+
+// Implements Parser.SetFramePerWrite.
+func (p *parser) SetFramePerWrite(enabled bool) {
+	if enabled && p.streamed && p.bodyLengths.In == nil {
+		// Streamed parsers do not normally need body lengths from Write, so we must set up the channel now.
+		p.bodyLengths.Init()
+	}
+	p.framePerWrite = enabled
+}
+
+// Implements Parser.SetRejectObsFold.
+func (p *parser) SetRejectObsFold(enabled bool) {
+	p.rejectObsFold = enabled
+}
+
+// Implements Parser.SetRejectInvalidUTF8.
+func (p *parser) SetRejectInvalidUTF8(enabled bool) {
+	p.rejectBadUTF8 = enabled
+}
+
+// Implements Parser.SetAcceptBareLF.
+func (p *parser) SetAcceptBareLF(enabled bool) {
+	p.acceptBareLF = enabled
+	p.input.acceptBareLF = enabled
+}
+
+// Implements Parser.SetRecoverable.
+func (p *parser) SetRecoverable(enabled bool) {
+	p.recoverable = enabled
+}
+
+// Implements Parser.SetIdleTimeout.
+func (p *parser) SetIdleTimeout(timeout time.Duration) {
+	p.input.idleTimeout = timeout
+}
+
+// Determines how a streamed parser delimits the body of a message with more than one Content-Length header.
+type ContentLengthPolicy int
+
+const (
+	// The message is malformed.
+	StrictContentLength ContentLengthPolicy = iota
+
+	// The first Content-Length header is used.
+	FirstContentLength
+
+	// The largest Content-Length header is used.
+	MaxContentLength
+)
+
+// Implements Parser.SetContentLengthPolicy.
+func (p *parser) SetContentLengthPolicy(policy ContentLengthPolicy) {
+	p.lengthPolicy = policy
+}
+
+// Report an error in the message currently being parsed down p.errs, and return whether the parser must stop.
+// If recovery is enabled on this streamed parser, the parser can carry on from the next message; otherwise the
+// error becomes the parser's terminal error.
+func (p *parser) reportMessageError(err error) (stop bool) {
+	if p.recoverable && p.streamed && !p.framePerWrite {
+		log.Debug("Parser %p recovering from error: %s", p, err.Error())
+		p.errs <- err
+		return false
+	}
+
+	p.terminalErr = err
+	p.errs <- err
+	return true
+}
+
+// Implements Parser.SetOnHeaderError.
+func (p *parser) SetOnHeaderError(callback func(headerText string, err error)) {
+	p.onHeaderError = callback
+}
+
+// Implements Parser.SetOnPing.
+func (p *parser) SetOnPing(callback func()) {
+	p.onPing = callback
+}
+
+// Determine whether the given message has an SDP body containing lines that look like SIP headers
+// before the SDP version ('v=') line.
+// A well-formed SDP body always starts with its version line, so any header-like lines preceding it
+// suggest that the message was framed incorrectly (e.g. due to a bad Content-Length on an earlier message),
+// or that someone is attempting to smuggle headers inside the body.
+func HasHeadersInSdpBody(message base.SipMessage) bool {
+	isSdp := false
+	for _, header := range message.Headers("Content-Type") {
+		if contentType, ok := header.(*base.ContentType); ok &&
+			strings.EqualFold(contentType.MediaType, "application/sdp") {
+			isSdp = true
+			break
+		}
+	}
+	if !isSdp {
+		return false
+	}
+
+	for _, line := range strings.Split(message.GetBody(), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "v=") {
+			// We've reached the start of the SDP proper.
+			return false
+		}
+		if isHeaderLike(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Heuristic to determine if the given line looks like a SIP header; that is, a field name
+// consisting of token characters, followed by optional whitespace and a colon.
+// SDP lines are of the form 'x=...', so will never pass this test.
+func isHeaderLike(line string) bool {
+	colonIdx := strings.Index(line, ":")
+	if colonIdx <= 0 {
+		return false
+	}
+
+	fieldName := strings.TrimRight(line[:colonIdx], c_ABNF_WS)
+	if len(fieldName) == 0 {
+		return false
+	}
+	for _, char := range fieldName {
+		if !isTokenChar(char) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Determine whether the given character is permitted in a SIP token (RFC 3261 s. 25.1).
+func isTokenChar(char rune) bool {
+	return (char >= 'a' && char <= 'z') ||
+		(char >= 'A' && char <= 'Z') ||
+		(char >= '0' && char <= '9') ||
+		strings.ContainsRune("-.!%*_+`'~", char)
+}
+
 // Calculate the size of a SIP message's body, given the entire contents of the message as a byte array.
-func getBodyLength(data []byte) int {
+// If acceptBareLF is true, the header section may also end with an empty line terminated by a bare LF.
+func getBodyLength(data []byte, acceptBareLF bool) int {
 	s := string(data)
 
 	// Body starts with first character following a double-CRLF.
 	bodyStart := strings.Index(s, "\r\n\r\n") + 4
 
+	if acceptBareLF {
+		// Body starts after the first empty line, whichever line endings are used.
+		for idx := 0; idx < len(s); idx++ {
+			if s[idx] != '\n' {
+				continue
+			} else if strings.HasPrefix(s[idx+1:], "\n") {
+				bodyStart = idx + 2
+				break
+			} else if strings.HasPrefix(s[idx+1:], "\r\n") {
+				bodyStart = idx + 3
+				break
+			}
+		}
+	}
+
 	return len(s) - bodyStart
 }
 
@@ -346,6 +1194,27 @@ func isRequest(startLine string) bool {
 	}
 }
 
+// Heuristic to determine if the given transmission looks like a SIP request when request lines are parsed leniently.
+// The request line may contain more than two spaces, but must still end with a SIP version.
+func isLenientRequest(startLine string) bool {
+	trimmed := strings.TrimSpace(startLine)
+	if strings.Count(trimmed, " ") < 2 || strings.HasPrefix(strings.ToUpper(trimmed), "SIP/") {
+		return false
+	}
+
+	version := trimmed[strings.LastIndex(trimmed, " ")+1:]
+	return len(version) >= 3 && strings.ToUpper(version[:3]) == "SIP"
+}
+
+// Determine whether the given start line looks like a SIP request, taking into account whether this parser
+// parses request lines leniently.
+func (p *parser) isRequest(startLine string) bool {
+	if p.lenientReqLine {
+		return isLenientRequest(startLine)
+	}
+	return isRequest(startLine)
+}
+
 // Heuristic to determine if the given transmission looks like a SIP response.
 // It is guaranteed that any RFC3261-compliant response will pass this test,
 // but invalid messages may not necessarily be rejected.
@@ -367,8 +1236,9 @@ func isResponse(startLine string) bool {
 }
 
 // Parse the first line of a SIP request, e.g:
-//   INVITE bob@example.com SIP/2.0
-//   REGISTER jane@telco.com SIP/1.0
+//
+//	INVITE bob@example.com SIP/2.0
+//	REGISTER jane@telco.com SIP/1.0
 func parseRequestLine(requestLine string) (
 	method base.Method, recipient base.Uri, sipVersion string, err error) {
 	parts := strings.Split(requestLine, " ")
@@ -377,21 +1247,87 @@ func parseRequestLine(requestLine string) (
 		return
 	}
 
-	method = base.Method(strings.ToUpper(parts[0]))
-	recipient, err = ParseUri(parts[1])
-	sipVersion = parts[2]
+	return parseRequestLineParts(requestLine, parts[0], parts[1], parts[2])
+}
 
-	switch recipient.(type) {
-	case *base.WildcardUri:
-		err = fmt.Errorf("wildcard URI '*' not permitted in request line: '%s'", requestLine)
-	}
+// Parse the first line of a SIP request, tolerating extra spaces, e.g:
+//
+//	INVITE  sip:bob@example.com   SIP/2.0
+//	INVITE sip:bob smith@example.com SIP/2.0
+//
+// The method ends at the first space and the SIP version begins after the last; everything in between is the
+// Request-URI, which has any internal spaces percent-encoded before it is parsed.
+func parseLenientRequestLine(requestLine string) (
+	method base.Method, recipient base.Uri, sipVersion string, err error) {
+	trimmed := strings.TrimSpace(requestLine)
+	firstSpace := strings.Index(trimmed, " ")
+	lastSpace := strings.LastIndex(trimmed, " ")
+	if firstSpace == -1 || firstSpace == lastSpace {
+		err = fmt.Errorf("request line should have at least 2 spaces: '%s'", requestLine)
+		return
+	}
+
+	uriStr := strings.TrimSpace(trimmed[firstSpace+1 : lastSpace])
+	if len(uriStr) == 0 {
+		err = fmt.Errorf("request line has no Request-URI: '%s'", requestLine)
+		return
+	}
+	uriStr = strings.Replace(uriStr, " ", "%20", -1)
+
+	return parseRequestLineParts(requestLine, trimmed[:firstSpace], uriStr, trimmed[lastSpace+1:])
+}
+
+// Build the parts of a request line from its method, Request-URI and SIP version strings.
+// The full request line is used only in error messages.
+func parseRequestLineParts(requestLine string, methodStr string, uriStr string, versionStr string) (
+	method base.Method, recipient base.Uri, sipVersion string, err error) {
+	method = base.Method(strings.ToUpper(methodStr))
+	recipient, err = ParseUri(uriStr)
+	err = parseErrorAt(err, "start-line", requestLine, strings.Index(requestLine, uriStr))
+	sipVersion = versionStr
 
 	return
 }
 
+// Check that the given Request-URI may be the target of a request.
+// Only SIP and SIPS URIs, and tel URIs if allowTel is true, are permitted; other schemes are carried as opaque
+// absolute URIs for use in headers, but are not routable.
+func checkRequestUri(requestLine string, recipient base.Uri, allowTel bool) error {
+	switch recipient.(type) {
+	case *base.SipUri:
+		return nil
+	case *base.TelUri:
+		if allowTel {
+			return nil
+		}
+		return fmt.Errorf("tel URI '%s' not permitted in request line: '%s'", recipient.String(), requestLine)
+	case base.WildcardUri:
+		return fmt.Errorf("wildcard URI '*' not permitted in request line: '%s'", requestLine)
+	default:
+		return fmt.Errorf("non-SIP URI '%s' not permitted in request line: '%s'", recipient.String(), requestLine)
+	}
+}
+
+// The methods permitted by the parser in strict mode.
+var standardMethods = []base.Method{
+	base.INVITE, base.ACK, base.BYE, base.CANCEL, base.REGISTER, base.OPTIONS, base.INFO,
+	base.PRACK, base.SUBSCRIBE, base.NOTIFY, base.PUBLISH, base.MESSAGE, base.REFER, base.UPDATE,
+}
+
+// Determine whether the given method is one of the standard SIP methods.
+func isStandardMethod(method base.Method) bool {
+	for _, standard := range standardMethods {
+		if method.Equals(&standard) {
+			return true
+		}
+	}
+	return false
+}
+
 // Parse the first line of a SIP response, e.g:
-//   SIP/2.0 200 OK
-//   SIP/1.0 403 Forbidden
+//
+//	SIP/2.0 200 OK
+//	SIP/1.0 403 Forbidden
 func parseStatusLine(statusLine string) (
 	sipVersion string, statusCode uint16, reasonPhrase string, err error) {
 	parts := strings.Split(statusLine, " ")
@@ -409,9 +1345,15 @@ func parseStatusLine(statusLine string) (
 }
 
 // parseUri converts a string representation of a URI into a Uri object.
-// If the URI is malformed, or the URI schema is not recognised, an error is returned.
+// URIs from schemes other than sip, sips and tel are returned as opaque AbsoluteUris.
+// If the URI is malformed, an error is returned.
 // URIs have the general form of schema:address.
 func ParseUri(uriStr string) (uri base.Uri, err error) {
+	return parseUriWithPolicy(uriStr, LastDuplicateParam)
+}
+
+// As ParseUri, but repeated URI parameter names are handled according to the policy.
+func parseUriWithPolicy(uriStr string, policy DuplicateParamPolicy) (uri base.Uri, err error) {
 	if strings.TrimSpace(uriStr) == "*" {
 		// Wildcard '*' URI used in the Contact headers of REGISTERs when unregistering.
 		return base.WildcardUri{}, nil
@@ -426,24 +1368,150 @@ func ParseUri(uriStr string) (uri base.Uri, err error) {
 	switch strings.ToLower(uriStr[:colonIdx]) {
 	case "sip":
 		var sipUri base.SipUri
-		sipUri, err = ParseSipUri(uriStr)
+		sipUri, err = parseSipUriWithPolicy(uriStr, policy)
 		uri = &sipUri
 	case "sips":
 		// SIPS URIs have the same form as SIP uris, so we use the same parser.
 		var sipUri base.SipUri
-		sipUri, err = ParseSipUri(uriStr)
+		sipUri, err = parseSipUriWithPolicy(uriStr, policy)
 		uri = &sipUri
+	case "tel":
+		var telUri base.TelUri
+		telUri, err = parseTelUriWithPolicy(uriStr, policy)
+		uri = &telUri
 	default:
-		err = fmt.Errorf("Unsupported URI schema %s", uriStr[:colonIdx])
+		// Other schemes are not natively understood, but may still be carried as opaque absolute URIs.
+		var absoluteUri base.AbsoluteUri
+		absoluteUri, err = ParseAbsoluteUri(uriStr)
+		uri = &absoluteUri
+	}
+
+	return
+}
+
+// ParseAbsoluteUri converts a string representation of a URI from any scheme into an AbsoluteUri object.
+// The scheme must be valid (RFC 3261 s. 25.1), and the remainder of the URI must be non-empty and free of
+// whitespace, but is otherwise not checked.
+func ParseAbsoluteUri(uriStr string) (uri base.AbsoluteUri, err error) {
+	colonIdx := strings.Index(uriStr, ":")
+	if colonIdx == -1 {
+		err = fmt.Errorf("no ':' in URI %s", uriStr)
+		return
+	}
+
+	uri.Scheme = uriStr[:colonIdx]
+	uri.Opaque = uriStr[colonIdx+1:]
+	if !isScheme(uri.Scheme) {
+		err = fmt.Errorf("invalid URI scheme '%s' in URI %s", uri.Scheme, uriStr)
+		return
+	}
+	if len(uri.Opaque) == 0 || strings.ContainsAny(uri.Opaque, c_ABNF_WS+"<>\"") {
+		err = fmt.Errorf("invalid URI %s", uriStr)
+		return
+	}
+
+	return
+}
+
+// Determine whether the given string is a valid URI scheme: a letter followed by letters, digits, '+', '-' or '.'.
+func isScheme(scheme string) bool {
+	if len(scheme) == 0 {
+		return false
+	}
+	for idx, char := range scheme {
+		isAlpha := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+		if idx == 0 && !isAlpha {
+			return false
+		} else if !isAlpha && !(char >= '0' && char <= '9') && !strings.ContainsRune("+-.", char) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTelUri converts a string representation of a tel URI (RFC 3966) into a TelUri object.
+// Global numbers must begin with '+' followed by digits and visual separators; local numbers may also contain
+// hex digits, '*' and '#', and must carry a 'phone-context' parameter.
+func ParseTelUri(uriStr string) (uri base.TelUri, err error) {
+	return parseTelUriWithPolicy(uriStr, LastDuplicateParam)
+}
+
+// As ParseTelUri, but repeated parameter names are handled according to the policy.
+func parseTelUriWithPolicy(uriStr string, policy DuplicateParamPolicy) (uri base.TelUri, err error) {
+	if len(uriStr) < 4 || strings.ToLower(uriStr[:4]) != "tel:" {
+		err = fmt.Errorf("invalid tel URI '%s': should start with 'tel:'", uriStr)
+		return
+	}
+
+	numberText := uriStr[4:]
+	paramsIdx := strings.Index(numberText, ";")
+	if paramsIdx == -1 {
+		paramsIdx = len(numberText)
+	}
+
+	uri.Number = numberText[:paramsIdx]
+	if len(uri.Number) == 0 {
+		err = fmt.Errorf("empty telephone number in tel URI '%s'", uriStr)
+		return
+	}
+
+	digits := uri.Number
+	permitted := "0123456789-.()"
+	if uri.IsGlobal() {
+		digits = digits[1:]
+	} else {
+		permitted += "abcdefABCDEF*#"
+	}
+	if len(strings.Trim(digits, "-.()")) == 0 {
+		err = fmt.Errorf("no digits in telephone number of tel URI '%s'", uriStr)
+		return
+	}
+	for _, char := range digits {
+		if !strings.ContainsRune(permitted, char) {
+			err = fmt.Errorf("invalid character '%c' in telephone number of tel URI '%s'", char, uriStr)
+			return
+		}
+	}
+
+	if paramsIdx < len(numberText) {
+		uri.Params, _, err = parseParamsWithPolicy(numberText[paramsIdx:], ';', ';', 0, false, true, policy)
+		if err == nil {
+			uri.Params, err = unescapeUriParams(uri.Params)
+		}
+		if err != nil {
+			return
+		}
+	} else {
+		uri.Params = base.NewParams()
+	}
+
+	if _, ok := uri.Params.Get("phone-context"); !uri.IsGlobal() && !ok {
+		err = fmt.Errorf("local number in tel URI '%s' requires a phone-context parameter", uriStr)
+		return
 	}
 
 	return
 }
 
 // ParseSipUri converts a string representation of a SIP or SIPS URI into a SipUri object.
+// Errors are returned as *base.ParseErrors, whose offset is that of the part of the URI which failed to parse.
 func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
+	return parseSipUriWithPolicy(uriStr, LastDuplicateParam)
+}
+
+// As ParseSipUri, but repeated URI parameter and header names are handled according to the policy.
+func parseSipUriWithPolicy(uriStr string, policy DuplicateParamPolicy) (uri base.SipUri, err error) {
 	// Store off the original URI in case we need to print it in an error.
 	uriStrCopy := uriStr
+	defer func() {
+		err = parseErrorAt(err, "", uriStrCopy, len(uriStrCopy)-len(uriStr))
+	}()
+
+	// The shortest possible prefix is 'sip:', so anything shorter cannot be a SIP URI.
+	if len(uriStr) < 4 {
+		err = fmt.Errorf("SIP uri '%s' is too short", uriStrCopy)
+		return
+	}
 
 	// URI should start 'sip' or 'sips'. Check the first 3 chars.
 	if strings.ToLower(uriStr[:3]) != "sip" {
@@ -459,7 +1527,7 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 	}
 
 	// The 'sip' or 'sips' protocol name should be followed by a ':' character.
-	if uriStr[0] != ':' {
+	if len(uriStr) == 0 || uriStr[0] != ':' {
 		err = fmt.Errorf("no ':' after protocol name in SIP uri '%s'", uriStrCopy)
 		return
 	}
@@ -479,14 +1547,26 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 			endOfUsernamePart = -1
 		}
 
+		// The user and password are stored unescaped, and escaped again when the URI is stringified.
+		var user, pwd string
 		if endOfUsernamePart == -1 {
 			// No password component; the whole of the user-info part before
 			// the '@' is a username.
-			user := uriStr[:endOfUserInfoPart]
+			user, err = base.UnescapeUser(uriStr[:endOfUserInfoPart])
+			if err != nil {
+				err = fmt.Errorf("bad user in SIP uri '%s': %s", uriStrCopy, err.Error())
+				return
+			}
 			uri.User = base.String{user}
 		} else {
-			user := uriStr[:endOfUsernamePart]
-			pwd := uriStr[endOfUsernamePart+1 : endOfUserInfoPart]
+			user, err = base.UnescapeUser(uriStr[:endOfUsernamePart])
+			if err == nil {
+				pwd, err = base.UnescapeUser(uriStr[endOfUsernamePart+1 : endOfUserInfoPart])
+			}
+			if err != nil {
+				err = fmt.Errorf("bad user-info in SIP uri '%s': %s", uriStrCopy, err.Error())
+				return
+			}
 			uri.User = base.String{user}
 			uri.Password = base.String{pwd}
 		}
@@ -505,10 +1585,11 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 	}
 
 	uri.Host, uri.Port, err = parseHostPort(uriStr[:endOfUriPart])
-	uriStr = uriStr[endOfUriPart:]
 	if err != nil {
 		return
-	} else if len(uriStr) == 0 {
+	}
+	uriStr = uriStr[endOfUriPart:]
+	if len(uriStr) == 0 {
 		uri.UriParams = base.NewParams()
 		uri.Headers = base.NewParams()
 		return
@@ -521,7 +1602,10 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 	var uriParams base.Params
 	var n int
 	if uriStr[0] == ';' {
-		uriParams, n, err = parseParams(uriStr, ';', ';', '?', true, true)
+		uriParams, n, err = parseParamsWithPolicy(uriStr, ';', ';', '?', true, true, policy)
+		if err == nil {
+			uriParams, err = unescapeUriParams(uriParams)
+		}
 		if err != nil {
 			return
 		}
@@ -534,7 +1618,10 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 	// Finally parse any URI headers.
 	// These are key-value pairs, starting with a '?' and separated by '&'.
 	var headers base.Params
-	headers, n, err = parseParams(uriStr, '?', '&', 0, true, false)
+	headers, n, err = parseParamsWithPolicy(uriStr, '?', '&', 0, true, false, policy)
+	if err == nil {
+		headers, err = unescapeUriParams(headers)
+	}
 	if err != nil {
 		return
 	}
@@ -549,10 +1636,65 @@ func ParseSipUri(uriStr string) (uri base.SipUri, err error) {
 	return
 }
 
+// Decode the percent-encoded names and values of a URI's parameters or headers. They are stored decoded, and
+// encoded again when the URI is stringified.
+func unescapeUriParams(params base.Params) (unescaped base.Params, err error) {
+	unescaped = base.NewParams()
+	for _, key := range params.Keys() {
+		var name string
+		name, err = base.UnescapeParam(key)
+		if err != nil {
+			return
+		}
+
+		value, _ := params.Get(key)
+		switch v := value.(type) {
+		case base.String:
+			var text string
+			text, err = base.UnescapeParam(v.S)
+			if err != nil {
+				return
+			}
+			value = base.String{text}
+		}
+		unescaped.Add(name, value)
+	}
+	return
+}
+
 // Parse a text representation of a host[:port] pair.
 // The port may or may not be present, so we represent it with a *uint16,
 // and return 'nil' if no port was present.
+// IPv6 literals must be enclosed in square brackets, e.g. '[2001:db8::1]:5060'; the brackets are not
+// included in the returned host.
 func parseHostPort(rawText string) (host string, port *uint16, err error) {
+	if strings.HasPrefix(rawText, "[") {
+		endOfHost := strings.Index(rawText, "]")
+		if endOfHost == -1 {
+			err = fmt.Errorf("unclosed '[' in IPv6 host '%s'", rawText)
+			return
+		}
+		host = rawText[1:endOfHost]
+		if len(host) == 0 || !strings.Contains(host, ":") {
+			err = fmt.Errorf("invalid IPv6 reference '%s'", rawText)
+			return
+		}
+
+		rest := rawText[endOfHost+1:]
+		if len(rest) == 0 {
+			return
+		} else if rest[0] != ':' {
+			err = fmt.Errorf("unexpected '%s' after IPv6 host in '%s'", rest, rawText)
+			return
+		}
+
+		var portRaw64 uint64
+		portRaw64, err = strconv.ParseUint(rest[1:], 10, 16)
+		portRaw16 := uint16(portRaw64)
+		port = &portRaw16
+		return
+	}
+
 	colonIdx := strings.Index(rawText, ":")
 	if colonIdx == -1 {
 		host = rawText
@@ -580,13 +1722,36 @@ func parseHostPort(rawText string) (host string, port *uint16, err error) {
 // parser and omitted from the returned map.
 // If permitSingletons is true, keys with no values are permitted.
 // These will result in a nil value in the returned map.
+// If a key appears more than once, the last value given for it is kept.
 func parseParams(source string,
 	start uint8, sep uint8, end uint8,
 	quoteValues bool, permitSingletons bool) (
 	params base.Params, consumed int, err error) {
+	return parseParamsWithPolicy(source, start, sep, end, quoteValues, permitSingletons, LastDuplicateParam)
+}
+
+// As parseParams, but repeated keys are handled according to the policy.
+func parseParamsWithPolicy(source string,
+	start uint8, sep uint8, end uint8,
+	quoteValues bool, permitSingletons bool,
+	policy DuplicateParamPolicy) (
+	params base.Params, consumed int, err error) {
 
 	params = base.NewParams()
 
+	addParam := func(key string, value base.MaybeString) error {
+		if _, exists := params.Get(key); exists {
+			switch policy {
+			case FirstDuplicateParam:
+				return nil
+			case StrictDuplicateParams:
+				return fmt.Errorf("duplicate parameter '%s' in params \"%s\"", key, source)
+			}
+		}
+		params.Add(key, value)
+		return nil
+	}
+
 	if len(source) == 0 {
 		// Key-value section is completely empty; return defaults.
 		return
@@ -628,14 +1793,16 @@ parseLoop:
 				continue
 			}
 			if parsingKey && permitSingletons {
-				params.Add(buffer.String(), base.NoString{})
+				err = addParam(buffer.String(), base.NoString{})
 			} else if parsingKey {
 				err = fmt.Errorf("Singleton param '%s' when parsing params which disallow singletons: \"%s\"",
 					buffer.String(), source)
-				return
 			} else {
 				value := buffer.String()
-				params.Add(key, base.String{value})
+				err = addParam(key, base.String{value})
+			}
+			if err != nil {
+				return
 			}
 			buffer.Reset()
 			parsingKey = true
@@ -671,7 +1838,25 @@ parseLoop:
 
 			inQuotes = !inQuotes
 
+		case '\\':
+			if !inQuotes {
+				buffer.WriteString("\\")
+				continue
+			}
+
+			// A backslash inside quotations escapes the following character (RFC 3261 s. 25.1).
+			consumed++
+			if consumed < len(source) {
+				buffer.WriteByte(source[consumed])
+			}
+
 		case '=':
+			if inQuotes {
+				// An equals sign inside quotations is a literal part of the value,
+				// e.g. in a quoted URI with its own parameters.
+				buffer.WriteString("=")
+				continue
+			}
 			if buffer.Len() == 0 {
 				err = fmt.Errorf("Key of length 0 in params \"%s\"", source)
 				return
@@ -685,12 +1870,13 @@ parseLoop:
 			parsingKey = false
 
 		default:
-			if !inQuotes && strings.Contains(c_ABNF_WS, string(source[consumed])) {
+			if !inQuotes && strings.IndexByte(c_ABNF_WS, source[consumed]) != -1 {
 				// Skip unquoted whitespace.
 				continue
 			}
 
-			buffer.WriteString(string(source[consumed]))
+			// Copy bytes rather than runes, so that multi-byte UTF-8 sequences are preserved intact.
+			buffer.WriteByte(source[consumed])
 		}
 	}
 
@@ -699,13 +1885,13 @@ parseLoop:
 	if inQuotes {
 		err = fmt.Errorf("Unclosed quotes in parameter string: %s", source)
 	} else if parsingKey && permitSingletons {
-		params.Add(buffer.String(), base.NoString{})
+		err = addParam(buffer.String(), base.NoString{})
 	} else if parsingKey {
 		err = fmt.Errorf("Singleton param '%s' when parsing params which disallow singletons: \"%s\"",
 			buffer.String(), source)
 	} else {
 		value := buffer.String()
-		params.Add(key, base.String{value})
+		err = addParam(key, base.String{value})
 	}
 	return
 }
@@ -717,15 +1903,26 @@ func (p *parser) parseHeader(headerText string) (headers []base.SipHeader, err e
 	log.Debug("Parser %p parsing header \"%s\"", p, headerText)
 	headers = make([]base.SipHeader, 0)
 
+	if p.rejectBadUTF8 && !utf8.ValidString(headerText) {
+		err = fmt.Errorf("invalid UTF-8 in header: %q", headerText)
+		return
+	}
+
 	colonIdx := strings.Index(headerText, ":")
 	if colonIdx == -1 {
 		err = fmt.Errorf("Field name with no value in header: %s", headerText)
 		return
 	}
 
-	fieldName := strings.TrimSpace(headerText[:colonIdx])
+	return p.ParseHeaderValue(strings.TrimSpace(headerText[:colonIdx]), headerText[colonIdx+1:])
+}
+
+// Implements Parser.ParseHeaderValue.
+func (p *parser) ParseHeaderValue(fieldName string, value string) (headers []base.SipHeader, err error) {
+	headers = make([]base.SipHeader, 0)
 	lowerFieldName := strings.ToLower(fieldName)
-	fieldText := strings.TrimSpace(headerText[colonIdx+1:])
+	// Only trim SIP whitespace, so that non-ASCII whitespace (e.g. a non-breaking space) survives in free-text headers.
+	fieldText := strings.Trim(value, c_ABNF_WS)
 	if headerParser, ok := p.headerParsers[lowerFieldName]; ok {
 		// We have a registered parser for this header type - use it.
 		headers, err = headerParser(lowerFieldName, fieldText)
@@ -737,22 +1934,159 @@ func (p *parser) parseHeader(headerText string) (headers []base.SipHeader, err e
 		headers = []base.SipHeader{&header}
 	}
 
+	if err == nil && p.strictVia {
+		for _, header := range headers {
+			if via, ok := header.(*base.ViaHeader); ok {
+				if err = checkViaProtocol(*via); err != nil {
+					headers = make([]base.SipHeader, 0)
+					return
+				}
+			}
+		}
+	}
+
+	if err == nil && p.strictHosts {
+		for _, header := range headers {
+			if err = checkHeaderHosts(header); err != nil {
+				headers = make([]base.SipHeader, 0)
+				return
+			}
+		}
+	}
+
 	return
 }
 
-// Parse a To, From or Contact header line, producing one or more logical SipHeaders.
+// Check the hosts in the given header for strict mode. Only Via headers and the address headers that route
+// requests are checked; others are always accepted.
+func checkHeaderHosts(header base.SipHeader) error {
+	switch h := header.(type) {
+	case *base.ViaHeader:
+		for _, hop := range *h {
+			if err := validateHost(hop.Host); err != nil {
+				return err
+			}
+		}
+	case *base.ToHeader:
+		return checkUriHost(h.Address)
+	case *base.FromHeader:
+		return checkUriHost(h.Address)
+	case *base.ContactHeader:
+		return checkUriHost(h.Address)
+	case *base.RouteHeader:
+		return checkUriHost(h.Address)
+	case *base.RecordRouteHeader:
+		return checkUriHost(h.Address)
+	}
+	return nil
+}
+
+// Check the host of the given URI for strict mode. URIs other than SIP URIs, which have no host, are accepted.
+func checkUriHost(uri base.Uri) error {
+	if sipUri, ok := uri.(*base.SipUri); ok {
+		return validateHost(sipUri.Host)
+	}
+	return nil
+}
+
+// Check that the given host, as returned by parseHostPort, is an IPv4 address, an IPv6 reference without its
+// brackets, or a hostname (RFC 3261 s. 25.1).
+func validateHost(host string) error {
+	if strings.Contains(host, ":") {
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid IPv6 reference '%s'", host)
+		}
+		return nil
+	}
+
+	if strings.Trim(host, "0123456789.") == "" {
+		if ip := net.ParseIP(host); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 address '%s'", host)
+		}
+		return nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	for _, label := range labels {
+		if len(label) == 0 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid hostname '%s'", host)
+		}
+		for _, char := range label {
+			if !(char >= 'a' && char <= 'z') && !(char >= 'A' && char <= 'Z') &&
+				!(char >= '0' && char <= '9') && char != '-' {
+				return fmt.Errorf("invalid hostname '%s'", host)
+			}
+		}
+	}
+	return nil
+}
+
+// The transports permitted in Via headers by the parser in strict mode.
+var standardViaTransports = []string{"UDP", "TCP", "TLS", "SCTP", "WS", "WSS"}
+
+// Check that each hop in the given Via header has a standard sent-protocol: the protocol name SIP, a numeric
+// version, and a known transport (RFC 3261 s. 20.42 and RFC 7118 s. 5).
+func checkViaProtocol(via base.ViaHeader) error {
+	for _, hop := range via {
+		if !strings.EqualFold(hop.ProtocolName, "SIP") {
+			return fmt.Errorf("unknown protocol name '%s' in Via hop '%s'", hop.ProtocolName, hop.String())
+		}
+
+		versionParts := strings.Split(hop.ProtocolVersion, ".")
+		if len(versionParts) != 2 || !isDigits(versionParts[0]) || !isDigits(versionParts[1]) {
+			return fmt.Errorf("non-numeric protocol version '%s' in Via hop '%s'", hop.ProtocolVersion, hop.String())
+		}
+
+		known := false
+		for _, transport := range standardViaTransports {
+			if strings.EqualFold(hop.Transport, transport) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown transport '%s' in Via hop '%s'", hop.Transport, hop.String())
+		}
+	}
+	return nil
+}
+
+// Determine whether the given string is a non-empty run of decimal digits.
+func isDigits(text string) bool {
+	if len(text) == 0 {
+		return false
+	}
+	for idx := 0; idx < len(text); idx++ {
+		if text[idx] < '0' || text[idx] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse a To, From, Contact, Refer-To or Referred-By header line, producing one or more logical SipHeaders.
 func parseAddressHeader(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	return parseAddressHeaderWithPolicy(headerName, headerText, BareUriParamsToHeader, LastDuplicateParam)
+}
+
+// As parseAddressHeader, but parameters following URIs not in angle brackets are assigned according to
+// bareUriParams, and repeated parameter names are handled according to duplicateParams.
+func parseAddressHeaderWithPolicy(headerName string, headerText string,
+	bareUriParams BareUriParamPolicy, duplicateParams DuplicateParamPolicy) (
 	headers []base.SipHeader, err error) {
 	switch headerName {
-	case "to", "from", "contact", "t", "f", "m":
+	case "to", "from", "contact", "t", "f", "m", "refer-to", "r", "referred-by", "b",
+		"route", "record-route", "reply-to":
 		var displayNames []base.MaybeString
 		var uris []base.Uri
 		var paramSets []base.Params
 
 		// Perform the actual parsing. The rest of this method is just typeclass bookkeeping.
-		displayNames, uris, paramSets, err = parseAddressValues(headerText)
+		displayNames, uris, paramSets, err = parseAddressValuesWithPolicy(headerText, bareUriParams, duplicateParams)
 
 		if err != nil {
+			err = parseErrorAt(err, base.CanonicalHeaderName(headerName), headerText, 0)
 			return
 		}
 		if len(displayNames) != len(uris) || len(uris) != len(paramSets) {
@@ -834,6 +2168,73 @@ func parseAddressHeader(headerName string, headerText string) (
 					return nil,
 						fmt.Errorf("Uri %s not valid in Contact header. Must be SIP uri or '*'", uris[idx].String())
 				}
+			} else if headerName == "refer-to" || headerName == "r" {
+				if idx > 0 {
+					// Only a single Refer-To header is permitted in a REFER request.
+					return nil,
+						fmt.Errorf("Multiple refer-to: headers in message:\n%s: %s",
+							headerName, headerText)
+				}
+				switch uris[idx].(type) {
+				case base.WildcardUri:
+					err = fmt.Errorf("wildcard uri not permitted in refer-to: "+
+						"header: %s", headerText)
+					return
+				default:
+					header = &base.ReferToHeader{displayNames[idx],
+						uris[idx],
+						paramSets[idx]}
+				}
+			} else if headerName == "referred-by" || headerName == "b" {
+				if idx > 0 {
+					// Only a single Referred-By header is permitted in a message.
+					return nil,
+						fmt.Errorf("Multiple referred-by: headers in message:\n%s: %s",
+							headerName, headerText)
+				}
+				switch uris[idx].(type) {
+				case base.WildcardUri:
+					err = fmt.Errorf("wildcard uri not permitted in referred-by: "+
+						"header: %s", headerText)
+					return
+				default:
+					header = &base.ReferredByHeader{displayNames[idx],
+						uris[idx],
+						paramSets[idx]}
+				}
+			} else if headerName == "reply-to" {
+				if idx > 0 {
+					// Only a single Reply-To header is permitted in a message.
+					return nil,
+						fmt.Errorf("Multiple reply-to: headers in message:\n%s: %s",
+							headerName, headerText)
+				}
+				switch uris[idx].(type) {
+				case base.WildcardUri:
+					err = fmt.Errorf("wildcard uri not permitted in reply-to: "+
+						"header: %s", headerText)
+					return
+				default:
+					header = &base.ReplyToHeader{displayNames[idx],
+						uris[idx],
+						paramSets[idx]}
+				}
+			} else if headerName == "route" || headerName == "record-route" {
+				switch uris[idx].(type) {
+				case base.WildcardUri:
+					err = fmt.Errorf("wildcard uri not permitted in %s: "+
+						"header: %s", headerName, headerText)
+					return
+				}
+				if headerName == "route" {
+					header = &base.RouteHeader{displayNames[idx],
+						uris[idx],
+						paramSets[idx]}
+				} else {
+					header = &base.RecordRouteHeader{displayNames[idx],
+						uris[idx],
+						paramSets[idx]}
+				}
 			}
 
 			headers = append(headers, header)
@@ -880,133 +2281,1239 @@ func parseCSeq(headerName string, headerText string) (
 	return
 }
 
-// Parse a string representation of a Call-Id header, returning a slice of at most one CallId.
-func parseCallId(headerName string, headerText string) (
+// Parse a string representation of an RSeq header, returning a slice of at most one RSeq.
+func parseRSeq(headerName string, headerText string) (
 	headers []base.SipHeader, err error) {
-	headerText = strings.TrimSpace(headerText)
-	var callId base.CallId = base.CallId(headerText)
-
-	if strings.ContainsAny(string(callId), c_ABNF_WS) {
-		err = fmt.Errorf("unexpected whitespace in CallId header body '%s'", headerText)
+	var rseq base.RSeq
+	var value uint64
+	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
+	if err != nil {
 		return
 	}
-	if strings.Contains(string(callId), ";") {
-		err = fmt.Errorf("unexpected semicolon in CallId header body '%s'", headerText)
+
+	if value == 0 || value > MAX_CSEQ {
+		err = fmt.Errorf("invalid RSeq %d: must be between 1 and 2**31 - 1", value)
 		return
 	}
-	if len(string(callId)) == 0 {
-		err = fmt.Errorf("empty Call-Id body")
+
+	rseq = base.RSeq(value)
+	headers = []base.SipHeader{&rseq}
+	return
+}
+
+// Parse a string representation of an RAck header, returning a slice of at most one RAck.
+func parseRAck(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var rack base.RAck
+
+	parts := splitByWhitespace(headerText)
+	if len(parts) != 3 {
+		err = fmt.Errorf("RAck field should have precisely two whitespace sections: '%s'",
+			headerText)
+		return
+	}
+
+	var rseqno uint64
+	rseqno, err = strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return
+	}
+	if rseqno == 0 || rseqno > MAX_CSEQ {
+		err = fmt.Errorf("invalid RSeq number %d in RAck: must be between 1 and 2**31 - 1", rseqno)
+		return
+	}
+
+	var seqno uint64
+	seqno, err = strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return
+	}
+	if seqno > MAX_CSEQ {
+		err = fmt.Errorf("invalid CSeq number %d in RAck: exceeds maximum permitted value "+
+			"2**31 - 1", seqno)
+		return
+	}
+
+	rack.RSeqNo = uint32(rseqno)
+	rack.CSeqNo = uint32(seqno)
+	rack.MethodName = base.Method(strings.TrimSpace(parts[2]))
+
+	if strings.Contains(string(rack.MethodName), ";") {
+		err = fmt.Errorf("unexpected ';' in RAck body: %s", headerText)
+		return
+	}
+
+	headers = []base.SipHeader{&rack}
+	return
+}
+
+// Parse a string representation of a Date header, returning a slice of at most one DateHeader.
+// SIP only permits RFC 1123 dates in GMT, so any other time zone is an error.
+func parseDate(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	headerText = strings.TrimSpace(headerText)
+
+	if !strings.HasSuffix(headerText, " GMT") {
+		err = fmt.Errorf("Date header must be in GMT: '%s'", headerText)
+		return
+	}
+
+	var date base.DateHeader
+	date.Time, err = time.Parse(time.RFC1123, headerText)
+	if err != nil {
+		err = fmt.Errorf("invalid Date header '%s': %s", headerText, err.Error())
+		return
+	}
+	date.Time = date.Time.UTC()
+
+	headers = []base.SipHeader{&date}
+	return
+}
+
+// Parse a string representation of a Retry-After header, returning a slice of at most one RetryAfterHeader.
+// The header consists of a number of seconds, optionally followed by a parenthesized comment and then parameters,
+// e.g. '120 (I'm busy);duration=3600'.
+func parseRetryAfter(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var retryAfter base.RetryAfterHeader
+	headerText = strings.TrimSpace(headerText)
+
+	endOfSeconds := strings.IndexAny(headerText, "(;"+c_ABNF_WS)
+	if endOfSeconds == -1 {
+		endOfSeconds = len(headerText)
+	}
+
+	var seconds uint64
+	seconds, err = strconv.ParseUint(headerText[:endOfSeconds], 10, 32)
+	if err != nil {
+		err = fmt.Errorf("invalid delta-seconds in Retry-After header '%s': %s", headerText, err.Error())
+		return
+	}
+	retryAfter.Seconds = uint32(seconds)
+
+	rest := strings.TrimSpace(headerText[endOfSeconds:])
+	retryAfter.Comment = base.NoString{}
+	if len(rest) > 0 && rest[0] == '(' {
+		// Comments may contain nested comments and escaped characters (RFC 3261 s. 25.1).
+		depth := 0
+		endOfComment := -1
+		for idx := 0; idx < len(rest) && endOfComment == -1; idx++ {
+			switch rest[idx] {
+			case '\\':
+				idx++
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					endOfComment = idx
+				}
+			}
+		}
+		if endOfComment == -1 {
+			err = fmt.Errorf("unclosed comment in Retry-After header '%s'", headerText)
+			return
+		}
+
+		retryAfter.Comment = base.String{rest[1:endOfComment]}
+		rest = strings.TrimSpace(rest[endOfComment+1:])
+	}
+
+	if len(rest) > 0 {
+		retryAfter.Params, _, err = parseParamsWithPolicy(rest, ';', ';', 0, true, true, policy)
+		if err != nil {
+			return
+		}
+	} else {
+		retryAfter.Params = base.NewParams()
+	}
+
+	headers = []base.SipHeader{&retryAfter}
+	return
+}
+
+// Parse a string representation of a Call-Id header, returning a slice of at most one CallId.
+func parseCallId(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	headerText = strings.TrimSpace(headerText)
+	var callId base.CallId = base.CallId(headerText)
+
+	err = checkCallId(headerText)
+	if err != nil {
+		return
+	}
+
+	headers = []base.SipHeader{&callId}
+
+	return
+}
+
+// Check that the given text, with surrounding whitespace already removed, is a valid Call-Id.
+// RFC 3261 s. 25.1 defines a Call-Id as 'word ["@" word]', where a word is a run of alphanumerics and the
+// characters -.!%*_+`'~()<>:\"/[]?{}
+func checkCallId(text string) error {
+	if strings.ContainsAny(text, c_ABNF_WS) {
+		return fmt.Errorf("unexpected whitespace in CallId header body '%s'", text)
+	}
+	if strings.Contains(text, ";") {
+		return fmt.Errorf("unexpected semicolon in CallId header body '%s'", text)
+	}
+	if len(text) == 0 {
+		return fmt.Errorf("empty Call-Id body")
+	}
+
+	words := strings.SplitN(text, "@", 2)
+	for _, word := range words {
+		if len(word) == 0 {
+			return fmt.Errorf("empty word around '@' in CallId header body '%s'", text)
+		}
+		for _, char := range word {
+			if !isWordChar(char) {
+				return fmt.Errorf("unexpected character '%c' in CallId header body '%s'", char, text)
+			}
+		}
+	}
+	return nil
+}
+
+// Determine whether the given character is permitted in a SIP word (RFC 3261 s. 25.1), as used in Call-Ids.
+func isWordChar(char rune) bool {
+	return isTokenChar(char) || strings.ContainsRune("()<>:\\\"/[]?{}", char)
+}
+
+// Parse a string representation of an In-Reply-To header, returning a slice of at most one InReplyToHeader.
+// The header is a comma-separated list of one or more Call-Ids.
+func parseInReplyTo(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var header base.InReplyToHeader = base.InReplyToHeader{}
+
+	for _, callId := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+		callId = strings.TrimSpace(callId)
+		err = checkCallId(callId)
+		if err != nil {
+			err = fmt.Errorf("invalid Call-Id in In-Reply-To header '%s': %s", headerText, err.Error())
+			return
+		}
+		header = append(header, base.CallId(callId))
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of a Via header, returning a slice of at most one ViaHeader.
+// Note that although Via headers may contain a comma-separated list, RFC 3261 makes it clear that
+// these should not be treated as separate logical Via headers, but as multiple values on a single
+// Via header.
+// Errors are returned as *base.ParseErrors, whose offset is that of the part of the header which failed to parse.
+func parseViaHeader(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	// The offset into the header text of the part currently being parsed.
+	offset := 0
+	defer func() {
+		err = parseErrorAt(err, "Via", headerText, offset)
+	}()
+
+	sections := base.SplitUnquoted(headerText, ',', quotes_delim)
+	var via base.ViaHeader = base.ViaHeader{}
+	sectionStart := 0
+	for _, section := range sections {
+		offset = sectionStart
+		if len(strings.TrimSpace(section)) == 0 {
+			err = fmt.Errorf("empty Via entry in via header '%s'", headerText)
+			return
+		}
+
+		var hop base.ViaHop
+		parts := strings.Split(section, "/")
+
+		if len(parts) < 3 {
+			err = fmt.Errorf("not enough protocol parts in via header: '%s'",
+				parts)
+			return
+		}
+
+		parts[2] = strings.Join(parts[2:], "/")
+
+		// The transport part ends when whitespace is reached, but may also start with
+		// whitespace.
+		// So the end of the transport part is the first whitespace char following the
+		// first non-whitespace char.
+		initialSpaces := len(parts[2]) - len(strings.TrimLeft(parts[2], c_ABNF_WS))
+		sentByIdx := strings.IndexAny(parts[2][initialSpaces:], c_ABNF_WS) + initialSpaces + 1
+		if sentByIdx == 0 {
+			err = fmt.Errorf("expected whitespace after sent-protocol part "+
+				"in via header '%s'", section)
+			return
+		} else if sentByIdx == 1 {
+			err = fmt.Errorf("empty transport field in via header '%s'", section)
+			return
+		}
+
+		hop.ProtocolName = strings.TrimSpace(parts[0])
+		hop.ProtocolVersion = strings.TrimSpace(parts[1])
+		hop.Transport = strings.TrimSpace(parts[2][:sentByIdx-1])
+
+		if len(hop.ProtocolName) == 0 {
+			err = fmt.Errorf("no protocol name provided in via header '%s'", section)
+		} else if len(hop.ProtocolVersion) == 0 {
+			err = fmt.Errorf("no version provided in via header '%s'", section)
+		} else if len(hop.Transport) == 0 {
+			err = fmt.Errorf("no transport provided in via header '%s'", section)
+		}
+		if err != nil {
+			return
+		}
+
+		viaBody := parts[2][sentByIdx:]
+		offset = sectionStart + len(parts[0]) + len(parts[1]) + 2 + sentByIdx
+
+		paramsIdx := strings.Index(viaBody, ";")
+		var host string
+		var port *uint16
+		if paramsIdx == -1 {
+			// There are no header parameters, so the rest of the Via body is part of the host[:post].
+			host, port, err = parseHostPort(viaBody)
+			hop.Host = host
+			hop.Port = port
+			if err != nil {
+				return
+			}
+			hop.Params = base.NewParams()
+		} else {
+			host, port, err = parseHostPort(viaBody[:paramsIdx])
+			if err != nil {
+				return
+			}
+			hop.Host = host
+			hop.Port = port
+
+			offset += paramsIdx
+			hop.Params, _, err = parseParamsWithPolicy(viaBody[paramsIdx:],
+				';', ';', 0, true, true, policy)
+			if err != nil {
+				return
+			}
+		}
+		via = append(via, &hop)
+		sectionStart += len(section) + 1
+	}
+
+	headers = []base.SipHeader{&via}
+	return
+}
+
+// Parse a string representation of a Max-Forwards header into a slice of at most one MaxForwards header object.
+func parseMaxForwards(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var maxForwards base.MaxForwards
+	var value uint64
+	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
+	maxForwards = base.MaxForwards(value)
+
+	headers = []base.SipHeader{&maxForwards}
+	return
+}
+
+// Parse a string representation of a Min-Expires header into a slice of at most one MinExpires header object.
+func parseMinExpires(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var minExpires base.MinExpires
+	var value uint64
+	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
+	if err != nil {
+		return
+	}
+
+	minExpires = base.MinExpires(value)
+	headers = []base.SipHeader{&minExpires}
+	return
+}
+
+// Parse a string representation of a Content-Length header into a slice of at most one ContentLength header object.
+func parseContentLength(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var contentLength base.ContentLength
+	var value uint64
+	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
+	contentLength = base.ContentLength(value)
+
+	headers = []base.SipHeader{&contentLength}
+	return
+}
+
+// Parse a string representation of a Content-Type header into a slice of at most one ContentType header object.
+func parseContentType(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var contentType base.ContentType
+
+	paramsIdx := strings.Index(headerText, ";")
+	if paramsIdx == -1 {
+		paramsIdx = len(headerText)
+	}
+
+	contentType.MediaType = strings.TrimSpace(headerText[:paramsIdx])
+	slashIdx := strings.Index(contentType.MediaType, "/")
+	if slashIdx <= 0 || slashIdx == len(contentType.MediaType)-1 {
+		err = fmt.Errorf("invalid media type in Content-Type header '%s'", headerText)
+		return
+	}
+	if strings.ContainsAny(contentType.MediaType, c_ABNF_WS) {
+		err = fmt.Errorf("unexpected whitespace in Content-Type media type '%s'", headerText)
+		return
+	}
+
+	if paramsIdx < len(headerText) {
+		contentType.Params, _, err = parseParamsWithPolicy(headerText[paramsIdx:], ';', ';', 0, true, true, policy)
+		if err != nil {
+			return
+		}
+	} else {
+		contentType.Params = base.NewParams()
+	}
+
+	headers = []base.SipHeader{&contentType}
+	return
+}
+
+// Parse a string representation of an Accept header, returning a slice of at most one AcceptHeader.
+// The media ranges are sorted so that the most preferred comes first. An empty list is permitted.
+func parseAccept(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var values []*base.QualifiedValue
+	values, err = parseQualifiedValues(headerText, isMediaRange, policy)
+	if err != nil {
+		return
+	}
+	base.SortByQ(values, func(i int) float32 { return values[i].Q })
+
+	header := base.AcceptHeader(values)
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of an Accept-Encoding header, returning a slice of at most one
+// AcceptEncodingHeader. The content codings are kept in the order given; AcceptEncodingHeader.Sorted gives them
+// most preferred first.
+func parseAcceptEncoding(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var values []*base.QualifiedValue
+	values, err = parseQualifiedValues(headerText, isToken, policy)
+	if err != nil {
+		return
+	}
+
+	header := base.AcceptEncodingHeader(values)
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of an Accept-Language header, returning a slice of at most one
+// AcceptLanguageHeader. The language ranges are kept in the order given; AcceptLanguageHeader.Sorted gives them
+// most preferred first.
+func parseAcceptLanguage(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var values []*base.QualifiedValue
+	values, err = parseQualifiedValues(headerText, isLanguageRange, policy)
+	if err != nil {
+		return
+	}
+
+	header := base.AcceptLanguageHeader(values)
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of a Content-Encoding header, returning a slice of at most one
+// ContentEncodingHeader. The content codings are kept in the order given.
+func parseContentEncoding(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var header base.ContentEncodingHeader = base.ContentEncodingHeader{}
+
+	for _, coding := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+		coding = strings.TrimSpace(coding)
+		if !isToken(coding) {
+			err = fmt.Errorf("invalid content coding '%s' in Content-Encoding header '%s'", coding, headerText)
+			return
+		}
+		header = append(header, coding)
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of an Allow header, returning a slice of at most one AllowHeader.
+// The header is a comma-separated list of methods, e.g. 'INVITE, ACK, BYE', which may be empty.
+func parseAllow(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var header base.AllowHeader = base.AllowHeader{}
+
+	if len(strings.TrimSpace(headerText)) > 0 {
+		for _, method := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+			method = strings.TrimSpace(method)
+			if !isToken(method) {
+				err = fmt.Errorf("invalid method '%s' in Allow header '%s'", method, headerText)
+				return
+			}
+			header = append(header, base.Method(method))
+		}
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of a Supported header, returning a slice of at most one SupportedHeader.
+// The header is a comma-separated list of option tags, e.g. '100rel, timer', which may be empty.
+func parseSupported(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	header := base.SupportedHeader{Options: []string{}}
+
+	if len(strings.TrimSpace(headerText)) > 0 {
+		for _, option := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+			option = strings.TrimSpace(option)
+			if !isToken(option) {
+				err = fmt.Errorf("invalid option tag '%s' in Supported header '%s'", option, headerText)
+				return
+			}
+			header.Options = append(header.Options, option)
+		}
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of an Allow-Events header, returning a slice of at most one AllowEventsHeader.
+// The header is a comma-separated list of one or more event packages, e.g. 'presence, dialog'.
+func parseAllowEvents(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var header base.AllowEventsHeader = base.AllowEventsHeader{}
+
+	for _, eventType := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+		eventType = strings.TrimSpace(eventType)
+		if !isToken(eventType) {
+			err = fmt.Errorf("invalid event package '%s' in Allow-Events header '%s'", eventType, headerText)
+			return
+		}
+		header = append(header, eventType)
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of an Organization header, returning a slice of at most one OrganizationHeader.
+// The organization name is free text, and may be empty.
+func parseOrganization(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	header := base.OrganizationHeader(strings.Trim(headerText, c_ABNF_WS))
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of a Subject header, returning a slice of at most one SubjectHeader.
+// The subject is free text, and may be empty.
+func parseSubject(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	header := base.SubjectHeader(strings.Trim(headerText, c_ABNF_WS))
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of a Priority header, returning a slice of at most one PriorityHeader.
+// Besides the standard priorities ('emergency', 'urgent', 'normal' and 'non-urgent'), any token is accepted
+// as an extension priority.
+func parsePriority(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	priority := strings.TrimSpace(headerText)
+	if !isToken(priority) {
+		err = fmt.Errorf("invalid priority '%s' in Priority header", priority)
+		return
+	}
+
+	header := base.PriorityHeader(priority)
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of a SIP-ETag or SIP-If-Match header, returning a slice of at most one
+// SIPETagHeader or SIPIfMatchHeader respectively. Either header holds a single entity-tag, which is a token.
+func parseEntityTag(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	entityTag := strings.TrimSpace(headerText)
+	if !isToken(entityTag) {
+		err = fmt.Errorf("invalid entity-tag '%s' in %s header", entityTag, headerName)
+		return
+	}
+
+	switch headerName {
+	case "sip-etag":
+		header := base.SIPETagHeader(entityTag)
+		headers = []base.SipHeader{&header}
+	case "sip-if-match":
+		header := base.SIPIfMatchHeader(entityTag)
+		headers = []base.SipHeader{&header}
+	default:
+		err = fmt.Errorf("unexpected header name '%s' for entity-tag parser", headerName)
+	}
+	return
+}
+
+// Parse a string representation of an Authorization, Proxy-Authorization, WWW-Authenticate or Proxy-Authenticate
+// header, returning a slice of at most one AuthHeader. The header holds an authentication scheme, such as 'Digest',
+// followed by comma-separated parameters whose values may be quoted.
+func parseAuthHeader(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	headerText = strings.TrimSpace(headerText)
+
+	header := base.AuthHeader{HeaderName: headerName, Params: base.NewParams()}
+	endOfScheme := strings.IndexAny(headerText, c_ABNF_WS)
+	if endOfScheme == -1 {
+		endOfScheme = len(headerText)
+	}
+	header.Scheme = headerText[:endOfScheme]
+	if !isToken(header.Scheme) {
+		err = fmt.Errorf("invalid authentication scheme '%s' in %s header", header.Scheme, headerName)
+		return
+	}
+
+	if rest := strings.TrimSpace(headerText[endOfScheme:]); len(rest) > 0 {
+		header.Params, _, err = parseParamsWithPolicy(rest, 0, ',', 0, true, true, policy)
+		if err != nil {
+			return
+		}
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a comma-separated list of values with optional q-values, such as the body of an Accept-Encoding header,
+// checking each value with the given function. The values are kept in the order given. An empty list is permitted.
+func parseQualifiedValues(text string, valid func(string) bool, policy DuplicateParamPolicy) (
+	values []*base.QualifiedValue, err error) {
+	values = make([]*base.QualifiedValue, 0)
+	if len(strings.TrimSpace(text)) == 0 {
+		return
+	}
+
+	for _, entry := range base.SplitUnquoted(text, ',', quotes_delim) {
+		paramsIdx := strings.Index(entry, ";")
+		if paramsIdx == -1 {
+			paramsIdx = len(entry)
+		}
+
+		qualified := base.QualifiedValue{Value: strings.TrimSpace(entry[:paramsIdx]), Q: 1}
+		if !valid(qualified.Value) {
+			err = fmt.Errorf("invalid value '%s' in list '%s'", qualified.Value, text)
+			return
+		}
+
+		params := base.NewParams()
+		if paramsIdx < len(entry) {
+			params, _, err = parseParamsWithPolicy(entry[paramsIdx:], ';', ';', 0, true, true, policy)
+			if err != nil {
+				return
+			}
+		}
+
+		// Separate out the q-value from any other parameters.
+		qualified.Params = base.NewParams()
+		for _, key := range params.Keys() {
+			value, _ := params.Get(key)
+			if strings.ToLower(key) != "q" {
+				qualified.Params.Add(key, value)
+				continue
+			}
+
+			qText, ok := value.(base.String)
+			if !ok {
+				err = fmt.Errorf("missing q-value for '%s' in list '%s'", qualified.Value, text)
+				return
+			}
+			qualified.Q, ok = base.ParseQValue(base.NewParams().Add(key, value))
+			if !ok {
+				err = fmt.Errorf("invalid q-value '%s': must be between 0 and 1 with at most three decimal places",
+					qText.S)
+				return
+			}
+		}
+
+		values = append(values, &qualified)
+	}
+
+	return
+}
+
+// Determine whether the given string is a non-empty SIP token (RFC 3261 s. 25.1).
+func isToken(text string) bool {
+	if len(text) == 0 {
+		return false
+	}
+	for _, char := range text {
+		if !isTokenChar(char) {
+			return false
+		}
+	}
+	return true
+}
+
+// Determine whether the given string is a media range (RFC 3261 s. 20.1), e.g. 'application/sdp', 'text/*' or '*/*'.
+func isMediaRange(text string) bool {
+	parts := strings.Split(text, "/")
+	return len(parts) == 2 && isToken(parts[0]) && isToken(parts[1])
+}
+
+// Determine whether the given string is a language range (RFC 3261 s. 20.3), e.g. 'en', 'en-gb' or '*'.
+func isLanguageRange(text string) bool {
+	if text == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(text, "-") {
+		if len(tag) == 0 || len(tag) > 8 {
+			return false
+		}
+		for _, char := range tag {
+			if !(char >= 'a' && char <= 'z') && !(char >= 'A' && char <= 'Z') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Parse a string representation of an Event header into a slice of at most one EventHeader object.
+func parseEventHeader(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var event base.EventHeader
+
+	paramsIdx := strings.Index(headerText, ";")
+	if paramsIdx == -1 {
+		paramsIdx = len(headerText)
+	}
+
+	event.EventType = strings.TrimSpace(headerText[:paramsIdx])
+	if len(event.EventType) == 0 {
+		err = fmt.Errorf("empty event package in Event header '%s'", headerText)
+		return
+	}
+	for _, char := range event.EventType {
+		if !isTokenChar(char) {
+			err = fmt.Errorf("invalid character '%c' in event package of Event header '%s'", char, headerText)
+			return
+		}
+	}
+
+	if paramsIdx < len(headerText) {
+		event.Params, _, err = parseParamsWithPolicy(headerText[paramsIdx:], ';', ';', 0, true, true, policy)
+		if err != nil {
+			return
+		}
+	} else {
+		event.Params = base.NewParams()
+	}
+
+	headers = []base.SipHeader{&event}
+	return
+}
+
+// Parse a string representation of a Session-Expires header, returning a slice of at most one
+// SessionExpiresHeader. The 'refresher' parameter is optional, but if present must be 'uac' or 'uas'.
+func parseSessionExpires(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var sessionExpires base.SessionExpiresHeader
+
+	paramsIdx := strings.Index(headerText, ";")
+	if paramsIdx == -1 {
+		paramsIdx = len(headerText)
+	}
+
+	var seconds uint64
+	seconds, err = strconv.ParseUint(strings.TrimSpace(headerText[:paramsIdx]), 10, 32)
+	if err != nil {
+		return
+	}
+	sessionExpires.Seconds = uint32(seconds)
+
+	if paramsIdx < len(headerText) {
+		sessionExpires.Params, _, err = parseParamsWithPolicy(headerText[paramsIdx:], ';', ';', 0, true, true, policy)
+		if err != nil {
+			return
+		}
+	} else {
+		sessionExpires.Params = base.NewParams()
+	}
+
+	if refresher, ok := sessionExpires.Params.Get("refresher"); ok {
+		value, isString := refresher.(base.String)
+		if !isString || (value.S != "uac" && value.S != "uas") {
+			err = fmt.Errorf("refresher in Session-Expires header '%s' must be 'uac' or 'uas'", headerText)
+			return
+		}
+	}
+
+	headers = []base.SipHeader{&sessionExpires}
+	return
+}
+
+// Parse a string representation of a Min-SE header, returning a slice of at most one MinSE.
+func parseMinSE(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var minSE base.MinSE
+	var value uint64
+	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
+	if err != nil {
+		return
+	}
+
+	minSE = base.MinSE(value)
+	headers = []base.SipHeader{&minSE}
+	return
+}
+
+// Parse a string representation of a Timestamp header, returning a slice of at most one TimestampHeader.
+// The header consists of a decimal timestamp, optionally followed by whitespace and a decimal delay, e.g. '54.21 0.5'.
+func parseTimestamp(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var timestamp base.TimestampHeader
+	parts := splitByWhitespace(strings.TrimSpace(headerText))
+	if len(parts) == 0 || len(parts) > 2 {
+		err = fmt.Errorf("expected timestamp and optional delay in Timestamp header '%s'", headerText)
+		return
+	}
+
+	timestamp.Timestamp, err = parseDecimal(parts[0])
+	if err != nil {
+		err = fmt.Errorf("invalid timestamp in Timestamp header '%s': %s", headerText, err.Error())
+		return
+	}
+
+	if len(parts) == 2 {
+		var delay float64
+		delay, err = parseDecimal(parts[1])
+		if err != nil {
+			err = fmt.Errorf("invalid delay in Timestamp header '%s': %s", headerText, err.Error())
+			return
+		}
+		timestamp.Delay = &delay
+	}
+
+	headers = []base.SipHeader{&timestamp}
+	return
+}
+
+// Parse a decimal number of the form 1*DIGIT [ "." *DIGIT ], as used in the Timestamp header.
+// Unlike strconv.ParseFloat, this rejects signs, exponents, and special values such as 'Inf'.
+func parseDecimal(text string) (float64, error) {
+	seenPoint := false
+	for idx, c := range text {
+		if c == '.' && idx > 0 && !seenPoint {
+			seenPoint = true
+		} else if c < '0' || c > '9' {
+			return 0, fmt.Errorf("'%s' is not a decimal number", text)
+		}
+	}
+	if len(text) == 0 {
+		return 0, fmt.Errorf("empty decimal number")
+	}
+
+	return strconv.ParseFloat(text, 64)
+}
+
+// Parse a string representation of a Warning header, returning a slice of at most one WarningHeader.
+// Each comma-separated warning consists of a three-digit code, a warning agent, and quoted text;
+// the text may itself contain commas, so we do not split within quotes.
+func parseWarning(headerName string, headerText string) (
+	headers []base.SipHeader, err error) {
+	var header base.WarningHeader = base.WarningHeader{}
+
+	for _, entry := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+		entry = strings.TrimSpace(entry)
+
+		var warning base.Warning
+		parts := strings.SplitN(entry, " ", 3)
+		if len(parts) != 3 {
+			err = fmt.Errorf("warning '%s' should have a code, an agent and text", entry)
+			return
+		}
+
+		if len(parts[0]) != 3 {
+			err = fmt.Errorf("warning code '%s' is not a three-digit number", parts[0])
+			return
+		}
+		var code uint64
+		code, err = strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			err = fmt.Errorf("warning code '%s' is not a three-digit number", parts[0])
+			return
+		}
+		warning.Code = uint16(code)
+
+		warning.Agent = parts[1]
+		if len(warning.Agent) == 0 {
+			err = fmt.Errorf("empty warning agent in warning '%s'", entry)
+			return
+		}
+
+		// The text is a quoted string, which may contain backslash-escaped characters (RFC 3261 s. 25.1).
+		quoted := strings.TrimSpace(parts[2])
+		if len(quoted) < 2 || quoted[0] != '"' {
+			err = fmt.Errorf("warning text must be quoted in warning '%s'", entry)
+			return
+		}
+		var text bytes.Buffer
+		endQuote := -1
+		for idx := 1; idx < len(quoted); idx++ {
+			if quoted[idx] == '\\' && idx+1 < len(quoted) {
+				idx++
+			} else if quoted[idx] == '"' {
+				endQuote = idx
+				break
+			}
+			text.WriteByte(quoted[idx])
+		}
+		if endQuote != len(quoted)-1 {
+			err = fmt.Errorf("malformed warning text in warning '%s'", entry)
+			return
+		}
+		warning.Text = text.String()
+
+		header = append(header, &warning)
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of a Refer-Sub header into a slice of at most one ReferSub object.
+func parseReferSub(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var referSub base.ReferSub
+
+	paramsIdx := strings.Index(headerText, ";")
+	if paramsIdx == -1 {
+		paramsIdx = len(headerText)
+	}
+
+	switch value := strings.TrimSpace(headerText[:paramsIdx]); strings.ToLower(value) {
+	case "true":
+		referSub.Value = true
+	case "false":
+		referSub.Value = false
+	default:
+		err = fmt.Errorf("Refer-Sub value must be 'true' or 'false'; got '%s'", value)
 		return
 	}
 
-	headers = []base.SipHeader{&callId}
+	if paramsIdx < len(headerText) {
+		referSub.Params, _, err = parseParamsWithPolicy(headerText[paramsIdx:], ';', ';', 0, true, true, policy)
+		if err != nil {
+			return
+		}
+	} else {
+		referSub.Params = base.NewParams()
+	}
 
+	headers = []base.SipHeader{&referSub}
 	return
 }
 
-// Parse a string representation of a Via header, returning a slice of at most one ViaHeader.
-// Note that although Via headers may contain a comma-separated list, RFC 3261 makes it clear that
-// these should not be treated as separate logical Via headers, but as multiple values on a single
-// Via header.
-func parseViaHeader(headerName string, headerText string) (
+// Parse a string representation of a Content-Disposition header into a slice of at most one
+// ContentDispositionHeader object.
+func parseContentDisposition(headerName string, headerText string, policy DuplicateParamPolicy) (
 	headers []base.SipHeader, err error) {
-	sections := strings.Split(headerText, ",")
-	var via base.ViaHeader = base.ViaHeader{}
-	for _, section := range sections {
-		var hop base.ViaHop
-		parts := strings.Split(section, "/")
+	var contentDisposition base.ContentDispositionHeader
 
-		if len(parts) < 3 {
-			err = fmt.Errorf("not enough protocol parts in via header: '%s'",
-				parts)
+	paramsIdx := strings.Index(headerText, ";")
+	if paramsIdx == -1 {
+		paramsIdx = len(headerText)
+	}
+
+	contentDisposition.DispositionType = strings.TrimSpace(headerText[:paramsIdx])
+	if len(contentDisposition.DispositionType) == 0 {
+		err = fmt.Errorf("empty disposition type in Content-Disposition header '%s'", headerText)
+		return
+	}
+	for _, char := range contentDisposition.DispositionType {
+		if !isTokenChar(char) {
+			err = fmt.Errorf("invalid character '%c' in disposition type of Content-Disposition header '%s'",
+				char, headerText)
 			return
 		}
+	}
 
-		parts[2] = strings.Join(parts[2:], "/")
+	if paramsIdx < len(headerText) {
+		contentDisposition.Params, _, err = parseParamsWithPolicy(headerText[paramsIdx:],
+			';', ';', 0, true, true, policy)
+		if err != nil {
+			return
+		}
+	} else {
+		contentDisposition.Params = base.NewParams()
+	}
 
-		// The transport part ends when whitespace is reached, but may also start with
-		// whitespace.
-		// So the end of the transport part is the first whitespace char following the
-		// first non-whitespace char.
-		initialSpaces := len(parts[2]) - len(strings.TrimLeft(parts[2], c_ABNF_WS))
-		sentByIdx := strings.IndexAny(parts[2][initialSpaces:], c_ABNF_WS) + initialSpaces + 1
-		if sentByIdx == 0 {
-			err = fmt.Errorf("expected whitespace after sent-protocol part "+
-				"in via header '%s'", section)
+	headers = []base.SipHeader{&contentDisposition}
+	return
+}
+
+// Parse a string representation of a Replaces header into a slice of at most one ReplacesHeader object.
+// RFC 3891 requires both the to-tag and from-tag parameters, so we error if either is missing.
+func parseReplaces(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var replaces base.ReplacesHeader
+
+	paramsIdx := strings.Index(headerText, ";")
+	if paramsIdx == -1 {
+		err = fmt.Errorf("missing to-tag and from-tag in Replaces header '%s'", headerText)
+		return
+	}
+
+	replaces.CallId = base.CallId(strings.TrimSpace(headerText[:paramsIdx]))
+	if len(replaces.CallId) == 0 {
+		err = fmt.Errorf("empty Call-Id in Replaces header '%s'", headerText)
+		return
+	}
+	if strings.ContainsAny(string(replaces.CallId), c_ABNF_WS) {
+		err = fmt.Errorf("unexpected whitespace in Call-Id of Replaces header '%s'", headerText)
+		return
+	}
+
+	replaces.Params, _, err = parseParamsWithPolicy(headerText[paramsIdx:], ';', ';', 0, true, true, policy)
+	if err != nil {
+		return
+	}
+
+	for _, tag := range []string{"to-tag", "from-tag"} {
+		if value, ok := replaces.Params.Get(tag); !ok {
+			err = fmt.Errorf("missing %s in Replaces header '%s'", tag, headerText)
 			return
-		} else if sentByIdx == 1 {
-			err = fmt.Errorf("empty transport field in via header '%s'", section)
+		} else if _, isString := value.(base.String); !isString {
+			err = fmt.Errorf("%s has no value in Replaces header '%s'", tag, headerText)
 			return
 		}
+	}
 
-		hop.ProtocolName = strings.TrimSpace(parts[0])
-		hop.ProtocolVersion = strings.TrimSpace(parts[1])
-		hop.Transport = strings.TrimSpace(parts[2][:sentByIdx-1])
+	headers = []base.SipHeader{&replaces}
+	return
+}
 
-		if len(hop.ProtocolName) == 0 {
-			err = fmt.Errorf("no protocol name provided in via header '%s'", section)
-		} else if len(hop.ProtocolVersion) == 0 {
-			err = fmt.Errorf("no version provided in via header '%s'", section)
-		} else if len(hop.Transport) == 0 {
-			err = fmt.Errorf("no transport provided in via header '%s'", section)
-		}
-		if err != nil {
+// Parse a string representation of a P-Visited-Network-ID header (RFC 3455 s. 5.3), returning a slice of at most
+// one PVisitedNetworkID header.
+// Network identifiers may be quoted strings, which can themselves contain commas, so we take care to only
+// split the header on commas outside of quotes.
+func parsePVisitedNetworkID(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	var header base.PVisitedNetworkID = base.PVisitedNetworkID{}
+
+	for _, entry := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+		entry = strings.TrimSpace(entry)
+
+		if len(entry) == 0 {
+			err = fmt.Errorf("empty network identifier in P-Visited-Network-ID header")
 			return
 		}
 
-		viaBody := parts[2][sentByIdx:]
+		var network base.VisitedNetwork
+		var paramsIdx int
+		if entry[0] == '"' {
+			// The quoted string may contain backslash-escaped quotes, so look for the parameters outside it.
+			paramsIdx = findUnescaped(entry, ';', quotes_delim)
+			if paramsIdx == -1 {
+				paramsIdx = len(entry)
+			}
+			var ok bool
+			network.Network, ok = unquoteString(strings.TrimSpace(entry[:paramsIdx]))
+			if !ok {
+				err = fmt.Errorf("malformed quoted string in P-Visited-Network-ID entry '%s'", entry)
+				return
+			}
+			network.Quoted = true
+		} else {
+			paramsIdx = strings.Index(entry, ";")
+			if paramsIdx == -1 {
+				paramsIdx = len(entry)
+			}
+			network.Network = strings.TrimSpace(entry[:paramsIdx])
+			if strings.ContainsAny(network.Network, c_ABNF_WS) {
+				err = fmt.Errorf("unexpected whitespace in P-Visited-Network-ID entry '%s'", entry)
+				return
+			}
+		}
 
-		paramsIdx := strings.Index(viaBody, ";")
-		var host string
-		var port *uint16
-		if paramsIdx == -1 {
-			// There are no header parameters, so the rest of the Via body is part of the host[:post].
-			host, port, err = parseHostPort(viaBody)
-			hop.Host = host
-			hop.Port = port
+		rest := strings.TrimSpace(entry[paramsIdx:])
+		if len(rest) > 0 {
+			network.Params, _, err = parseParamsWithPolicy(rest, ';', ';', 0, true, true, policy)
 			if err != nil {
 				return
 			}
-			hop.Params = base.NewParams()
 		} else {
-			host, port, err = parseHostPort(viaBody[:paramsIdx])
+			network.Params = base.NewParams()
+		}
+
+		header = append(header, &network)
+	}
+
+	headers = []base.SipHeader{&header}
+	return
+}
+
+// Parse a string representation of an Alert-Info, Call-Info or Error-Info header, returning a slice of at most
+// one AlertInfoHeader, CallInfoHeader or ErrorInfoHeader respectively.
+// Each comma-separated entry is a URI in angle brackets, optionally followed by parameters.
+func parseInfoHeader(headerName string, headerText string, policy DuplicateParamPolicy) (
+	headers []base.SipHeader, err error) {
+	entries := make([]*base.InfoEntry, 0)
+
+	for _, entry := range base.SplitUnquoted(headerText, ',', quotes_delim, angles_delim) {
+		entry = strings.TrimSpace(entry)
+
+		if len(entry) == 0 || entry[0] != '<' {
+			err = fmt.Errorf("URI in %s entry '%s' must be enclosed in angle brackets", headerName, entry)
+			return
+		}
+		endOfUri := strings.Index(entry, ">")
+		if endOfUri == -1 {
+			err = fmt.Errorf("'<' without closing '>' in %s entry '%s'", headerName, entry)
+			return
+		}
+
+		var info base.InfoEntry
+		info.Address, err = parseUriWithPolicy(entry[1:endOfUri], policy)
+		if err != nil {
+			return
+		}
+		if _, ok := info.Address.(base.WildcardUri); ok {
+			err = fmt.Errorf("wildcard uri not permitted in %s entry '%s'", headerName, entry)
+			return
+		}
+
+		rest := strings.TrimSpace(entry[endOfUri+1:])
+		if len(rest) > 0 {
+			info.Params, _, err = parseParamsWithPolicy(rest, ';', ';', 0, true, true, policy)
 			if err != nil {
 				return
 			}
-			hop.Host = host
-			hop.Port = port
-
-			hop.Params, _, err = parseParams(viaBody[paramsIdx:],
-				';', ';', 0, true, true)
+		} else {
+			info.Params = base.NewParams()
 		}
-		via = append(via, &hop)
+
+		entries = append(entries, &info)
 	}
 
-	headers = []base.SipHeader{&via}
+	switch headerName {
+	case "alert-info":
+		header := base.AlertInfoHeader(entries)
+		headers = []base.SipHeader{&header}
+	case "call-info":
+		header := base.CallInfoHeader(entries)
+		headers = []base.SipHeader{&header}
+	default:
+		header := base.ErrorInfoHeader(entries)
+		headers = []base.SipHeader{&header}
+	}
 	return
 }
 
-// Parse a string representation of a Max-Forwards header into a slice of at most one MaxForwards header object.
-func parseMaxForwards(headerName string, headerText string) (
+// Parse a string representation of a P-Asserted-Identity header (RFC 3325), returning a slice of at most one
+// PAssertedIdentityHeader. The header may hold at most two identities, in which case one must be a SIP or SIPS
+// URI and the other a tel URI.
+func parsePAssertedIdentity(headerName string, headerText string, policy DuplicateParamPolicy) (
 	headers []base.SipHeader, err error) {
-	var maxForwards base.MaxForwards
-	var value uint64
-	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
-	maxForwards = base.MaxForwards(value)
+	var header base.PAssertedIdentityHeader = base.PAssertedIdentityHeader{}
 
-	headers = []base.SipHeader{&maxForwards}
+	var displayNames []base.MaybeString
+	var uris []base.Uri
+	var paramSets []base.Params
+	displayNames, uris, paramSets, err = parseAddressValuesWithPolicy(headerText, BareUriParamsToHeader, policy)
+	if err != nil {
+		return
+	}
+
+	if len(uris) > 2 {
+		err = fmt.Errorf("too many identities in P-Asserted-Identity header '%s': at most two are permitted",
+			headerText)
+		return
+	}
+
+	sipCount, telCount := 0, 0
+	for idx, uri := range uris {
+		switch uri.(type) {
+		case *base.SipUri:
+			sipCount++
+		case *base.TelUri:
+			telCount++
+		default:
+			err = fmt.Errorf("URI %s not valid in P-Asserted-Identity header. Must be SIP, SIPS or tel URI",
+				uri.String())
+			return
+		}
+		header = append(header, &base.NameAddr{displayNames[idx], uri, paramSets[idx]})
+	}
+
+	if sipCount > 1 || telCount > 1 {
+		err = fmt.Errorf("P-Asserted-Identity header '%s' may contain at most one SIP and one tel identity",
+			headerText)
+		return
+	}
+
+	headers = []base.SipHeader{&header}
 	return
 }
 
-// Parse a string representation of a Content-Length header into a slice of at most one ContentLength header object.
-func parseContentLength(headerName string, headerText string) (
+// Parse a string representation of an Accept-Contact or Reject-Contact header (RFC 3841), returning a slice of
+// at most one header. Each comma-separated entry is a '*' followed by feature parameters; feature tags may be
+// negated with a leading '!', and list-valued tags are quoted strings which may themselves contain commas.
+func parseCallerPrefs(headerName string, headerText string, policy DuplicateParamPolicy) (
 	headers []base.SipHeader, err error) {
-	var contentLength base.ContentLength
-	var value uint64
-	value, err = strconv.ParseUint(strings.TrimSpace(headerText), 10, 32)
-	contentLength = base.ContentLength(value)
+	entries := make([]*base.CallerPrefEntry, 0)
 
-	headers = []base.SipHeader{&contentLength}
+	for _, entry := range base.SplitUnquoted(headerText, ',', quotes_delim) {
+		entry = strings.TrimSpace(entry)
+
+		if len(entry) == 0 || entry[0] != '*' {
+			err = fmt.Errorf("caller preference entry '%s' in %s header must begin with '*'", entry, headerName)
+			return
+		}
+
+		var prefs base.CallerPrefEntry
+		rest := strings.TrimSpace(entry[1:])
+		if len(rest) > 0 {
+			prefs.Params, _, err = parseParamsWithPolicy(rest, ';', ';', 0, true, true, policy)
+			if err != nil {
+				return
+			}
+		} else {
+			prefs.Params = base.NewParams()
+		}
+
+		for _, key := range prefs.Params.Keys() {
+			if !strings.HasPrefix(key, "!") {
+				continue
+			}
+			if len(key) == 1 {
+				err = fmt.Errorf("negation of empty feature tag in caller preference entry '%s'", entry)
+				return
+			}
+			if value, _ := prefs.Params.Get(key); value != (base.NoString{}) {
+				err = fmt.Errorf("negated feature tag %s should not have a value in caller preference entry '%s'",
+					key, entry)
+				return
+			}
+		}
+
+		entries = append(entries, &prefs)
+	}
+
+	switch headerName {
+	case "accept-contact", "a":
+		header := base.AcceptContactHeader(entries)
+		headers = []base.SipHeader{&header}
+	default:
+		header := base.RejectContactHeader(entries)
+		headers = []base.SipHeader{&header}
+	}
 	return
 }
 
@@ -1017,37 +3524,32 @@ func parseContentLength(headerName string, headerText string) (
 func parseAddressValues(addresses string) (
 	displayNames []base.MaybeString, uris []base.Uri,
 	headerParams []base.Params, err error) {
+	return parseAddressValuesWithPolicy(addresses, BareUriParamsToHeader, LastDuplicateParam)
+}
 
-	prevIdx := 0
-	inBrackets := false
-	inQuotes := false
-
-	// Append a comma to simplify the parsing code; we split address sections
-	// on commas, so use a comma to signify the end of the final address section.
-	addresses = addresses + ","
-
-	for idx, char := range addresses {
-		if char == '<' && !inQuotes {
-			inBrackets = true
-		} else if char == '>' && !inQuotes {
-			inBrackets = false
-		} else if char == '"' {
-			inQuotes = !inQuotes
-		} else if !inQuotes && !inBrackets && char == ',' {
-			var displayName base.MaybeString
-			var uri base.Uri
-			var params base.Params
-			displayName, uri, params, err =
-				parseAddressValue(addresses[prevIdx:idx])
-			if err != nil {
-				return
-			}
-			prevIdx = idx + 1
+// As parseAddressValues, but parameters following URIs not in angle brackets are assigned according to
+// bareUriParams, and repeated parameter names are handled according to duplicateParams.
+func parseAddressValuesWithPolicy(addresses string,
+	bareUriParams BareUriParamPolicy, duplicateParams DuplicateParamPolicy) (
+	displayNames []base.MaybeString, uris []base.Uri,
+	headerParams []base.Params, err error) {
 
-			displayNames = append(displayNames, displayName)
-			uris = append(uris, uri)
-			headerParams = append(headerParams, params)
+	// The offset into the address list of the address currently being parsed.
+	offset := 0
+	for _, address := range base.SplitUnquoted(addresses, ',', quotes_delim, angles_delim) {
+		var displayName base.MaybeString
+		var uri base.Uri
+		var params base.Params
+		displayName, uri, params, err = parseAddressValueWithPolicy(address, bareUriParams, duplicateParams)
+		if err != nil {
+			err = parseErrorAt(err, "", addresses, offset)
+			return
 		}
+		offset += len(address) + 1
+
+		displayNames = append(displayNames, displayName)
+		uris = append(uris, uri)
+		headerParams = append(headerParams, params)
 	}
 
 	return
@@ -1059,21 +3561,54 @@ func parseAddressValues(addresses string) (
 //   - a parsed SipUri object
 //   - a map containing any header parameters present
 //   - the error object
+//
 // See RFC 3261 section 20.10 for details on parsing an address.
 // Note that this method will not accept a comma-separated list of addresses;
 // addresses in that form should be handled by parseAddressValues.
+// Errors are returned as *base.ParseErrors, whose offset is that of the part of the address which failed to parse.
+//
+// If the URI is not enclosed in angle brackets (the bare addr-spec form), it ends at the first semicolon, and any
+// parameters after it are header parameters, as RFC 3261 s. 20.10 requires; so 'sip:a@h;transport=tcp' gives a
+// 'transport' header parameter. A URI with parameters of its own must be enclosed in angle brackets.
 func parseAddressValue(addressText string) (
 	displayName base.MaybeString, uri base.Uri,
 	headerParams base.Params, err error) {
+	return parseAddressValueWithPolicy(addressText, BareUriParamsToHeader, LastDuplicateParam)
+}
+
+// Determines which parameters following a URI that is not enclosed in angle brackets belong to, e.g. whether
+// 'From: sip:a@h;transport=tcp' has a 'transport' header parameter or URI parameter.
+type BareUriParamPolicy int
+
+const (
+	// The parameters are header parameters, as RFC 3261 s. 20.10 requires.
+	BareUriParamsToHeader BareUriParamPolicy = iota
+
+	// The parameters are URI parameters, and the header has none.
+	BareUriParamsToUri
+)
+
+// As parseAddressValue, but parameters following a URI not in angle brackets are assigned according to
+// bareUriParams, and repeated parameter names are handled according to duplicateParams.
+func parseAddressValueWithPolicy(addressText string,
+	bareUriParams BareUriParamPolicy, duplicateParams DuplicateParamPolicy) (
+	displayName base.MaybeString, uri base.Uri,
+	headerParams base.Params, err error) {
 
 	headerParams = base.NewParams()
 
+	// Since addressText is only ever trimmed, the part being parsed always ends where the trimmed original does.
+	addressTextCopy := addressText
+	trimmedEnd := len(strings.TrimRightFunc(addressText, unicode.IsSpace))
+	defer func() {
+		err = parseErrorAt(err, "", addressTextCopy, trimmedEnd-len(addressText))
+	}()
+
 	if len(addressText) == 0 {
 		err = fmt.Errorf("address-type header has empty body")
 		return
 	}
 
-	addressTextCopy := addressText
 	addressText = strings.TrimSpace(addressText)
 
 	firstAngleBracket := findUnescaped(addressText, '<', quotes_delim)
@@ -1084,9 +3619,20 @@ func parseAddressValue(addressText string) (
 		// be a display name.
 		if addressText[0] == '"' {
 			// The display name is within quotations.
-			// So it is comprised of all text until the closing quote.
+			// So it is comprised of all text until the closing quote, which may contain
+			// backslash-escaped characters (RFC 3261 s. 25.1).
 			addressText = addressText[1:]
-			nextQuote := strings.Index(addressText, "\"")
+			var nameField bytes.Buffer
+			nextQuote := -1
+			for idx := 0; idx < len(addressText); idx++ {
+				if addressText[idx] == '\\' && idx+1 < len(addressText) {
+					idx++
+				} else if addressText[idx] == '"' {
+					nextQuote = idx
+					break
+				}
+				nameField.WriteByte(addressText[idx])
+			}
 
 			if nextQuote == -1 {
 				// Unclosed quotes - parse error.
@@ -1095,8 +3641,7 @@ func parseAddressValue(addressText string) (
 				return
 			}
 
-			nameField := addressText[:nextQuote]
-			displayName = base.String{nameField}
+			displayName = base.String{nameField.String()}
 			addressText = addressText[nextQuote+1:]
 		} else {
 			// The display name is unquoted, so it is comprised of
@@ -1113,6 +3658,10 @@ func parseAddressValue(addressText string) (
 
 	// Work out where the SIP URI starts and ends.
 	addressText = strings.TrimSpace(addressText)
+	if len(addressText) == 0 {
+		err = fmt.Errorf("no address in address line: %s", addressTextCopy)
+		return
+	}
 	var endOfUri int
 	var startOfParams int
 	if addressText[0] != '<' {
@@ -1127,7 +3676,7 @@ func parseAddressValue(addressText string) (
 		}
 
 		endOfUri = strings.Index(addressText, ";")
-		if endOfUri == -1 {
+		if endOfUri == -1 || bareUriParams == BareUriParamsToUri {
 			endOfUri = len(addressText)
 		}
 		startOfParams = endOfUri
@@ -1135,7 +3684,7 @@ func parseAddressValue(addressText string) (
 	} else {
 		addressText = addressText[1:]
 		endOfUri = strings.Index(addressText, ">")
-		if endOfUri == 0 {
+		if endOfUri == -1 {
 			err = fmt.Errorf("'<' without closing '>' in address %s",
 				addressTextCopy)
 			return
@@ -1145,7 +3694,7 @@ func parseAddressValue(addressText string) (
 	}
 
 	// Now parse the SIP URI.
-	uri, err = ParseUri(addressText[:endOfUri])
+	uri, err = parseUriWithPolicy(addressText[:endOfUri], duplicateParams)
 	if err != nil {
 		return
 	}
@@ -1156,7 +3705,38 @@ func parseAddressValue(addressText string) (
 
 	// Finally, parse any header parameters and then return.
 	addressText = addressText[startOfParams:]
-	headerParams, _, err = parseParams(addressText, ';', ';', ',', true, true)
+	headerParams, _, err = parseParamsWithPolicy(addressText, ';', ';', ',', true, true, duplicateParams)
+	return
+}
+
+// Parse a single part of a multipart body into its headers and content.
+// The headers are separated from the content by an empty line; a part with no headers starts with that empty line.
+func (p *parser) parseBodyPart(text string) (part base.BodyPart, err error) {
+	part.Headers = make([]base.SipHeader, 0)
+
+	var headerSection string
+	if strings.HasPrefix(text, "\r\n") {
+		part.Content = text[2:]
+	} else if endOfHeaders := strings.Index(text, "\r\n\r\n"); endOfHeaders != -1 {
+		headerSection, part.Content = text[:endOfHeaders], text[endOfHeaders+4:]
+	} else {
+		headerSection = text
+	}
+
+	lines := strings.Split(headerSection, "\r\n")
+	for len(lines) > 0 && lines[0] != "" {
+		headerText, consumed := getNextHeaderLine(lines)
+		lines = lines[consumed:]
+
+		var headers []base.SipHeader
+		headers, err = p.parseHeader(headerText)
+		if err != nil {
+			err = fmt.Errorf("invalid header '%s' in multipart body: %s", headerText, err.Error())
+			return
+		}
+		part.Headers = append(part.Headers, headers...)
+	}
+
 	return
 }
 
@@ -1177,68 +3757,65 @@ func getNextHeaderLine(contents []string) (headerText string, consumed int) {
 	buffer.WriteString(contents[0])
 
 	for consumed = 1; consumed < len(contents); consumed++ {
-		firstChar, _ := utf8.DecodeRuneInString(contents[consumed])
-		if !unicode.IsSpace(firstChar) {
+		// Only SP and HTAB mark a continuation line; other Unicode whitespace (e.g. a non-breaking space)
+		// is ordinary header content.
+		if len(contents[consumed]) == 0 {
 			break
-		} else if len(contents[consumed]) == 0 {
+		} else if strings.IndexByte(c_ABNF_WS, contents[consumed][0]) == -1 {
 			break
 		}
 
-		buffer.WriteString(" " + strings.TrimSpace(contents[consumed]))
+		appendFoldedLine(&buffer, contents[consumed])
 	}
 
 	headerText = buffer.String()
 	return
 }
 
-// A delimiter is any pair of characters used for quoting text (i.e. bulk escaping literals).
-type delimiter struct {
-	start uint8
-	end   uint8
+// Append a continuation line to the header text in the buffer. The line folding, together with any whitespace
+// either side of it, is equivalent to a single space (RFC 3261 s. 7.3.1), so that a folded header parses exactly
+// as it would have done had it been written on one line.
+func appendFoldedLine(buffer *bytes.Buffer, line string) {
+	buffer.Truncate(len(strings.TrimRight(buffer.String(), c_ABNF_WS)))
+	buffer.WriteString(" ")
+	buffer.WriteString(strings.TrimLeft(line, c_ABNF_WS))
 }
 
 // Define common quote characters needed in parsing.
-var quotes_delim = delimiter{'"', '"'}
-var angles_delim = delimiter{'<', '>'}
+var quotes_delim = base.Quotes
+var angles_delim = base.AngleBrackets
 
 // Find the first instance of the target in the given text which is not enclosed in any delimiters
 // from the list provided.
-func findUnescaped(text string, target uint8, delims ...delimiter) int {
-	return findAnyUnescaped(text, string(target), delims...)
+func findUnescaped(text string, target uint8, delims ...base.Delimiter) int {
+	return base.IndexAnyUnquoted(text, string(target), delims...)
 }
 
-// Find the first instance of any of the targets in the given text that are not enclosed in any delimiters
-// from the list provided.
-func findAnyUnescaped(text string, targets string, delims ...delimiter) int {
-	escaped := false
-	var endEscape uint8 = 0
-
-	endChars := make(map[uint8]uint8)
-	for _, delim := range delims {
-		endChars[delim.start] = delim.end
+// Return the contents of the given quoted-string (RFC 3261 s. 25.1), with its quotes removed and any
+// backslash-escaped characters unescaped. Returns ok=false if the text is not exactly one quoted-string.
+func unquoteString(text string) (contents string, ok bool) {
+	if len(text) < 2 || text[0] != '"' {
+		return "", false
 	}
 
-	for idx := 0; idx < len(text); idx++ {
-		if !escaped && strings.Contains(targets, string(text[idx])) {
-			return idx
-		}
-
-		if escaped {
-			escaped = (text[idx] != endEscape)
-			continue
-		} else {
-			endEscape, escaped = endChars[text[idx]]
+	var buffer bytes.Buffer
+	for idx := 1; idx < len(text); idx++ {
+		if text[idx] == '\\' && idx+1 < len(text) {
+			idx++
+		} else if text[idx] == '"' {
+			return buffer.String(), idx == len(text)-1
 		}
+		buffer.WriteByte(text[idx])
 	}
-
-	return -1
+	return "", false
 }
 
 // Splits the given string into sections, separated by one or more characters
 // from c_ABNF_WS.
 func splitByWhitespace(text string) []string {
 	var buffer bytes.Buffer
-	var inString bool = true
+	// Start as though after whitespace, so that leading whitespace does not give an empty first field.
+	var inString bool = false
 	result := make([]string, 0)
 
 	for _, char := range text {
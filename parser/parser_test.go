@@ -9,9 +9,13 @@ import (
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -140,6 +144,96 @@ func TestParams(t *testing.T) {
 	}, t)
 }
 
+// Test that ParseMessage, which uses the default LastDuplicateParam policy, keeps the last value of a repeated
+// parameter in a parsed message.
+func TestDuplicateParamsInMessage(t *testing.T) {
+	testsRun++
+	msg, err := ParseMessage([]byte("MESSAGE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK1;branch=z9hG4bK2\r\n" +
+		"To: <sip:bob@biloxi.com>;tag=a;tag=b\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	if err != nil {
+		t.Errorf("unexpected error parsing message with duplicate params: %s", err.Error())
+		return
+	}
+
+	branch, _ := msg.(*base.Request).ViaHops()[0].Params.Get("branch")
+	tag, _ := msg.Headers("To")[0].(*base.ToHeader).Params.Get("tag")
+	if branch != (base.String{"z9hG4bK2"}) || tag != (base.String{"b"}) {
+		t.Errorf("expected last duplicate params to be kept; got branch=%v, tag=%v", branch, tag)
+		return
+	}
+	testsPassed++
+}
+
+// Test that repeated header and URI parameters are handled according to the parser's DuplicateParamPolicy.
+func TestDuplicateParamPolicy(t *testing.T) {
+	tests := []struct {
+		policy DuplicateParamPolicy
+		value  string
+	}{
+		{LastDuplicateParam, "b"},
+		{FirstDuplicateParam, "a"},
+		{StrictDuplicateParams, ""},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+		var failedHeaders []string
+
+		p := NewParser(output, errs, false)
+		p.SetDuplicateParamPolicy(test.policy)
+		p.SetOnHeaderError(func(headerText string, err error) {
+			failedHeaders = append(failedHeaders, headerText)
+		})
+		p.Write([]byte("MESSAGE sip:bob@biloxi.com SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP pc33.atlanta.com;foo=a;foo=b\r\n" +
+			"To: <sip:bob@biloxi.com>;foo=a;foo=b\r\n" +
+			"Contact: <sip:alice@pc33.atlanta.com;foo=a;foo=b>\r\n" +
+			"Content-Type: text/plain;foo=a;foo=b\r\n" +
+			"Content-Length: 0\r\n\r\n"))
+
+		var msg base.SipMessage
+		select {
+		case msg = <-output:
+		case err := <-errs:
+			t.Errorf("policy %d: unexpected error: %s", test.policy, err.Error())
+		case <-time.After(time.Second * 1):
+			t.Errorf("policy %d: timeout waiting for parser", test.policy)
+		}
+		p.Stop()
+		if msg == nil {
+			continue
+		}
+
+		if test.policy == StrictDuplicateParams {
+			if len(failedHeaders) != 4 || len(msg.Headers("Via")) != 0 || len(msg.Headers("To")) != 0 ||
+				len(msg.Headers("Contact")) != 0 || len(msg.Headers("Content-Type")) != 0 {
+				t.Errorf("policy %d: expected all headers with duplicate params to fail; failed %v",
+					test.policy, failedHeaders)
+			} else {
+				testsPassed++
+			}
+			continue
+		}
+
+		expected := base.String{test.value}
+		via, _ := msg.(*base.Request).ViaHops()[0].Params.Get("foo")
+		to, _ := msg.Headers("To")[0].(*base.ToHeader).Params.Get("foo")
+		contact, _ := msg.Headers("Contact")[0].(*base.ContactHeader).Address.(*base.SipUri).UriParams.Get("foo")
+		contentType, _ := msg.Headers("Content-Type")[0].(*base.ContentType).Params.Get("foo")
+		if len(failedHeaders) != 0 || via != expected || to != expected || contact != expected ||
+			contentType != expected {
+			t.Errorf("policy %d: expected foo=%s; got %v, %v, %v and %v (failed headers %v)",
+				test.policy, test.value, via, to, contact, contentType, failedHeaders)
+		} else {
+			testsPassed++
+		}
+	}
+}
+
 func TestSipUris(t *testing.T) {
 	doTests([]test{
 		test{sipUriInput("sip:bob@example.com"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "example.com", UriParams: noParams, Headers: noParams}}},
@@ -149,10 +243,26 @@ func TestSipUris(t *testing.T) {
 			Host: "example.com", UriParams: noParams, Headers: noParams}}},
 		test{sipUriInput("sips:bob@example.com"), &sipUriResult{pass, base.SipUri{IsEncrypted: true, User: base.String{"bob"}, Password: base.NoString{}, Host: "example.com", UriParams: noParams, Headers: noParams}}},
 		test{sipUriInput("sip:example.com"), &sipUriResult{pass, base.SipUri{User: base.NoString{}, Password: base.NoString{}, Host: "example.com", UriParams: noParams, Headers: noParams}}},
+		test{sipUriInput("sip:a%40b%3Bc:p%3Aw@example.com"), &sipUriResult{pass, base.SipUri{User: base.String{"a@b;c"}, Password: base.String{"p:w"}, Host: "example.com", UriParams: noParams, Headers: noParams}}},
+		test{sipUriInput("sip:bob%4@example.com"), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput("sip:example.com;x=a%3Bb?subject=fish%20%26%20chips"), &sipUriResult{pass, base.SipUri{User: base.NoString{}, Password: base.NoString{}, Host: "example.com",
+			UriParams: base.NewParams().Add("x", base.String{"a;b"}), Headers: base.NewParams().Add("subject", base.String{"fish & chips"})}}},
+		test{sipUriInput("sip:example.com;x=a%3"), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput(""), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput("s"), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput("si"), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput("sip"), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput("sips"), &sipUriResult{fail, base.SipUri{}}},
 		test{sipUriInput("example.com"), &sipUriResult{fail, base.SipUri{}}},
 		test{sipUriInput("bob@example.com"), &sipUriResult{fail, base.SipUri{}}},
 		test{sipUriInput("sip:bob@example.com:5060"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "example.com", Port: &ui16_5060, UriParams: noParams, Headers: noParams}}},
 		test{sipUriInput("sip:bob@88.88.88.88:5060"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "88.88.88.88", Port: &ui16_5060, UriParams: noParams, Headers: noParams}}},
+		test{sipUriInput("sip:bob@[2001:db8::1]"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "2001:db8::1", UriParams: noParams, Headers: noParams}}},
+		test{sipUriInput("sip:bob:Hunter2@[2001:db8::1]:5060"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.String{"Hunter2"}, Host: "2001:db8::1", Port: &ui16_5060, UriParams: noParams, Headers: noParams}}},
+		test{sipUriInput("sip:[::1]:5060;transport=tcp"), &sipUriResult{pass, base.SipUri{User: base.NoString{}, Password: base.NoString{}, Host: "::1", Port: &ui16_5060, UriParams: base.NewParams().Add("transport", base.String{"tcp"}), Headers: noParams}}},
+		test{sipUriInput("sip:bob@[2001:db8::1"), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput("sip:bob@[2001:db8::1]5060"), &sipUriResult{fail, base.SipUri{}}},
+		test{sipUriInput("sip:bob@[example.com]"), &sipUriResult{fail, base.SipUri{}}},
 		test{sipUriInput("sip:bob:Hunter2@example.com:5060"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.String{"Hunter2"},
 			Host: "example.com", Port: &ui16_5060, UriParams: noParams, Headers: noParams}}},
 		test{sipUriInput("sip:bob@example.com:5"), &sipUriResult{pass, base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "example.com", Port: &ui16_5, UriParams: noParams, Headers: noParams}}},
@@ -220,6 +330,89 @@ func TestSipUris(t *testing.T) {
 	}, t)
 }
 
+// Test SIP URI comparison against the examples of equivalent and non-equivalent URIs in RFC 3261 s. 19.1.4.
+func TestSipUriEquivalence(t *testing.T) {
+	tests := []struct {
+		a          string
+		b          string
+		equivalent bool
+	}{
+		{"sip:%61lice@atlanta.com;transport=TCP", "sip:alice@AtLanTa.CoM;Transport=tcp", true},
+		{"sip:carol@chicago.com", "sip:carol@chicago.com;newparam=5", true},
+		{"sip:carol@chicago.com;security=on", "sip:carol@chicago.com;newparam=5", true},
+		{"sip:biloxi.com;transport=tcp;method=REGISTER?to=sip:bob%40biloxi.com",
+			"sip:biloxi.com;method=REGISTER;transport=tcp?to=sip:bob%40biloxi.com", true},
+		{"sip:alice@atlanta.com?subject=project%20x&priority=urgent",
+			"sip:alice@atlanta.com?priority=urgent&subject=project%20x", true},
+		{"SIP:ALICE@AtLanTa.CoM;Transport=udp", "sip:alice@AtLanTa.CoM;Transport=UDP", false},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com:5060", false},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com;transport=udp", false},
+		{"sip:bob@biloxi.com", "sip:bob@biloxi.com:6000;transport=tcp", false},
+		{"sip:carol@chicago.com", "sip:carol@chicago.com?Subject=next%20meeting", false},
+		{"sip:bob@phone21.boxesbybob.com", "sip:bob@192.0.2.4", false},
+		{"sip:bob@biloxi.com", "sips:bob@biloxi.com", false},
+		{"sip:bob@biloxi.com;maddr=239.255.255.1", "sip:bob@biloxi.com", false},
+		{"sip:bob@biloxi.com;ttl=15", "sip:bob@biloxi.com;ttl=16", false},
+		{"sip:bob:secret@biloxi.com", "sip:bob:Secret@biloxi.com", false},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		a, err := ParseSipUri(test.a)
+		if err != nil {
+			t.Errorf("failed to parse %s: %s", test.a, err.Error())
+			continue
+		}
+		b, err := ParseSipUri(test.b)
+		if err != nil {
+			t.Errorf("failed to parse %s: %s", test.b, err.Error())
+			continue
+		}
+
+		if a.EqualsRFC(&b) != test.equivalent || b.EqualsRFC(&a) != test.equivalent {
+			t.Errorf("expected equivalence of %s and %s to be %t", test.a, test.b, test.equivalent)
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+func TestTelUris(t *testing.T) {
+	phoneContext := base.NewParams().Add("phone-context", base.String{"example.com"})
+	ext := base.NewParams().Add("ext", base.String{"1234"})
+	doTests([]test{
+		test{uriInput("tel:+14085551212"), &uriResult{pass, &base.TelUri{"+14085551212", noParams}}},
+		test{uriInput("TEL:+1-408-555-1212"), &uriResult{pass, &base.TelUri{"+1-408-555-1212", noParams}}},
+		test{uriInput("tel:+1.408.555.1212"), &uriResult{pass, &base.TelUri{"+14085551212", noParams}}},
+		test{uriInput("tel:+1-408-555-1212;ext=1234"), &uriResult{pass, &base.TelUri{"+1-408-555-1212", ext}}},
+		test{uriInput("tel:7042;phone-context=example.com"), &uriResult{pass, &base.TelUri{"7042", phoneContext}}},
+		test{uriInput("tel:*69;phone-context=example.com"), &uriResult{pass, &base.TelUri{"*69", phoneContext}}},
+		test{uriInput("tel:7042"), &uriResult{fail, &base.TelUri{}}},
+		test{uriInput("tel:+1408x5551212"), &uriResult{fail, &base.TelUri{}}},
+		test{uriInput("tel:+"), &uriResult{fail, &base.TelUri{}}},
+		test{uriInput("tel:"), &uriResult{fail, &base.TelUri{}}},
+		test{uriInput("tel:;phone-context=example.com"), &uriResult{fail, &base.TelUri{}}},
+	}, t)
+}
+
+func TestAbsoluteUris(t *testing.T) {
+	doTests([]test{
+		test{uriInput("http://www.example.com/sounds/moo.wav"),
+			&uriResult{pass, &base.AbsoluteUri{"http", "//www.example.com/sounds/moo.wav"}}},
+		test{uriInput("HTTPS://www.example.com/alice/photo.jpg"),
+			&uriResult{pass, &base.AbsoluteUri{"https", "//www.example.com/alice/photo.jpg"}}},
+		test{uriInput("http://example.com/x"), &uriResult{pass, &base.AbsoluteUri{"http", "//example.com/x"}}},
+		test{uriInput("mailto:foo@bar"), &uriResult{pass, &base.AbsoluteUri{"mailto", "foo@bar"}}},
+		test{uriInput("mailto:alice@atlanta.com"), &uriResult{pass, &base.AbsoluteUri{"mailto", "alice@atlanta.com"}}},
+		test{uriInput("urn:service:sos"), &uriResult{pass, &base.AbsoluteUri{"urn", "service:sos"}}},
+		test{uriInput("http:"), &uriResult{fail, &base.AbsoluteUri{}}},
+		test{uriInput("1http://www.example.com"), &uriResult{fail, &base.AbsoluteUri{}}},
+		test{uriInput("ht tp://www.example.com"), &uriResult{fail, &base.AbsoluteUri{}}},
+		test{uriInput("http://www.example.com/a b"), &uriResult{fail, &base.AbsoluteUri{}}},
+		test{uriInput("www.example.com"), &uriResult{fail, &base.AbsoluteUri{}}},
+	}, t)
+}
+
 func TestHostPort(t *testing.T) {
 	doTests([]test{
 		test{hostPortInput("example.com"), &hostPortResult{pass, "example.com", nil}},
@@ -306,6 +499,12 @@ func TestToHeaders(t *testing.T) {
 		test{toHeaderInput("To: foo bar"), &toHeaderResult{fail,
 			&base.ToHeader{}}},
 
+		test{toHeaderInput("To: <sip:alice@wonderland.com"), &toHeaderResult{fail,
+			&base.ToHeader{}}},
+
+		test{toHeaderInput("To: \"Alice\" <sip:alice@wonderland.com"), &toHeaderResult{fail,
+			&base.ToHeader{}}},
+
 		test{toHeaderInput("To: \"Alice\" sip:alice@wonderland.com"), &toHeaderResult{fail,
 			&base.ToHeader{}}},
 
@@ -837,6 +1036,160 @@ func TestContactHeaders(t *testing.T) {
 	}, t)
 }
 
+// Test reading GRUUs (RFC 5627) from Contact headers, as returned by a registrar, and that they survive a round trip.
+func TestGruuContacts(t *testing.T) {
+	testsRun++
+	header := "Contact: <sip:callee@192.0.2.1>;pub-gruu=\"sip:callee@example.com;gr=urn:uuid:f81d4fae\"" +
+		";temp-gruu=\"sip:tgruu.7hs==jd7vnzga5w7fajsc7-ajd6fabz0f8g5@example.com;gr\";expires=3600"
+
+	headers, err := parseHeader(header)
+	if err != nil {
+		t.Fatalf("unexpected error parsing GRUU contact: %s", err.Error())
+	}
+	contact := headers[0].(*base.ContactHeader)
+	if pub := contact.PubGRUU(); pub != (base.String{"sip:callee@example.com;gr=urn:uuid:f81d4fae"}) {
+		t.Errorf("unexpected pub-gruu: %v", pub)
+		return
+	}
+	if temp := contact.TempGRUU(); temp != (base.String{"sip:tgruu.7hs==jd7vnzga5w7fajsc7-ajd6fabz0f8g5@example.com;gr"}) {
+		t.Errorf("unexpected temp-gruu: %v", temp)
+		return
+	}
+	if gr := contact.GRUU(); gr != (base.NoString{}) {
+		t.Errorf("expected registered contact not to be a GRUU itself; got gr=%v", gr)
+		return
+	}
+
+	reparsed, err := parseHeader(contact.String())
+	if err != nil {
+		t.Errorf("unexpected error reparsing GRUU contact '%s': %s", contact.String(), err.Error())
+		return
+	} else if pub := reparsed[0].(*base.ContactHeader).PubGRUU(); pub != contact.PubGRUU() {
+		t.Errorf("pub-gruu changed on round trip through '%s': got %v", contact.String(), pub)
+		return
+	}
+
+	// A UA then uses the GRUUs as its own Contact addresses.
+	for _, test := range []struct {
+		header string
+		gr     base.MaybeString
+	}{
+		{"Contact: <sip:callee@example.com;gr=urn:uuid:f81d4fae>", base.String{"urn:uuid:f81d4fae"}},
+		{"Contact: <sip:tgruu.7hs@example.com;gr>", base.String{""}},
+		{"Contact: <sip:callee@192.0.2.1>;expires=3600", base.NoString{}},
+		{"Contact: *", base.NoString{}},
+	} {
+		headers, err := parseHeader(test.header)
+		if err != nil {
+			t.Errorf("unexpected error parsing '%s': %s", test.header, err.Error())
+			return
+		}
+		if gr := headers[0].(*base.ContactHeader).GRUU(); gr != test.gr {
+			t.Errorf("unexpected gr for '%s': expected %v, got %v", test.header, test.gr, gr)
+			return
+		}
+	}
+	testsPassed++
+}
+
+// Test that parameters after a URI without angle brackets are header parameters by default, as RFC 3261 requires,
+// but can be bound to the URI instead.
+func TestBareUriParams(t *testing.T) {
+	tests := []struct {
+		header      string
+		policy      BareUriParamPolicy
+		uri         string
+		headerParam string
+	}{
+		{"From: sip:alice@atlanta.com;tag=1928301774", BareUriParamsToHeader, "sip:alice@atlanta.com", "tag=1928301774"},
+		{"From: sip:alice@atlanta.com;tag=1928301774", BareUriParamsToUri, "sip:alice@atlanta.com;tag=1928301774", ""},
+		{"Contact: sip:bob@192.0.2.4;transport=tcp", BareUriParamsToHeader, "sip:bob@192.0.2.4", "transport=tcp"},
+		{"Contact: sip:bob@192.0.2.4;transport=tcp", BareUriParamsToUri, "sip:bob@192.0.2.4;transport=tcp", ""},
+		{"m: sip:bob@192.0.2.4;transport=tcp", BareUriParamsToUri, "sip:bob@192.0.2.4;transport=tcp", ""},
+		{"Contact: sip:bob@192.0.2.4", BareUriParamsToUri, "sip:bob@192.0.2.4", ""},
+		{"Contact: <sip:bob@192.0.2.4;transport=tcp>;expires=60", BareUriParamsToHeader, "sip:bob@192.0.2.4;transport=tcp", "expires=60"},
+		{"Contact: <sip:bob@192.0.2.4;transport=tcp>;expires=60", BareUriParamsToUri, "sip:bob@192.0.2.4;transport=tcp", "expires=60"},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		p := NewParser(make(chan base.SipMessage), make(chan error), false)
+		p.SetBareUriParams(test.policy)
+		headers, err := p.(*parser).parseHeader(test.header)
+		p.Stop()
+
+		if err != nil || len(headers) != 1 {
+			t.Errorf("unexpected result parsing '%s' with policy %d: %v, %v", test.header, test.policy, headers, err)
+			continue
+		}
+
+		var uri base.Uri
+		var params base.Params
+		switch header := headers[0].(type) {
+		case *base.FromHeader:
+			uri, params = header.Address, header.Params
+		case *base.ContactHeader:
+			uri, params = header.Address, header.Params
+		}
+		if uri.String() != test.uri || params.ToString(';') != test.headerParam {
+			t.Errorf("unexpected result parsing '%s' with policy %d: expected URI %s and params '%s', got %s and '%s'",
+				test.header, test.policy, test.uri, test.headerParam, uri.String(), params.ToString(';'))
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+func TestReferHeaders(t *testing.T) {
+	replaces := base.NewParams().Add("Replaces", base.String{"12345@192.168.118.3;to-tag=12345;from-tag=5FFE-3994"})
+	transportTcp := base.NewParams().Add("transport", base.String{"tcp"})
+	cidEqFoo := base.NewParams().Add("cid", base.String{"foo"})
+	doTests([]test{
+		test{referInput("Refer-To: <sip:bob@biloxi.com>"), &referResult{pass, &base.ReferToHeader{
+			base.NoString{},
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+			noParams}}},
+		test{referInput("r: sip:bob@biloxi.com"), &referResult{pass, &base.ReferToHeader{
+			base.NoString{},
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+			noParams}}},
+		test{referInput("Refer-To: <sip:bob@biloxi.com?Replaces=12345%40192.168.118.3%3Bto-tag%3D12345%3Bfrom-tag%3D5FFE-3994>"), &referResult{pass, &base.ReferToHeader{
+			base.NoString{},
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, replaces},
+			noParams}}},
+		test{referInput("Refer-To: \"Bob\" <sip:bob@biloxi.com;transport=tcp?Replaces=12345%40192.168.118.3%3Bto-tag%3D12345%3Bfrom-tag%3D5FFE-3994>"), &referResult{pass, &base.ReferToHeader{
+			base.String{"Bob"},
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, transportTcp, replaces},
+			noParams}}},
+		test{referInput("Refer-To: <sip:bob@biloxi.com>, <sip:carol@chicago.com>"), &referResult{fail, nil}},
+		test{referInput("Refer-To: *"), &referResult{fail, nil}},
+		test{referInput("Referred-By: <sip:alice@atlanta.com>"), &referResult{pass, &base.ReferredByHeader{
+			base.NoString{},
+			&base.SipUri{false, base.String{"alice"}, base.NoString{}, "atlanta.com", nil, noParams, noParams},
+			noParams}}},
+		test{referInput("b: \"Alice\" <sip:alice@atlanta.com>;cid=foo"), &referResult{pass, &base.ReferredByHeader{
+			base.String{"Alice"},
+			&base.SipUri{false, base.String{"alice"}, base.NoString{}, "atlanta.com", nil, noParams, noParams},
+			cidEqFoo}}},
+		test{referInput("Referred-By: <sip:alice@atlanta.com>, <sip:carol@chicago.com>"), &referResult{fail, nil}},
+	}, t)
+}
+
+func TestRouteHeaders(t *testing.T) {
+	lr := base.NewParams().Add("lr", base.NoString{})
+	doTests([]test{
+		test{routeInput("Record-Route: <sip:p2.biloxi.com;lr>, <sip:p1.atlanta.com;lr>"), &routeResult{pass, []base.SipHeader{
+			&base.RecordRouteHeader{base.NoString{},
+				&base.SipUri{false, base.NoString{}, base.NoString{}, "p2.biloxi.com", nil, lr, noParams}, noParams},
+			&base.RecordRouteHeader{base.NoString{},
+				&base.SipUri{false, base.NoString{}, base.NoString{}, "p1.atlanta.com", nil, lr, noParams}, noParams}}}},
+		test{routeInput("Route: <sip:p1.atlanta.com;lr>"), &routeResult{pass, []base.SipHeader{
+			&base.RouteHeader{base.NoString{},
+				&base.SipUri{false, base.NoString{}, base.NoString{}, "p1.atlanta.com", nil, lr, noParams}, noParams}}}},
+		test{routeInput("Route: *"), &routeResult{fail, nil}},
+	}, t)
+}
+
 func TestSplitByWS(t *testing.T) {
 	doTests([]test{
 		test{splitByWSInput("Hello world"), splitByWSResult([]string{"Hello", "world"})},
@@ -850,9 +1203,40 @@ func TestSplitByWS(t *testing.T) {
 		test{splitByWSInput("Hello\twonderful\tworld"), splitByWSResult([]string{"Hello", "wonderful", "world"})},
 		test{splitByWSInput("Hello   wonderful\tworld"), splitByWSResult([]string{"Hello", "wonderful", "world"})},
 		test{splitByWSInput("Hello   wonderful  world"), splitByWSResult([]string{"Hello", "wonderful", "world"})},
+		test{splitByWSInput("\tHello world "), splitByWSResult([]string{"Hello", "world"})},
+		test{splitByWSInput(" \t "), splitByWSResult([]string{})},
 	}, t)
 }
 
+func TestSplitUnquoted(t *testing.T) {
+	tests := []struct {
+		text     string
+		delims   []base.Delimiter
+		expected []string
+	}{
+		{"a,b,c", nil, []string{"a", "b", "c"}},
+		{"abc", nil, []string{"abc"}},
+		{"", nil, []string{""}},
+		{"a,,b,", nil, []string{"a", "", "b", ""}},
+		{`a, "b, c", d`, []base.Delimiter{quotes_delim}, []string{"a", ` "b, c"`, " d"}},
+		{`"say \"hi, there\"", b`, []base.Delimiter{quotes_delim}, []string{`"say \"hi, there\""`, " b"}},
+		{"<sip:a@b;x=1,2>, <sip:c@d>", []base.Delimiter{angles_delim}, []string{"<sip:a@b;x=1,2>", " <sip:c@d>"}},
+		{"<sip:a@b;x=1,2>, <sip:c@d>", []base.Delimiter{quotes_delim}, []string{"<sip:a@b;x=1", "2>", " <sip:c@d>"}},
+		{`"A, B" <sip:a@b>, "<C, D>" <sip:c@d>`, []base.Delimiter{quotes_delim, angles_delim},
+			[]string{`"A, B" <sip:a@b>`, ` "<C, D>" <sip:c@d>`}},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		result := base.SplitUnquoted(test.text, ',', test.delims...)
+		if fmt.Sprintf("%q", result) != fmt.Sprintf("%q", test.expected) {
+			t.Errorf("unexpected result splitting '%s': expected %q, got %q", test.text, test.expected, result)
+		} else {
+			testsPassed++
+		}
+	}
+}
+
 func TestCSeqs(t *testing.T) {
 	doTests([]test{
 		test{cSeqInput("CSeq: 1 INVITE"), &cSeqResult{pass, &base.CSeq{1, "INVITE"}}},
@@ -880,6 +1264,276 @@ func TestCSeqs(t *testing.T) {
 	}, t)
 }
 
+func TestRSeqs(t *testing.T) {
+	doTests([]test{
+		test{rSeqInput("RSeq: 988789"), &rSeqResult{pass, base.RSeq(988789)}},
+		test{rSeqInput("RSeq :\t1"), &rSeqResult{pass, base.RSeq(1)}},
+		test{rSeqInput("RSeq: 2147483647"), &rSeqResult{pass, base.RSeq(2147483647)}},
+		test{rSeqInput("RSeq: 2147483648"), &rSeqResult{fail, base.RSeq(0)}},
+		test{rSeqInput("RSeq: 0"), &rSeqResult{fail, base.RSeq(0)}},
+		test{rSeqInput("RSeq: -1"), &rSeqResult{fail, base.RSeq(0)}},
+		test{rSeqInput("RSeq: foo"), &rSeqResult{fail, base.RSeq(0)}},
+		test{rSeqInput("RSeq:"), &rSeqResult{fail, base.RSeq(0)}},
+		test{rSeqInput("RSeq: 1 2"), &rSeqResult{fail, base.RSeq(0)}},
+	}, t)
+}
+
+func TestSessionExpires(t *testing.T) {
+	refresherUac := base.NewParams().Add("refresher", base.String{"uac"})
+	refresherUas := base.NewParams().Add("refresher", base.String{"uas"})
+	doTests([]test{
+		test{sessionExpiresInput("Session-Expires: 1800;refresher=uac"),
+			&sessionExpiresResult{pass, &base.SessionExpiresHeader{1800, refresherUac}}},
+		test{sessionExpiresInput("x: 4000;refresher=uas"),
+			&sessionExpiresResult{pass, &base.SessionExpiresHeader{4000, refresherUas}}},
+		test{sessionExpiresInput("Session-Expires: 90"),
+			&sessionExpiresResult{pass, &base.SessionExpiresHeader{90, noParams}}},
+		test{sessionExpiresInput("Session-Expires:\t1800 "),
+			&sessionExpiresResult{pass, &base.SessionExpiresHeader{1800, noParams}}},
+		test{sessionExpiresInput("Session-Expires: 1800;refresher=proxy"),
+			&sessionExpiresResult{fail, &base.SessionExpiresHeader{}}},
+		test{sessionExpiresInput("Session-Expires: 1800;refresher"),
+			&sessionExpiresResult{fail, &base.SessionExpiresHeader{}}},
+		test{sessionExpiresInput("Session-Expires: soon"), &sessionExpiresResult{fail, &base.SessionExpiresHeader{}}},
+		test{sessionExpiresInput("Session-Expires: -1"), &sessionExpiresResult{fail, &base.SessionExpiresHeader{}}},
+		test{sessionExpiresInput("Session-Expires:"), &sessionExpiresResult{fail, &base.SessionExpiresHeader{}}},
+	}, t)
+}
+
+func TestMinSEs(t *testing.T) {
+	doTests([]test{
+		test{minSEInput("Min-SE: 90"), &minSEResult{pass, base.MinSE(90)}},
+		test{minSEInput("Min-SE :\t3600 "), &minSEResult{pass, base.MinSE(3600)}},
+		test{minSEInput("Min-SE: -90"), &minSEResult{fail, base.MinSE(0)}},
+		test{minSEInput("Min-SE: ninety"), &minSEResult{fail, base.MinSE(0)}},
+		test{minSEInput("Min-SE:"), &minSEResult{fail, base.MinSE(0)}},
+	}, t)
+}
+
+func TestTimestamps(t *testing.T) {
+	delay := 0.5
+	doTests([]test{
+		test{timestampInput("Timestamp: 54"), &timestampResult{pass, &base.TimestampHeader{54, nil}}},
+		test{timestampInput("Timestamp: 54.21"), &timestampResult{pass, &base.TimestampHeader{54.21, nil}}},
+		test{timestampInput("Timestamp: 54.21 0.5"), &timestampResult{pass, &base.TimestampHeader{54.21, &delay}}},
+		test{timestampInput("Timestamp :\t54.21\t 0.5 "), &timestampResult{pass, &base.TimestampHeader{54.21, &delay}}},
+		test{timestampInput("Timestamp: -54"), &timestampResult{fail, &base.TimestampHeader{}}},
+		test{timestampInput("Timestamp: 5e4"), &timestampResult{fail, &base.TimestampHeader{}}},
+		test{timestampInput("Timestamp: .5"), &timestampResult{fail, &base.TimestampHeader{}}},
+		test{timestampInput("Timestamp: 54 0.5 1"), &timestampResult{fail, &base.TimestampHeader{}}},
+		test{timestampInput("Timestamp:"), &timestampResult{fail, &base.TimestampHeader{}}},
+	}, t)
+}
+
+// Test the single-valued informational headers: Organization, Subject, Priority, Reply-To, SIP-ETag and SIP-If-Match.
+func TestInformationalHeaders(t *testing.T) {
+	tests := []struct {
+		input    string
+		success  bool
+		expected base.SipHeader
+	}{
+		{"Organization: Boxes by Bob", true, base.OrganizationHeader("Boxes by Bob")},
+		{"Organization:", true, base.OrganizationHeader("")},
+		{"Subject: Need more boxes", true, base.SubjectHeader("Need more boxes")},
+		{"s: Tech Support", true, base.SubjectHeader("Tech Support")},
+		{"Priority: emergency", true, base.PriorityEmergency},
+		{"Priority: urgent", true, base.PriorityUrgent},
+		{"Priority: normal", true, base.PriorityNormal},
+		{"Priority: non-urgent", true, base.PriorityNonUrgent},
+		{"Priority: x-extreme", true, base.PriorityHeader("x-extreme")},
+		{"Priority: very urgent", false, nil},
+		{"Priority:", false, nil},
+		{"Reply-To: Bob <sip:bob@biloxi.com>", true,
+			&base.ReplyToHeader{base.String{"Bob"}, &base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams}, noParams}},
+		{"Reply-To: <sip:bob@biloxi.com>;purpose=info", true,
+			&base.ReplyToHeader{base.NoString{}, &base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "biloxi.com", UriParams: noParams, Headers: noParams},
+				base.NewParams().Add("purpose", base.String{"info"})}},
+		{"Reply-To: <sip:bob@biloxi.com>, <sip:alice@atlanta.com>", false, nil},
+		{"Reply-To: *", false, nil},
+		{"SIP-ETag: dx200xyz", true, base.SIPETagHeader("dx200xyz")},
+		{"sip-etag:\tkwj449x ", true, base.SIPETagHeader("kwj449x")},
+		{"SIP-ETag: dx200 xyz", false, nil},
+		{"SIP-ETag:", false, nil},
+		{"SIP-If-Match: dx200xyz", true, base.SIPIfMatchHeader("dx200xyz")},
+		{"SIP-If-Match: dx200\txyz", false, nil},
+		{"SIP-If-Match: ", false, nil},
+		{"WWW-Authenticate: Digest realm=\"atlanta.com\", qop=\"auth,auth-int\", nonce=\"84a4cc6f\", stale=FALSE", true,
+			&base.AuthHeader{"WWW-Authenticate", "Digest", base.NewParams().Add("realm", base.String{"atlanta.com"}).
+				Add("qop", base.String{"auth,auth-int"}).Add("nonce", base.String{"84a4cc6f"}).
+				Add("stale", base.String{"FALSE"})}},
+		{"Proxy-Authorization: Digest username=\"alice\",qop=auth", true,
+			&base.AuthHeader{"Proxy-Authorization", "Digest", base.NewParams().Add("username", base.String{"alice"}).
+				Add("qop", base.String{"auth"})}},
+		{"Authorization: Basic", true, &base.AuthHeader{"Authorization", "Basic", base.NewParams()}},
+		{"Authorization: Digest realm=\"atlanta.com", false, nil},
+		{"Authorization:", false, nil},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		headers, err := parseHeader(test.input)
+		if !test.success {
+			if err == nil {
+				t.Errorf("expected error parsing %q; got %v", test.input, headers)
+			} else {
+				testsPassed++
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", test.input, err.Error())
+		} else if len(headers) != 1 || headers[0].String() != test.expected.String() ||
+			headers[0].Name() != test.expected.Name() {
+			t.Errorf("unexpected result parsing %q: expected %s, got %v", test.input, test.expected.String(), headers)
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+// Test that the entity-tag parser rejects header names it does not know how to build, rather than dropping the header.
+func TestEntityTagUnexpectedName(t *testing.T) {
+	testsRun++
+	headers, err := parseEntityTag("x-etag", "dx200xyz")
+	if err == nil {
+		t.Errorf("expected error parsing entity-tag under unexpected header name; got %v", headers)
+	} else {
+		testsPassed++
+	}
+}
+
+func TestCommaListHeaders(t *testing.T) {
+	tests := []struct {
+		input    string
+		success  bool
+		expected base.SipHeader
+	}{
+		{"In-Reply-To: 70710@saturn.bell-tel.com", true, base.InReplyToHeader{"70710@saturn.bell-tel.com"}},
+		{"In-Reply-To: 70710@saturn.bell-tel.com, 17320@saturn.bell-tel.com", true,
+			base.InReplyToHeader{"70710@saturn.bell-tel.com", "17320@saturn.bell-tel.com"}},
+		{"In-Reply-To:a,b ,\tc", true, base.InReplyToHeader{"a", "b", "c"}},
+		{"In-Reply-To: 70710 @saturn.bell-tel.com", false, nil},
+		{"In-Reply-To: 70710@saturn.bell-tel.com;x", false, nil},
+		{"In-Reply-To: a,,b", false, nil},
+		{"In-Reply-To:", false, nil},
+		{"Allow-Events: presence", true, base.AllowEventsHeader{"presence"}},
+		{"Allow-Events: presence, dialog", true, base.AllowEventsHeader{"presence", "dialog"}},
+		{"u: refer,message-summary,  conference", true, base.AllowEventsHeader{"refer", "message-summary", "conference"}},
+		{"Allow-Events: presence dialog", false, nil},
+		{"Allow-Events: presence,", false, nil},
+		{"Allow-Events:", false, nil},
+		{"Allow: INVITE, ACK, OPTIONS,CANCEL", true, base.AllowHeader{base.INVITE, base.ACK, base.OPTIONS, base.CANCEL}},
+		{"Allow:", true, base.AllowHeader{}},
+		{"Allow: INVITE ACK", false, nil},
+		{"Allow: INVITE,", false, nil},
+		{"Supported: 100rel, timer", true, &base.SupportedHeader{[]string{"100rel", "timer"}}},
+		{"k: replaces", true, &base.SupportedHeader{[]string{"replaces"}}},
+		{"Supported:", true, &base.SupportedHeader{[]string{}}},
+		{"Supported: 100rel timer", false, nil},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		headers, err := parseHeader(test.input)
+		if !test.success {
+			if err == nil {
+				t.Errorf("expected error parsing %q; got %v", test.input, headers)
+			} else {
+				testsPassed++
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", test.input, err.Error())
+		} else if len(headers) != 1 || headers[0].String() != test.expected.String() ||
+			headers[0].Name() != test.expected.Name() {
+			t.Errorf("unexpected result parsing %q: expected %s, got %v", test.input, test.expected.String(), headers)
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+func TestRAcks(t *testing.T) {
+	doTests([]test{
+		test{rAckInput("RAck: 776656 1 INVITE"), &rAckResult{pass, &base.RAck{776656, 1, "INVITE"}}},
+		test{rAckInput("RAck : 1 2 INVITE"), &rAckResult{pass, &base.RAck{1, 2, "INVITE"}}},
+		test{rAckInput("RAck:\t3\t\t4 \tINVITE"), &rAckResult{pass, &base.RAck{3, 4, "INVITE"}}},
+		test{rAckInput("RAck: 5 0 reGister"), &rAckResult{pass, &base.RAck{5, 0, "reGister"}}},
+		test{rAckInput("RAck: 2147483647 2147483647 INVITE"), &rAckResult{pass, &base.RAck{2147483647, 2147483647, "INVITE"}}},
+		test{rAckInput("RAck: 2147483648 1 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: 1 2147483648 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: 0 1 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: -1 1 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: 1 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: 1 2 3 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck:"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: FOO 1 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: 1 FOO INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: 9999999999999999999999999999999 1 INVITE"), &rAckResult{fail, &base.RAck{}}},
+		test{rAckInput("RAck: 1 2 INVITE;foo=bar"), &rAckResult{fail, &base.RAck{}}},
+	}, t)
+}
+
+func TestDates(t *testing.T) {
+	doTests([]test{
+		test{dateInput("Date: Sat, 13 Nov 2010 23:29:00 GMT"), &dateResult{pass, time.Date(2010, time.November, 13, 23, 29, 0, 0, time.UTC)}},
+		test{dateInput("Date:   Thu, 01 Jan 1970 00:00:00 GMT  "), &dateResult{pass, time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)}},
+		test{dateInput("Date: Sat, 13 Nov 2010 23:29:00 PST"), &dateResult{fail, time.Time{}}},
+		test{dateInput("Date: Sat, 13 Nov 2010 23:29:00 +0000"), &dateResult{fail, time.Time{}}},
+		test{dateInput("Date: Sat, 13 Nov 2010 23:29:00"), &dateResult{fail, time.Time{}}},
+		test{dateInput("Date: 13 Nov 2010 23:29:00 GMT"), &dateResult{fail, time.Time{}}},
+		test{dateInput("Date: Sat, 31 Nov 2010 23:29:00 GMT"), &dateResult{fail, time.Time{}}},
+		test{dateInput("Date: yesterday GMT"), &dateResult{fail, time.Time{}}},
+		test{dateInput("Date:"), &dateResult{fail, time.Time{}}},
+	}, t)
+}
+
+func TestRetryAfters(t *testing.T) {
+	durationEq3600 := base.NewParams().Add("duration", base.String{"3600"})
+	doTests([]test{
+		test{retryAfterInput("Retry-After: 120"), &retryAfterResult{pass, &base.RetryAfterHeader{120, base.NoString{}, noParams}}},
+		test{retryAfterInput("Retry-After:\t0 "), &retryAfterResult{pass, &base.RetryAfterHeader{0, base.NoString{}, noParams}}},
+		test{retryAfterInput("Retry-After: 120 (I'm busy);duration=3600"), &retryAfterResult{pass,
+			&base.RetryAfterHeader{120, base.String{"I'm busy"}, durationEq3600}}},
+		test{retryAfterInput("Retry-After: 120(I'm busy) ; duration=3600"), &retryAfterResult{pass,
+			&base.RetryAfterHeader{120, base.String{"I'm busy"}, durationEq3600}}},
+		test{retryAfterInput("Retry-After: 120;duration=3600"), &retryAfterResult{pass, &base.RetryAfterHeader{120, base.NoString{}, durationEq3600}}},
+		test{retryAfterInput("Retry-After: 18000 (back at (around) 5; maybe)"), &retryAfterResult{pass,
+			&base.RetryAfterHeader{18000, base.String{"back at (around) 5; maybe"}, noParams}}},
+		test{retryAfterInput("Retry-After: 120 (I'm busy"), &retryAfterResult{fail, &base.RetryAfterHeader{}}},
+		test{retryAfterInput("Retry-After: soon"), &retryAfterResult{fail, &base.RetryAfterHeader{}}},
+		test{retryAfterInput("Retry-After: -5"), &retryAfterResult{fail, &base.RetryAfterHeader{}}},
+		test{retryAfterInput("Retry-After: (I'm busy)"), &retryAfterResult{fail, &base.RetryAfterHeader{}}},
+		test{retryAfterInput("Retry-After:"), &retryAfterResult{fail, &base.RetryAfterHeader{}}},
+	}, t)
+}
+
+func TestWarnings(t *testing.T) {
+	doTests([]test{
+		test{warningInput(`Warning: 307 isi.edu "Session parameter 'foo' not understood"`),
+			&warningResult{pass, base.WarningHeader{&base.Warning{307, "isi.edu", "Session parameter 'foo' not understood"}}}},
+		test{warningInput(`Warning: 307 isi.edu "Session parameter 'foo' not understood", ` +
+			`301 isi.edu "Incompatible network address type 'E.164'"`),
+			&warningResult{pass, base.WarningHeader{
+				&base.Warning{307, "isi.edu", "Session parameter 'foo' not understood"},
+				&base.Warning{301, "isi.edu", "Incompatible network address type 'E.164'"}}}},
+		test{warningInput(`Warning: 399 proxy.example.com:5060 "Busy, try later"`),
+			&warningResult{pass, base.WarningHeader{&base.Warning{399, "proxy.example.com:5060", "Busy, try later"}}}},
+		test{warningInput(`Warning: 399 example.com "A \"quoted\" word"`),
+			&warningResult{pass, base.WarningHeader{&base.Warning{399, "example.com", `A "quoted" word`}}}},
+		test{warningInput(`Warning: 30 isi.edu "Too short"`), &warningResult{fail, base.WarningHeader{}}},
+		test{warningInput(`Warning: 3070 isi.edu "Too long"`), &warningResult{fail, base.WarningHeader{}}},
+		test{warningInput(`Warning: abc isi.edu "Not a number"`), &warningResult{fail, base.WarningHeader{}}},
+		test{warningInput(`Warning: 307 isi.edu Unquoted`), &warningResult{fail, base.WarningHeader{}}},
+		test{warningInput(`Warning: 307 isi.edu "Unclosed`), &warningResult{fail, base.WarningHeader{}}},
+		test{warningInput(`Warning: 307 "No agent"`), &warningResult{fail, base.WarningHeader{}}},
+		test{warningInput(`Warning:`), &warningResult{fail, base.WarningHeader{}}},
+	}, t)
+}
+
 func TestCallIds(t *testing.T) {
 	doTests([]test{
 		test{callIdInput("Call-ID: fdlknfa32bse3yrbew23bf"), &callIdResult{pass, base.CallId("fdlknfa32bse3yrbew23bf")}},
@@ -894,6 +1548,16 @@ func TestCallIds(t *testing.T) {
 		test{callIdInput("Call-ID: banana\tspaghetti"), &callIdResult{fail, base.CallId("")}},
 		test{callIdInput("Call-ID: banana;spaghetti"), &callIdResult{fail, base.CallId("")}},
 		test{callIdInput("Call-ID: banana;spaghetti=tasty"), &callIdResult{fail, base.CallId("")}},
+		test{callIdInput("Call-ID: f81d4fae-7dec@foo.bar"), &callIdResult{pass, base.CallId("f81d4fae-7dec@foo.bar")}},
+		test{callIdInput("i: f81d4fae-7dec-11d0-a765-00a0c91e6bf6@[2001:db8::1]"), &callIdResult{pass, base.CallId("f81d4fae-7dec-11d0-a765-00a0c91e6bf6@[2001:db8::1]")}},
+		test{callIdInput("Call-ID: a!%*_+`'~()<>:\\\"/[]?{}.-z"), &callIdResult{pass, base.CallId("a!%*_+`'~()<>:\\\"/[]?{}.-z")}},
+		test{callIdInput("Call-ID: banana,spaghetti"), &callIdResult{fail, base.CallId("")}},
+		test{callIdInput("Call-ID: banana=spaghetti"), &callIdResult{fail, base.CallId("")}},
+		test{callIdInput("Call-ID: banana@spaghetti@bolognese"), &callIdResult{fail, base.CallId("")}},
+		test{callIdInput("Call-ID: @spaghetti"), &callIdResult{fail, base.CallId("")}},
+		test{callIdInput("Call-ID: banana@"), &callIdResult{fail, base.CallId("")}},
+		test{callIdInput("Call-ID: banana&spaghetti"), &callIdResult{fail, base.CallId("")}},
+		test{callIdInput("Call-ID: bananä"), &callIdResult{fail, base.CallId("")}},
 	}, t)
 }
 
@@ -916,6 +1580,23 @@ func TestMaxForwards(t *testing.T) {
 	}, t)
 }
 
+func TestMinExpires(t *testing.T) {
+	doTests([]test{
+		test{minExpiresInput("Min-Expires: 60"), &minExpiresResult{pass, base.MinExpires(60)}},
+		test{minExpiresInput("Min-Expires: 3600"), &minExpiresResult{pass, base.MinExpires(3600)}},
+		test{minExpiresInput("Min-Expires: 0"), &minExpiresResult{pass, base.MinExpires(0)}},
+		test{minExpiresInput("Min-Expires:      60"), &minExpiresResult{pass, base.MinExpires(60)}},
+		test{minExpiresInput("Min-Expires:\t60"), &minExpiresResult{pass, base.MinExpires(60)}},
+		test{minExpiresInput("Min-Expires:\n  60"), &minExpiresResult{pass, base.MinExpires(60)}},
+		test{minExpiresInput("Min-Expires: -1"), &minExpiresResult{fail, base.MinExpires(0)}},
+		test{minExpiresInput("Min-Expires: sixty"), &minExpiresResult{fail, base.MinExpires(0)}},
+		test{minExpiresInput("Min-Expires: 60s"), &minExpiresResult{fail, base.MinExpires(0)}},
+		test{minExpiresInput("Min-Expires:"), &minExpiresResult{fail, base.MinExpires(0)}},
+		test{minExpiresInput("Min-Expires: "), &minExpiresResult{fail, base.MinExpires(0)}},
+		test{minExpiresInput("Min-Expires:\t"), &minExpiresResult{fail, base.MinExpires(0)}},
+	}, t)
+}
+
 func TestContentLength(t *testing.T) {
 	doTests([]test{
 		test{contentLengthInput("Content-Length: 9"), &contentLengthResult{pass, base.ContentLength(9)}},
@@ -936,6 +1617,21 @@ func TestContentLength(t *testing.T) {
 	}, t)
 }
 
+// Test that an empty entry in a Via header, e.g. from a trailing or doubled comma, gives a clear error.
+func TestEmptyViaEntry(t *testing.T) {
+	for _, text := range []string{"SIP/2.0/UDP box,", "SIP/2.0/UDP a.com,,SIP/2.0/UDP b.com"} {
+		testsRun++
+		_, err := parseViaHeader("via", text, LastDuplicateParam)
+		if err == nil {
+			t.Errorf("expected error parsing Via '%s'", text)
+		} else if !strings.Contains(err.Error(), "empty Via entry") {
+			t.Errorf("unexpected error parsing Via '%s': %s", text, err.Error())
+		} else {
+			testsPassed++
+		}
+	}
+}
+
 func TestViaHeaders(t *testing.T) {
 	// branch=z9hG4bKnashds8
 	fooEqBar := base.NewParams().Add("foo", base.String{"bar"})
@@ -967,74 +1663,602 @@ func TestViaHeaders(t *testing.T) {
 		test{viaInput("Via: "), &viaResult{fail, &base.ViaHeader{}}},
 		test{viaInput("Via:\t"), &viaResult{fail, &base.ViaHeader{}}},
 		test{viaInput("Via: box:5060"), &viaResult{fail, &base.ViaHeader{}}},
+		test{viaInput("Via: SIP/2.0/UDP box,"), &viaResult{fail, &base.ViaHeader{}}},
+		test{viaInput("Via: SIP/2.0/UDP box, "), &viaResult{fail, &base.ViaHeader{}}},
+		test{viaInput("Via: SIP/2.0/UDP a.com,,SIP/2.0/UDP b.com"), &viaResult{fail, &base.ViaHeader{}}},
+		test{viaInput("Via: SIP/2.0/UDP a.com, ,SIP/2.0/UDP b.com"), &viaResult{fail, &base.ViaHeader{}}},
 		test{viaInput("Via: box:5060;foo=bar"), &viaResult{fail, &base.ViaHeader{}}},
 	}, t)
 }
 
-// Basic test of unstreamed parsing, using empty INVITE.
-func TestUnstreamedParse1(t *testing.T) {
-	test := ParserTest{false, []parserTestStep{
-		// Steps each have: Input, result, sent error, returned error
-		parserTestStep{"INVITE sip:bob@biloxi.com SIP/2.0\r\n\r\n",
-			base.NewRequest(base.INVITE,
-				&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
-				"SIP/2.0",
-				make([]base.SipHeader, 0),
-				""),
-			nil,
-			nil},
-	}}
-
-	test.Test(t)
-}
+// Test that Via headers with non-standard sent-protocols are accepted by default, but rejected in strict mode.
+func TestStrictVia(t *testing.T) {
+	tests := []struct {
+		header  string
+		strict  bool
+		success bool
+	}{
+		{"Via: SIP/2.0/UDP pc33.atlanta.com", false, true},
+		{"Via: SIP/2.0/UDP pc33.atlanta.com", true, true},
+		{"Via: sip/2.0/tls pc33.atlanta.com, SIP/2.0/WSS df7jal23ls0d.invalid", true, true},
+		{"Via: bAzz/fooo/BAAR pc33.atlanta.com", false, true},
+		{"Via: bAzz/fooo/BAAR pc33.atlanta.com", true, false},
+		{"Via: SIP/2.0/CARRIERPIGEON pc33.atlanta.com", false, true},
+		{"Via: SIP/2.0/CARRIERPIGEON pc33.atlanta.com", true, false},
+		{"Via: SIP/2.0/UDP pc33.atlanta.com, SIP/2.0/CARRIERPIGEON bigbox3.site3.atlanta.com", true, false},
+		{"Via: SIP/two/UDP pc33.atlanta.com", true, false},
+		{"Via: SIP/2./UDP pc33.atlanta.com", true, false},
+		{"Via: HTTP/2.0/TCP pc33.atlanta.com", true, false},
+	}
 
-// Test unstreamed parsing with a header and body.
-func TestUnstreamedParse2(t *testing.T) {
-	test := ParserTest{false, []parserTestStep{
-		// Steps each have: Input, result, sent error, returned error
-		parserTestStep{"INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
-			"CSeq: 13 INVITE\r\n" +
-			"\r\n" +
-			"I am a banana",
-			base.NewRequest(base.INVITE,
-				&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
-				"SIP/2.0",
-				[]base.SipHeader{&base.CSeq{13, base.INVITE}},
-				"I am a banana"),
-			nil,
-			nil},
-	}}
+	for _, test := range tests {
+		testsRun++
+		p := NewParser(make(chan base.SipMessage), make(chan error), false)
+		p.SetStrictVia(test.strict)
+		headers, err := p.(*parser).parseHeader(test.header)
+		p.Stop()
 
-	test.Test(t)
+		if test.success && err != nil {
+			t.Errorf("unexpected error parsing '%s' with strict=%t: %s", test.header, test.strict, err.Error())
+		} else if !test.success && err == nil {
+			t.Errorf("expected error parsing '%s' with strict=%t; got %v", test.header, test.strict, headers)
+		} else if !test.success && len(headers) != 0 {
+			t.Errorf("expected no headers from rejected '%s'; got %v", test.header, headers)
+		} else {
+			testsPassed++
+		}
+	}
 }
 
-// Test unstreamed parsing of a base.Request object (rather than a base.Response).
-func TestUnstreamedParse3(t *testing.T) {
-	test := ParserTest{false, []parserTestStep{
-		// Steps each have: Input, result, sent error, returned error
-		parserTestStep{"SIP/2.0 200 OK\r\n" +
-			"CSeq: 2 INVITE\r\n" +
-			"\r\n" +
-			"Everything is awesome.",
-			base.NewResponse("SIP/2.0",
-				200,
-				"OK",
-				[]base.SipHeader{&base.CSeq{2, base.INVITE}},
-				"Everything is awesome."),
-			nil,
-			nil},
-	}}
+// Test that hosts with illegal characters are accepted by default, but rejected in strict mode.
+func TestStrictHosts(t *testing.T) {
+	tests := []struct {
+		header  string
+		strict  bool
+		success bool
+	}{
+		{"Via: SIP/2.0/UDP pc33.atlanta.com", true, true},
+		{"Via: SIP/2.0/UDP abc123:5060", true, true},
+		{"Via: SIP/2.0/UDP 192.0.2.4;branch=z9hG4bK776asdhds", true, true},
+		{"Via: SIP/2.0/UDP [2001:db8::1]:5060", true, true},
+		{"Via: SIP/2.0/UDP host name with spaces", false, true},
+		{"Via: SIP/2.0/UDP host name with spaces", true, false},
+		{"Via: SIP/2.0/UDP bad\x01host", false, true},
+		{"Via: SIP/2.0/UDP bad\x01host", true, false},
+		{"Via: SIP/2.0/UDP a.com, SIP/2.0/UDP b_c.com", true, false},
+		{"To: <sip:bob@biloxi.com>", true, true},
+		{"To: <sip:bob@bil oxi.com>", false, true},
+		{"To: <sip:bob@bil oxi.com>", true, false},
+		{"From: <sip:alice@-atlanta.com>;tag=1928301774", true, false},
+		{"Contact: <sip:alice@999.0.2.4>", true, false},
+		{"Contact: *", true, true},
+		{"Route: <sip:p1.example.com;lr>", true, true},
+		{"Record-Route: <sip:p1..example.com;lr>", true, false},
+		{"Refer-To: <sip:carol@bad host>", true, true},
+	}
 
-	test.Test(t)
-}
+	for _, test := range tests {
+		testsRun++
+		p := NewParser(make(chan base.SipMessage), make(chan error), false)
+		p.SetStrictHosts(test.strict)
+		headers, err := p.(*parser).parseHeader(test.header)
+		p.Stop()
 
-// Test unstreamed parsing with more than one header.
-func TestUnstreamedParse4(t *testing.T) {
-	callId := base.CallId("cheesecake1729")
-	maxForwards := base.MaxForwards(65)
-	test := ParserTest{false, []parserTestStep{
-		// Steps each have: Input, result, sent error, returned error
-		parserTestStep{"SIP/2.0 200 OK\r\n" +
+		if test.success && err != nil {
+			t.Errorf("unexpected error parsing '%s' with strict=%t: %s", test.header, test.strict, err.Error())
+		} else if !test.success && err == nil {
+			t.Errorf("expected error parsing '%s' with strict=%t; got %v", test.header, test.strict, headers)
+		} else if !test.success && len(headers) != 0 {
+			t.Errorf("expected no headers from rejected '%s'; got %v", test.header, headers)
+		} else {
+			testsPassed++
+		}
+	}
+
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	p := NewParser(output, errs, false)
+	defer p.Stop()
+	p.SetStrictHosts(true)
+	p.Write([]byte("MESSAGE sip:bob@bad_host SIP/2.0\r\nContent-Length: 0\r\n\r\n"))
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "bad_host") {
+			t.Errorf("unexpected error for malformed Request-URI host: %s", err.Error())
+		} else {
+			testsPassed++
+		}
+	case msg := <-output:
+		t.Errorf("expected malformed Request-URI host to be rejected; got:\n%s", msg.String())
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for malformed Request-URI host to be rejected")
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	charsetUtf8 := base.NewParams().Add("charset", base.String{"utf-8"})
+	doTests([]test{
+		test{contentTypeInput("Content-Type: application/sdp"), &contentTypeResult{pass, &base.ContentType{"application/sdp", noParams}}},
+		test{contentTypeInput("c: application/sdp"), &contentTypeResult{pass, &base.ContentType{"application/sdp", noParams}}},
+		test{contentTypeInput("Content-Type:\ttext/plain ;charset=utf-8"), &contentTypeResult{pass, &base.ContentType{"text/plain", charsetUtf8}}},
+		test{contentTypeInput("Content-Type: text/plain;charset=\"utf-8\""), &contentTypeResult{pass, &base.ContentType{"text/plain", charsetUtf8}}},
+		test{contentTypeInput("Content-Type:"), &contentTypeResult{fail, &base.ContentType{}}},
+		test{contentTypeInput("Content-Type: application"), &contentTypeResult{fail, &base.ContentType{}}},
+		test{contentTypeInput("Content-Type: application/"), &contentTypeResult{fail, &base.ContentType{}}},
+		test{contentTypeInput("Content-Type: /sdp"), &contentTypeResult{fail, &base.ContentType{}}},
+		test{contentTypeInput("Content-Type: application/ sdp"), &contentTypeResult{fail, &base.ContentType{}}},
+	}, t)
+}
+
+func TestAcceptEncodings(t *testing.T) {
+	doTests([]test{
+		test{qualifiedInput("Accept-Encoding: gzip"),
+			&qualifiedResult{pass, []*base.QualifiedValue{&base.QualifiedValue{"gzip", 1, noParams}}}},
+		test{qualifiedInput("Accept-Encoding: identity;q=0.5, gzip;q=1.0, compress;q=0.5, deflate"),
+			&qualifiedResult{pass, []*base.QualifiedValue{
+				&base.QualifiedValue{"identity", 0.5, noParams},
+				&base.QualifiedValue{"gzip", 1, noParams},
+				&base.QualifiedValue{"compress", 0.5, noParams},
+				&base.QualifiedValue{"deflate", 1, noParams}}}},
+		test{qualifiedInput("Accept-Encoding: *;Q=0"),
+			&qualifiedResult{pass, []*base.QualifiedValue{&base.QualifiedValue{"*", 0, noParams}}}},
+		test{qualifiedInput("Accept-Encoding:"), &qualifiedResult{pass, []*base.QualifiedValue{}}},
+		test{qualifiedInput("Accept-Encoding: gzip;q=1.5"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Encoding: gzip;q=0.0001"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Encoding: gzip;q=high"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Encoding: gzip;q"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Encoding: gzip, "), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Encoding: g zip"), &qualifiedResult{fail, nil}},
+	}, t)
+}
+
+func TestAccepts(t *testing.T) {
+	doTests([]test{
+		test{qualifiedInput("Accept: application/sdp"),
+			&qualifiedResult{pass, []*base.QualifiedValue{&base.QualifiedValue{"application/sdp", 1, noParams}}}},
+		test{qualifiedInput("Accept: text/*;q=0.5, application/sdp;level=1, */*;q=0.1"),
+			&qualifiedResult{pass, []*base.QualifiedValue{
+				&base.QualifiedValue{"application/sdp", 1, base.NewParams().Add("level", base.String{"1"})},
+				&base.QualifiedValue{"text/*", 0.5, noParams},
+				&base.QualifiedValue{"*/*", 0.1, noParams}}}},
+		test{qualifiedInput("Accept:"), &qualifiedResult{pass, []*base.QualifiedValue{}}},
+		test{qualifiedInput("Accept: application"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept: application/sdp/x"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept: application/ sdp"), &qualifiedResult{fail, nil}},
+	}, t)
+}
+
+// Test that the headers built for an OPTIONS response survive a round trip through the parser.
+func TestCapabilityHeadersRoundTrip(t *testing.T) {
+	testsRun++
+	capabilities := base.CapabilityHeaders(
+		[]base.Method{base.INVITE, base.ACK, base.CANCEL, base.BYE, base.OPTIONS},
+		[]string{"100rel", "timer"},
+		[]string{"application/sdp", "multipart/mixed"})
+
+	response := base.NewResponse("SIP/2.0", 200, "OK", capabilities, "")
+	parsed, err := ParseMessage([]byte(response.String()))
+	if err != nil {
+		t.Errorf("unexpected error parsing OPTIONS response:\n%s\n%s", response.String(), err.Error())
+		return
+	}
+
+	parsedHeaders := parsed.AllHeaders()
+	if len(parsedHeaders) != len(capabilities) {
+		t.Errorf("expected %d headers after round trip; got %v", len(capabilities), parsedHeaders)
+		return
+	}
+	for idx, header := range capabilities {
+		parsedHeader := parsedHeaders[idx]
+		if parsedHeader.String() != header.String() || fmt.Sprintf("%T", parsedHeader) != fmt.Sprintf("%T", header) {
+			t.Errorf("expected %T '%s' after round trip; got %T '%s'", header, header, parsedHeader, parsedHeader)
+			return
+		}
+	}
+	testsPassed++
+}
+
+// Test that parsed Accept-Encoding and Accept-Language headers are written out in their original order,
+// and that Sorted orders their entries by q-value.
+func TestQualifiedValuesOrder(t *testing.T) {
+	for _, header := range []string{
+		"Accept-Encoding: identity;q=0.5, gzip, compress;q=0.5, deflate",
+		"Accept-Language: en;q=0.7, *;q=0.1, en-gb;q=0.8, da",
+	} {
+		testsRun++
+		headers, err := parseHeader(header)
+		if err != nil {
+			t.Errorf("unexpected error parsing '%s': %s", header, err.Error())
+			continue
+		} else if len(headers) != 1 || headers[0].String() != header {
+			t.Errorf("expected '%s' to round-trip unchanged; got %v", header, headers)
+			continue
+		}
+
+		var values []*base.QualifiedValue
+		switch parsed := headers[0].(type) {
+		case *base.AcceptEncodingHeader:
+			values = parsed.Sorted()
+		case *base.AcceptLanguageHeader:
+			values = parsed.Sorted()
+		}
+
+		order := make([]string, 0, len(values))
+		for _, value := range values {
+			order = append(order, value.Value)
+		}
+		if sorted := strings.Join(order, ","); sorted != "gzip,deflate,identity,compress" && sorted != "da,en-gb,en,*" {
+			t.Errorf("unexpected preference order for '%s': %s", header, sorted)
+			continue
+		} else if headers[0].String() != header {
+			t.Errorf("expected Sorted to leave '%s' unchanged; got %s", header, headers[0].String())
+			continue
+		}
+		testsPassed++
+	}
+}
+
+func TestQuotedCommaInList(t *testing.T) {
+	header := `Accept: application/sdp;note="a, b", text/plain`
+	testsRun++
+	headers, err := parseHeader(header)
+	if err != nil {
+		t.Errorf("unexpected error parsing '%s': %s", header, err.Error())
+		return
+	}
+
+	accept, ok := headers[0].(*base.AcceptHeader)
+	if len(headers) != 1 || !ok || len(*accept) != 2 {
+		t.Errorf("expected '%s' to give two accepted types; got %v", header, headers)
+		return
+	} else if note, _ := (*accept)[0].Params.Get("note"); note != (base.String{S: "a, b"}) {
+		t.Errorf("expected quoted param 'a, b' on '%s'; got %v", (*accept)[0].Value, note)
+		return
+	} else if (*accept)[1].Value != "text/plain" {
+		t.Errorf("expected second accepted type 'text/plain'; got '%s'", (*accept)[1].Value)
+		return
+	}
+	testsPassed++
+}
+
+func TestAcceptLanguages(t *testing.T) {
+	doTests([]test{
+		test{qualifiedInput("Accept-Language: da, en-gb;q=0.8, en;q=0.7"),
+			&qualifiedResult{pass, []*base.QualifiedValue{
+				&base.QualifiedValue{"da", 1, noParams},
+				&base.QualifiedValue{"en-gb", 0.8, noParams},
+				&base.QualifiedValue{"en", 0.7, noParams}}}},
+		test{qualifiedInput("Accept-Language: en;q=0.7, *;q=0.1, en-gb;q=0.8, da"),
+			&qualifiedResult{pass, []*base.QualifiedValue{
+				&base.QualifiedValue{"en", 0.7, noParams},
+				&base.QualifiedValue{"*", 0.1, noParams},
+				&base.QualifiedValue{"en-gb", 0.8, noParams},
+				&base.QualifiedValue{"da", 1, noParams}}}},
+		test{qualifiedInput("Accept-Language: en-gb-oxendict"),
+			&qualifiedResult{pass, []*base.QualifiedValue{&base.QualifiedValue{"en-gb-oxendict", 1, noParams}}}},
+		test{qualifiedInput("Accept-Language: en_GB"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Language: en-"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Language: abcdefghi"), &qualifiedResult{fail, nil}},
+		test{qualifiedInput("Accept-Language: en;q=2"), &qualifiedResult{fail, nil}},
+	}, t)
+}
+
+func TestContentEncodings(t *testing.T) {
+	doTests([]test{
+		test{contentEncodingInput("Content-Encoding: gzip"), &contentEncodingResult{pass, base.ContentEncodingHeader{"gzip"}}},
+		test{contentEncodingInput("e: gzip"), &contentEncodingResult{pass, base.ContentEncodingHeader{"gzip"}}},
+		test{contentEncodingInput("Content-Encoding: gzip, compress"),
+			&contentEncodingResult{pass, base.ContentEncodingHeader{"gzip", "compress"}}},
+		test{contentEncodingInput("Content-Encoding:\tdeflate ,gzip "),
+			&contentEncodingResult{pass, base.ContentEncodingHeader{"deflate", "gzip"}}},
+		test{contentEncodingInput("Content-Encoding:"), &contentEncodingResult{fail, nil}},
+		test{contentEncodingInput("Content-Encoding: gzip,"), &contentEncodingResult{fail, nil}},
+		test{contentEncodingInput("Content-Encoding: gzip;q=0.5"), &contentEncodingResult{fail, nil}},
+	}, t)
+}
+
+func TestHeadersInSdpBody(t *testing.T) {
+	doTests([]test{
+		test{sdpBodyInput{"application/sdp", "v=0\r\no=alice 2890844526 2890844526 IN IP4 host.atlanta.com\r\n"}, sdpBodyResult(false)},
+		test{sdpBodyInput{"application/sdp", "CSeq: 2 INVITE\r\nv=0\r\no=alice 2890844526 2890844526 IN IP4 host.atlanta.com\r\n"}, sdpBodyResult(true)},
+		test{sdpBodyInput{"application/SDP", "Via : SIP/2.0/UDP box\r\nv=0\r\n"}, sdpBodyResult(true)},
+		test{sdpBodyInput{"application/sdp", "v=0\r\na=rtpmap: 0 PCMU/8000\r\nCSeq: 2 INVITE\r\n"}, sdpBodyResult(false)},
+		test{sdpBodyInput{"text/plain", "CSeq: 2 INVITE\r\nv=0\r\n"}, sdpBodyResult(false)},
+	}, t)
+}
+
+// A writer which collects log output, and which may safely be written by several goroutines at once.
+type lockedBuffer struct {
+	sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(data []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buffer.Write(data)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buffer.String()
+}
+
+// Test that parsed messages carry a *base.ContentType, and that header-like lines in an SDP body are only
+// logged when body diagnostics are enabled.
+func TestBodyDiagnostics(t *testing.T) {
+	msg := "INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"Content-Length: 21\r\n\r\n" +
+		"CSeq: 2 INVITE\r\nv=0\r\n"
+
+	var logs lockedBuffer
+	log.SetDefaultOutput(&logs)
+	defer log.SetDefaultOutput(os.Stderr)
+
+	for _, enabled := range []bool{false, true} {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+		p := NewParser(output, errs, false)
+		p.SetBodyDiagnostics(enabled)
+		p.Write([]byte(msg))
+
+		select {
+		case parsed := <-output:
+			if contentTypes := parsed.Headers("Content-Type"); len(contentTypes) != 1 {
+				t.Errorf("expected one Content-Type; got %v", contentTypes)
+			} else if contentType, ok := contentTypes[0].(*base.ContentType); !ok || contentType.MediaType != "application/sdp" {
+				t.Errorf("expected Content-Type to parse as application/sdp; got %#v", contentTypes[0])
+			} else if logged := strings.Contains(logs.String(), "header-like lines"); logged != enabled {
+				t.Errorf("expected diagnostics logged=%t with diagnostics enabled=%t; log was:\n%s",
+					enabled, enabled, logs.String())
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			t.Errorf("unexpected error parsing message with diagnostics=%t: %s", enabled, err.Error())
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing message with diagnostics=%t", enabled)
+		}
+		p.Stop()
+	}
+}
+
+func TestEventHeaders(t *testing.T) {
+	idEqAbc := base.NewParams().Add("id", base.String{"abc"})
+	doTests([]test{
+		test{eventInput("Event: presence"), &eventResult{pass, &base.EventHeader{"presence", noParams}, base.NoString{}}},
+		test{eventInput("o: presence"), &eventResult{pass, &base.EventHeader{"presence", noParams}, base.NoString{}}},
+		test{eventInput("Event: presence;id=abc"), &eventResult{pass, &base.EventHeader{"presence", idEqAbc}, base.String{"abc"}}},
+		test{eventInput("Event:\tpresence ; id=abc"), &eventResult{pass, &base.EventHeader{"presence", idEqAbc}, base.String{"abc"}}},
+		test{eventInput("Event: presence.winfo"), &eventResult{pass, &base.EventHeader{"presence.winfo", noParams}, base.NoString{}}},
+		test{eventInput("Event:"), &eventResult{fail, &base.EventHeader{}, base.NoString{}}},
+		test{eventInput("Event: ;id=abc"), &eventResult{fail, &base.EventHeader{}, base.NoString{}}},
+		test{eventInput("Event: pres ence"), &eventResult{fail, &base.EventHeader{}, base.NoString{}}},
+		test{eventInput("Event: <presence>"), &eventResult{fail, &base.EventHeader{}, base.NoString{}}},
+	}, t)
+}
+
+func TestReferSubs(t *testing.T) {
+	fooEqBar := base.NewParams().Add("foo", base.String{"bar"})
+	doTests([]test{
+		test{referSubInput("Refer-Sub: false"), &referSubResult{pass, &base.ReferSub{false, noParams}}},
+		test{referSubInput("Refer-Sub: true"), &referSubResult{pass, &base.ReferSub{true, noParams}}},
+		test{referSubInput("Refer-Sub:\tFALSE "), &referSubResult{pass, &base.ReferSub{false, noParams}}},
+		test{referSubInput("Refer-Sub: true;foo=bar"), &referSubResult{pass, &base.ReferSub{true, fooEqBar}}},
+		test{referSubInput("Refer-Sub: yes"), &referSubResult{fail, &base.ReferSub{}}},
+		test{referSubInput("Refer-Sub: 0"), &referSubResult{fail, &base.ReferSub{}}},
+		test{referSubInput("Refer-Sub: ;foo=bar"), &referSubResult{fail, &base.ReferSub{}}},
+		test{referSubInput("Refer-Sub:"), &referSubResult{fail, &base.ReferSub{}}},
+	}, t)
+}
+
+func TestContentDispositions(t *testing.T) {
+	handlingOptional := base.NewParams().Add("handling", base.String{"optional"})
+	handlingRequired := base.NewParams().Add("handling", base.String{"required"})
+	doTests([]test{
+		test{contentDispositionInput("Content-Disposition: render"),
+			&contentDispositionResult{pass, &base.ContentDispositionHeader{"render", noParams}}},
+		test{contentDispositionInput("Content-Disposition: session;handling=optional"),
+			&contentDispositionResult{pass, &base.ContentDispositionHeader{"session", handlingOptional}}},
+		test{contentDispositionInput("Content-Disposition:\tsession ; handling=required"),
+			&contentDispositionResult{pass, &base.ContentDispositionHeader{"session", handlingRequired}}},
+		test{contentDispositionInput("Content-Disposition: ;handling=optional"),
+			&contentDispositionResult{fail, &base.ContentDispositionHeader{}}},
+		test{contentDispositionInput("Content-Disposition: early session"),
+			&contentDispositionResult{fail, &base.ContentDispositionHeader{}}},
+		test{contentDispositionInput("Content-Disposition:"),
+			&contentDispositionResult{fail, &base.ContentDispositionHeader{}}},
+	}, t)
+}
+
+func TestReplacesHeaders(t *testing.T) {
+	tags := base.NewParams().Add("to-tag", base.String{"xyz"}).Add("from-tag", base.String{"abc"})
+	earlyOnly := base.NewParams().Add("to-tag", base.String{"xyz"}).Add("from-tag", base.String{"abc"}).Add("early-only", base.NoString{})
+	doTests([]test{
+		test{replacesInput("Replaces: 98732@sip.example.com;to-tag=xyz;from-tag=abc"),
+			&replacesResult{pass, &base.ReplacesHeader{"98732@sip.example.com", tags}}},
+		test{replacesInput("Replaces: 98732@sip.example.com ; to-tag=xyz ; from-tag=abc"),
+			&replacesResult{pass, &base.ReplacesHeader{"98732@sip.example.com", tags}}},
+		test{replacesInput("Replaces: 98732@sip.example.com;to-tag=xyz;from-tag=abc;early-only"),
+			&replacesResult{pass, &base.ReplacesHeader{"98732@sip.example.com", earlyOnly}}},
+		test{replacesInput("Replaces: 98732@sip.example.com;to-tag=xyz"), &replacesResult{fail, &base.ReplacesHeader{}}},
+		test{replacesInput("Replaces: 98732@sip.example.com;from-tag=abc"), &replacesResult{fail, &base.ReplacesHeader{}}},
+		test{replacesInput("Replaces: 98732@sip.example.com;to-tag=xyz;from-tag"), &replacesResult{fail, &base.ReplacesHeader{}}},
+		test{replacesInput("Replaces: 98732@sip.example.com"), &replacesResult{fail, &base.ReplacesHeader{}}},
+		test{replacesInput("Replaces: ;to-tag=xyz;from-tag=abc"), &replacesResult{fail, &base.ReplacesHeader{}}},
+	}, t)
+}
+
+func TestCallerPrefs(t *testing.T) {
+	videoOnly := base.NewParams().Add("video", base.NoString{})
+	audioAndVideo := base.NewParams().Add("audio", base.NoString{}).Add("video", base.NoString{})
+	videoInFrench := base.NewParams().Add("video", base.NoString{}).Add("language", base.String{"fr"})
+	videoInGerman := base.NewParams().Add("video", base.NoString{}).Add("language", base.String{"de"})
+	doTests([]test{
+		test{callerPrefsInput{"Accept-Contact: *;video;!audio", videoOnly}, &callerPrefsResult{pass, "Accept-Contact: *;video;!audio", []bool{true}}},
+		test{callerPrefsInput{"Accept-Contact: *;video;!audio", audioAndVideo}, &callerPrefsResult{pass, "Accept-Contact: *;video;!audio", []bool{false}}},
+		test{callerPrefsInput{"a: *;video;!audio;require;explicit", videoOnly}, &callerPrefsResult{pass, "Accept-Contact: *;video;!audio;require;explicit", []bool{true}}},
+		test{callerPrefsInput{"Accept-Contact: *;video;language=\"en,fr\"", videoInFrench}, &callerPrefsResult{pass, "Accept-Contact: *;video;language=\"en,fr\"", []bool{true}}},
+		test{callerPrefsInput{"Accept-Contact: *;video;language=\"en,fr\"", videoInGerman}, &callerPrefsResult{pass, "Accept-Contact: *;video;language=\"en,fr\"", []bool{false}}},
+		test{callerPrefsInput{"Accept-Contact: *;language=\"en,fr\", *;audio", videoOnly}, &callerPrefsResult{pass, "Accept-Contact: *;language=\"en,fr\", *;audio", []bool{false, false}}},
+		test{callerPrefsInput{"Reject-Contact: *;audio", audioAndVideo}, &callerPrefsResult{pass, "Reject-Contact: *;audio", []bool{true}}},
+		test{callerPrefsInput{"j: *;!video", audioAndVideo}, &callerPrefsResult{pass, "Reject-Contact: *;!video", []bool{false}}},
+		test{callerPrefsInput{"Accept-Contact: *", videoOnly}, &callerPrefsResult{pass, "Accept-Contact: *", []bool{true}}},
+		test{callerPrefsInput{"Accept-Contact: video", videoOnly}, &callerPrefsResult{fail, "", nil}},
+		test{callerPrefsInput{"Accept-Contact: *;video,", videoOnly}, &callerPrefsResult{fail, "", nil}},
+		test{callerPrefsInput{"Accept-Contact: *;!", videoOnly}, &callerPrefsResult{fail, "", nil}},
+		test{callerPrefsInput{"Accept-Contact: *;!language=\"en\"", videoOnly}, &callerPrefsResult{fail, "", nil}},
+		test{callerPrefsInput{"Accept-Contact: *;language=\"en", videoOnly}, &callerPrefsResult{fail, "", nil}},
+	}, t)
+}
+
+func TestInfoHeaders(t *testing.T) {
+	moo := &base.AbsoluteUri{"http", "//www.example.com/sounds/moo.wav"}
+	photo := &base.AbsoluteUri{"http", "//wwww.example.com/alice/photo.jpg"}
+	alice := &base.AbsoluteUri{"http", "//www.example.com/alice/"}
+	purposeIcon := base.NewParams().Add("purpose", base.String{"icon"})
+	purposeInfo := base.NewParams().Add("purpose", base.String{"info"})
+	doTests([]test{
+		test{infoInput("Alert-Info: <http://www.example.com/sounds/moo.wav>"),
+			&infoResult{pass, &base.AlertInfoHeader{&base.InfoEntry{moo, noParams}}}},
+		test{infoInput("Call-Info: <http://wwww.example.com/alice/photo.jpg> ;purpose=icon, " +
+			"<http://www.example.com/alice/> ;purpose=info"),
+			&infoResult{pass, &base.CallInfoHeader{&base.InfoEntry{photo, purposeIcon}, &base.InfoEntry{alice, purposeInfo}}}},
+		test{infoInput("Error-Info: <sip:not-in-service-recording@atlanta.com>"),
+			&infoResult{pass, &base.ErrorInfoHeader{&base.InfoEntry{
+				&base.SipUri{false, base.String{"not-in-service-recording"}, base.NoString{}, "atlanta.com", nil, noParams, noParams},
+				noParams}}}},
+		test{infoInput("Call-Info: <http://www.example.com/a,b>;purpose=info"),
+			&infoResult{pass, &base.CallInfoHeader{&base.InfoEntry{&base.AbsoluteUri{"http", "//www.example.com/a,b"}, purposeInfo}}}},
+		test{infoInput("Alert-Info: http://www.example.com/sounds/moo.wav"), &infoResult{fail, nil}},
+		test{infoInput("Alert-Info: <http://www.example.com/sounds/moo.wav"), &infoResult{fail, nil}},
+		test{infoInput("Alert-Info: <*>"), &infoResult{fail, nil}},
+		test{infoInput("Alert-Info:"), &infoResult{fail, nil}},
+	}, t)
+}
+
+func TestPAssertedIdentities(t *testing.T) {
+	fluffy := &base.SipUri{false, base.String{"fluffy"}, base.NoString{}, "cisco.com", nil, noParams, noParams}
+	doTests([]test{
+		test{paiInput(`P-Asserted-Identity: "Cullen Jennings" <sip:fluffy@cisco.com>`),
+			&paiResult{pass, base.PAssertedIdentityHeader{
+				&base.NameAddr{base.String{"Cullen Jennings"}, fluffy, noParams}}}},
+		test{paiInput(`P-Asserted-Identity: "Cullen Jennings" <sip:fluffy@cisco.com>, tel:+14085551212`),
+			&paiResult{pass, base.PAssertedIdentityHeader{
+				&base.NameAddr{base.String{"Cullen Jennings"}, fluffy, noParams},
+				&base.NameAddr{base.NoString{}, &base.TelUri{"+14085551212", noParams}, noParams}}}},
+		test{paiInput(`P-Asserted-Identity: <tel:+14085551212>, <sip:fluffy@cisco.com>`),
+			&paiResult{pass, base.PAssertedIdentityHeader{
+				&base.NameAddr{base.NoString{}, &base.TelUri{"+14085551212", noParams}, noParams},
+				&base.NameAddr{base.NoString{}, fluffy, noParams}}}},
+		test{paiInput(`P-Asserted-Identity: <sip:fluffy@cisco.com>, <sip:bob@biloxi.com>`),
+			&paiResult{fail, base.PAssertedIdentityHeader{}}},
+		test{paiInput(`P-Asserted-Identity: <tel:+14085551212>, <tel:+14085551213>`),
+			&paiResult{fail, base.PAssertedIdentityHeader{}}},
+		test{paiInput(`P-Asserted-Identity: <sip:fluffy@cisco.com>, <tel:+14085551212>, <tel:+14085551213>`),
+			&paiResult{fail, base.PAssertedIdentityHeader{}}},
+		test{paiInput(`P-Asserted-Identity: *`), &paiResult{fail, base.PAssertedIdentityHeader{}}},
+	}, t)
+}
+
+func TestPVisitedNetworkIDs(t *testing.T) {
+	fooEqBar := base.NewParams().Add("foo", base.String{"bar"})
+	doTests([]test{
+		test{pvniInput("P-Visited-Network-ID: other.net"), &pvniResult{pass, &base.PVisitedNetworkID{
+			&base.VisitedNetwork{"other.net", false, noParams}}}},
+		test{pvniInput("P-Visited-Network-ID: \"Visited network number 1\""), &pvniResult{pass, &base.PVisitedNetworkID{
+			&base.VisitedNetwork{"Visited network number 1", true, noParams}}}},
+		test{pvniInput("P-Visited-Network-ID: \"Visited network number 1\", other.net"), &pvniResult{pass, &base.PVisitedNetworkID{
+			&base.VisitedNetwork{"Visited network number 1", true, noParams},
+			&base.VisitedNetwork{"other.net", false, noParams}}}},
+		test{pvniInput("P-Visited-Network-ID: \"Network, with a comma\";foo=bar,other.net"), &pvniResult{pass, &base.PVisitedNetworkID{
+			&base.VisitedNetwork{"Network, with a comma", true, fooEqBar},
+			&base.VisitedNetwork{"other.net", false, noParams}}}},
+		test{pvniInput("p-visited-network-id: other.net;foo=bar"), &pvniResult{pass, &base.PVisitedNetworkID{
+			&base.VisitedNetwork{"other.net", false, fooEqBar}}}},
+		test{pvniInput("P-Visited-Network-ID: \"The \\\"other\\\" net\";foo=bar, other.net"), &pvniResult{pass, &base.PVisitedNetworkID{
+			&base.VisitedNetwork{"The \"other\" net", true, fooEqBar},
+			&base.VisitedNetwork{"other.net", false, noParams}}}},
+		test{pvniInput("P-Visited-Network-ID: \"Escaped \\\\\", other.net"), &pvniResult{pass, &base.PVisitedNetworkID{
+			&base.VisitedNetwork{"Escaped \\", true, noParams},
+			&base.VisitedNetwork{"other.net", false, noParams}}}},
+		test{pvniInput("P-Visited-Network-ID: \"Unclosed \\\""), &pvniResult{fail, &base.PVisitedNetworkID{}}},
+		test{pvniInput("P-Visited-Network-ID: \"other\" net"), &pvniResult{fail, &base.PVisitedNetworkID{}}},
+		test{pvniInput("P-Visited-Network-ID:"), &pvniResult{fail, &base.PVisitedNetworkID{}}},
+		test{pvniInput("P-Visited-Network-ID: other.net,"), &pvniResult{fail, &base.PVisitedNetworkID{}}},
+		test{pvniInput("P-Visited-Network-ID: \"Unclosed network"), &pvniResult{fail, &base.PVisitedNetworkID{}}},
+		test{pvniInput("P-Visited-Network-ID: other net"), &pvniResult{fail, &base.PVisitedNetworkID{}}},
+	}, t)
+}
+
+// Basic test of unstreamed parsing, using empty INVITE.
+func TestUnstreamedParse1(t *testing.T) {
+	test := ParserTest{false, []parserTestStep{
+		// Steps each have: Input, result, sent error, returned error
+		parserTestStep{"INVITE sip:bob@biloxi.com SIP/2.0\r\n\r\n",
+			base.NewRequest(base.INVITE,
+				&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+				"SIP/2.0",
+				make([]base.SipHeader, 0),
+				""),
+			nil,
+			nil},
+	}}
+
+	test.Test(t)
+}
+
+// Test unstreamed parsing with a header and body.
+func TestUnstreamedParse2(t *testing.T) {
+	test := ParserTest{false, []parserTestStep{
+		// Steps each have: Input, result, sent error, returned error
+		parserTestStep{"INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+			"CSeq: 13 INVITE\r\n" +
+			"\r\n" +
+			"I am a banana",
+			base.NewRequest(base.INVITE,
+				&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+				"SIP/2.0",
+				[]base.SipHeader{&base.CSeq{13, base.INVITE}},
+				"I am a banana"),
+			nil,
+			nil},
+	}}
+
+	test.Test(t)
+}
+
+// Test unstreamed parsing of a base.Request object (rather than a base.Response).
+func TestUnstreamedParse3(t *testing.T) {
+	test := ParserTest{false, []parserTestStep{
+		// Steps each have: Input, result, sent error, returned error
+		parserTestStep{"SIP/2.0 200 OK\r\n" +
+			"CSeq: 2 INVITE\r\n" +
+			"\r\n" +
+			"Everything is awesome.",
+			base.NewResponse("SIP/2.0",
+				200,
+				"OK",
+				[]base.SipHeader{&base.CSeq{2, base.INVITE}},
+				"Everything is awesome."),
+			nil,
+			nil},
+	}}
+
+	test.Test(t)
+}
+
+// Test unstreamed parsing with more than one header.
+func TestUnstreamedParse4(t *testing.T) {
+	callId := base.CallId("cheesecake1729")
+	maxForwards := base.MaxForwards(65)
+	test := ParserTest{false, []parserTestStep{
+		// Steps each have: Input, result, sent error, returned error
+		parserTestStep{"SIP/2.0 200 OK\r\n" +
 			"CSeq: 2 INVITE\r\n" +
 			"Call-ID: cheesecake1729\r\n" +
 			"Max-Forwards: 65\r\n" +
@@ -1193,577 +2417,3555 @@ func TestStreamedParse3(t *testing.T) {
 	test.Test(t)
 }
 
-type paramInput struct {
-	paramString      string
-	start            uint8
-	sep              uint8
-	end              uint8
-	quoteValues      bool
-	permitSingletons bool
-}
+// Test writing a single message one byte at a time.
+func TestStreamedParseByteByByte(t *testing.T) {
+	contentLength := base.ContentLength(13)
+	message := "INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Content-Length: 13\r\n\r\n" +
+		"I am a banana"
 
-func (data *paramInput) String() string {
-	return fmt.Sprintf("paramString=\"%s\", start=%c, sep=%c, end=%c, quoteValues=%b, permitSingletons=%b",
-		data.paramString, data.start, data.sep, data.end, data.quoteValues, data.permitSingletons)
-}
-func (data *paramInput) evaluate() result {
-	output, consumed, err := parseParams(data.paramString, data.start, data.sep, data.end, data.quoteValues, data.permitSingletons)
-	return &paramResult{err, output, consumed}
-}
+	chunks := make([]string, 0, len(message))
+	for idx := range message {
+		chunks = append(chunks, message[idx:idx+1])
+	}
 
-type paramResult struct {
-	err      error
-	params   base.Params
-	consumed int
+	test := streamedWritesTest{chunks, []base.SipMessage{
+		base.NewRequest(base.INVITE,
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+			"SIP/2.0",
+			[]base.SipHeader{&contentLength},
+			"I am a banana"),
+	}}
+
+	test.Test(t)
 }
 
-func (expected *paramResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*paramResult))
-	if expected.err == nil && actual.err != nil {
-		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
-	} else if expected.err != nil && actual.err == nil {
-		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.params.ToString('-'))
-	} else if actual.err == nil && !expected.params.Equals(actual.params) {
-		return false, fmt.Sprintf("unexpected result: expected \"%s\", got \"%s\"",
-			expected.params.ToString('-'), actual.params.ToString('-'))
-	} else if actual.err == nil && expected.consumed != actual.consumed {
-		return false, fmt.Sprintf("unexpected consumed value: expected %d, got %d", expected.consumed, actual.consumed)
-	}
+// Test writing a single message in pieces which break in the middle of headers, and between the CR and LF
+// of a line ending.
+func TestStreamedParseSplitMidHeader(t *testing.T) {
+	contentLength := base.ContentLength(0)
+	callId := base.CallId("cheesecake1729")
+	test := streamedWritesTest{[]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0\r\nCSe",
+		"q: 13 INV",
+		"ITE\r",
+		"\nCall-ID: cheesecake1729\r\nContent-Len",
+		"gth: 0\r\n\r",
+		"\n",
+	}, []base.SipMessage{
+		base.NewRequest(base.INVITE,
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+			"SIP/2.0",
+			[]base.SipHeader{&base.CSeq{13, base.INVITE}, &callId, &contentLength},
+			""),
+	}}
 
-	return true, ""
+	test.Test(t)
 }
 
-type sipUriInput string
+// Test writing a single message in pieces which break in the middle of the body.
+func TestStreamedParseSplitMidBody(t *testing.T) {
+	contentLength := base.ContentLength(26)
+	test := streamedWritesTest{[]string{
+		"SIP/2.0 200 OK\r\nContent-Length: 26\r\n\r\nEvery",
+		"thing is\r",
+		"\n",
+		" awesome.\r\n",
+	}, []base.SipMessage{
+		base.NewResponse("SIP/2.0",
+			200,
+			"OK",
+			[]base.SipHeader{&contentLength},
+			"Everything is\r\n awesome.\r\n"),
+	}}
 
-func (data sipUriInput) String() string {
-	return string(data)
+	test.Test(t)
 }
-func (data sipUriInput) evaluate() result {
-	output, err := ParseSipUri(string(data))
-	return &sipUriResult{err, output}
+
+// Test writes in which the boundary between two messages falls inside a single write.
+func TestStreamedParseBoundaryMidWrite(t *testing.T) {
+	contentLength5 := base.ContentLength(5)
+	contentLength0 := base.ContentLength(0)
+	test := streamedWritesTest{[]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 5\r\n\r\nHe",
+		"llo" + "ACK sip:bob@biloxi.com SIP/2.0\r\nContent-",
+		"Length: 0\r\n\r\n" + "SIP/2.0 200 OK\r\nContent-Length: 5\r\n\r\nHello" + "SIP/2.0 180 Ringing\r\n",
+		"Content-Length: 0\r\n\r\n",
+	}, []base.SipMessage{
+		base.NewRequest(base.INVITE,
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+			"SIP/2.0",
+			[]base.SipHeader{&contentLength5},
+			"Hello"),
+		base.NewRequest(base.ACK,
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+			"SIP/2.0",
+			[]base.SipHeader{&contentLength0},
+			""),
+		base.NewResponse("SIP/2.0", 200, "OK", []base.SipHeader{&contentLength5}, "Hello"),
+		base.NewResponse("SIP/2.0", 180, "Ringing", []base.SipHeader{&contentLength0}, ""),
+	}}
+
+	test.Test(t)
 }
 
-type sipUriResult struct {
-	err error
-	uri base.SipUri
+// Test that a stray CR immediately before a line's CRLF does not prevent the line from being recognised.
+func TestStreamedParseStrayCarriageReturn(t *testing.T) {
+	contentLength := base.ContentLength(0)
+	callId := base.CallId("cheesecake1729")
+	test := streamedWritesTest{[]string{
+		"INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+			"Call-ID: cheesecake1729\r\r\n" +
+			"Content-Length: 0\r\n\r\n",
+	}, []base.SipMessage{
+		base.NewRequest(base.INVITE,
+			&base.SipUri{false, base.String{"bob"}, base.NoString{}, "biloxi.com", nil, noParams, noParams},
+			"SIP/2.0",
+			[]base.SipHeader{&callId, &contentLength},
+			""),
+	}}
+
+	test.Test(t)
 }
 
-func (expected *sipUriResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*sipUriResult))
-	if expected.err == nil && actual.err != nil {
-		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
-	} else if expected.err != nil && actual.err == nil {
-		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.uri.String())
-	} else if actual.err != nil {
+// Test that non-ASCII UTF-8 content in free-text headers, display names and quoted parameters survives
+// parsing, header folding and stringification intact.
+func TestUtf8Headers(t *testing.T) {
+	testsRun++
+	input := "INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Subject: Café ☕ – 日本語のテスト\r\n" +
+		"Organization: Ελληνική Εταιρεία\r\n" +
+		" Ñandú S.A.\u00a0\r\n" +
+		"From: \"Zoë Müller\" <sip:zoe@biloxi.com>;tag=ä1;note=\"naïve café\"\r\n" +
+		"\r\n"
+	msg, err := ParseMessage([]byte(input))
+	if err != nil {
+		t.Errorf("unexpected error parsing message: %s", err.Error())
+		return
+	}
+
+	expected := map[string]string{
+		"Subject":      "Subject: Café ☕ – 日本語のテスト",
+		"Organization": "Organization: Ελληνική Εταιρεία Ñandú S.A.\u00a0",
+		"From":         "From: \"Zoë Müller\" <sip:zoe@biloxi.com>;tag=ä1;note=\"naïve café\"",
+	}
+	for name, value := range expected {
+		headers := msg.Headers(name)
+		if len(headers) != 1 {
+			t.Errorf("expected one %s header; got %d", name, len(headers))
+			return
+		}
+		if headers[0].String() != value {
+			t.Errorf("unexpected %s header: expected %q, got %q", name, value, headers[0].String())
+			return
+		}
+	}
+
+	from := msg.Headers("From")[0].(*base.FromHeader)
+	if from.DisplayName != (base.String{"Zoë Müller"}) {
+		t.Errorf("unexpected display name in From header: %q", strMaybeStr(from.DisplayName))
+		return
+	}
+	if note, _ := from.Params.Get("note"); note != (base.String{"naïve café"}) {
+		t.Errorf("unexpected note parameter in From header: %q", strMaybeStr(note))
+		return
+	}
+
+	testsPassed++
+}
+
+// Test that a streamed message declaring a body larger than the configured maximum causes a terminal error,
+// rather than the parser attempting to buffer the body.
+func TestStreamedParseMaxBodyLength(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.SetMaxBodyLength(1024)
+
+	p.Write([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Content-Length: 2000000000\r\n\r\n" +
+		"I am a banana"))
+
+	select {
+	case msg := <-output:
+		t.Errorf("expected error for oversized Content-Length; got message:\n%s", msg.String())
+		return
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("nil error output from parser for oversized Content-Length")
+			return
+		}
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for error for oversized Content-Length")
+		return
+	}
+
+	if _, err := p.Write([]byte("ACK sip:bob@biloxi.com SIP/2.0\r\n")); err == nil {
+		t.Errorf("expected parser to reject further writes after oversized Content-Length")
+		return
+	}
+
+	testsPassed++
+}
+
+// Test that a message whose header section exceeds the configured maximum causes a terminal error,
+// even if no blank line ever arrives to end the header section.
+func TestStreamedParseMaxHeaderBytes(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.SetMaxHeaderBytes(1024)
+
+	p.Write([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Subject: This header goes on\r\n"))
+	for idx := 0; idx < 100; idx++ {
+		if _, err := p.Write([]byte(" and on and on and on\r\n")); err != nil {
+			break
+		}
+	}
+
+	select {
+	case msg := <-output:
+		t.Errorf("expected error for oversized header section; got message:\n%s", msg.String())
+		return
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("nil error output from parser for oversized header section")
+			return
+		}
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for error for oversized header section")
+		return
+	}
+
+	testsPassed++
+}
+
+// Test that a header line which never ends is cut off at the configured maximum, rather than buffered without limit.
+func TestStreamedParseMaxHeaderBytesUnterminated(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.SetMaxHeaderBytes(1024)
+
+	go func() {
+		p.Write([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\nSubject: "))
+		chunk := []byte(strings.Repeat("x", 100))
+		for idx := 0; idx < 10000; idx++ {
+			if _, err := p.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case msg := <-output:
+		t.Errorf("expected error for unterminated oversized header; got message:\n%s", msg.String())
+		return
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "exceeds maximum size") {
+			t.Errorf("unexpected error for unterminated oversized header: %s", err.Error())
+			return
+		}
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for error for unterminated oversized header")
+		return
+	}
+
+	testsPassed++
+}
+
+// Test that a header section within the configured maximum is parsed as normal.
+func TestStreamedParseWithinMaxHeaderBytes(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.SetMaxHeaderBytes(1024)
+
+	p.Write([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Subject: This header is\r\n" +
+		" folded but short\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+
+	select {
+	case msg := <-output:
+		if subject := msg.Headers("Subject"); len(subject) != 1 ||
+			subject[0].String() != "Subject: This header is folded but short" {
+			t.Errorf("unexpected Subject in parsed message:\n%s", msg.String())
+			return
+		}
+	case err := <-errs:
+		t.Errorf("unexpected error parsing message: %s", err.Error())
+		return
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for message")
+		return
+	}
+
+	testsPassed++
+}
+
+// Test that ParseStream parses concatenated messages from a reader, however the reads split them up.
+func TestParseStream(t *testing.T) {
+	testsRun++
+	data := "INVITE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 5\r\n\r\nHello" +
+		"SIP/2.0 200 OK\r\nContent-Length: 0\r\n\r\n"
+
+	for _, reader := range []io.Reader{
+		strings.NewReader(data),
+		iotest.OneByteReader(strings.NewReader(data)),
+		iotest.DataErrReader(iotest.HalfReader(strings.NewReader(data))),
+	} {
+		output := make(chan base.SipMessage, 2)
+		errs := make(chan error, 1)
+		done := make(chan struct{})
+		go func() {
+			ParseStream(reader, output, errs)
+			close(done)
+		}()
+
+		for _, expected := range []string{"INVITE sip:bob@biloxi.com SIP/2.0", "SIP/2.0 200 OK"} {
+			select {
+			case msg := <-output:
+				if !strings.HasPrefix(msg.String(), expected) {
+					t.Errorf("unexpected message from stream; expected %q, got:\n%s", expected, msg.String())
+					return
+				}
+			case err := <-errs:
+				t.Errorf("unexpected error parsing stream: %s", err.Error())
+				return
+			case <-time.After(time.Second * 1):
+				t.Errorf("timeout waiting for %q from stream", expected)
+				return
+			}
+		}
+
+		select {
+		case <-done:
+		case err := <-errs:
+			t.Errorf("unexpected error at end of stream: %s", err.Error())
+			return
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout waiting for ParseStream to return at end of stream")
+			return
+		}
+	}
+
+	testsPassed++
+}
+
+// Test that a stream which ends part-way through a message produces an error.
+func TestParseStreamTruncated(t *testing.T) {
+	testsRun++
+	for _, data := range []string{
+		"INVITE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 5\r\n\r\nHel",
+		"INVITE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 5\r\n",
+		"SIP/2.0 200 OK\r\nContent-Length: 0\r\n\r\nINVITE sip:bob@bil",
+	} {
+		output := make(chan base.SipMessage, 2)
+		errs := make(chan error, 1)
+		go ParseStream(strings.NewReader(data), output, errs)
+
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Errorf("nil error output from ParseStream for truncated stream %q", data)
+				return
+			}
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout waiting for error from truncated stream %q", data)
+			return
+		}
+	}
+
+	testsPassed++
+}
+
+// Test that unknown methods are only rejected when strict method checking is enabled.
+func TestStrictMethods(t *testing.T) {
+	tests := []struct {
+		method  string
+		strict  bool
+		success bool
+	}{
+		{"INVITE", false, true},
+		{"PRACK", true, true},
+		{"update", true, true},
+		{"FOOBAR", false, true},
+		{"FOOBAR", true, false},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+
+		p := NewParser(output, errs, true)
+		p.SetStrictMethods(test.strict)
+		p.Write([]byte(test.method + " sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 0\r\n\r\n"))
+
+		select {
+		case msg := <-output:
+			if !test.success {
+				t.Errorf("expected error for method %s with strict=%t; got message:\n%s",
+					test.method, test.strict, msg.String())
+			} else if method := msg.(*base.Request).Method; string(method) != strings.ToUpper(test.method) {
+				t.Errorf("unexpected method: expected %s, got %s", strings.ToUpper(test.method), method)
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			if test.success {
+				t.Errorf("unexpected error for method %s with strict=%t: %s", test.method, test.strict, err.Error())
+			} else {
+				testsPassed++
+			}
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing request with method %s with strict=%t", test.method, test.strict)
+		}
+
+		p.Stop()
+	}
+}
+
+// Test that malformed request lines are rejected by default, but accepted in lenient mode.
+func TestLenientRequestLine(t *testing.T) {
+	tests := []struct {
+		requestLine string
+		lenient     bool
+		success     bool
+		uri         string
+	}{
+		{"INVITE sip:bob@biloxi.com SIP/2.0", false, true, "sip:bob@biloxi.com"},
+		{"INVITE sip:bob@biloxi.com SIP/2.0", true, true, "sip:bob@biloxi.com"},
+		{"INVITE  sip:bob@biloxi.com  SIP/2.0", false, false, ""},
+		{"INVITE  sip:bob@biloxi.com  SIP/2.0", true, true, "sip:bob@biloxi.com"},
+		{"INVITE sip:bob smith@biloxi.com SIP/2.0", false, false, ""},
+		{"INVITE sip:bob smith@biloxi.com SIP/2.0", true, true, "sip:bob%20smith@biloxi.com"},
+		{"INVITE sip:bob@biloxi.com", true, false, ""},
+		{"INVITE   SIP/2.0", true, false, ""},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+
+		p := NewParser(output, errs, true)
+		p.SetLenientRequestLine(test.lenient)
+		p.Write([]byte(test.requestLine + "\r\nContent-Length: 0\r\n\r\n"))
+
+		select {
+		case msg := <-output:
+			request := msg.(*base.Request)
+			if !test.success {
+				t.Errorf("expected error for request line '%s' with lenient=%t; got message:\n%s",
+					test.requestLine, test.lenient, msg.String())
+			} else if request.Recipient.String() != test.uri || request.SipVersion != "SIP/2.0" {
+				t.Errorf("unexpected request line for '%s': got URI %s and version %s",
+					test.requestLine, request.Recipient.String(), request.SipVersion)
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			if test.success {
+				t.Errorf("unexpected error for request line '%s' with lenient=%t: %s",
+					test.requestLine, test.lenient, err.Error())
+			} else {
+				testsPassed++
+			}
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing request line '%s' with lenient=%t", test.requestLine, test.lenient)
+		}
+
+		p.Stop()
+	}
+}
+
+// Test that only SIP and SIPS URIs are accepted as the Request-URI, even though other schemes can be parsed.
+func TestRequestUriSchemes(t *testing.T) {
+	tests := []struct {
+		uri     string
+		success bool
+	}{
+		{"sip:bob@biloxi.com", true},
+		{"sips:bob@biloxi.com", true},
+		{"http://example.com/x", false},
+		{"mailto:foo@bar", false},
+		{"tel:+14085551212", false},
+		{"*", false},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		msg, err := ParseMessage([]byte("OPTIONS " + test.uri + " SIP/2.0\r\nContent-Length: 0\r\n\r\n"))
+		if test.success && err != nil {
+			t.Errorf("unexpected error parsing request to %s: %s", test.uri, err.Error())
+		} else if !test.success && err == nil {
+			t.Errorf("expected error parsing request to %s; got message:\n%s", test.uri, msg.String())
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+// Test that tel URIs are only accepted as the Request-URI when enabled.
+func TestTelRequestUris(t *testing.T) {
+	tests := []struct {
+		uri     string
+		tel     bool
+		success bool
+	}{
+		{"tel:+15551234567", false, false},
+		{"tel:+15551234567", true, true},
+		{"tel:7042;phone-context=example.com", true, true},
+		{"sip:bob@biloxi.com", true, true},
+		{"mailto:foo@bar", true, false},
+		{"*", true, false},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+
+		p := NewParser(output, errs, false)
+		p.SetTelRequestUris(test.tel)
+		p.Write([]byte("MESSAGE " + test.uri + " SIP/2.0\r\nContent-Length: 0\r\n\r\n"))
+
+		select {
+		case msg := <-output:
+			if !test.success {
+				t.Errorf("expected error parsing request to %s with tel=%t; got message:\n%s", test.uri, test.tel, msg.String())
+			} else if recipient := msg.(*base.Request).Recipient; recipient.String() != test.uri {
+				t.Errorf("unexpected Request-URI: expected %s, got %s", test.uri, recipient.String())
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			if test.success {
+				t.Errorf("unexpected error parsing request to %s with tel=%t: %s", test.uri, test.tel, err.Error())
+			} else {
+				testsPassed++
+			}
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing request to %s with tel=%t", test.uri, test.tel)
+		}
+
+		p.Stop()
+	}
+}
+
+// Test that headers of different types written interleaved are serialized in the order they were parsed,
+// rather than grouped by type.
+func TestHeaderOrderRoundTrip(t *testing.T) {
+	testsRun++
+	msg := "INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds\r\n" +
+		"Route: <sip:p1.example.com;lr>\r\n" +
+		"Contact: <sip:alice@pc33.atlanta.com>\r\n" +
+		"Via: SIP/2.0/UDP bigbox3.site3.atlanta.com;branch=z9hG4bK77ef4c2312983.1\r\n" +
+		"Route: <sip:p2.example.com;lr>\r\n" +
+		"Contact: <sip:alice@192.0.2.4>\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n"
+
+	parsed, err := ParseMessage([]byte(msg))
+	if err != nil {
+		t.Errorf("unexpected error parsing message: %s", err.Error())
+		return
+	}
+
+	var buffer bytes.Buffer
+	if err := base.WriteMessage(&buffer, parsed); err != nil {
+		t.Errorf("unexpected error writing message: %s", err.Error())
+		return
+	}
+
+	if buffer.String() != msg {
+		t.Errorf("header order not preserved on round trip.\nExpected:\n%q\nGot:\n%q", msg, buffer.String())
+		return
+	}
+	testsPassed++
+}
+
+// Test that a multi-hop Via header can be written either as a comma list or as separate lines, and that both
+// parse back to the same hops.
+func TestViaStyleRoundTrip(t *testing.T) {
+	commaList := "SIP/2.0 200 OK\r\n" +
+		"Via: SIP/2.0/UDP p1.example.com;branch=z9hG4bK1, SIP/2.0/TCP p2.example.com:5070;branch=z9hG4bK2\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n"
+	separateLines := "SIP/2.0 200 OK\r\n" +
+		"Via: SIP/2.0/UDP p1.example.com;branch=z9hG4bK1\r\n" +
+		"Via: SIP/2.0/TCP p2.example.com:5070;branch=z9hG4bK2\r\n" +
+		"Content-Length: 0\r\n" +
+		"\r\n"
+
+	tests := []struct {
+		style    base.ViaStyle
+		expected string
+	}{
+		{base.ViaCommaList, commaList},
+		{base.ViaSeparateLines, separateLines},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		parsed, err := ParseMessage([]byte(commaList))
+		if err != nil {
+			t.Errorf("unexpected error parsing message: %s", err.Error())
+			continue
+		}
+
+		var buffer bytes.Buffer
+		if err := base.WriteMessageWithViaStyle(&buffer, parsed, test.style); err != nil {
+			t.Errorf("unexpected error writing message: %s", err.Error())
+			continue
+		}
+		if buffer.String() != test.expected {
+			t.Errorf("Via style %d: expected:\n%q\nGot:\n%q", test.style, test.expected, buffer.String())
+			continue
+		}
+
+		reparsed, err := ParseMessage(buffer.Bytes())
+		if err != nil {
+			t.Errorf("Via style %d: unexpected error reparsing message: %s", test.style, err.Error())
+			continue
+		}
+		var hops []string
+		for _, h := range reparsed.Headers("Via") {
+			for _, hop := range *(h.(*base.ViaHeader)) {
+				hops = append(hops, hop.String())
+			}
+		}
+		expectedHops := []string{
+			"SIP/2.0/UDP p1.example.com;branch=z9hG4bK1",
+			"SIP/2.0/TCP p2.example.com:5070;branch=z9hG4bK2",
+		}
+		if strings.Join(hops, ", ") != strings.Join(expectedHops, ", ") {
+			t.Errorf("Via style %d: expected hops %v after round trip; got %v", test.style, expectedHops, hops)
+			continue
+		}
+		testsPassed++
+	}
+}
+
+// Test that ParseMessageN parses back-to-back messages from a single buffer, reporting the bytes each consumed.
+func TestParseMessageN(t *testing.T) {
+	testsRun++
+	first := "MESSAGE sip:bob@biloxi.com SIP/2.0\r\nCall-ID: abc\r\nContent-Length: 5\r\n\r\nHello"
+	second := "SIP/2.0 200 OK\r\nCall-ID: abc\r\nContent-Length: 0\r\n\r\n"
+	data := []byte(first + second)
+
+	msg, n, err := ParseMessageN(data)
+	if err != nil {
+		t.Errorf("unexpected error parsing first message: %s", err.Error())
+		return
+	}
+	if n != len(first) {
+		t.Errorf("expected first message to consume %d bytes; got %d", len(first), n)
+		return
+	}
+	if request, ok := msg.(*base.Request); !ok || request.Body != "Hello" {
+		t.Errorf("unexpected first message:\n%s", msg.String())
+		return
+	}
+
+	msg, n, err = ParseMessageN(data[n:])
+	if err != nil {
+		t.Errorf("unexpected error parsing second message: %s", err.Error())
+		return
+	}
+	if n != len(second) {
+		t.Errorf("expected second message to consume %d bytes; got %d", len(second), n)
+		return
+	}
+	if _, ok := msg.(*base.Response); !ok {
+		t.Errorf("unexpected second message:\n%s", msg.String())
+		return
+	}
+
+	// Incomplete messages should ask for more data, whether they are cut off in the headers or in the body.
+	for _, partial := range []string{first[:20], first[:len(first)-1]} {
+		if _, _, err := ParseMessageN([]byte(partial)); err != io.ErrUnexpectedEOF {
+			t.Errorf("expected io.ErrUnexpectedEOF parsing partial message %q; got %v", partial, err)
+			return
+		}
+	}
+	testsPassed++
+}
+
+// Test that a multipart body carrying SDP and ISUP is split into its parts, each with its own headers.
+func TestParseMultipartBody(t *testing.T) {
+	testsRun++
+	contentType := base.ContentType{"multipart/mixed", base.NewParams().Add("boundary", base.String{"unique-boundary-1"})}
+	body := "This is a preamble.\r\n" +
+		"--unique-boundary-1\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"\r\n" +
+		"v=0\r\n" +
+		"o=alice 2890844526 2890844526 IN IP4 atlanta.com\r\n" +
+		"\r\n" +
+		"--unique-boundary-1\r\n" +
+		"Content-Type: application/ISUP;version=itu-t92+\r\n" +
+		"Content-Disposition: signal;handling=optional\r\n" +
+		"\r\n" +
+		"\x01\x00\x49\x00\x00\x03\x02\x00\x07\r\n" +
+		"--unique-boundary-1--\r\n"
+
+	parts, err := ParseMultipartBody(contentType, body)
+	if err != nil {
+		t.Errorf("unexpected error parsing multipart body: %s", err.Error())
+		return
+	}
+	if len(parts) != 2 {
+		t.Errorf("expected 2 body parts; got %d", len(parts))
+		return
+	}
+
+	expected := []struct {
+		mediaType  string
+		numHeaders int
+		content    string
+	}{
+		{"application/sdp", 1, "v=0\r\no=alice 2890844526 2890844526 IN IP4 atlanta.com\r\n"},
+		{"application/ISUP", 2, "\x01\x00\x49\x00\x00\x03\x02\x00\x07"},
+	}
+	for idx, part := range parts {
+		if len(part.Headers) != expected[idx].numHeaders {
+			t.Errorf("expected %d headers in part %d; got %d", expected[idx].numHeaders, idx, len(part.Headers))
+			return
+		}
+		if partType, ok := part.Headers[0].(*base.ContentType); !ok || partType.MediaType != expected[idx].mediaType {
+			t.Errorf("expected part %d to have Content-Type %s; got %s", idx, expected[idx].mediaType, part.Headers[0].String())
+			return
+		}
+		if part.Content != expected[idx].content {
+			t.Errorf("unexpected content in part %d: expected %q, got %q", idx, expected[idx].content, part.Content)
+			return
+		}
+	}
+
+	// Bodies which are not multipart, or which are missing their closing delimiter, cannot be split.
+	if _, err := ParseMultipartBody(base.ContentType{"application/sdp", base.NewParams()}, body); err == nil {
+		t.Errorf("expected error splitting body of non-multipart type")
+		return
+	}
+	if _, err := ParseMultipartBody(contentType, body[:len(body)-6]); err == nil {
+		t.Errorf("expected error splitting body with no closing delimiter")
+		return
+	}
+	testsPassed++
+}
+
+// Test that in frame-per-write mode, each write is parsed as one complete message, with the body running to the
+// end of the write, so that messages without a Content-Length can be parsed on streamed and unstreamed parsers alike.
+func TestFramePerWrite(t *testing.T) {
+	frames := []struct {
+		data string
+		body string
+	}{
+		{"MESSAGE sip:bob@biloxi.com SIP/2.0\r\nSubject: Hi\r\n\r\nHello\r\n\r\nBob", "Hello\r\n\r\nBob"},
+		{"SIP/2.0 200 OK\r\nCall-ID: abc\r\n\r\n", ""},
+		{"MESSAGE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 3\r\n\r\nBye", "Bye"},
+	}
+
+	for _, streamed := range []bool{true, false} {
+		testsRun++
+		output := make(chan base.SipMessage, len(frames))
+		errs := make(chan error, 1)
+
+		p := NewParser(output, errs, streamed)
+		p.SetFramePerWrite(true)
+		for _, frame := range frames {
+			p.Write([]byte(frame.data))
+		}
+
+		passed := true
+		for _, frame := range frames {
+			select {
+			case msg := <-output:
+				var body string
+				switch msg := msg.(type) {
+				case *base.Request:
+					body = msg.Body
+				case *base.Response:
+					body = msg.Body
+				}
+				if body != frame.body {
+					t.Errorf("unexpected body parsing frame %q with streamed=%t: expected %q, got %q",
+						frame.data, streamed, frame.body, body)
+					passed = false
+				}
+			case err := <-errs:
+				t.Errorf("unexpected error parsing frame %q with streamed=%t: %s", frame.data, streamed, err.Error())
+				passed = false
+			case <-time.After(time.Second * 1):
+				t.Errorf("timeout parsing frame %q with streamed=%t", frame.data, streamed)
+				passed = false
+			}
+			if !passed {
+				break
+			}
+		}
+
+		p.Stop()
+		if passed {
+			testsPassed++
+		}
+	}
+}
+
+// Test that the header error callback fires for each malformed header, while valid headers are still parsed.
+func TestOnHeaderError(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+
+	var failed []string
+	p := NewParser(output, errs, true)
+	p.SetOnHeaderError(func(headerText string, err error) {
+		failed = append(failed, headerText)
+	})
+	p.Write([]byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\n" +
+		"CSeq: abc OPTIONS\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"Max-Forwards: many\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	defer p.Stop()
+
+	select {
+	case msg := <-output:
+		if len(failed) != 2 || failed[0] != "CSeq: abc OPTIONS" || failed[1] != "Max-Forwards: many" {
+			t.Errorf("expected callback for the CSeq and Max-Forwards headers; got %q", failed)
+			return
+		}
+		if callIds := msg.Headers("Call-Id"); len(callIds) != 1 {
+			t.Errorf("expected valid Call-ID header to be parsed; got message:\n%s", msg.String())
+			return
+		}
+	case err := <-errs:
+		t.Errorf("unexpected error parsing message: %s", err.Error())
+		return
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout parsing message")
+		return
+	}
+	testsPassed++
+}
+
+// Test that headers containing invalid UTF-8 are kept by default, but discarded when such headers are rejected.
+func TestRejectInvalidUTF8(t *testing.T) {
+	msg := "OPTIONS sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Subject: caf\xc3\x28\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"Content-Length: 0\r\n\r\n"
+
+	for _, reject := range []bool{false, true} {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+
+		var failed []string
+		p := NewParser(output, errs, true)
+		p.SetRejectInvalidUTF8(reject)
+		p.SetOnHeaderError(func(headerText string, err error) {
+			failed = append(failed, headerText)
+		})
+		p.Write([]byte(msg))
+
+		select {
+		case parsed := <-output:
+			subjects := parsed.Headers("Subject")
+			if reject && (len(subjects) != 0 || len(failed) != 1 || failed[0] != "Subject: caf\xc3\x28") {
+				t.Errorf("expected invalid Subject header to be rejected; got errors for %q and message:\n%s",
+					failed, parsed.String())
+			} else if !reject && (len(subjects) != 1 || len(failed) != 0) {
+				t.Errorf("expected invalid Subject header to be kept; got errors for %q and message:\n%s",
+					failed, parsed.String())
+			} else if len(parsed.Headers("Call-Id")) != 1 {
+				t.Errorf("expected valid Call-ID header to be parsed; got message:\n%s", parsed.String())
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			t.Errorf("unexpected error parsing message with reject=%t: %s", reject, err.Error())
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing message with reject=%t", reject)
+		}
+
+		p.Stop()
+	}
+}
+
+// Test that messages using bare LF line endings parse when bare LFs are accepted, leaving the body untouched.
+// Test that in frame-per-write mode, the body is delimited correctly when header lines end in bare LFs.
+func TestFramePerWriteBareLF(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.SetFramePerWrite(true)
+	p.SetAcceptBareLF(true)
+
+	p.Write([]byte("MESSAGE sip:bob@biloxi.com SIP/2.0\nCall-Id: abc@x\r\nCSeq: 1 MESSAGE\n\nhello"))
+
+	select {
+	case parsed := <-output:
+		if body := parsed.(*base.Request).Body; body != "hello" {
+			t.Errorf("expected body 'hello' from frame with bare LFs; got %q", body)
+		} else {
+			testsPassed++
+		}
+	case err := <-errs:
+		t.Errorf("unexpected error parsing frame with bare LFs: %s", err.Error())
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout parsing frame with bare LFs")
+	}
+}
+
+func TestAcceptBareLF(t *testing.T) {
+	msg := "INVITE sip:bob@biloxi.com SIP/2.0\n" +
+		"Call-ID: a84b4c76e66710\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"Subject: lunch\n" +
+		" tomorrow?\n" +
+		"Content-Length: 10\n" +
+		"\n" +
+		"v=0\no=bob\n"
+
+	for _, streamed := range []bool{false, true} {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+
+		p := NewParser(output, errs, streamed)
+		p.SetAcceptBareLF(true)
+		p.Write([]byte(msg))
+
+		select {
+		case parsed := <-output:
+			expected := "INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+				"Call-Id: a84b4c76e66710\r\n" +
+				"CSeq: 314159 INVITE\r\n" +
+				"Subject: lunch tomorrow?\r\n" +
+				"Content-Length: 10\r\n" +
+				"\r\n" +
+				"v=0\no=bob\n"
+			if parsed.String() != expected {
+				t.Errorf("unexpected message parsed from bare LFs with streamed=%t: expected\n%q\ngot\n%q",
+					streamed, expected, parsed.String())
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			t.Errorf("unexpected error parsing message with bare LFs with streamed=%t: %s", streamed, err.Error())
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing message with bare LFs with streamed=%t", streamed)
+		}
+
+		p.Stop()
+	}
+
+	// By default, bare LFs do not end lines, so the parser is still waiting for the end of the start line.
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.Write([]byte(msg))
+
+	select {
+	case parsed := <-output:
+		t.Errorf("unexpectedly parsed message with bare LFs by default:\n%s", parsed.String())
+	case <-errs:
+		testsPassed++
+	case <-time.After(time.Millisecond * 100):
+		testsPassed++
+	}
+}
+
+// Test that folded header lines are unfolded by default, but cause a terminal error when obs-fold is rejected.
+// Test that folded headers, and headers with extra whitespace around their values, parse exactly as their plain
+// single-line equivalents do (RFC 3261 s. 7.3.1).
+func TestFoldedHeadersMatchUnfolded(t *testing.T) {
+	tests := []struct {
+		folded   string
+		unfolded string
+	}{
+		{"Contact:\r\n <sip:a@b>", "Contact: <sip:a@b>"},
+		{"Contact: \t\r\n\t<sip:a@b>;expires=5", "Contact: <sip:a@b>;expires=5"},
+		{"From: Bob\r\n <sip:a@b>;tag=1", "From: Bob <sip:a@b>;tag=1"},
+		{"To:\t<sip:a@b>;tag=1 ", "To: <sip:a@b>;tag=1"},
+		{"CSeq: 1  \r\n   INVITE", "CSeq: 1 INVITE"},
+		{"CSeq:\r\n\t1\tINVITE", "CSeq: 1 INVITE"},
+		{"Call-ID:\r\n abc@def\t", "Call-ID: abc@def"},
+		{"Via: SIP/2.0/UDP\r\n host;branch=z9hG4bK1", "Via: SIP/2.0/UDP host;branch=z9hG4bK1"},
+		{"Via: SIP/2.0/UDP host;branch=z9hG4bK1,\r\n SIP/2.0/UDP h2", "Via: SIP/2.0/UDP host;branch=z9hG4bK1, SIP/2.0/UDP h2"},
+		{"Max-Forwards:\t\r\n 70", "Max-Forwards: 70"},
+		{"Subject: Need \r\n  more boxes", "Subject: Need more boxes"},
+		{"X-Custom: a  \r\n\t b", "X-Custom: a b"},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		var results [2]string
+		for idx, header := range []string{test.folded, test.unfolded} {
+			msg, err := ParseMessage([]byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\n" + header +
+				"\r\nContent-Length: 0\r\n\r\n"))
+			if err != nil {
+				results[idx] = "error: " + err.Error()
+			} else {
+				results[idx] = msg.String()
+			}
+		}
+
+		if results[0] != results[1] {
+			t.Errorf("header %q parsed differently to %q:\n%q\n%q", test.folded, test.unfolded, results[0], results[1])
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+func TestRejectObsFold(t *testing.T) {
+	msg := "OPTIONS sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Subject: I know you're there,\r\n" +
+		"  pick up the phone\r\n" +
+		"Content-Length: 0\r\n\r\n"
+
+	for _, reject := range []bool{false, true} {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+
+		p := NewParser(output, errs, true)
+		p.SetRejectObsFold(reject)
+		p.Write([]byte(msg))
+
+		select {
+		case parsed := <-output:
+			if reject {
+				t.Errorf("expected error parsing folded header with obs-fold rejected; got message:\n%s", parsed.String())
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			if reject {
+				testsPassed++
+			} else {
+				t.Errorf("unexpected error parsing folded header: %s", err.Error())
+			}
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing folded header with reject=%t", reject)
+		}
+		p.Stop()
+	}
+}
+
+// Test that empty lines before a message are skipped, and that a bare double-CRLF keepalive is ignored.
+func TestLeadingEmptyLines(t *testing.T) {
+	msg := "MESSAGE sip:bob@biloxi.com SIP/2.0\r\nCall-Id: abc\r\nContent-Length: 5\r\n\r\nHello"
+
+	for _, streamed := range []bool{true, false} {
+		testsRun++
+		output := make(chan base.SipMessage, 2)
+		errs := make(chan error, 2)
+		p := NewParser(output, errs, streamed)
+
+		if n, err := p.Write([]byte("\r\n\r\n")); err != nil || n != 4 {
+			t.Errorf("streamed=%t: unexpected result writing keepalive: %d, %v", streamed, n, err)
+		}
+		p.Write([]byte("\r\n\r\n\r\n" + msg))
+
+		select {
+		case parsed := <-output:
+			if parsed.String() != msg {
+				t.Errorf("streamed=%t: unexpected message after empty lines:\n%s", streamed, parsed.String())
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			t.Errorf("streamed=%t: unexpected error: %s", streamed, err.Error())
+		case <-time.After(time.Second * 1):
+			t.Errorf("streamed=%t: timeout waiting for message", streamed)
+		}
+
+		select {
+		case err := <-errs:
+			t.Errorf("streamed=%t: unexpected error: %s", streamed, err.Error())
+		case extra := <-output:
+			t.Errorf("streamed=%t: unexpected extra message:\n%s", streamed, extra.String())
+		default:
+		}
+		p.Stop()
+	}
+
+	testsRun++
+	if parsed, err := ParseMessage([]byte("\r\n" + msg)); err != nil {
+		t.Errorf("unexpected error parsing message after empty line: %s", err.Error())
+	} else if parsed.(*base.Request).Body != "Hello" {
+		t.Errorf("unexpected body '%s' parsing message after empty line", parsed.(*base.Request).Body)
+	} else {
+		testsPassed++
+	}
+}
+
+// Test that parsing data which holds only empty lines returns an error rather than blocking.
+func TestParseOnlyEmptyLines(t *testing.T) {
+	msg := "MESSAGE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 5\r\n\r\nHello"
+
+	for _, input := range []string{"", "\r\n", "\r\n\r\n", "\r\n\r\n\r\n"} {
+		testsRun++
+		done := make(chan error, 1)
+		go func() {
+			_, err := ParseMessage([]byte(input))
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("expected error parsing %q", input)
+			} else {
+				testsPassed++
+			}
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout parsing %q", input)
+		}
+	}
+
+	testsRun++
+	type result struct {
+		msg base.SipMessage
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		parsed, n, err := ParseMessageN([]byte("\r\n\r\n" + msg + "\r\n\r\n"))
+		done <- result{parsed, n, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			t.Errorf("unexpected error from ParseMessageN after keepalive: %s", res.err.Error())
+		} else if res.n != len(msg)+4 || res.msg.(*base.Request).Body != "Hello" {
+			t.Errorf("unexpected result from ParseMessageN after keepalive: %d bytes, %v", res.n, res.msg)
+		} else {
+			testsPassed++
+		}
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout running ParseMessageN after keepalive")
+	}
+}
+
+// Test that a CRLFCRLF keepalive ping on a streamed parser invokes the ping callback, and produces no message.
+func TestOnPing(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	pings := make(chan bool, 2)
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.SetOnPing(func() { pings <- true })
+
+	p.Write([]byte("\r\n\r\n"))
+
+	select {
+	case <-pings:
+	case msg := <-output:
+		t.Errorf("unexpected message from ping:\n%s", msg.String())
+		return
+	case err := <-errs:
+		t.Errorf("unexpected error from ping: %s", err.Error())
+		return
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for ping callback")
+		return
+	}
+
+	p.Write([]byte("MESSAGE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 0\r\n\r\n"))
+
+	select {
+	case <-output:
+	case err := <-errs:
+		t.Errorf("unexpected error after ping: %s", err.Error())
+		return
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for message after ping")
+		return
+	}
+
+	if len(pings) != 0 {
+		t.Errorf("ping callback invoked more than once")
+		return
+	}
+	testsPassed++
+}
+
+// Test that two single-CRLF pongs, which a client receives in reply to its own pings, do not count as a ping.
+func TestOnPingIgnoresPongs(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	pings := make(chan bool, 2)
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+	p.SetOnPing(func() { pings <- true })
+
+	p.Write([]byte("\r\n"))
+	p.Write([]byte("\r\n"))
+	p.Write([]byte("MESSAGE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 0\r\n\r\n"))
+
+	select {
+	case <-output:
+	case err := <-errs:
+		t.Errorf("unexpected error after pongs: %s", err.Error())
+		return
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for message after pongs")
+		return
+	}
+
+	if len(pings) != 0 {
+		t.Errorf("ping callback invoked for two separate pongs")
+		return
+	}
+	testsPassed++
+}
+
+// Test that a streamed parser with recovery enabled reports malformed messages, but resumes parsing at the next
+// start line rather than stopping.
+func TestRecoverable(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 2)
+	errs := make(chan error, 2)
+
+	p := NewParser(output, errs, true)
+	p.SetRecoverable(true)
+	defer p.Stop()
+
+	// A message with no Content-Length, whose body must be skipped.
+	p.Write([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\nCall-ID: bad1\r\n\r\nsome body\r\n"))
+	// A valid message.
+	p.Write([]byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\nCall-ID: good1\r\nContent-Length: 0\r\n\r\n"))
+	// Some line noise which is not a SIP message at all.
+	p.Write([]byte("GARBAGE\r\n\r\n"))
+	// Another valid message, after a keepalive, which is not an error.
+	if _, err := p.Write([]byte("\r\nSIP/2.0 200 OK\r\nCall-ID: good2\r\nContent-Length: 2\r\n\r\nok")); err != nil {
+		t.Errorf("unexpected error writing to recoverable parser: %s", err.Error())
+		return
+	}
+
+	expected := []string{"Call-Id: good1", "Call-Id: good2"}
+	errorCount := 0
+	for len(expected) > 0 {
+		select {
+		case msg := <-output:
+			callIds := msg.Headers("Call-Id")
+			if len(callIds) != 1 || callIds[0].String() != expected[0] {
+				t.Errorf("expected message with %s; got:\n%s", expected[0], msg.String())
+				return
+			}
+			expected = expected[1:]
+		case <-errs:
+			errorCount++
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout waiting for message with %s", expected[0])
+			return
+		}
+	}
+
+	// The errors were sent before the messages that follow them, so any not yet received are already buffered.
+	for drained := false; !drained; {
+		select {
+		case <-errs:
+			errorCount++
+		default:
+			drained = true
+		}
+	}
+
+	if errorCount != 2 {
+		t.Errorf("expected 2 errors for the malformed messages; got %d", errorCount)
+		return
+	}
+	testsPassed++
+}
+
+func TestIdleTimeout(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 2)
+	errs := make(chan error, 2)
+
+	p := NewParser(output, errs, true)
+	p.SetIdleTimeout(50 * time.Millisecond)
+	defer p.Stop()
+
+	// A complete message, after which the parser idles between messages without timing out.
+	p.Write([]byte("OPTIONS sip:bob@biloxi.com SIP/2.0\r\nCall-ID: idle1\r\nContent-Length: 0\r\n\r\n"))
+	select {
+	case <-output:
+	case err := <-errs:
+		t.Errorf("unexpected error from parser: %s", err.Error())
+		return
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for complete message")
+		return
+	}
+
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected error from parser idling between messages: %s", err.Error())
+		return
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// A partial message, which then stalls.
+	p.Write([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\nCall-ID: idle2\r\nContent-Length: 10\r\n\r\nabc"))
+	select {
+	case msg := <-output:
+		t.Errorf("unexpected message from stalled parser:\n%s", msg.String())
+		return
+	case <-errs:
+	case <-time.After(time.Second * 1):
+		t.Errorf("stalled message did not trigger the idle timeout")
+		return
+	}
+
+	if _, err := p.Write([]byte("defghij")); err == nil {
+		t.Errorf("parser accepted input after idle timeout")
+		return
+	}
+	testsPassed++
+}
+
+func TestContentLengthPolicy(t *testing.T) {
+	tests := []struct {
+		policy ContentLengthPolicy
+		body   string
+	}{
+		{StrictContentLength, ""},
+		{FirstContentLength, "abc"},
+		{MaxContentLength, "abcde"},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+
+		p := NewParser(output, errs, true)
+		p.SetContentLengthPolicy(test.policy)
+		p.Write([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 3\r\nContent-Length: 5\r\n\r\nabcde"))
+
+		select {
+		case msg := <-output:
+			if test.policy == StrictContentLength {
+				t.Errorf("policy %d: expected error for duplicate Content-Length; got:\n%s", test.policy, msg.String())
+			} else if body := msg.(*base.Request).Body; body != test.body {
+				t.Errorf("policy %d: expected body '%s'; got '%s'", test.policy, test.body, body)
+			} else if len(msg.Headers("Content-Length")) != 2 {
+				t.Errorf("policy %d: expected both Content-Length headers to be kept", test.policy)
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			if test.policy == StrictContentLength {
+				testsPassed++
+			} else {
+				t.Errorf("policy %d: unexpected error: %s", test.policy, err.Error())
+			}
+		case <-time.After(time.Second * 1):
+			t.Errorf("policy %d: timeout waiting for parser", test.policy)
+		}
+		p.Stop()
+	}
+}
+
+// Test that malformed URIs, Via headers, addresses and request lines give ParseErrors locating the problem.
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		parse  func() error
+		header string
+		text   string
+		offset int
+	}{
+		{func() error { _, err := ParseSipUri("sup:bob@biloxi.com"); return err }, "", "sup:bob@biloxi.com", 0},
+		{func() error { _, err := ParseSipUri("sip:bob@biloxi.com:abc"); return err }, "", "sip:bob@biloxi.com:abc", 8},
+		{func() error { _, err := ParseSipUri("sip:bob@biloxi.com;a=\"b"); return err },
+			"", "sip:bob@biloxi.com;a=\"b", 18},
+		{func() error {
+			_, err := parseViaHeader("v", "SIP/2.0/UDP a.com, SIP/2.0/UDP b.com:xyz", LastDuplicateParam)
+			return err
+		},
+			"Via", "SIP/2.0/UDP a.com, SIP/2.0/UDP b.com:xyz", 31},
+		{func() error { _, err := parseViaHeader("via", "SIP/2.0 a.com", LastDuplicateParam); return err }, "Via", "SIP/2.0 a.com", 0},
+		{func() error { _, _, _, err := parseAddressValue("\"Bob\" <sip:bob@biloxi.com:abc>"); return err },
+			"", "\"Bob\" <sip:bob@biloxi.com:abc>", 15},
+		{func() error { _, _, _, err := parseAddressValue("Bob sip:bob@biloxi.com"); return err },
+			"", "Bob sip:bob@biloxi.com", 0},
+		{func() error { _, err := parseAddressHeader("t", "<sip:a@b>, <sip:c@d:xx>"); return err },
+			"To", "<sip:a@b>, <sip:c@d:xx>", 18},
+		{func() error { _, _, _, err := parseRequestLine("INVITE sip:bob@biloxi.com:abc SIP/2.0"); return err },
+			"start-line", "INVITE sip:bob@biloxi.com:abc SIP/2.0", 15},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		err := test.parse()
+		parseErr, ok := err.(*base.ParseError)
+		if !ok {
+			t.Errorf("expected ParseError for '%s'; got %#v", test.text, err)
+			continue
+		}
+		if parseErr.Header != test.header || parseErr.Text != test.text || parseErr.Offset != test.offset {
+			t.Errorf("expected ParseError for header '%s', text '%s' at offset %d; got header '%s', text '%s' "+
+				"at offset %d", test.header, test.text, test.offset, parseErr.Header, parseErr.Text, parseErr.Offset)
+			continue
+		}
+		if parseErr.Error() != parseErr.Err.Error() {
+			t.Errorf("expected ParseError to report its underlying error '%s'; got '%s'", parseErr.Err, parseErr)
+			continue
+		}
+		testsPassed++
+	}
+}
+
+func TestAuthNonceCount(t *testing.T) {
+	testsRun++
+	headers, err := parseHeader("Authorization: Digest username=\"bob\", realm=\"biloxi.com\", " +
+		"nonce=\"dcd98b7102dd2f0e\", qop=auth, nc=00000001, cnonce=\"0a4f113b\"")
+	if err != nil {
+		t.Errorf("unexpected error parsing Authorization header: %s", err.Error())
+		return
+	}
+	auth := headers[0].(*base.AuthHeader)
+
+	if nc, ok := auth.NonceCount(); !ok || nc != 1 {
+		t.Errorf("expected nonce count 1; got %d, %v", nc, ok)
+		return
+	}
+	if cnonce, ok := auth.CNonce(); !ok || cnonce != "0a4f113b" {
+		t.Errorf("expected cnonce 0a4f113b; got '%s', %v", cnonce, ok)
+		return
+	}
+
+	// The next request with the same nonce increments the count.
+	if nc := auth.IncrementNonceCount(); nc != 2 {
+		t.Errorf("expected incremented nonce count 2; got %d", nc)
+		return
+	}
+	auth.SetCNonce("f00dcafe")
+	expected := "Authorization: Digest username=\"bob\", realm=\"biloxi.com\", nonce=\"dcd98b7102dd2f0e\", " +
+		"qop=auth, nc=00000002, cnonce=\"f00dcafe\""
+	if auth.String() != expected {
+		t.Errorf("expected '%s' after incrementing nonce count; got '%s'", expected, auth.String())
+		return
+	}
+
+	auth.SetNonceCount(0x1a)
+	if !strings.Contains(auth.String(), " nc=0000001a,") {
+		t.Errorf("expected nonce count 0x1a to be formatted as 8 hex digits; got '%s'", auth.String())
+		return
+	}
+
+	// Credentials without a nonce count start counting from 1.
+	headers, _ = parseHeader("Proxy-Authorization: Digest username=\"bob\"")
+	fresh := headers[0].(*base.AuthHeader)
+	if _, ok := fresh.NonceCount(); ok {
+		t.Errorf("expected no nonce count on fresh credentials")
+		return
+	}
+	if nc := fresh.IncrementNonceCount(); nc != 1 || !strings.HasSuffix(fresh.String(), "nc=00000001") {
+		t.Errorf("expected first nonce count to be 00000001; got %d in '%s'", nc, fresh.String())
+		return
+	}
+	testsPassed++
+}
+
+// Test that a registered header parser can be looked up and decorated by a wrapper.
+func TestWrapHeaderParser(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, 1)
+	errs := make(chan error, 1)
+	p := NewParser(output, errs, false)
+	defer p.Stop()
+
+	if _, ok := p.HeaderParser("X-Unknown"); ok {
+		t.Errorf("expected no parser to be registered for X-Unknown headers")
+		return
+	}
+
+	viaParser, ok := p.HeaderParser("Via")
+	if !ok {
+		t.Errorf("expected a parser to be registered for Via headers")
+		return
+	}
+	p.SetHeaderParser("Via", func(headerName string, headerText string) ([]base.SipHeader, error) {
+		headers, err := viaParser(headerName, headerText)
+		for _, header := range headers {
+			for _, hop := range *(header.(*base.ViaHeader)) {
+				hop.Params.Add("x-seen", base.String{"yes"})
+			}
+		}
+		return headers, err
+	})
+
+	p.Write([]byte("SIP/2.0 200 OK\r\n" +
+		"Via: SIP/2.0/UDP p1.example.com;branch=z9hG4bK1, SIP/2.0/UDP p2.example.com;branch=z9hG4bK2\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+
+	select {
+	case msg := <-output:
+		hops := msg.(*base.Response).ViaHops()
+		if len(hops) != 2 {
+			t.Errorf("expected 2 Via hops; got %d", len(hops))
+			return
+		}
+		for _, hop := range hops {
+			if seen, ok := hop.Params.Get("x-seen"); !ok || seen != (base.String{"yes"}) {
+				t.Errorf("expected hop '%s' to be annotated by the wrapping parser", hop.String())
+				return
+			}
+			if _, ok := hop.Params.Get("branch"); !ok {
+				t.Errorf("expected hop '%s' to keep the parameters from the original parser", hop.String())
+				return
+			}
+		}
+	case err := <-errs:
+		t.Errorf("unexpected error: %s", err.Error())
+		return
+	case <-time.After(time.Second * 1):
+		t.Errorf("timeout waiting for message")
+		return
+	}
+	testsPassed++
+}
+
+// Test that repeated unknown headers are kept separate by default, and merged when configured.
+func TestMergeGenericHeaders(t *testing.T) {
+	msg := "OPTIONS sip:bob@biloxi.com SIP/2.0\r\n" +
+		"X-Custom: alpha\r\n" +
+		"X-Other: one\r\n" +
+		"X-Custom: beta, gamma\r\n" +
+		"X-Other: two\r\n" +
+		"Content-Length: 0\r\n\r\n"
+
+	tests := []struct {
+		merge    bool
+		expected []string
+	}{
+		{false, []string{"X-Custom: alpha", "X-Custom: beta, gamma"}},
+		{true, []string{"X-Custom: alpha, beta, gamma"}},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		output := make(chan base.SipMessage, 1)
+		errs := make(chan error, 1)
+		p := NewParser(output, errs, false)
+		if test.merge {
+			p.SetMergeGenericHeaders("X-Custom", true)
+		}
+		p.Write([]byte(msg))
+
+		select {
+		case parsed := <-output:
+			var custom []string
+			for _, header := range parsed.Headers("X-Custom") {
+				custom = append(custom, header.String())
+			}
+			if strings.Join(custom, "\n") != strings.Join(test.expected, "\n") {
+				t.Errorf("merge=%t: expected X-Custom headers %q; got %q", test.merge, test.expected, custom)
+			} else if len(parsed.Headers("X-Other")) != 2 {
+				t.Errorf("merge=%t: expected X-Other headers to be kept separate; got %v",
+					test.merge, parsed.Headers("X-Other"))
+			} else {
+				testsPassed++
+			}
+		case err := <-errs:
+			t.Errorf("merge=%t: unexpected error: %s", test.merge, err.Error())
+		case <-time.After(time.Second * 1):
+			t.Errorf("merge=%t: timeout waiting for message", test.merge)
+		}
+		p.Stop()
+	}
+}
+
+// Test that unknown headers keep the capitalization of their names through a parse and re-serialize, while
+// still being found case-insensitively.
+func TestGenericHeaderNameCase(t *testing.T) {
+	testsRun++
+	input := "MESSAGE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"X-Custom-Thing: a\r\n" +
+		"x-lower-thing: b\r\n" +
+		"X-MiXeD-tHiNg: c\r\n" +
+		"Content-Length: 0\r\n\r\n"
+
+	msg, err := ParseMessage([]byte(input))
+	if err != nil {
+		t.Errorf("unexpected error parsing message with custom headers: %s", err.Error())
+		return
+	}
+
+	if msg.String() != input {
+		t.Errorf("custom header names changed case on round-trip:\n%s", msg.String())
+		return
+	}
+	if found := msg.Header("x-custom-thing"); len(found) != 1 || found[0].String() != "X-Custom-Thing: a" {
+		t.Errorf("expected case-insensitive lookup to find 'X-Custom-Thing: a'; got %v", found)
+		return
+	}
+	testsPassed++
+}
+
+// Test that setting the body of a parsed message updates its Content-Length on the wire.
+func TestSetBodyAfterParse(t *testing.T) {
+	inputs := []string{
+		"MESSAGE sip:bob@biloxi.com SIP/2.0\r\nContent-Length: 0\r\nCall-Id: abc\r\n\r\n",
+		"SIP/2.0 200 OK\r\nContent-Length: 0\r\nCall-Id: abc\r\n\r\n",
+	}
+
+	for _, input := range inputs {
+		testsRun++
+		msg, err := ParseMessage([]byte(input))
+		if err != nil {
+			t.Errorf("unexpected error parsing message:\n%s\n%s", input, err.Error())
+			continue
+		}
+
+		msg.SetBody("hello")
+		expected := strings.Replace(input, "Content-Length: 0", "Content-Length: 5", 1) + "hello"
+		if msg.String() != expected {
+			t.Errorf("unexpected message after setting body: expected\n%s\ngot\n%s", expected, msg.String())
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+// Test that a quoted '+sip.instance' Contact parameter (RFC 5626 s. 4.1) stays quoted through a round-trip.
+func TestSipInstanceRoundTrip(t *testing.T) {
+	testsRun++
+	header := "Contact: <sip:bob@192.0.2.4>;+sip.instance=\"<urn:uuid:00000000-0000-1000-8000-000A95A0E128>\";reg-id=1"
+	headers, err := parseHeader(header)
+	if err != nil {
+		t.Errorf("unexpected error parsing '%s': %s", header, err.Error())
+	} else if len(headers) != 1 || headers[0].String() != header {
+		t.Errorf("unexpected round-trip of '%s': got %v", header, headers)
+	} else {
+		testsPassed++
+	}
+}
+
+// Test that URI params and headers containing reserved characters survive a round-trip.
+func TestUriParamRoundTrip(t *testing.T) {
+	uri := base.SipUri{User: base.String{"bob"}, Password: base.NoString{}, Host: "biloxi.com",
+		UriParams: base.NewParams().Add("x", base.String{"a;b=c?d"}).Add("y", base.String{"\"quoted\" <text>"}),
+		Headers:   base.NewParams().Add("subject", base.String{"fish & chips"}).Add("to", base.String{"a=b"})}
+
+	testsRun++
+	parsed, err := ParseSipUri(uri.String())
+	if err != nil {
+		t.Errorf("unexpected error parsing '%s': %s", uri.String(), err.Error())
+	} else if !parsed.UriParams.Equals(uri.UriParams) || !parsed.Headers.Equals(uri.Headers) ||
+		parsed.String() != uri.String() {
+		t.Errorf("unexpected round-trip of '%s': got '%s'", uri.String(), parsed.String())
+	} else {
+		testsPassed++
+	}
+}
+
+// Test that a header parameter whose value contains escaped quotes and backslashes survives a round-trip.
+func TestEscapedParamRoundTrip(t *testing.T) {
+	testsRun++
+	to := &base.ToHeader{base.NoString{}, &base.SipUri{Host: "h", User: base.NoString{}, Password: base.NoString{},
+		UriParams: base.NewParams(), Headers: base.NewParams()},
+		base.NewParams().Add("x", base.String{"say \"hi\", now"}).Add("y", base.String{"a\\b"})}
+	msg, err := ParseMessage([]byte("MESSAGE sip:bob@biloxi.com SIP/2.0\r\n" + to.String() + "\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	if err != nil {
+		t.Errorf("unexpected error parsing '%s': %s", to.String(), err.Error())
+		return
+	}
+
+	headers := msg.Headers("To")
+	if len(headers) != 1 || !headers[0].(*base.ToHeader).Params.Equals(to.Params) ||
+		headers[0].String() != to.String() {
+		t.Errorf("unexpected round-trip of '%s': got %v", to.String(), headers)
+		return
+	}
+	testsPassed++
+}
+
+// Test that the Max-Forwards of a parsed request can be decremented.
+func TestDecrementParsedMaxForwards(t *testing.T) {
+	testsRun++
+	msg, err := ParseMessage([]byte("MESSAGE sip:bob@biloxi.com SIP/2.0\r\nMax-Forwards: 70\r\nCall-Id: abc\r\n\r\n"))
+	if err != nil {
+		t.Errorf("unexpected error parsing request: %s", err.Error())
+		return
+	}
+
+	request := msg.(*base.Request)
+	remaining, err := request.DecrementMaxForwards()
+	if err != nil || remaining != 69 {
+		t.Errorf("expected 69 hops remaining; got %d, %v", remaining, err)
+		return
+	}
+	expected := "MESSAGE sip:bob@biloxi.com SIP/2.0\r\nMax-Forwards: 69\r\nCall-Id: abc\r\n\r\n"
+	if request.String() != expected {
+		t.Errorf("unexpected request after decrementing Max-Forwards: expected\n%s\ngot\n%s", expected, request.String())
+		return
+	}
+	if maxForwards := request.Headers("Max-Forwards"); len(maxForwards) != 1 || maxForwards[0].String() != "Max-Forwards: 69" {
+		t.Errorf("expected a single Max-Forwards of 69; got %v", maxForwards)
+		return
+	}
+	testsPassed++
+}
+
+// Test that parsed messages report whether they carry an SDP body.
+func TestHasSDPBody(t *testing.T) {
+	sdp := "v=0\r\no=alice 2890844526 2890844526 IN IP4 pc33.atlanta.com\r\ns=-\r\n"
+	message := func(startLine string, contentType string, body string) string {
+		text := startLine + "\r\nCall-Id: a84b4c76e66710\r\n"
+		if contentType != "" {
+			text += "Content-Type: " + contentType + "\r\n"
+		}
+		return text + fmt.Sprintf("Content-Length: %d\r\n\r\n", len(body)) + body
+	}
+	invite := "INVITE sip:bob@biloxi.com SIP/2.0"
+	ok := "SIP/2.0 200 OK"
+
+	tests := []struct {
+		input  string
+		hasSDP bool
+	}{
+		{message(invite, "application/sdp", sdp), true},
+		{message(invite, "Application/SDP;charset=utf-8", sdp), true},
+		{message(ok, "application/sdp", sdp), true},
+		{message(invite, "text/plain", "hello"), false},
+		{message(invite, "application/sdp", ""), false},
+		{message(invite, "", ""), false},
+		{message(invite, "", sdp), false},
+		{"INVITE sip:bob@biloxi.com SIP/2.0\r\nc: application/sdp\r\nl: 4\r\n\r\nv=0\n", true},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		msg, err := ParseMessage([]byte(test.input))
+		if err != nil {
+			t.Errorf("unexpected error parsing message:\n%s\n%s", test.input, err.Error())
+		} else if msg.HasSDPBody() != test.hasSDP {
+			t.Errorf("expected HasSDPBody() to be %t for message:\n%s", test.hasSDP, test.input)
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+// Test that header values can be parsed on their own, given the name of the header they belong to.
+func TestParseHeaderValue(t *testing.T) {
+	p := NewParser(make(chan base.SipMessage), make(chan error), false)
+	defer p.Stop()
+
+	tests := []struct {
+		headerName string
+		value      string
+		success    bool
+		expected   string
+	}{
+		{"Via", "SIP/2.0/UDP host;branch=x", true, "Via: SIP/2.0/UDP host;branch=x"},
+		{"v", " SIP/2.0/TCP host:5070, SIP/2.0/UDP other ", true, "Via: SIP/2.0/TCP host:5070, SIP/2.0/UDP other"},
+		{"Contact", "\"Bob\" <sip:bob@biloxi.com>;expires=60", true, "Contact: \"Bob\" <sip:bob@biloxi.com>;expires=60"},
+		{"m", "<sip:bob@biloxi.com>, <sip:bob@192.0.2.4>", true,
+			"Contact: <sip:bob@biloxi.com>\nContact: <sip:bob@192.0.2.4>"},
+		{"X-Custom", "anything: at all", true, "X-Custom: anything: at all"},
+		{"Via", "SIP/2.0 host", false, ""},
+		{"Contact", "<sip:bob@biloxi.com", false, ""},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		headers, err := p.ParseHeaderValue(test.headerName, test.value)
+		if !test.success {
+			if err == nil {
+				t.Errorf("expected error parsing %s value %q; got %v", test.headerName, test.value, headers)
+			} else {
+				testsPassed++
+			}
+			continue
+		}
+
+		var result []string
+		for _, header := range headers {
+			result = append(result, header.String())
+		}
+		if err != nil {
+			t.Errorf("unexpected error parsing %s value %q: %s", test.headerName, test.value, err.Error())
+		} else if strings.Join(result, "\n") != test.expected {
+			t.Errorf("unexpected result parsing %s value %q: expected %q, got %q",
+				test.headerName, test.value, test.expected, strings.Join(result, "\n"))
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+// Test that display names containing escaped quotes and backslashes are unescaped by the parser,
+// and re-escaped when the header is stringified.
+func TestEscapedDisplayNames(t *testing.T) {
+	tests := []struct {
+		header      string
+		displayName string
+	}{
+		{"To: \"Alice \\\"the\\\" Liddell\" <sip:alice@wonderland.com>", "Alice \"the\" Liddell"},
+		{"From: \"C:\\\\Users\\\\bob\" <sip:bob@biloxi.com>;tag=1928301774", "C:\\Users\\bob"},
+		{"Contact: \"Trailing \\\\\" <sip:bob@biloxi.com>", "Trailing \\"},
+		{"Contact: \"\\\"<sip:eve@evil.com>, \\\"\" <sip:bob@biloxi.com>", "\"<sip:eve@evil.com>, \""},
+	}
+
+	for _, test := range tests {
+		testsRun++
+		headers, err := parseHeader(test.header)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test.header, err.Error())
+			continue
+		} else if len(headers) != 1 {
+			t.Errorf("expected one header from %s; got %d", test.header, len(headers))
+			continue
+		}
+
+		var displayName base.MaybeString
+		switch h := headers[0].(type) {
+		case *base.ToHeader:
+			displayName = h.DisplayName
+		case *base.FromHeader:
+			displayName = h.DisplayName
+		case *base.ContactHeader:
+			displayName = h.DisplayName
+		}
+
+		if displayName != (base.String{test.displayName}) {
+			t.Errorf("unexpected display name parsed from %s: expected %q, got %q",
+				test.header, test.displayName, strMaybeStr(displayName))
+		} else if headers[0].String() != test.header {
+			t.Errorf("header did not round-trip: expected %s, got %s", test.header, headers[0].String())
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+// Test that SIP URIs and Via headers with IPv6 hosts survive a round trip through the parser.
+func TestIPv6RoundTrip(t *testing.T) {
+	tests := []string{
+		"sip:bob@[2001:db8::1]:5060",
+		"sips:[2001:db8::1];transport=tcp?subject=lunch",
+		"sip:alice@[::1]",
+		"sip:alice@192.0.2.1:5060",
+	}
+
+	for _, test := range tests {
+		testsRun++
+		uri, err := ParseSipUri(test)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", test, err.Error())
+		} else if uri.String() != test {
+			t.Errorf("URI did not round-trip: expected %s, got %s", test, uri.String())
+		} else {
+			testsPassed++
+		}
+	}
+
+	testsRun++
+	via := "Via: SIP/2.0/UDP [2001:db8::9]:5060;branch=z9hG4bK776asdhds"
+	headers, err := parseHeader(via)
+	if err != nil {
+		t.Errorf("unexpected error parsing %s: %s", via, err.Error())
+	} else if headers[0].String() != via {
+		t.Errorf("Via did not round-trip: expected %s, got %s", via, headers[0].String())
+	} else {
+		testsPassed++
+	}
+}
+
+// Test that Via hops split over several Via lines, and over comma-separated lists within a line,
+// are all collected in order.
+func TestMultipleViaLines(t *testing.T) {
+	testsRun++
+	msg, err := ParseMessage([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP first.example.com;branch=z9hG4bK1\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Via: SIP/2.0/TCP second.example.com, SIP/2.0/UDP third.example.com:5070\r\n" +
+		"\r\n"))
+	if err != nil {
+		t.Errorf("unexpected error parsing message: %s", err.Error())
+		return
+	}
+
+	request := msg.(*base.Request)
+	if len(request.Headers("Via")) != 2 {
+		t.Errorf("expected 2 Via headers; got %d", len(request.Headers("Via")))
+		return
+	}
+
+	hops := request.ViaHops()
+	expected := []string{"first.example.com", "second.example.com", "third.example.com"}
+	if len(hops) != len(expected) {
+		t.Errorf("expected %d Via hops; got %d", len(expected), len(hops))
+		return
+	}
+	for idx, host := range expected {
+		if hops[idx].Host != host {
+			t.Errorf("unexpected host '%s' in Via hop %d - expected '%s'", hops[idx].Host, idx, host)
+			return
+		}
+	}
+
+	testsPassed++
+}
+
+type paramInput struct {
+	paramString      string
+	start            uint8
+	sep              uint8
+	end              uint8
+	quoteValues      bool
+	permitSingletons bool
+}
+
+func (data *paramInput) String() string {
+	return fmt.Sprintf("paramString=\"%s\", start=%c, sep=%c, end=%c, quoteValues=%b, permitSingletons=%b",
+		data.paramString, data.start, data.sep, data.end, data.quoteValues, data.permitSingletons)
+}
+func (data *paramInput) evaluate() result {
+	output, consumed, err := parseParams(data.paramString, data.start, data.sep, data.end, data.quoteValues, data.permitSingletons)
+	return &paramResult{err, output, consumed}
+}
+
+type paramResult struct {
+	err      error
+	params   base.Params
+	consumed int
+}
+
+func (expected *paramResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*paramResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.params.ToString('-'))
+	} else if actual.err == nil && !expected.params.Equals(actual.params) {
+		return false, fmt.Sprintf("unexpected result: expected \"%s\", got \"%s\"",
+			expected.params.ToString('-'), actual.params.ToString('-'))
+	} else if actual.err == nil && expected.consumed != actual.consumed {
+		return false, fmt.Sprintf("unexpected consumed value: expected %d, got %d", expected.consumed, actual.consumed)
+	}
+
+	return true, ""
+}
+
+type sipUriInput string
+
+func (data sipUriInput) String() string {
+	return string(data)
+}
+func (data sipUriInput) evaluate() result {
+	output, err := ParseSipUri(string(data))
+	return &sipUriResult{err, output}
+}
+
+type sipUriResult struct {
+	err error
+	uri base.SipUri
+}
+
+func (expected *sipUriResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*sipUriResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.uri.String())
+	} else if actual.err != nil {
+		// Expected error. Test passes immediately.
+		return true, ""
+	}
+
+	equal = expected.uri.Equals(&actual.uri)
+	if !equal {
+		reason = fmt.Sprintf("expected result %s, but got %s", expected.uri.String(), actual.uri.String())
+	}
+	return
+}
+
+type uriInput string
+
+func (data uriInput) String() string {
+	return string(data)
+}
+
+func (data uriInput) evaluate() result {
+	output, err := ParseUri(string(data))
+	return &uriResult{err, output}
+}
+
+type uriResult struct {
+	err error
+	uri base.Uri
+}
+
+func (expected *uriResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*uriResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.uri.String())
+	} else if actual.err != nil {
 		// Expected error. Test passes immediately.
 		return true, ""
 	}
 
-	equal = expected.uri.Equals(&actual.uri)
-	if !equal {
-		reason = fmt.Sprintf("expected result %s, but got %s", expected.uri.String(), actual.uri.String())
+	equal = expected.uri.Equals(actual.uri)
+	if !equal {
+		reason = fmt.Sprintf("expected result %s, but got %s", expected.uri.String(), actual.uri.String())
+	}
+	return
+}
+
+type hostPortInput string
+
+func (data hostPortInput) String() string {
+	return string(data)
+}
+
+func (data hostPortInput) evaluate() result {
+	host, port, err := parseHostPort(string(data))
+	return &hostPortResult{err, host, port}
+}
+
+type hostPortResult struct {
+	err  error
+	host string
+	port *uint16
+}
+
+func (expected *hostPortResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*hostPortResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err != nil {
+		// Expected failure. Return true unconditionally.
+		return true, ""
+	}
+
+	var actualStr string
+	if actual.port == nil {
+		actualStr = actual.host
+	} else {
+		actualStr = fmt.Sprintf("%s:%d", actual.host, actual.port)
+	}
+
+	if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got %s", actualStr)
+	} else if expected.host != actual.host {
+		return false, fmt.Sprintf("unexpected host part: expected \"%s\", got \"%s\"", expected.host, actual.host)
+	} else if uint16PtrStr(expected.port) != uint16PtrStr(actual.port) {
+		return false, fmt.Sprintf("unexpected port: expected %s, got %s",
+			uint16PtrStr(expected.port),
+			uint16PtrStr(actual.port))
+	}
+
+	return true, ""
+}
+
+type headerBlockInput []string
+
+func (data headerBlockInput) String() string {
+	return "['" + strings.Join([]string(data), "', '") + "']"
+}
+
+func (data headerBlockInput) evaluate() result {
+	contents, linesConsumed := getNextHeaderLine([]string(data))
+	return &headerBlockResult{contents, linesConsumed}
+}
+
+type headerBlockResult struct {
+	contents      string
+	linesConsumed int
+}
+
+func (expected *headerBlockResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*headerBlockResult))
+	if expected.contents != actual.contents {
+		return false, fmt.Sprintf("unexpected block contents: got \"%s\"; expected \"%s\"",
+			actual.contents, expected.contents)
+	} else if expected.linesConsumed != actual.linesConsumed {
+		return false, fmt.Sprintf("unexpected number of lines used: %d (expected %d)",
+			actual.linesConsumed, expected.linesConsumed)
+	}
+
+	return true, ""
+}
+
+func parseHeader(rawHeader string) (headers []base.SipHeader, err error) {
+	messages := make(chan base.SipMessage, 0)
+	errors := make(chan error, 0)
+	p := NewParser(messages, errors, false)
+	defer func() {
+		log.Debug("Stopping %p", p)
+		p.Stop()
+	}()
+
+	headers, err = (p.(*parser)).parseHeader(rawHeader)
+
+	return
+}
+
+type toHeaderInput string
+
+func (data toHeaderInput) String() string {
+	return string(data)
+}
+
+func (data toHeaderInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &toHeaderResult{err, headers[0].(*base.ToHeader)}
+	} else if len(headers) == 0 {
+		return &toHeaderResult{err, &base.ToHeader{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by To test: %s", string(data)))
+	}
+}
+
+type toHeaderResult struct {
+	err    error
+	header *base.ToHeader
+}
+
+func (expected *toHeaderResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*toHeaderResult))
+
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got:\n%s\n\n", actual.header.String())
+	} else if expected.err != nil {
+		// Expected error. Return true immediately with no further checks.
+		return true, ""
+	}
+
+	if expected.header.DisplayName != actual.header.DisplayName {
+		return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
+			strMaybeStr(expected.header.DisplayName),
+			strMaybeStr(actual.header.DisplayName))
+	}
+
+	switch expected.header.Address.(type) {
+	case *base.SipUri:
+		uri := *(expected.header.Address.(*base.SipUri))
+		urisEqual := uri.Equals(actual.header.Address)
+		msg := ""
+		if !urisEqual {
+			msg = fmt.Sprintf("unexpected result: expected %s, got %s",
+				expected.header.Address.String(), actual.header.Address.String())
+		}
+		if !urisEqual {
+			return false, msg
+		}
+	default:
+		// If you're hitting this block, then you need to do the following:
+		// - implement a package-private 'equals' method for the URI schema being tested.
+		// - add a case block above for that schema, using the 'equals' method in the same was as the existing base.SipUri block above.
+		return false, fmt.Sprintf("no support for testing Uri schema in Uri \"%s\" - fix me!", expected.header.Address)
+	}
+
+	if !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
+	}
+
+	return true, ""
+}
+
+type fromHeaderInput string
+
+func (data fromHeaderInput) String() string {
+	return string(data)
+}
+
+func (data fromHeaderInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &fromHeaderResult{err, headers[0].(*base.FromHeader)}
+	} else if len(headers) == 0 {
+		return &fromHeaderResult{err, &base.FromHeader{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by From test: %s", string(data)))
+	}
+}
+
+type fromHeaderResult struct {
+	err    error
+	header *base.FromHeader
+}
+
+func (expected *fromHeaderResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*fromHeaderResult))
+
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got:\n%s\n\n", actual.header.String())
+	} else if expected.err != nil {
+		// Expected error. Return true immediately with no further checks.
+		return true, ""
+	}
+
+	if expected.header.DisplayName != actual.header.DisplayName {
+		return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
+			strMaybeStr(expected.header.DisplayName),
+			strMaybeStr(actual.header.DisplayName))
+	}
+
+	switch expected.header.Address.(type) {
+	case *base.SipUri:
+		uri := *(expected.header.Address.(*base.SipUri))
+		urisEqual := uri.Equals(actual.header.Address)
+		msg := ""
+		if !urisEqual {
+			msg = fmt.Sprintf("unexpected result: expected %s, got %s",
+				expected.header.Address.String(), actual.header.Address.String())
+		}
+		if !urisEqual {
+			return false, msg
+		}
+	default:
+		// If you're hitting this block, then you need to do the following:
+		// - implement a package-private 'equals' method for the URI schema being tested.
+		// - add a case block above for that schema, using the 'equals' method in the same was as the existing base.SipUri block above.
+		return false, fmt.Sprintf("no support for testing Uri schema in Uri \"%s\" - fix me!", expected.header.Address)
+	}
+
+	if !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
+	}
+
+	return true, ""
+}
+
+type contactHeaderInput string
+
+func (data contactHeaderInput) String() string {
+	return string(data)
+}
+
+func (data contactHeaderInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	contactHeaders := make([]*base.ContactHeader, len(headers))
+	if len(headers) > 0 {
+		for idx, header := range headers {
+			contactHeaders[idx] = header.(*base.ContactHeader)
+		}
+		return &contactHeaderResult{err, contactHeaders}
+	} else {
+		return &contactHeaderResult{err, contactHeaders}
+	}
+}
+
+type contactHeaderResult struct {
+	err     error
+	headers []*base.ContactHeader
+}
+
+func (expected *contactHeaderResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*contactHeaderResult))
+
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err != nil {
+		// Expected error. Return true immediately with no further checks.
+		return true, ""
+	}
+
+	var buffer bytes.Buffer
+	for _, header := range actual.headers {
+		buffer.WriteString(fmt.Sprintf("\n\t%s", header))
+	}
+	buffer.WriteString("\n\n")
+	actualStr := buffer.String()
+
+	if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got: %s", actualStr)
+	}
+
+	if len(expected.headers) != len(actual.headers) {
+		return false, fmt.Sprintf("expected %d headers; got %d. Last expected header: %s. Last actual header: %s",
+			len(expected.headers), len(actual.headers),
+			expected.headers[len(expected.headers)-1].String(), actual.headers[len(actual.headers)-1].String())
+	}
+
+	for idx := range expected.headers {
+		if expected.headers[idx].DisplayName != actual.headers[idx].DisplayName {
+			return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
+				strMaybeStr(expected.headers[idx].DisplayName),
+				strMaybeStr(actual.headers[idx].DisplayName))
+		}
+
+		UrisEqual := expected.headers[idx].Address.Equals(actual.headers[idx].Address)
+		if !UrisEqual {
+			return false, fmt.Sprintf("expected Uri %#v; got Uri %#v", expected.headers[idx].Address, actual.headers[idx].Address)
+		}
+
+		if !expected.headers[idx].Params.Equals(actual.headers[idx].Params) {
+			return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+				actual.headers[idx].Params.ToString('-'),
+				expected.headers[idx].Params.ToString('-'))
+		}
+	}
+
+	return true, ""
+}
+
+type referInput string
+
+func (data referInput) String() string {
+	return string(data)
+}
+
+func (data referInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &referResult{err, headers[0]}
+	} else if len(headers) == 0 {
+		return &referResult{err, nil}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Refer-To/Referred-By test: %s", string(data)))
+	}
+}
+
+// Holds either a Refer-To or a Referred-By header.
+type referResult struct {
+	err    error
+	header base.SipHeader
+}
+
+// Extract the address fields from a Refer-To or Referred-By header.
+func referFields(header base.SipHeader) (displayName base.MaybeString, address base.Uri, params base.Params) {
+	switch h := header.(type) {
+	case *base.ReferToHeader:
+		return h.DisplayName, h.Address, h.Params
+	case *base.ReferredByHeader:
+		return h.DisplayName, h.Address, h.Params
+	default:
+		panic(fmt.Sprintf("Unexpected header type in Refer-To/Referred-By test: %s", header.String()))
+	}
+}
+
+func (expected *referResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*referResult))
+
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got:\n%s\n\n", actual.header.String())
+	} else if expected.err != nil {
+		// Expected error. Return true immediately with no further checks.
+		return true, ""
+	}
+
+	if expected.header.Name() != actual.header.Name() {
+		return false, fmt.Sprintf("unexpected header type: expected %s; got %s",
+			expected.header.Name(), actual.header.Name())
+	}
+
+	expectedName, expectedAddress, expectedParams := referFields(expected.header)
+	actualName, actualAddress, actualParams := referFields(actual.header)
+	if expectedName != actualName {
+		return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
+			strMaybeStr(expectedName), strMaybeStr(actualName))
+	} else if !expectedAddress.Equals(actualAddress) {
+		return false, fmt.Sprintf("unexpected result: expected %s, got %s",
+			expectedAddress.String(), actualAddress.String())
+	} else if !expectedParams.Equals(actualParams) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actualParams.ToString('-'), expectedParams.ToString('-'))
+	}
+
+	return true, ""
+}
+
+type routeInput string
+
+func (data routeInput) String() string {
+	return string(data)
+}
+
+func (data routeInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	return &routeResult{err, headers}
+}
+
+type routeResult struct {
+	err     error
+	headers []base.SipHeader
+}
+
+func (expected *routeResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*routeResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got %d headers", len(actual.headers))
+	} else if expected.err != nil {
+		// Expected error. Return true immediately with no further checks.
+		return true, ""
+	}
+
+	if len(expected.headers) != len(actual.headers) {
+		return false, fmt.Sprintf("unexpected number of headers: expected %d, got %d",
+			len(expected.headers), len(actual.headers))
+	}
+	for idx, header := range expected.headers {
+		if header.String() != actual.headers[idx].String() {
+			return false, fmt.Sprintf("unexpected header %d: expected \"%s\", got \"%s\"",
+				idx, header.String(), actual.headers[idx].String())
+		}
+	}
+
+	return true, ""
+}
+
+type splitByWSInput string
+
+func (data splitByWSInput) String() string {
+	return string(data)
+}
+
+func (data splitByWSInput) evaluate() result {
+	return splitByWSResult(splitByWhitespace(string(data)))
+}
+
+type splitByWSResult []string
+
+func (expected splitByWSResult) equals(other result) (equal bool, reason string) {
+	actual := other.(splitByWSResult)
+	if len(expected) != len(actual) {
+		return false, fmt.Sprintf("unexpected result length in splitByWS test: expected %d %v, got %d %v.", len(expected), expected, len(actual), actual)
+	}
+
+	for idx, e := range expected {
+		if e != actual[idx] {
+			return false, fmt.Sprintf("unexpected result at index %d in splitByWS test: expected '%s'; got '%s'", idx, e, actual[idx])
+		}
+	}
+
+	return true, ""
+}
+
+type cSeqInput string
+
+func (data cSeqInput) String() string {
+	return string(data)
+}
+
+func (data cSeqInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &cSeqResult{err, headers[0].(*base.CSeq)}
+	} else if len(headers) == 0 {
+		return &cSeqResult{err, &base.CSeq{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by base.CSeq test: %s", string(data)))
+	}
+}
+
+type cSeqResult struct {
+	err    error
+	header *base.CSeq
+}
+
+func (expected *cSeqResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*cSeqResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.SeqNo != actual.header.SeqNo {
+		return false, fmt.Sprintf("unexpected sequence number: expected \"%d\", got \"%d\"",
+			expected.header.SeqNo, actual.header.SeqNo)
+	} else if actual.err == nil && expected.header.MethodName != actual.header.MethodName {
+		return false, fmt.Sprintf("unexpected method name: expected %s, got %s", expected.header.MethodName, actual.header.MethodName)
+	}
+
+	return true, ""
+}
+
+type rSeqInput string
+
+func (data rSeqInput) String() string {
+	return string(data)
+}
+
+func (data rSeqInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &rSeqResult{err, *(headers[0].(*base.RSeq))}
+	} else if len(headers) == 0 {
+		return &rSeqResult{err, base.RSeq(0)}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by base.RSeq test: %s", string(data)))
+	}
+}
+
+type rSeqResult struct {
+	err    error
+	header base.RSeq
+}
+
+func (expected *rSeqResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*rSeqResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header != actual.header {
+		return false, fmt.Sprintf("unexpected response number: expected \"%d\", got \"%d\"",
+			expected.header, actual.header)
+	}
+
+	return true, ""
+}
+
+type sessionExpiresInput string
+
+func (data sessionExpiresInput) String() string {
+	return string(data)
+}
+
+func (data sessionExpiresInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &sessionExpiresResult{err, headers[0].(*base.SessionExpiresHeader)}
+	} else if len(headers) == 0 {
+		return &sessionExpiresResult{err, &base.SessionExpiresHeader{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Session-Expires test: %s", string(data)))
 	}
-	return
 }
 
-type hostPortInput string
+type sessionExpiresResult struct {
+	err    error
+	header *base.SessionExpiresHeader
+}
 
-func (data hostPortInput) String() string {
+func (expected *sessionExpiresResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*sessionExpiresResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.Seconds != actual.header.Seconds {
+		return false, fmt.Sprintf("unexpected session interval: expected %d, got %d",
+			expected.header.Seconds, actual.header.Seconds)
+	} else if actual.err == nil && !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
+	}
+	return true, ""
+}
+
+type minSEInput string
+
+func (data minSEInput) String() string {
 	return string(data)
 }
 
-func (data hostPortInput) evaluate() result {
-	host, port, err := parseHostPort(string(data))
-	return &hostPortResult{err, host, port}
+func (data minSEInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &minSEResult{err, *(headers[0].(*base.MinSE))}
+	} else if len(headers) == 0 {
+		return &minSEResult{err, base.MinSE(0)}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by base.MinSE test: %s", string(data)))
+	}
 }
 
-type hostPortResult struct {
+type minSEResult struct {
+	err    error
+	header base.MinSE
+}
+
+func (expected *minSEResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*minSEResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header != actual.header {
+		return false, fmt.Sprintf("unexpected minimum session interval: expected %d, got %d",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
+type rAckInput string
+
+func (data rAckInput) String() string {
+	return string(data)
+}
+
+func (data rAckInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &rAckResult{err, headers[0].(*base.RAck)}
+	} else if len(headers) == 0 {
+		return &rAckResult{err, &base.RAck{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by base.RAck test: %s", string(data)))
+	}
+}
+
+type rAckResult struct {
+	err    error
+	header *base.RAck
+}
+
+func (expected *rAckResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*rAckResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.RSeqNo != actual.header.RSeqNo {
+		return false, fmt.Sprintf("unexpected response number: expected \"%d\", got \"%d\"",
+			expected.header.RSeqNo, actual.header.RSeqNo)
+	} else if actual.err == nil && expected.header.CSeqNo != actual.header.CSeqNo {
+		return false, fmt.Sprintf("unexpected sequence number: expected \"%d\", got \"%d\"",
+			expected.header.CSeqNo, actual.header.CSeqNo)
+	} else if actual.err == nil && expected.header.MethodName != actual.header.MethodName {
+		return false, fmt.Sprintf("unexpected method name: expected %s, got %s", expected.header.MethodName, actual.header.MethodName)
+	}
+
+	return true, ""
+}
+
+type dateInput string
+
+func (data dateInput) String() string {
+	return string(data)
+}
+
+func (data dateInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &dateResult{err, headers[0].(*base.DateHeader).Time}
+	} else if len(headers) == 0 {
+		return &dateResult{err, time.Time{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by base.DateHeader test: %s", string(data)))
+	}
+}
+
+type dateResult struct {
 	err  error
-	host string
-	port *uint16
+	time time.Time
 }
 
-func (expected *hostPortResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*hostPortResult))
+func (expected *dateResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*dateResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
-	} else if expected.err != nil && actual.err != nil {
-		// Expected failure. Return true unconditionally.
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.time.String())
+	} else if actual.err == nil && !expected.time.Equal(actual.time) {
+		return false, fmt.Sprintf("unexpected date: expected \"%s\", got \"%s\"",
+			expected.time.String(), actual.time.String())
+	}
+
+	return true, ""
+}
+
+type warningInput string
+
+func (data warningInput) String() string {
+	return string(data)
+}
+
+func (data warningInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &warningResult{err, *(headers[0].(*base.WarningHeader))}
+	} else if len(headers) == 0 {
+		return &warningResult{err, base.WarningHeader{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Warning test: %s", string(data)))
+	}
+}
+
+type warningResult struct {
+	err    error
+	header base.WarningHeader
+}
+
+func (expected *warningResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*warningResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err != nil {
 		return true, ""
+	} else if len(expected.header) != len(actual.header) {
+		return false, fmt.Sprintf("unexpected number of warnings: expected %d, got %d",
+			len(expected.header), len(actual.header))
 	}
 
-	var actualStr string
-	if actual.port == nil {
-		actualStr = actual.host
+	for idx, expectedWarning := range expected.header {
+		if *expectedWarning != *actual.header[idx] {
+			return false, fmt.Sprintf("unexpected warning %d: expected %#v, got %#v",
+				idx, *expectedWarning, *actual.header[idx])
+		}
+	}
+	return true, ""
+}
+
+type timestampInput string
+
+func (data timestampInput) String() string {
+	return string(data)
+}
+
+func (data timestampInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &timestampResult{err, headers[0].(*base.TimestampHeader)}
+	} else if len(headers) == 0 {
+		return &timestampResult{err, &base.TimestampHeader{}}
 	} else {
-		actualStr = fmt.Sprintf("%s:%d", actual.host, actual.port)
+		panic(fmt.Sprintf("Multiple headers returned by Timestamp test: %s", string(data)))
 	}
+}
 
-	if expected.err != nil && actual.err == nil {
-		return false, fmt.Sprintf("unexpected success: got %s", actualStr)
-	} else if expected.host != actual.host {
-		return false, fmt.Sprintf("unexpected host part: expected \"%s\", got \"%s\"", expected.host, actual.host)
-	} else if uint16PtrStr(expected.port) != uint16PtrStr(actual.port) {
-		return false, fmt.Sprintf("unexpected port: expected %s, got %s",
-			uint16PtrStr(expected.port),
-			uint16PtrStr(actual.port))
+type timestampResult struct {
+	err    error
+	header *base.TimestampHeader
+}
+
+func (expected *timestampResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*timestampResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.String() != actual.header.String() {
+		return false, fmt.Sprintf("unexpected result: expected \"%s\", got \"%s\"",
+			expected.header.String(), actual.header.String())
+	}
+
+	return true, ""
+}
+
+type retryAfterInput string
+
+func (data retryAfterInput) String() string {
+	return string(data)
+}
+
+func (data retryAfterInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &retryAfterResult{err, headers[0].(*base.RetryAfterHeader)}
+	} else if len(headers) == 0 {
+		return &retryAfterResult{err, &base.RetryAfterHeader{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Retry-After test: %s", string(data)))
+	}
+}
+
+type retryAfterResult struct {
+	err    error
+	header *base.RetryAfterHeader
+}
+
+func (expected *retryAfterResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*retryAfterResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.Seconds != actual.header.Seconds {
+		return false, fmt.Sprintf("unexpected seconds: expected %d, got %d",
+			expected.header.Seconds, actual.header.Seconds)
+	} else if actual.err == nil && expected.header.Comment != actual.header.Comment {
+		return false, fmt.Sprintf("unexpected comment: expected \"%s\", got \"%s\"",
+			strMaybeStr(expected.header.Comment), strMaybeStr(actual.header.Comment))
+	} else if actual.err == nil && !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
+	}
+
+	return true, ""
+}
+
+type callIdInput string
+
+func (data callIdInput) String() string {
+	return string(data)
+}
+
+func (data callIdInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &callIdResult{err, *(headers[0].(*base.CallId))}
+	} else if len(headers) == 0 {
+		return &callIdResult{err, base.CallId("")}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by base.CallId test: %s", string(data)))
+	}
+}
+
+type callIdResult struct {
+	err    error
+	header base.CallId
+}
+
+func (expected callIdResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*callIdResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.String() != actual.header.String() {
+		return false, fmt.Sprintf("unexpected call ID string: expected \"%s\", got \"%s\"",
+			expected.header, actual.header)
+	}
+	return true, ""
+}
+
+type maxForwardsInput string
+
+func (data maxForwardsInput) String() string {
+	return string(data)
+}
+
+func (data maxForwardsInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &maxForwardsResult{err, *(headers[0].(*base.MaxForwards))}
+	} else if len(headers) == 0 {
+		return &maxForwardsResult{err, base.MaxForwards(0)}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Max-Forwards test: %s", string(data)))
+	}
+}
+
+type maxForwardsResult struct {
+	err    error
+	header base.MaxForwards
+}
+
+func (expected *maxForwardsResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*maxForwardsResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header != actual.header {
+		return false, fmt.Sprintf("unexpected max forwards value: expected \"%d\", got \"%d\"",
+			expected.header, actual.header)
 	}
-
 	return true, ""
 }
 
-type headerBlockInput []string
+type minExpiresInput string
 
-func (data headerBlockInput) String() string {
-	return "['" + strings.Join([]string(data), "', '") + "']"
+func (data minExpiresInput) String() string {
+	return string(data)
 }
 
-func (data headerBlockInput) evaluate() result {
-	contents, linesConsumed := getNextHeaderLine([]string(data))
-	return &headerBlockResult{contents, linesConsumed}
+func (data minExpiresInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &minExpiresResult{err, *(headers[0].(*base.MinExpires))}
+	} else if len(headers) == 0 {
+		return &minExpiresResult{err, base.MinExpires(0)}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Min-Expires test: %s", string(data)))
+	}
 }
 
-type headerBlockResult struct {
-	contents      string
-	linesConsumed int
+type minExpiresResult struct {
+	err    error
+	header base.MinExpires
 }
 
-func (expected *headerBlockResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*headerBlockResult))
-	if expected.contents != actual.contents {
-		return false, fmt.Sprintf("unexpected block contents: got \"%s\"; expected \"%s\"",
-			actual.contents, expected.contents)
-	} else if expected.linesConsumed != actual.linesConsumed {
-		return false, fmt.Sprintf("unexpected number of lines used: %d (expected %d)",
-			actual.linesConsumed, expected.linesConsumed)
+func (expected *minExpiresResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*minExpiresResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header != actual.header {
+		return false, fmt.Sprintf("unexpected min expires value: expected \"%d\", got \"%d\"",
+			expected.header, actual.header)
 	}
-
 	return true, ""
 }
 
-func parseHeader(rawHeader string) (headers []base.SipHeader, err error) {
-	messages := make(chan base.SipMessage, 0)
-	errors := make(chan error, 0)
-	p := NewParser(messages, errors, false)
-	defer func() {
-		log.Debug("Stopping %p", p)
-		p.Stop()
-	}()
-
-	headers, err = (p.(*parser)).parseHeader(rawHeader)
-
-	return
-}
-
-type toHeaderInput string
+type contentLengthInput string
 
-func (data toHeaderInput) String() string {
+func (data contentLengthInput) String() string {
 	return string(data)
 }
 
-func (data toHeaderInput) evaluate() result {
+func (data contentLengthInput) evaluate() result {
 	headers, err := parseHeader(string(data))
 	if len(headers) == 1 {
-		return &toHeaderResult{err, headers[0].(*base.ToHeader)}
+		return &contentLengthResult{err, *(headers[0].(*base.ContentLength))}
 	} else if len(headers) == 0 {
-		return &toHeaderResult{err, &base.ToHeader{}}
+		return &contentLengthResult{err, base.ContentLength(0)}
 	} else {
-		panic(fmt.Sprintf("Multiple headers returned by To test: %s", string(data)))
+		panic(fmt.Sprintf("Multiple headers returned by Content-Length test: %s", string(data)))
 	}
 }
 
-type toHeaderResult struct {
+type contentLengthResult struct {
 	err    error
-	header *base.ToHeader
+	header base.ContentLength
 }
 
-func (expected *toHeaderResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*toHeaderResult))
-
+func (expected *contentLengthResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*contentLengthResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
 	} else if expected.err != nil && actual.err == nil {
-		return false, fmt.Sprintf("unexpected success: got:\n%s\n\n", actual.header.String())
-	} else if expected.err != nil {
-		// Expected error. Return true immediately with no further checks.
-		return true, ""
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header != actual.header {
+		return false, fmt.Sprintf("unexpected max forwards value: expected \"%d\", got \"%d\"",
+			expected.header, actual.header)
 	}
+	return true, ""
+}
 
-	if expected.header.DisplayName != actual.header.DisplayName {
-		return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
-			strMaybeStr(expected.header.DisplayName),
-			strMaybeStr(actual.header.DisplayName))
+type viaInput string
+
+func (data viaInput) String() string {
+	return string(data)
+}
+
+func (data viaInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 0 {
+		return &viaResult{err, &base.ViaHeader{}}
+	} else if len(headers) == 1 {
+		return &viaResult{err, headers[0].(*base.ViaHeader)}
+	} else {
+		panic("got more than one via header on test " + data)
 	}
+}
 
-	switch expected.header.Address.(type) {
-	case *base.SipUri:
-		uri := *(expected.header.Address.(*base.SipUri))
-		urisEqual := uri.Equals(actual.header.Address)
-		msg := ""
-		if !urisEqual {
-			msg = fmt.Sprintf("unexpected result: expected %s, got %s",
-				expected.header.Address.String(), actual.header.Address.String())
-		}
-		if !urisEqual {
-			return false, msg
-		}
-	default:
-		// If you're hitting this block, then you need to do the following:
-		// - implement a package-private 'equals' method for the URI schema being tested.
-		// - add a case block above for that schema, using the 'equals' method in the same was as the existing base.SipUri block above.
-		return false, fmt.Sprintf("no support for testing Uri schema in Uri \"%s\" - fix me!", expected.header.Address)
+type viaResult struct {
+	err    error
+	header *base.ViaHeader
+}
+
+func (expected *viaResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*viaResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, "unexpected success - got: " + actual.header.String()
+	} else if expected.err != nil {
+		// Got an error, and were expecting one - return with no further checks.
+	} else if len(*expected.header) != len(*actual.header) {
+		return false,
+			fmt.Sprintf("unexpected number of entries: expected %d; got %d.\n"+
+				"expected the following entries: %s\n"+
+				"got the following entries: %s",
+				len(*expected.header), len(*actual.header),
+				expected.header.String(), actual.header.String())
 	}
 
-	if !expected.header.Params.Equals(actual.header.Params) {
-		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
-			actual.header.Params.ToString('-'),
-			expected.header.Params.ToString('-'))
+	for idx, expectedHop := range *expected.header {
+		actualHop := (*actual.header)[idx]
+		if expectedHop.ProtocolName != actualHop.ProtocolName {
+			return false, fmt.Sprintf("unexpected protocol name '%s' in via entry %d - expected '%s'",
+				actualHop.ProtocolName, idx, expectedHop.ProtocolName)
+		} else if expectedHop.ProtocolVersion != actualHop.ProtocolVersion {
+			return false, fmt.Sprintf("unexpected protocol version '%s' in via entry %d - expected '%s'",
+				actualHop.ProtocolVersion, idx, expectedHop.ProtocolVersion)
+		} else if expectedHop.Transport != actualHop.Transport {
+			return false, fmt.Sprintf("unexpected transport '%s' in via entry %d - expected '%s'",
+				actualHop.Transport, idx, expectedHop.Transport)
+		} else if expectedHop.Host != actualHop.Host {
+			return false, fmt.Sprintf("unexpected host '%s' in via entry %d - expected '%s'",
+				actualHop.Host, idx, expectedHop.Host)
+		} else if !utils.Uint16PtrEq(expectedHop.Port, actualHop.Port) {
+			return false, fmt.Sprintf("unexpected port '%d' in via entry %d - expected '%d'",
+				uint16PtrStr(actualHop.Port), idx, uint16PtrStr(expectedHop.Port))
+		} else if !expectedHop.Params.Equals(actualHop.Params) {
+			return false, fmt.Sprintf("unexpected params '%s' in via entry %d - expected '%s'",
+				actualHop.Params.ToString('-'),
+				idx,
+				expectedHop.Params.ToString('-'))
+		}
 	}
 
 	return true, ""
 }
 
-type fromHeaderInput string
+// Input for Accept-Encoding and Accept-Language tests.
+type qualifiedInput string
 
-func (data fromHeaderInput) String() string {
+func (data qualifiedInput) String() string {
 	return string(data)
 }
 
-func (data fromHeaderInput) evaluate() result {
+func (data qualifiedInput) evaluate() result {
 	headers, err := parseHeader(string(data))
 	if len(headers) == 1 {
-		return &fromHeaderResult{err, headers[0].(*base.FromHeader)}
+		switch header := headers[0].(type) {
+		case *base.AcceptEncodingHeader:
+			return &qualifiedResult{err, *header}
+		case *base.AcceptLanguageHeader:
+			return &qualifiedResult{err, *header}
+		case *base.AcceptHeader:
+			return &qualifiedResult{err, *header}
+		default:
+			panic(fmt.Sprintf("Unexpected header type returned by qualified list test: %s", string(data)))
+		}
 	} else if len(headers) == 0 {
-		return &fromHeaderResult{err, &base.FromHeader{}}
+		return &qualifiedResult{err, nil}
 	} else {
-		panic(fmt.Sprintf("Multiple headers returned by From test: %s", string(data)))
+		panic(fmt.Sprintf("Multiple headers returned by qualified list test: %s", string(data)))
 	}
 }
 
-type fromHeaderResult struct {
+type qualifiedResult struct {
 	err    error
-	header *base.FromHeader
+	values []*base.QualifiedValue
 }
 
-func (expected *fromHeaderResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*fromHeaderResult))
-
+func (expected *qualifiedResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*qualifiedResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
 	} else if expected.err != nil && actual.err == nil {
-		return false, fmt.Sprintf("unexpected success: got:\n%s\n\n", actual.header.String())
-	} else if expected.err != nil {
-		// Expected error. Return true immediately with no further checks.
+		return false, fmt.Sprintf("unexpected success: got %d values", len(actual.values))
+	} else if actual.err != nil {
 		return true, ""
+	} else if len(expected.values) != len(actual.values) {
+		return false, fmt.Sprintf("unexpected number of values: expected %d, got %d",
+			len(expected.values), len(actual.values))
 	}
 
-	if expected.header.DisplayName != actual.header.DisplayName {
-		return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
-			strMaybeStr(expected.header.DisplayName),
-			strMaybeStr(actual.header.DisplayName))
-	}
-
-	switch expected.header.Address.(type) {
-	case *base.SipUri:
-		uri := *(expected.header.Address.(*base.SipUri))
-		urisEqual := uri.Equals(actual.header.Address)
-		msg := ""
-		if !urisEqual {
-			msg = fmt.Sprintf("unexpected result: expected %s, got %s",
-				expected.header.Address.String(), actual.header.Address.String())
-		}
-		if !urisEqual {
-			return false, msg
+	for idx, expectedValue := range expected.values {
+		actualValue := actual.values[idx]
+		if expectedValue.Value != actualValue.Value || expectedValue.Q != actualValue.Q ||
+			!expectedValue.Params.Equals(actualValue.Params) {
+			return false, fmt.Sprintf("unexpected value %d: expected \"%s\", got \"%s\"",
+				idx, expectedValue.String(), actualValue.String())
 		}
-	default:
-		// If you're hitting this block, then you need to do the following:
-		// - implement a package-private 'equals' method for the URI schema being tested.
-		// - add a case block above for that schema, using the 'equals' method in the same was as the existing base.SipUri block above.
-		return false, fmt.Sprintf("no support for testing Uri schema in Uri \"%s\" - fix me!", expected.header.Address)
 	}
+	return true, ""
+}
 
-	if !expected.header.Params.Equals(actual.header.Params) {
-		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
-			actual.header.Params.ToString('-'),
-			expected.header.Params.ToString('-'))
+type contentEncodingInput string
+
+func (data contentEncodingInput) String() string {
+	return string(data)
+}
+
+func (data contentEncodingInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &contentEncodingResult{err, *(headers[0].(*base.ContentEncodingHeader))}
+	} else if len(headers) == 0 {
+		return &contentEncodingResult{err, nil}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Content-Encoding test: %s", string(data)))
 	}
+}
 
+type contentEncodingResult struct {
+	err    error
+	header base.ContentEncodingHeader
+}
+
+func (expected *contentEncodingResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*contentEncodingResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.String() != actual.header.String() {
+		return false, fmt.Sprintf("unexpected content codings: expected \"%s\", got \"%s\"",
+			expected.header.String(), actual.header.String())
+	}
 	return true, ""
 }
 
-type contactHeaderInput string
+type contentTypeInput string
 
-func (data contactHeaderInput) String() string {
+func (data contentTypeInput) String() string {
 	return string(data)
 }
 
-func (data contactHeaderInput) evaluate() result {
+func (data contentTypeInput) evaluate() result {
 	headers, err := parseHeader(string(data))
-	contactHeaders := make([]*base.ContactHeader, len(headers))
-	if len(headers) > 0 {
-		for idx, header := range headers {
-			contactHeaders[idx] = header.(*base.ContactHeader)
-		}
-		return &contactHeaderResult{err, contactHeaders}
+	if len(headers) == 1 {
+		return &contentTypeResult{err, headers[0].(*base.ContentType)}
+	} else if len(headers) == 0 {
+		return &contentTypeResult{err, &base.ContentType{}}
 	} else {
-		return &contactHeaderResult{err, contactHeaders}
+		panic(fmt.Sprintf("Multiple headers returned by Content-Type test: %s", string(data)))
 	}
 }
 
-type contactHeaderResult struct {
-	err     error
-	headers []*base.ContactHeader
+type contentTypeResult struct {
+	err    error
+	header *base.ContentType
+}
+
+func (expected *contentTypeResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*contentTypeResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.MediaType != actual.header.MediaType {
+		return false, fmt.Sprintf("unexpected media type: expected \"%s\", got \"%s\"",
+			expected.header.MediaType, actual.header.MediaType)
+	} else if actual.err == nil && !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
+	}
+	return true, ""
 }
 
-func (expected *contactHeaderResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*contactHeaderResult))
+type sdpBodyInput struct {
+	contentType string
+	body        string
+}
 
-	if expected.err == nil && actual.err != nil {
-		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
-	} else if expected.err != nil && actual.err != nil {
-		// Expected error. Return true immediately with no further checks.
-		return true, ""
-	}
+func (data sdpBodyInput) String() string {
+	return fmt.Sprintf("contentType=%s, body=%q", data.contentType, data.body)
+}
 
-	var buffer bytes.Buffer
-	for _, header := range actual.headers {
-		buffer.WriteString(fmt.Sprintf("\n\t%s", header))
+func (data sdpBodyInput) evaluate() result {
+	msg, err := ParseMessage([]byte("INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Content-Type: " + data.contentType + "\r\n" +
+		"\r\n" +
+		data.body))
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse message for SDP body test: %s", err.Error()))
 	}
-	buffer.WriteString("\n\n")
-	actualStr := buffer.String()
+	return sdpBodyResult(HasHeadersInSdpBody(msg))
+}
 
-	if expected.err != nil && actual.err == nil {
-		return false, fmt.Sprintf("unexpected success: got: %s", actualStr)
-	}
+type sdpBodyResult bool
 
-	if len(expected.headers) != len(actual.headers) {
-		return false, fmt.Sprintf("expected %d headers; got %d. Last expected header: %s. Last actual header: %s",
-			len(expected.headers), len(actual.headers),
-			expected.headers[len(expected.headers)-1].String(), actual.headers[len(actual.headers)-1].String())
+func (expected sdpBodyResult) equals(other result) (equal bool, reason string) {
+	actual := other.(sdpBodyResult)
+	if expected != actual {
+		return false, fmt.Sprintf("expected header-like lines detected = %t; got %t", bool(expected), bool(actual))
 	}
+	return true, ""
+}
 
-	for idx := range expected.headers {
-		if expected.headers[idx].DisplayName != actual.headers[idx].DisplayName {
-			return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
-				strMaybeStr(expected.headers[idx].DisplayName),
-				strMaybeStr(actual.headers[idx].DisplayName))
-		}
+type eventInput string
 
-		UrisEqual := expected.headers[idx].Address.Equals(actual.headers[idx].Address)
-		if !UrisEqual {
-			return false, fmt.Sprintf("expected Uri %#v; got Uri %#v", expected.headers[idx].Address, actual.headers[idx].Address)
-		}
+func (data eventInput) String() string {
+	return string(data)
+}
 
-		if !expected.headers[idx].Params.Equals(actual.headers[idx].Params) {
-			return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
-				actual.headers[idx].Params.ToString('-'),
-				expected.headers[idx].Params.ToString('-'))
-		}
+func (data eventInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		event := headers[0].(*base.EventHeader)
+		return &eventResult{err, event, event.Id()}
+	} else if len(headers) == 0 {
+		return &eventResult{err, &base.EventHeader{}, base.NoString{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Event test: %s", string(data)))
 	}
+}
+
+type eventResult struct {
+	err    error
+	header *base.EventHeader
+	id     base.MaybeString
+}
 
+func (expected *eventResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*eventResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.EventType != actual.header.EventType {
+		return false, fmt.Sprintf("unexpected event package: expected \"%s\", got \"%s\"",
+			expected.header.EventType, actual.header.EventType)
+	} else if actual.err == nil && !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
+	} else if actual.err == nil && expected.id != actual.id {
+		return false, fmt.Sprintf("unexpected id: expected \"%s\", got \"%s\"",
+			strMaybeStr(expected.id), strMaybeStr(actual.id))
+	}
 	return true, ""
 }
 
-type splitByWSInput string
+type referSubInput string
 
-func (data splitByWSInput) String() string {
+func (data referSubInput) String() string {
 	return string(data)
 }
 
-func (data splitByWSInput) evaluate() result {
-	return splitByWSResult(splitByWhitespace(string(data)))
+func (data referSubInput) evaluate() result {
+	headers, err := parseHeader(string(data))
+	if len(headers) == 1 {
+		return &referSubResult{err, headers[0].(*base.ReferSub)}
+	} else if len(headers) == 0 {
+		return &referSubResult{err, &base.ReferSub{}}
+	} else {
+		panic(fmt.Sprintf("Multiple headers returned by Refer-Sub test: %s", string(data)))
+	}
 }
 
-type splitByWSResult []string
-
-func (expected splitByWSResult) equals(other result) (equal bool, reason string) {
-	actual := other.(splitByWSResult)
-	if len(expected) != len(actual) {
-		return false, fmt.Sprintf("unexpected result length in splitByWS test: expected %d %v, got %d %v.", len(expected), expected, len(actual), actual)
-	}
+type referSubResult struct {
+	err    error
+	header *base.ReferSub
+}
 
-	for idx, e := range expected {
-		if e != actual[idx] {
-			return false, fmt.Sprintf("unexpected result at index %d in splitByWS test: expected '%s'; got '%s'", idx, e, actual[idx])
-		}
+func (expected *referSubResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*referSubResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.Value != actual.header.Value {
+		return false, fmt.Sprintf("unexpected value: expected %t, got %t",
+			expected.header.Value, actual.header.Value)
+	} else if actual.err == nil && !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
 	}
-
 	return true, ""
 }
 
-type cSeqInput string
+type contentDispositionInput string
 
-func (data cSeqInput) String() string {
+func (data contentDispositionInput) String() string {
 	return string(data)
 }
 
-func (data cSeqInput) evaluate() result {
+func (data contentDispositionInput) evaluate() result {
 	headers, err := parseHeader(string(data))
 	if len(headers) == 1 {
-		return &cSeqResult{err, headers[0].(*base.CSeq)}
+		return &contentDispositionResult{err, headers[0].(*base.ContentDispositionHeader)}
 	} else if len(headers) == 0 {
-		return &cSeqResult{err, &base.CSeq{}}
+		return &contentDispositionResult{err, &base.ContentDispositionHeader{}}
 	} else {
-		panic(fmt.Sprintf("Multiple headers returned by base.CSeq test: %s", string(data)))
+		panic(fmt.Sprintf("Multiple headers returned by Content-Disposition test: %s", string(data)))
 	}
 }
 
-type cSeqResult struct {
+type contentDispositionResult struct {
 	err    error
-	header *base.CSeq
+	header *base.ContentDispositionHeader
 }
 
-func (expected *cSeqResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*cSeqResult))
+func (expected *contentDispositionResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*contentDispositionResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
 	} else if expected.err != nil && actual.err == nil {
 		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
-	} else if actual.err == nil && expected.header.SeqNo != actual.header.SeqNo {
-		return false, fmt.Sprintf("unexpected sequence number: expected \"%d\", got \"%d\"",
-			expected.header.SeqNo, actual.header.SeqNo)
-	} else if actual.err == nil && expected.header.MethodName != actual.header.MethodName {
-		return false, fmt.Sprintf("unexpected method name: expected %s, got %s", expected.header.MethodName, actual.header.MethodName)
+	} else if actual.err == nil && expected.header.DispositionType != actual.header.DispositionType {
+		return false, fmt.Sprintf("unexpected disposition type: expected \"%s\", got \"%s\"",
+			expected.header.DispositionType, actual.header.DispositionType)
+	} else if actual.err == nil && !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
 	}
-
 	return true, ""
 }
 
-type callIdInput string
+type replacesInput string
 
-func (data callIdInput) String() string {
+func (data replacesInput) String() string {
 	return string(data)
 }
 
-func (data callIdInput) evaluate() result {
+func (data replacesInput) evaluate() result {
 	headers, err := parseHeader(string(data))
 	if len(headers) == 1 {
-		return &callIdResult{err, *(headers[0].(*base.CallId))}
+		return &replacesResult{err, headers[0].(*base.ReplacesHeader)}
 	} else if len(headers) == 0 {
-		return &callIdResult{err, base.CallId("")}
+		return &replacesResult{err, &base.ReplacesHeader{}}
 	} else {
-		panic(fmt.Sprintf("Multiple headers returned by base.CallId test: %s", string(data)))
+		panic(fmt.Sprintf("Multiple headers returned by Replaces test: %s", string(data)))
 	}
 }
 
-type callIdResult struct {
+type replacesResult struct {
 	err    error
-	header base.CallId
+	header *base.ReplacesHeader
 }
 
-func (expected callIdResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*callIdResult))
+func (expected *replacesResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*replacesResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
 	} else if expected.err != nil && actual.err == nil {
 		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
+	} else if actual.err == nil && expected.header.CallId != actual.header.CallId {
+		return false, fmt.Sprintf("unexpected call-id: expected \"%s\", got \"%s\"",
+			string(expected.header.CallId), string(actual.header.CallId))
+	} else if actual.err == nil && !expected.header.Params.Equals(actual.header.Params) {
+		return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+			actual.header.Params.ToString('-'),
+			expected.header.Params.ToString('-'))
 	} else if actual.err == nil && expected.header.String() != actual.header.String() {
-		return false, fmt.Sprintf("unexpected call ID string: expected \"%s\", got \"%s\"",
-			expected.header, actual.header)
+		return false, fmt.Sprintf("unexpected string form: expected \"%s\", got \"%s\"",
+			expected.header.String(), actual.header.String())
 	}
 	return true, ""
 }
 
-type maxForwardsInput string
+// Parses an Accept-Contact or Reject-Contact header, and evaluates each of its entries against the given capabilities.
+type callerPrefsInput struct {
+	header string
+	caps   base.Params
+}
 
-func (data maxForwardsInput) String() string {
+func (data callerPrefsInput) String() string {
+	return fmt.Sprintf("%s (capabilities: %s)", data.header, data.caps.ToString(';'))
+}
+
+func (data callerPrefsInput) evaluate() result {
+	headers, err := parseHeader(data.header)
+	if len(headers) == 0 {
+		return &callerPrefsResult{err, "", nil}
+	} else if len(headers) > 1 {
+		panic(fmt.Sprintf("Multiple headers returned by caller preferences test: %s", data.header))
+	}
+
+	var entries []*base.CallerPrefEntry
+	switch h := headers[0].(type) {
+	case *base.AcceptContactHeader:
+		entries = *h
+	case *base.RejectContactHeader:
+		entries = *h
+	}
+
+	matches := make([]bool, 0, len(entries))
+	for _, entry := range entries {
+		matches = append(matches, entry.Matches(data.caps))
+	}
+	return &callerPrefsResult{err, headers[0].String(), matches}
+}
+
+type callerPrefsResult struct {
+	err     error
+	header  string
+	matches []bool
+}
+
+func (expected *callerPrefsResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*callerPrefsResult))
+	if expected.err == nil && actual.err != nil {
+		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
+	} else if expected.err != nil && actual.err == nil {
+		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header)
+	} else if actual.err == nil && expected.header != actual.header {
+		return false, fmt.Sprintf("unexpected header: expected \"%s\", got \"%s\"", expected.header, actual.header)
+	} else if actual.err == nil && fmt.Sprint(expected.matches) != fmt.Sprint(actual.matches) {
+		return false, fmt.Sprintf("unexpected matches: expected %v, got %v", expected.matches, actual.matches)
+	}
+	return true, ""
+}
+
+type infoInput string
+
+func (data infoInput) String() string {
 	return string(data)
 }
 
-func (data maxForwardsInput) evaluate() result {
+func (data infoInput) evaluate() result {
 	headers, err := parseHeader(string(data))
 	if len(headers) == 1 {
-		return &maxForwardsResult{err, *(headers[0].(*base.MaxForwards))}
+		return &infoResult{err, headers[0]}
 	} else if len(headers) == 0 {
-		return &maxForwardsResult{err, base.MaxForwards(0)}
+		return &infoResult{err, nil}
 	} else {
-		panic(fmt.Sprintf("Multiple headers returned by Max-Forwards test: %s", string(data)))
+		panic(fmt.Sprintf("Multiple headers returned by Alert-Info/Call-Info/Error-Info test: %s", string(data)))
 	}
 }
 
-type maxForwardsResult struct {
+// Holds an Alert-Info, Call-Info or Error-Info header.
+type infoResult struct {
 	err    error
-	header base.MaxForwards
+	header base.SipHeader
 }
 
-func (expected *maxForwardsResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*maxForwardsResult))
+// Extract the entries from an Alert-Info, Call-Info or Error-Info header.
+func infoEntries(header base.SipHeader) []*base.InfoEntry {
+	switch h := header.(type) {
+	case *base.AlertInfoHeader:
+		return *h
+	case *base.CallInfoHeader:
+		return *h
+	case *base.ErrorInfoHeader:
+		return *h
+	default:
+		panic(fmt.Sprintf("Unexpected header type in Alert-Info/Call-Info/Error-Info test: %s", header.String()))
+	}
+}
+
+func (expected *infoResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*infoResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
 	} else if expected.err != nil && actual.err == nil {
 		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
-	} else if actual.err == nil && expected.header != actual.header {
-		return false, fmt.Sprintf("unexpected max forwards value: expected \"%d\", got \"%d\"",
-			expected.header, actual.header)
+	} else if actual.err != nil {
+		return true, ""
+	} else if expected.header.Name() != actual.header.Name() {
+		return false, fmt.Sprintf("unexpected header type: expected %s; got %s",
+			expected.header.Name(), actual.header.Name())
+	}
+
+	expectedEntries, actualEntries := infoEntries(expected.header), infoEntries(actual.header)
+	if len(expectedEntries) != len(actualEntries) {
+		return false, fmt.Sprintf("unexpected number of entries: expected %d, got %d",
+			len(expectedEntries), len(actualEntries))
+	}
+	for idx, expectedEntry := range expectedEntries {
+		actualEntry := actualEntries[idx]
+		if !expectedEntry.Address.Equals(actualEntry.Address) {
+			return false, fmt.Sprintf("unexpected address: expected %s, got %s",
+				expectedEntry.Address.String(), actualEntry.Address.String())
+		} else if !expectedEntry.Params.Equals(actualEntry.Params) {
+			return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+				actualEntry.Params.ToString('-'), expectedEntry.Params.ToString('-'))
+		}
 	}
 	return true, ""
 }
 
-type contentLengthInput string
+type paiInput string
 
-func (data contentLengthInput) String() string {
+func (data paiInput) String() string {
 	return string(data)
 }
 
-func (data contentLengthInput) evaluate() result {
+func (data paiInput) evaluate() result {
 	headers, err := parseHeader(string(data))
 	if len(headers) == 1 {
-		return &contentLengthResult{err, *(headers[0].(*base.ContentLength))}
+		return &paiResult{err, *(headers[0].(*base.PAssertedIdentityHeader))}
 	} else if len(headers) == 0 {
-		return &contentLengthResult{err, base.ContentLength(0)}
+		return &paiResult{err, base.PAssertedIdentityHeader{}}
 	} else {
-		panic(fmt.Sprintf("Multiple headers returned by Content-Length test: %s", string(data)))
+		panic(fmt.Sprintf("Multiple headers returned by P-Asserted-Identity test: %s", string(data)))
 	}
 }
 
-type contentLengthResult struct {
+type paiResult struct {
 	err    error
-	header base.ContentLength
+	header base.PAssertedIdentityHeader
 }
 
-func (expected *contentLengthResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*contentLengthResult))
+func (expected *paiResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*paiResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
 	} else if expected.err != nil && actual.err == nil {
 		return false, fmt.Sprintf("unexpected success: got \"%s\"", actual.header.String())
-	} else if actual.err == nil && expected.header != actual.header {
-		return false, fmt.Sprintf("unexpected max forwards value: expected \"%d\", got \"%d\"",
-			expected.header, actual.header)
+	} else if actual.err != nil {
+		return true, ""
+	} else if len(expected.header) != len(actual.header) {
+		return false, fmt.Sprintf("unexpected number of identities: expected %d, got %d",
+			len(expected.header), len(actual.header))
+	}
+
+	for idx, expectedIdentity := range expected.header {
+		actualIdentity := actual.header[idx]
+		if expectedIdentity.DisplayName != actualIdentity.DisplayName {
+			return false, fmt.Sprintf("unexpected display name: expected \"%s\"; got \"%s\"",
+				strMaybeStr(expectedIdentity.DisplayName), strMaybeStr(actualIdentity.DisplayName))
+		} else if !expectedIdentity.Address.Equals(actualIdentity.Address) {
+			return false, fmt.Sprintf("unexpected address: expected %s, got %s",
+				expectedIdentity.Address.String(), actualIdentity.Address.String())
+		} else if !expectedIdentity.Params.Equals(actualIdentity.Params) {
+			return false, fmt.Sprintf("unexpected parameters \"%s\" (expected \"%s\")",
+				actualIdentity.Params.ToString('-'), expectedIdentity.Params.ToString('-'))
+		}
 	}
 	return true, ""
 }
 
-type viaInput string
+type pvniInput string
 
-func (data viaInput) String() string {
+func (data pvniInput) String() string {
 	return string(data)
 }
 
-func (data viaInput) evaluate() result {
+func (data pvniInput) evaluate() result {
 	headers, err := parseHeader(string(data))
 	if len(headers) == 0 {
-		return &viaResult{err, &base.ViaHeader{}}
+		return &pvniResult{err, &base.PVisitedNetworkID{}}
 	} else if len(headers) == 1 {
-		return &viaResult{err, headers[0].(*base.ViaHeader)}
+		return &pvniResult{err, headers[0].(*base.PVisitedNetworkID)}
 	} else {
-		panic("got more than one via header on test " + data)
+		panic("got more than one P-Visited-Network-ID header on test " + data)
 	}
 }
 
-type viaResult struct {
+type pvniResult struct {
 	err    error
-	header *base.ViaHeader
+	header *base.PVisitedNetworkID
 }
 
-func (expected *viaResult) equals(other result) (equal bool, reason string) {
-	actual := *(other.(*viaResult))
+func (expected *pvniResult) equals(other result) (equal bool, reason string) {
+	actual := *(other.(*pvniResult))
 	if expected.err == nil && actual.err != nil {
 		return false, fmt.Sprintf("unexpected error: %s", actual.err.Error())
 	} else if expected.err != nil && actual.err == nil {
 		return false, "unexpected success - got: " + actual.header.String()
 	} else if expected.err != nil {
 		// Got an error, and were expecting one - return with no further checks.
+		return true, ""
 	} else if len(*expected.header) != len(*actual.header) {
 		return false,
 			fmt.Sprintf("unexpected number of entries: expected %d; got %d.\n"+
@@ -1773,28 +5975,19 @@ func (expected *viaResult) equals(other result) (equal bool, reason string) {
 				expected.header.String(), actual.header.String())
 	}
 
-	for idx, expectedHop := range *expected.header {
-		actualHop := (*actual.header)[idx]
-		if expectedHop.ProtocolName != actualHop.ProtocolName {
-			return false, fmt.Sprintf("unexpected protocol name '%s' in via entry %d - expected '%s'",
-				actualHop.ProtocolName, idx, expectedHop.ProtocolName)
-		} else if expectedHop.ProtocolVersion != actualHop.ProtocolVersion {
-			return false, fmt.Sprintf("unexpected protocol version '%s' in via entry %d - expected '%s'",
-				actualHop.ProtocolVersion, idx, expectedHop.ProtocolVersion)
-		} else if expectedHop.Transport != actualHop.Transport {
-			return false, fmt.Sprintf("unexpected transport '%s' in via entry %d - expected '%s'",
-				actualHop.Transport, idx, expectedHop.Transport)
-		} else if expectedHop.Host != actualHop.Host {
-			return false, fmt.Sprintf("unexpected host '%s' in via entry %d - expected '%s'",
-				actualHop.Host, idx, expectedHop.Host)
-		} else if !utils.Uint16PtrEq(expectedHop.Port, actualHop.Port) {
-			return false, fmt.Sprintf("unexpected port '%d' in via entry %d - expected '%d'",
-				uint16PtrStr(actualHop.Port), idx, uint16PtrStr(expectedHop.Port))
-		} else if !expectedHop.Params.Equals(actualHop.Params) {
-			return false, fmt.Sprintf("unexpected params '%s' in via entry %d - expected '%s'",
-				actualHop.Params.ToString('-'),
+	for idx, expectedNetwork := range *expected.header {
+		actualNetwork := (*actual.header)[idx]
+		if expectedNetwork.Network != actualNetwork.Network {
+			return false, fmt.Sprintf("unexpected network '%s' in entry %d - expected '%s'",
+				actualNetwork.Network, idx, expectedNetwork.Network)
+		} else if expectedNetwork.Quoted != actualNetwork.Quoted {
+			return false, fmt.Sprintf("unexpected quoting in entry %d - expected quoted=%t",
+				idx, expectedNetwork.Quoted)
+		} else if !expectedNetwork.Params.Equals(actualNetwork.Params) {
+			return false, fmt.Sprintf("unexpected params '%s' in entry %d - expected '%s'",
+				actualNetwork.Params.ToString('-'),
 				idx,
-				expectedHop.Params.ToString('-'))
+				expectedNetwork.Params.ToString('-'))
 		}
 	}
 
@@ -1896,6 +6089,49 @@ func (step *parserTestStep) Test(parser Parser, msgChan chan base.SipMessage, er
 	return
 }
 
+// A streamed parser test which performs several writes before checking the parser's output.
+// Unlike ParserTest, no output is expected (or waited for) between individual writes, so this is suited to
+// inputs which are split into many small pieces.
+type streamedWritesTest struct {
+	writes   []string
+	expected []base.SipMessage
+}
+
+func (test *streamedWritesTest) Test(t *testing.T) {
+	testsRun++
+	output := make(chan base.SipMessage, len(test.expected))
+	errs := make(chan error, 1)
+
+	p := NewParser(output, errs, true)
+	defer p.Stop()
+
+	for idx, data := range test.writes {
+		if _, err := p.Write([]byte(data)); err != nil {
+			t.Errorf("unexpected error on write %d of %q: %s", idx, test.writes, err.Error())
+			return
+		}
+	}
+
+	for idx, expected := range test.expected {
+		select {
+		case msg := <-output:
+			if msg.String() != expected.String() {
+				t.Errorf("unexpected message %d parsed from %q; expected:\n\n%s\n\nbut got:\n\n%s",
+					idx, test.writes, expected.String(), msg.String())
+				return
+			}
+		case err := <-errs:
+			t.Errorf("unexpected error parsing message %d from %q: %s", idx, test.writes, err.Error())
+			return
+		case <-time.After(time.Second * 1):
+			t.Errorf("timeout waiting for message %d from %q", idx, test.writes)
+			return
+		}
+	}
+
+	testsPassed++
+}
+
 func TestZZZCountTests(t *testing.T) {
 	fmt.Printf("\n *** %d tests run ***", testsRun)
 	fmt.Printf("\n *** %d tests passed (%.2f%%) ***\n\n", testsPassed, (float32(testsPassed) * 100.0 / float32(testsRun)))
@@ -1927,3 +6163,72 @@ func errToStr(err error) string {
 		return err.Error()
 	}
 }
+
+// Test that malformed address headers found by FuzzParseMessage produce errors rather than panics.
+func TestMalformedAddressHeaders(t *testing.T) {
+	for _, input := range []string{
+		"Contact: <sip:alice@wonderland.com>, ",
+		"P-Asserted-Identity: <sip:alice@wonderland.com>,\t",
+		"Route: <sip:p1.example.com;lr",
+	} {
+		testsRun++
+		if headers, err := parseHeader(input); err == nil {
+			t.Errorf("expected error parsing %q; got %v", input, headers)
+		} else {
+			testsPassed++
+		}
+	}
+}
+
+// Messages used to seed FuzzParseMessage, covering the URI, address, Via and parameter parsers.
+var fuzzSeeds = []string{
+	"INVITE sip:bob@biloxi.com SIP/2.0\r\n\r\n",
+	"INVITE sip:bob@biloxi.com SIP/2.0\r\nCSeq: 13 INVITE\r\n\r\nI am a banana",
+	"INVITE sips:bob:Hunter2@[2001:db8::1]:5061;transport=tls;lr?subject=project%20x SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds, SIP/2.0/TCP [::1]:5060;received=192.0.2.1\r\n" +
+		"Max-Forwards: 70\r\n" +
+		"To: Bob <sip:bob@biloxi.com>\r\n" +
+		"From: \"Alice \\\"A\\\" Liddell\" <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"Contact: <sip:alice@pc33.atlanta.com;transport=tcp>;expires=3600;q=0.5\r\n" +
+		"Route: <sip:p1.example.com;lr>, <sip:p2.example.com;lr>\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"Content-Length: 4\r\n\r\nv=0\n",
+	"SIP/2.0 200 OK\r\n" +
+		"Via: SIP/2.0/UDP server10.biloxi.com;branch=z9hG4bKnashds8;received=192.0.2.3\r\n" +
+		"To: Bob <sip:bob@biloxi.com>;tag=a6c85cf\r\n" +
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"Contact: *\r\n" +
+		"Record-Route: <sip:p2.biloxi.com;lr>\r\n" +
+		"P-Asserted-Identity: \"Cullen Jennings\" <sip:fluffy@cisco.com>, tel:+14085264000\r\n" +
+		"Refer-To: <sip:bob@biloxi.com?Replaces=12345%40192.168.118.3%3Bto-tag%3D12345>\r\n" +
+		"Content-Length: 0\r\n\r\n",
+	"REGISTER sip:registrar.biloxi.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7\r\n" +
+		"To: Bob <sip:bob@biloxi.com>\r\n" +
+		"From: Bob <sip:bob@biloxi.com>;tag=456248\r\n" +
+		"Contact: <sip:bob@192.0.2.4>;expires=7200, \"Bob\" sip:bob@192.0.2.5\r\n" +
+		"Accept-Contact: *;+sip.instance=\"<urn:uuid:1>\";require;explicit\r\n" +
+		"Warning: 307 isi.edu \"Session parameter 'foo' not understood\"\r\n" +
+		"Retry-After: 120 (I'm in a meeting);duration=3600\r\n" +
+		"Subject: Folded\r\n continuation\r\n" +
+		"l: 0\r\n\r\n",
+}
+
+// Check that ParseMessage never panics, but always returns either a message or an error.
+// Run the fuzzer with 'go test -run=^$ -fuzz=FuzzParseMessage ./parser'; otherwise only the seed corpus is tested.
+func FuzzParseMessage(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// ParseMessage waits for the end of the header section, so make sure that it arrives.
+		data = append(data, "\r\n\r\n"...)
+		msg, err := ParseMessage(data)
+		if msg == nil && err == nil {
+			t.Errorf("no message or error parsing %q", data)
+		}
+	})
+}
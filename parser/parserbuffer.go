@@ -3,11 +3,21 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/stefankopieczek/gossip/log"
 )
 
+// errIdleTimeout is returned by the blocking read methods if the idle timeout expires.
+var errIdleTimeout = errors.New("parser buffer idle timeout")
+
+// errLineTooLong is returned by NextLine if the line exceeds the buffer's line limit.
+var errLineTooLong = errors.New("parser buffer line exceeds limit")
+
 // parserBuffer is a specialized buffer for use in the parser package.
 // It is written to via the non-blocking Write.
 // It exposes various blocking read methods, which wait until the requested
@@ -19,8 +29,26 @@ type parserBuffer struct {
 	// Wraps parserBuffer.pipeReader
 	reader *bufio.Reader
 
-	// Don't access this directly except when closing.
+	// If true, a bare LF also ends a line, as well as a CRLF.
+	acceptBareLF bool
+
+	// If non-zero, how long to wait for more data part-way through a message before giving up.
+	idleTimeout time.Duration
+	// Set to 1 by the idle timer if it expires.
+	timedOut int32
+
+	// Count bytes read from the pipe and bytes returned to the caller, so that we can tell whether
+	// any data has arrived since the start of the current message.
+	received     int64
+	consumed     int64
+	messageStart int64
+
+	// If non-zero, the maximum number of bytes, including the line ending, that NextLine will read for one line.
+	lineLimit int
+
+	// Don't access these directly except when closing.
 	pipeReader *io.PipeReader
+	pipeWriter *io.PipeWriter
 }
 
 // Create a new parserBuffer object (see struct comment for object details).
@@ -28,35 +56,95 @@ type parserBuffer struct {
 // until the Dispose() method is called.
 func newParserBuffer() *parserBuffer {
 	var pb parserBuffer
-	pb.pipeReader, pb.Writer = io.Pipe()
-	pb.reader = bufio.NewReader(pb.pipeReader)
+	pb.pipeReader, pb.pipeWriter = io.Pipe()
+	pb.Writer = pb.pipeWriter
+	pb.reader = bufio.NewReader(idleReader{&pb})
 	return &pb
 }
 
-// Block until the buffer contains at least one CRLF-terminated line.
-// Return the line, excluding the terminal CRLF, and delete it from the buffer.
-// Returns an error if the parserbuffer has been stopped.
+// idleReader reads from the parserBuffer's pipe, enforcing the idle timeout if one is set.
+type idleReader struct {
+	pb *parserBuffer
+}
+
+func (r idleReader) Read(data []byte) (n int, err error) {
+	pb := r.pb
+	if pb.received > pb.messageStart && pb.idleTimeout > 0 {
+		// We're part-way through a message, so don't wait forever for the rest of it.
+		timer := time.AfterFunc(pb.idleTimeout, func() {
+			atomic.StoreInt32(&pb.timedOut, 1)
+			pb.pipeReader.CloseWithError(errIdleTimeout)
+		})
+		n, err = pb.pipeReader.Read(data)
+		timer.Stop()
+	} else {
+		n, err = pb.pipeReader.Read(data)
+	}
+
+	pb.received += int64(n)
+	if err != nil && atomic.LoadInt32(&pb.timedOut) == 1 {
+		err = errIdleTimeout
+	}
+	return
+}
+
+// Mark the start of a new message, so that the idle timeout only applies once some of its data has arrived.
+func (pb *parserBuffer) MarkMessageStart() {
+	pb.messageStart = pb.consumed
+}
+
+// Return the number of bytes returned to the caller since the last call to MarkMessageStart, including line endings.
+func (pb *parserBuffer) MessageBytes() int {
+	return int(pb.consumed - pb.messageStart)
+}
+
+// Return the number of bytes which have arrived but have not yet been returned by the read methods.
+// Data still waiting to be written to the buffer is not counted.
+func (pb *parserBuffer) Buffered() int {
+	return pb.reader.Buffered()
+}
+
+// Limit the number of bytes, including the line ending, that subsequent calls to NextLine will read for one line,
+// so that a peer cannot make the buffer grow without bound by never ending a line. A limit of 0 removes the limit.
+func (pb *parserBuffer) SetLineLimit(limit int) {
+	pb.lineLimit = limit
+}
+
+// Block until the buffer contains at least one CRLF-terminated line (or LF-terminated, if bare LFs are accepted).
+// Return the line, excluding the terminal CRLF or LF, and delete it from the buffer.
+// Returns an error if the parserbuffer has been stopped, or errIdleTimeout if the idle timeout expired.
+// Returns errLineTooLong, having consumed part of the line, if the line exceeds the line limit.
+// If the parserbuffer has been closed, returns io.EOF, or io.ErrUnexpectedEOF if a partial line was left unread.
 func (pb *parserBuffer) NextLine() (response string, err error) {
 	var buffer bytes.Buffer
-	var data string
-	var b byte
+	var data []byte
 
-	// There has to be a better way!
+	// Read up to each LF in turn, until we find one which is preceded by a CR.
+	// Bare LFs, and CRs which are not followed by an LF, are treated as part of the line.
 	for {
-		data, err = pb.reader.ReadString('\r')
-		if err != nil {
+		// Read at most a buffer's worth at a time, so that we can stop at the line limit.
+		data, err = pb.reader.ReadSlice('\n')
+		pb.consumed += int64(len(data))
+		buffer.Write(data)
+		if pb.lineLimit > 0 && buffer.Len() > pb.lineLimit {
+			err = errLineTooLong
 			return
+		} else if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && buffer.Len() > 0 {
+			err = io.ErrUnexpectedEOF
 		}
-
-		buffer.WriteString(data)
-
-		b, err = pb.reader.ReadByte()
 		if err != nil {
 			return
 		}
 
-		buffer.WriteByte(b)
-		if b == '\n' {
+		if pb.acceptBareLF {
+			response = strings.TrimSuffix(buffer.String()[:buffer.Len()-1], "\r")
+			log.Debug("Parser buffer returns line '%s'", response)
+			return
+		}
+		if buffer.Len() >= 2 && buffer.Bytes()[buffer.Len()-2] == '\r' {
 			response = buffer.String()
 			response = response[:len(response)-2]
 			log.Debug("Parser buffer returns line '%s'", response)
@@ -67,6 +155,7 @@ func (pb *parserBuffer) NextLine() (response string, err error) {
 
 // Block until the buffer contains at least n characters.
 // Return precisely those n characters, then delete them from the buffer.
+// If the parserbuffer is closed before n characters are available, returns io.ErrUnexpectedEOF.
 func (pb *parserBuffer) NextChunk(n int) (response string, err error) {
 	var data []byte = make([]byte, n)
 
@@ -74,6 +163,10 @@ func (pb *parserBuffer) NextChunk(n int) (response string, err error) {
 	for total := 0; total < n; {
 		read, err = pb.reader.Read(data[total:])
 		total += read
+		pb.consumed += int64(read)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		if err != nil {
 			return
 		}
@@ -84,6 +177,12 @@ func (pb *parserBuffer) NextChunk(n int) (response string, err error) {
 	return
 }
 
+// Indicate that no more data will be written to the parser buffer.
+// Once any buffered data has been consumed, the blocking read methods will return io.EOF.
+func (pb *parserBuffer) Close() {
+	pb.pipeWriter.Close()
+}
+
 // Stop the parser buffer.
 func (pb *parserBuffer) Stop() {
 	pb.pipeReader.Close()
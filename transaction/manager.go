@@ -296,7 +296,7 @@ func (mng *Manager) request(r *base.Request) {
 	trying := base.NewResponse(
 		"SIP/2.0",
 		100,
-		"Trying",
+		base.DefaultReason(100),
 		[]base.SipHeader{},
 		"",
 	)